@@ -0,0 +1,90 @@
+package onynet
+
+import (
+	"net"
+	"sync"
+
+	intErrors "github.com/Onyz107/onynet/errors"
+	"github.com/Onyz107/onynet/internal/ratelimit"
+)
+
+// admission decides whether a new connection may start its handshake.
+type admission struct {
+	mu         sync.Mutex
+	maxClients int
+	maxPerIP   int
+	clients    int
+	perIP      map[string]int
+	handshakes *ratelimit.Limiter
+}
+
+func newAdmission() *admission {
+	return &admission{perIP: make(map[string]int), handshakes: ratelimit.NewLimiter(ratelimit.Unlimited)}
+}
+
+// admit counts a connection from addr, or returns why it is rejected.
+// The returned release func must be called once the connection is closed.
+func (a *admission) admit(addr net.Addr) (release func(), reason error) {
+	ip := hostOf(addr)
+
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	if a.maxClients > 0 && a.clients >= a.maxClients {
+		return nil, intErrors.ErrTooManyClients
+	}
+	if a.maxPerIP > 0 && a.perIP[ip] >= a.maxPerIP {
+		return nil, intErrors.ErrTooManyFromIP
+	}
+	if !a.handshakes.Allow(1) {
+		return nil, intErrors.ErrHandshakeRate
+	}
+
+	a.clients++
+	a.perIP[ip]++
+
+	var once sync.Once
+	return func() {
+		once.Do(func() {
+			a.mu.Lock()
+			defer a.mu.Unlock()
+
+			a.clients--
+			if a.perIP[ip]--; a.perIP[ip] <= 0 {
+				delete(a.perIP, ip)
+			}
+		})
+	}, nil
+}
+
+func hostOf(addr net.Addr) string {
+	if udpAddr, ok := addr.(*net.UDPAddr); ok {
+		return udpAddr.IP.String()
+	}
+	host, _, err := net.SplitHostPort(addr.String())
+	if err != nil {
+		return addr.String()
+	}
+	return host
+}
+
+// SetMaxClients limits the number of clients connected or handshaking at once, 0 means unlimited.
+// Connections over the limit are rejected before any authentication work.
+func (s *Server) SetMaxClients(n int) {
+	s.admission.mu.Lock()
+	defer s.admission.mu.Unlock()
+	s.admission.maxClients = n
+}
+
+// SetMaxClientsPerIP limits the number of clients connected or handshaking at once from a single IP address, 0 means unlimited.
+func (s *Server) SetMaxClientsPerIP(n int) {
+	s.admission.mu.Lock()
+	defer s.admission.mu.Unlock()
+	s.admission.maxPerIP = n
+}
+
+// SetHandshakeRate limits how many handshakes are started per second, with up to burst handshakes at once.
+// A perSecond lower or equal to 0 means unlimited, a burst lower or equal to 0 means perSecond.
+func (s *Server) SetHandshakeRate(perSecond, burst int) {
+	s.admission.handshakes.SetLimit(ratelimit.Limit{BytesPerSecond: perSecond, Burst: burst})
+}
@@ -0,0 +1,45 @@
+package onynet
+
+import "sync"
+
+// Attributes is a concurrency-safe store of application state attached to a connection,
+// such as the user it belongs to. Values are read and written through typed AttrKeys.
+type Attributes struct {
+	values sync.Map // *AttrKey[T] -> T
+}
+
+// AttrKey is a typed key of Attributes. Keys are compared by identity,
+// two keys created with the same name are different keys.
+type AttrKey[T any] struct {
+	name string
+}
+
+// NewAttrKey returns a new key for values of type T, name is only used for debugging.
+func NewAttrKey[T any](name string) *AttrKey[T] {
+	return &AttrKey[T]{name: name}
+}
+
+// String returns the name of the key.
+func (k *AttrKey[T]) String() string {
+	return k.name
+}
+
+// Get returns the value stored under the key, and whether there was one.
+func (k *AttrKey[T]) Get(a *Attributes) (T, bool) {
+	v, ok := a.values.Load(k)
+	if !ok {
+		var zero T
+		return zero, false
+	}
+	return v.(T), true
+}
+
+// Set stores value under the key, replacing the previous one.
+func (k *AttrKey[T]) Set(a *Attributes, value T) {
+	a.values.Store(k, value)
+}
+
+// Delete removes the value stored under the key.
+func (k *AttrKey[T]) Delete(a *Attributes) {
+	a.values.Delete(k)
+}
@@ -0,0 +1,117 @@
+package onynet
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"slices"
+	"sync"
+
+	"github.com/Onyz107/onynet/internal/crypto"
+)
+
+// BroadcastResult holds the outcome of a broadcast for every targeted client.
+type BroadcastResult struct {
+	// Sent holds the ids of the clients the data was sent to.
+	Sent []ClientID
+	// Errors holds the error encountered for every client the data could not be sent to.
+	Errors map[ClientID]error
+}
+
+// Err returns the errors of every failed client joined together, or nil if every send succeeded.
+func (r *BroadcastResult) Err() error {
+	if len(r.Errors) == 0 {
+		return nil
+	}
+
+	ids := make([]ClientID, 0, len(r.Errors))
+	for id := range r.Errors {
+		ids = append(ids, id)
+	}
+	slices.SortFunc(ids, compareClientIDs)
+
+	errs := make([]error, 0, len(ids))
+	for _, id := range ids {
+		errs = append(errs, fmt.Errorf("client %s: %w", id, r.Errors[id]))
+	}
+	return errors.Join(errs...)
+}
+
+type broadcastJob struct {
+	id     ClientID
+	client *ClientConn
+}
+
+// Broadcast sends data to every connected client accepted by filter through the stream with the given name.
+// A nil filter selects every client. The stream is opened on first use and reused by later broadcasts,
+// so clients should accept it once and keep receiving from it.
+//
+// Data is encrypted with the key of each client when authentication is enabled and received with ReceiveEncrypted,
+// and received with ReceiveSerialized otherwise. Sends, encryption included, run concurrently on a bounded number of workers.
+//
+// Possible errors for every client are the same as the ones returned by OpenStream and SendEncrypted.
+func (s *Server) Broadcast(streamName string, data []byte, filter func(id ClientID, c *ClientConn) bool) *BroadcastResult {
+	result := &BroadcastResult{Errors: make(map[ClientID]error)}
+
+	var jobs []broadcastJob
+	for id, client := range s.GetClients() {
+		if filter == nil || filter(id, client) {
+			jobs = append(jobs, broadcastJob{id: id, client: client})
+		}
+	}
+
+	var mu sync.Mutex
+	var wg sync.WaitGroup
+	queue := make(chan broadcastJob)
+
+	for range min(broadcastWorkers, len(jobs)) {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for job := range queue {
+				err := job.client.broadcast(streamName, data)
+
+				mu.Lock()
+				if err != nil {
+					result.Errors[job.id] = err
+				} else {
+					result.Sent = append(result.Sent, job.id)
+				}
+				mu.Unlock()
+			}
+		}()
+	}
+
+	for _, job := range jobs {
+		queue <- job
+	}
+	close(queue)
+	wg.Wait()
+
+	slices.SortFunc(result.Sent, compareClientIDs)
+	return result
+}
+
+func compareClientIDs(a, b ClientID) int {
+	return bytes.Compare(a[:], b[:])
+}
+
+func (cn *ClientConn) broadcast(streamName string, data []byte) error {
+	payload := data
+	if cn.aesKey != nil {
+		ciphertext, err := crypto.EncryptAESGCM(data, cn.aesKey)
+		if err != nil {
+			return err
+		}
+		payload = ciphertext
+	}
+
+	cached, err := cn.cachedStream(streamName, broadcastTimeout)
+	if err != nil {
+		return err
+	}
+
+	cached.mu.Lock()
+	defer cached.mu.Unlock()
+	return cached.stream.SendSerialized(payload, broadcastTimeout)
+}
@@ -4,15 +4,21 @@ import (
 	"context"
 	"crypto/rsa"
 	"errors"
+	"log/slog"
 	"net"
+	"sync"
+	"sync/atomic"
 	"time"
 
 	intErrors "github.com/Onyz107/onynet/errors"
 	"github.com/Onyz107/onynet/internal/auth"
 	"github.com/Onyz107/onynet/internal/heartbeat"
 	"github.com/Onyz107/onynet/internal/kcp"
-	"github.com/Onyz107/onynet/internal/logger"
+	"github.com/Onyz107/onynet/internal/ratelimit"
 	intSmux "github.com/Onyz107/onynet/internal/smux"
+	intTransport "github.com/Onyz107/onynet/internal/transport"
+	"github.com/Onyz107/onynet/metrics"
+	"github.com/Onyz107/onynet/tracing"
 	"github.com/xtaci/smux"
 )
 
@@ -21,16 +27,36 @@ import (
 // only be used when performing operations on the Client, while ClientConn
 // should only be used when performing operations on the Server.
 type Client struct {
-	client    *kcp.Client
-	connected bool
+	client    intTransport.Conn
+	connected atomic.Bool
 	manager   *intSmux.Manager
+	aesKey    []byte
+	datagrams *datagramState
 	ctx       context.Context
+	cancel    context.CancelFunc
+	attrs     Attributes
+	hooks     ClientHooks
+	metrics   *metrics.Metrics
+	logger    *slog.Logger
+	heartbeat atomic.Pointer[intSmux.Stream]
+	// heartbeatDone is closed when the heartbeat ends.
+	heartbeatDone chan struct{}
+	closeOnce     sync.Once
+
+	idleTimeout  atomic.Int64 // time.Duration
+	drainTimeout atomic.Int64 // time.Duration
+
+	streamRules *ratelimit.Rules
+	// relayIdentity signs the keys of the relayed streams the client opens and accepts.
+	relayIdentity *rsa.PrivateKey
 }
 
 // Dial connects to an OnyNet server, optionally authenticates (if publicKey is provided), and returns a client.
+// Options such as WithHooks configure the returned client.
 //
 // Possible errors:
 //   - ErrDial: failed to dial the target address
+//   - ErrRejected: the server refused the connection, joined with ErrConnDenied, ErrTooManyClients, ErrTooManyFromIP or ErrHandshakeRate
 //   - ErrBadAddr: the given address was invalid in the used context
 //   - ErrPublicKey: failed to encrypt authentication challenges
 //   - ErrWrite: failed to send headers to the server
@@ -41,53 +67,115 @@ type Client struct {
 //   - ErrCtxCancelled: context was cancelled while waiting for the heartbeat stream to establish connection
 //   - ErrTimeout: timeout occurred waiting for the heartbeat stream to establish connection
 //   - ErrOpenStream: failed to open a multiplexing stream
-func Dial(addr net.Addr, publicKey *rsa.PublicKey, ctx context.Context) (*Client, error) {
-	client, err := kcp.Dial(addr, ctx)
+func Dial(addr net.Addr, publicKey *rsa.PublicKey, ctx context.Context, opts ...DialOption) (*Client, error) {
+	options := dialOptions{tracer: tracing.Noop, transport: kcp.UDP}
+	for _, opt := range opts {
+		opt(&options)
+	}
+	if options.logger == nil {
+		options.logger = slog.Default()
+	}
+
+	traceCtx, span := options.tracer.Start(ctx, "onynet.Dial", tracing.String("onynet.remote", addr.String()))
+	client, err := dial(addr, publicKey, ctx, options, traceCtx)
+	span.RecordError(err)
+	span.End()
+	return client, err
+}
+
+// dial connects to the server, is admitted and authenticates, then establishes the session and heartbeat.
+func dial(addr net.Addr, publicKey *rsa.PublicKey, ctx context.Context, options dialOptions, traceCtx context.Context) (*Client, error) {
+	_, handshakeSpan := options.tracer.Start(traceCtx, "onynet.handshake")
+	client, err := connect(addr, ctx, options)
 	if err != nil {
-		return nil, errors.Join(intErrors.ErrDial, err)
+		handshakeSpan.RecordError(err)
+		handshakeSpan.End()
+		if errors.Is(err, intErrors.ErrAuth) {
+			options.metrics.Handshake(metrics.SideClient, handshakeResult(err))
+		}
+		return nil, err
 	}
+	logger := options.logger.With("remote_addr", client.RemoteAddr().String())
 
 	var aesKey []byte
 	if publicKey != nil {
 		aesKey, err = auth.AuthorizeSelfClient(client, publicKey)
-		if err != nil {
-			client.Close()
-			return nil, errors.Join(intErrors.ErrAuth, err)
+		if err == nil {
+			err = auth.AuthorizeServer(client, publicKey)
 		}
-
-		if err := auth.AuthorizeServer(client, publicKey); err != nil {
-			client.Close()
-			return nil, errors.Join(intErrors.ErrAuth, err)
+	}
+	handshakeSpan.RecordError(err)
+	handshakeSpan.End()
+	if err != nil {
+		client.Close()
+		if options.hooks.OnAuthFailed != nil {
+			options.hooks.OnAuthFailed(client.RemoteAddr(), err)
 		}
+		err = errors.Join(intErrors.ErrAuth, err)
+		options.metrics.Handshake(metrics.SideClient, handshakeResult(err))
+		return nil, err
 	}
 
-	session, err := smux.Client(client, nil)
+	session, err := smux.Client(client, intSmux.Config())
 	if err != nil {
 		client.Close()
-		return nil, errors.Join(intErrors.ErrCreateSession, err)
+		err = errors.Join(intErrors.ErrCreateSession, err)
+		options.metrics.Handshake(metrics.SideClient, handshakeResult(err))
+		return nil, err
 	}
 
-	manager := intSmux.NewManager(session, aesKey, ctx)
+	onynetClient := &Client{}
+	ctx, cancel := context.WithCancel(context.WithValue(ctx, clientKey{}, onynetClient))
 
-	onynetClient := &Client{
-		client:    client,
-		connected: true,
-		manager:   manager,
-		ctx:       ctx,
+	manager := intSmux.NewManager(session, aesKey, ctx, logger)
+	streamRules := ratelimit.NewRules()
+	manager.SetStreamRules(streamRules)
+	manager.SetInternal(heartbeatStreamName)
+	manager.SetMetrics(options.metrics, metrics.SideClient)
+	manager.SetTracer(options.tracer)
+
+	*onynetClient = Client{
+		client:        client,
+		manager:       manager,
+		aesKey:        aesKey,
+		datagrams:     newDatagramState(datagramToServer),
+		heartbeatDone: make(chan struct{}),
+		ctx:           ctx,
+		cancel:        cancel,
+		streamRules:   streamRules,
+		hooks:         options.hooks,
+		metrics:       options.metrics,
+		logger:        logger,
+		relayIdentity: options.relayIdentity,
 	}
+	onynetClient.connected.Store(true)
+	manager.SetStreamHooks(onynetClient.streamOpened, onynetClient.streamClosed)
 
-	heartbeatStream, err := onynetClient.OpenStream("heartbeatStream", onynetClient.ctx, 5*time.Second)
+	heartbeatStream, err := onynetClient.OpenStreamWithPriority(heartbeatStreamName, PriorityControl, onynetClient.ctx, 5*time.Second)
 	if err != nil {
 		onynetClient.Close()
-		return nil, errors.Join(intErrors.ErrHeartbeatStream, err)
+		err = errors.Join(intErrors.ErrHeartbeatStream, err)
+		options.metrics.Handshake(metrics.SideClient, handshakeResult(err))
+		return nil, err
 	}
+	onynetClient.heartbeat.Store(heartbeatStream)
+
 	go func() {
+		defer close(onynetClient.heartbeatDone)
 		defer heartbeatStream.Close()
-		if err := heartbeat.SendHeartbeat(heartbeatStream, ctx); err != nil {
-			logger.Log.Debugf("closing client because of heartbeat err: %v", err)
-			onynetClient.Close()
+		if err := heartbeat.SendHeartbeat(heartbeatStream, ctx, onynetClient.heartbeatRTT); err != nil {
+			logger.Debug("closing client because of heartbeat error", "error", err, "error_kind", intErrors.Kind(err))
+			onynetClient.closeWithReason(heartbeatReason(err))
 		}
 	}()
+	go watchIdle(ctx, manager, onynetClient.loadIdleTimeout, onynetClient.closeWithReason)
+
+	options.metrics.Handshake(metrics.SideClient, metrics.HandshakeSucceeded)
+	options.metrics.ConnectionOpened(metrics.SideClient)
+
+	if options.hooks.OnConnect != nil {
+		options.hooks.OnConnect(onynetClient)
+	}
 
 	return onynetClient, nil
 }
@@ -107,6 +195,15 @@ func (c *Client) OpenStream(name string, ctx context.Context, timeout time.Durat
 	return c.manager.OpenStream(name, ctx, timeout)
 }
 
+// OpenStreamWithPriority opens a named stream to communicate with the server, whose writes are scheduled with the given priority.
+// Writes of higher priority streams, on both ends of the connection, are sent before the ones of lower priority streams.
+// The ctx argument defines the stream's deadline while timeout defines the handshake's deadline.
+//
+// Possible errors are the same as the ones returned by OpenStream.
+func (c *Client) OpenStreamWithPriority(name string, priority Priority, ctx context.Context, timeout time.Duration) (*intSmux.Stream, error) {
+	return c.manager.OpenStreamWithPriority(name, priority, ctx, timeout)
+}
+
 // AcceptStream accepts an incoming named stream from the server.
 // The ctx argument defines the stream's deadline while timeout defines the handshake's deadline.
 //
@@ -121,18 +218,57 @@ func (c *Client) AcceptStream(name string, ctx context.Context, timeout time.Dur
 	return c.manager.AcceptStream(name, ctx, timeout)
 }
 
+// Context returns the connection's context. It is derived from the context given to Dial,
+// is cancelled when the client is closed and is inherited by every stream of the connection.
+// ClientFromContext retrieves the Client from it.
+func (c *Client) Context() context.Context {
+	return c.ctx
+}
+
+// Attributes returns the store of application state attached to the connection.
+func (c *Client) Attributes() *Attributes {
+	return &c.attrs
+}
+
 // IsConnected returns true if the client is currently connected to the server, and false otherwise.
 // The connection status is tracked by a variable that is set to true when a connection is established,
 // and set to false when the Close function is called (for example, after a heartbeat failure or a manual disconnect).
 func (c *Client) IsConnected() bool {
-	return c.connected
+	return c.connected.Load()
 }
 
-// Close gracefully closes client connections and streams.
+// Close gracefully closes client connections and streams, it is the same as CloseWithCode with CloseNormal and no reason.
 func (c *Client) Close() error {
-	c.connected = false
+	return c.CloseWithCode(CloseNormal, "")
+}
+
+// CloseWithCode gracefully closes client connections and streams, sending the code and reason to the server.
+// Reasons longer than 123 bytes are truncated. The streams in flight are given the drain timeout to finish
+// (see SetDrainTimeout). The disconnect reason given to the OnDisconnect hook is ErrClosedLocally joined
+// with a CloseError, the server's is ErrPeerClosed joined with the same CloseError.
+func (c *Client) CloseWithCode(code CloseCode, reason string) error {
+	return c.closeWithReason(errors.Join(intErrors.ErrClosedLocally, &CloseError{Code: code, Reason: reason}))
+}
+
+func (c *Client) closeWithReason(reason error) error {
+	closing := false
+	c.closeOnce.Do(func() { closing = true })
+	if !closing {
+		return nil
+	}
+
+	c.connected.Store(false)
 	var errs []error
 
+	heartbeatStream := c.heartbeat.Load()
+	closeGracefully(c.manager, heartbeatStream, c.heartbeatDone, reason, c.loadDrainTimeout(), c.logger)
+
+	// Closing the heartbeat stream first tells the server the connection is closed on purpose.
+	if heartbeatStream != nil {
+		heartbeatStream.Close()
+	}
+	c.cancel()
+
 	if err := c.client.Close(); err != nil {
 		errs = append(errs, err)
 	}
@@ -141,5 +277,32 @@ func (c *Client) Close() error {
 		errs = append(errs, err)
 	}
 
+	if heartbeatStream != nil {
+		c.metrics.ConnectionClosed(metrics.SideClient)
+		if c.hooks.OnDisconnect != nil {
+			c.hooks.OnDisconnect(c, reason)
+		}
+	}
+
 	return errors.Join(errs...)
 }
+
+func (c *Client) streamOpened(stream *intSmux.Stream) {
+	if c.hooks.OnStreamOpened != nil {
+		c.hooks.OnStreamOpened(c, stream)
+	}
+}
+
+func (c *Client) streamClosed(stream *intSmux.Stream) {
+	if c.hooks.OnStreamClosed != nil {
+		c.hooks.OnStreamClosed(c, stream)
+	}
+}
+
+func (c *Client) heartbeatRTT(rtt time.Duration) {
+	c.metrics.HeartbeatRTT(metrics.SideClient, rtt)
+}
+
+func (c *Client) loadIdleTimeout() time.Duration {
+	return time.Duration(c.idleTimeout.Load())
+}
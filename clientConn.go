@@ -3,11 +3,16 @@ package onynet
 import (
 	"context"
 	"errors"
+	"log/slog"
 	"net"
+	"sync"
+	"sync/atomic"
 	"time"
 
-	"github.com/Onyz107/onynet/internal/kcp"
+	intErrors "github.com/Onyz107/onynet/errors"
 	intSmux "github.com/Onyz107/onynet/internal/smux"
+	intTransport "github.com/Onyz107/onynet/internal/transport"
+	"github.com/Onyz107/onynet/metrics"
 )
 
 // ClientConn defines a client that is connected to a server.
@@ -15,10 +20,35 @@ import (
 // only be used when performing operations on the Client, while ClientConn
 // should only be used when performing operations on the Server.
 type ClientConn struct {
-	client    *kcp.ClientConn
-	connected bool
+	id        ClientID
+	client    intTransport.Conn
+	connected atomic.Bool
 	manager   *intSmux.Manager
+	aesKey    []byte
+	datagrams *datagramState
 	ctx       context.Context
+	cancel    context.CancelFunc
+	attrs     Attributes
+	hooks     func() *ServerHooks
+	drain     func() time.Duration
+	metrics   *metrics.Metrics
+	logger    *slog.Logger
+	heartbeat atomic.Pointer[intSmux.Stream]
+	// heartbeatDone is closed when the heartbeat ends.
+	heartbeatDone chan struct{}
+	closeOnce     sync.Once
+
+	cachedStreams map[string]*cachedStream
+	cachedMu      sync.Mutex
+
+	// release removes the client from the server and frees its admission slot.
+	release func()
+}
+
+// cachedStream is a stream kept open by the server to be reused between broadcasts.
+type cachedStream struct {
+	stream *intSmux.Stream
+	mu     sync.Mutex
 }
 
 // OpenStream opens a named stream to communicate with the client.
@@ -36,6 +66,15 @@ func (cn *ClientConn) OpenStream(name string, ctx context.Context, timeout time.
 	return cn.manager.OpenStream(name, ctx, timeout)
 }
 
+// OpenStreamWithPriority opens a named stream to communicate with the client, whose writes are scheduled with the given priority.
+// Writes of higher priority streams, on both ends of the connection, are sent before the ones of lower priority streams.
+// The ctx argument defines the stream's deadline while timeout defines the handshake's deadline.
+//
+// Possible errors are the same as the ones returned by OpenStream.
+func (cn *ClientConn) OpenStreamWithPriority(name string, priority Priority, ctx context.Context, timeout time.Duration) (*intSmux.Stream, error) {
+	return cn.manager.OpenStreamWithPriority(name, priority, ctx, timeout)
+}
+
 // AcceptStream accepts an incoming named stream from the client.
 // The ctx argument defines the stream's deadline while timeout defines the handshake's deadline.
 //
@@ -50,11 +89,63 @@ func (cn *ClientConn) AcceptStream(name string, ctx context.Context, timeout tim
 	return cn.manager.AcceptStream(name, ctx, timeout)
 }
 
+// cachedStream returns the stream with the given name kept open by the server, opening it if needed.
+func (cn *ClientConn) cachedStream(name string, timeout time.Duration) (*cachedStream, error) {
+	cn.cachedMu.Lock()
+	defer cn.cachedMu.Unlock()
+
+	if cached, ok := cn.cachedStreams[name]; ok {
+		select {
+		case <-cached.stream.GetDieCh():
+			delete(cn.cachedStreams, name)
+		default:
+			return cached, nil
+		}
+	}
+
+	stream, err := cn.OpenStream(name, cn.ctx, timeout)
+	if err != nil {
+		return nil, err
+	}
+
+	cached := &cachedStream{stream: stream}
+	cn.cachedStreams[name] = cached
+	return cached, nil
+}
+
+// closeCachedStreams closes the streams kept open for broadcasts, which would otherwise hold back a drain.
+func (cn *ClientConn) closeCachedStreams() {
+	cn.cachedMu.Lock()
+	defer cn.cachedMu.Unlock()
+
+	for name, cached := range cn.cachedStreams {
+		cached.stream.Close()
+		delete(cn.cachedStreams, name)
+	}
+}
+
 // IsConnected returns true if the client is currently connected to the server, and false otherwise.
 // The connection status is tracked by a variable that is set to true when a connection is established,
 // and set to false when the Close function is called (for example, after a heartbeat failure or a manual disconnect).
 func (cn *ClientConn) IsConnected() bool {
-	return cn.connected
+	return cn.connected.Load()
+}
+
+// Context returns the connection's context. It is derived from the server's context,
+// is cancelled when the connection is closed and is inherited by every stream of the connection.
+// ClientConnFromContext retrieves the ClientConn from it.
+func (cn *ClientConn) Context() context.Context {
+	return cn.ctx
+}
+
+// Attributes returns the store of application state attached to the connection.
+func (cn *ClientConn) Attributes() *Attributes {
+	return &cn.attrs
+}
+
+// ID returns the ID the server assigned to the client.
+func (cn *ClientConn) ID() ClientID {
+	return cn.id
 }
 
 // LocalAddr returns the client's local address.
@@ -67,11 +158,40 @@ func (cn *ClientConn) RemoteAddr() net.Addr {
 	return cn.client.RemoteAddr()
 }
 
-// Close closes the client connection and streams.
+// Close gracefully closes the client connection and streams, it is the same as CloseWithCode with CloseNormal and no reason.
 func (cn *ClientConn) Close() error {
-	cn.connected = false
+	return cn.CloseWithCode(CloseNormal, "")
+}
+
+// CloseWithCode gracefully closes the client connection and streams, sending the code and reason to the client.
+// Reasons longer than 123 bytes are truncated. The streams in flight are given the drain timeout to finish
+// (see Server.SetDrainTimeout). The disconnect reason given to the OnDisconnect hook is ErrClosedLocally joined
+// with a CloseError, the client's is ErrPeerClosed joined with the same CloseError.
+func (cn *ClientConn) CloseWithCode(code CloseCode, reason string) error {
+	return cn.closeWithReason(errors.Join(intErrors.ErrClosedLocally, &CloseError{Code: code, Reason: reason}))
+}
+
+func (cn *ClientConn) closeWithReason(reason error) error {
+	closing := false
+	cn.closeOnce.Do(func() { closing = true })
+	if !closing {
+		return nil
+	}
+
+	cn.connected.Store(false)
 	var errs []error
 
+	heartbeatStream := cn.heartbeat.Load()
+	cn.closeCachedStreams()
+	closeGracefully(cn.manager, heartbeatStream, cn.heartbeatDone, reason, cn.drain(), cn.logger)
+
+	// Closing the heartbeat stream first tells the client the connection is closed on purpose.
+	if heartbeatStream != nil {
+		heartbeatStream.Close()
+	}
+	cn.cancel()
+	cn.release()
+
 	if err := cn.client.Close(); err != nil {
 		errs = append(errs, err)
 	}
@@ -80,5 +200,24 @@ func (cn *ClientConn) Close() error {
 		errs = append(errs, err)
 	}
 
+	if heartbeatStream != nil {
+		cn.metrics.ConnectionClosed(metrics.SideServer)
+		if hooks := cn.hooks(); hooks.OnDisconnect != nil {
+			hooks.OnDisconnect(cn, reason)
+		}
+	}
+
 	return errors.Join(errs...)
 }
+
+func (cn *ClientConn) streamOpened(stream *intSmux.Stream) {
+	if hooks := cn.hooks(); hooks.OnStreamOpened != nil {
+		hooks.OnStreamOpened(cn, stream)
+	}
+}
+
+func (cn *ClientConn) streamClosed(stream *intSmux.Stream) {
+	if hooks := cn.hooks(); hooks.OnStreamClosed != nil {
+		hooks.OnStreamClosed(cn, stream)
+	}
+}
@@ -0,0 +1,24 @@
+package onynet
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+)
+
+// ClientID identifies a client connection accepted by a Server.
+// IDs are random, unique for the lifetime of the process and never reused,
+// a client which reconnects gets a new ID. The zero ClientID is never assigned.
+type ClientID [16]byte
+
+func newClientID() ClientID {
+	var id ClientID
+	if _, err := rand.Read(id[:]); err != nil {
+		panic(err)
+	}
+	return id
+}
+
+// String returns the hexadecimal representation of the ID.
+func (id ClientID) String() string {
+	return hex.EncodeToString(id[:])
+}
@@ -0,0 +1,70 @@
+package onynet
+
+import (
+	"errors"
+	"log/slog"
+	"time"
+
+	intErrors "github.com/Onyz107/onynet/errors"
+	"github.com/Onyz107/onynet/internal/heartbeat"
+	intSmux "github.com/Onyz107/onynet/internal/smux"
+)
+
+// closeGracefully runs the graceful part of closing a connection when reason carries a CloseError:
+// new streams are refused, the close frame is sent to the peer unless it is the one who sent it,
+// and the streams in flight are given up to drainTimeout to finish. Once a close frame is sent, it waits up to
+// closeAckTimeout for the peer to acknowledge it by closing its end of the heartbeat stream, which ends the
+// heartbeat and closes heartbeatDone, so the frame is not dropped along with the connection.
+func closeGracefully(manager *intSmux.Manager, heartbeatStream *intSmux.Stream, heartbeatDone <-chan struct{}, reason error, drainTimeout time.Duration, logger *slog.Logger) {
+	var closeErr *CloseError
+	if heartbeatStream == nil || !errors.As(reason, &closeErr) {
+		return
+	}
+
+	manager.SetClosing()
+	sent := false
+	if !errors.Is(reason, intErrors.ErrPeerClosed) {
+		if err := heartbeat.SendClose(heartbeatStream, closeErr.Code, closeErr.Reason); err != nil {
+			logger.Debug("failed to send close frame", "error", err, "error_kind", intErrors.Kind(err))
+		} else {
+			sent = true
+		}
+	}
+
+	if drainTimeout > 0 && !manager.Drain(drainTimeout) {
+		logger.Debug("closing connection with streams still open after the drain timeout")
+	}
+
+	if sent {
+		timer := time.NewTimer(closeAckTimeout)
+		defer timer.Stop()
+		select {
+		case <-heartbeatDone:
+		case <-timer.C:
+			logger.Debug("closing connection before the peer acknowledged the close frame")
+		}
+	}
+}
+
+// SetDrainTimeout sets how long a graceful close, started by either end, waits for the streams
+// of a connection to be closed before closing them. New streams are refused in the meantime.
+// A d of 0 closes the streams right away, which is the default.
+// It applies immediately, including to connections already accepted.
+func (s *Server) SetDrainTimeout(d time.Duration) {
+	s.drainTimeout.Store(int64(d))
+}
+
+func (s *Server) loadDrainTimeout() time.Duration {
+	return time.Duration(s.drainTimeout.Load())
+}
+
+// SetDrainTimeout sets how long a graceful close, started by either end, waits for the streams
+// of the connection to be closed before closing them. New streams are refused in the meantime.
+// A d of 0 closes the streams right away, which is the default.
+func (c *Client) SetDrainTimeout(d time.Duration) {
+	c.drainTimeout.Store(int64(d))
+}
+
+func (c *Client) loadDrainTimeout() time.Duration {
+	return time.Duration(c.drainTimeout.Load())
+}
@@ -0,0 +1,236 @@
+// Command onynet-forward forwards TCP ports over an onynet connection, like the -L and -R options of SSH.
+//
+// The server side dials and listens on behalf of its clients, within the addresses it allows:
+//
+//	onynet-forward server -listen :7000 -key server.pem -allow-dial localhost:22 -allow-listen 'localhost:*'
+//
+// The client side forwards local ports to targets dialed by the server (-L) and ports the server listens on
+// to targets dialed locally (-R), both given as [bind_address:]port:host:hostport:
+//
+//	onynet-forward client -server example.com:7000 -pubkey server.pub.pem -L 2222:localhost:22 -R 8080:localhost:3000
+//
+// Keys are RSA keys in PEM files, such as the ones created by:
+//
+//	openssl genrsa -out server.pem 2048
+//	openssl rsa -in server.pem -pubout -out server.pub.pem
+//
+// Without keys the connection is not authenticated nor encrypted.
+package main
+
+import (
+	"context"
+	"crypto/rsa"
+	"flag"
+	"fmt"
+	"log/slog"
+	"net"
+	"os"
+	"os/signal"
+	"strings"
+	"syscall"
+
+	"github.com/Onyz107/onynet"
+	intErrors "github.com/Onyz107/onynet/errors"
+	"github.com/Onyz107/onynet/forward"
+	"github.com/Onyz107/onynet/internal/keyfile"
+)
+
+// listFlag is a flag which may be given several times.
+type listFlag []string
+
+func (f *listFlag) String() string { return strings.Join(*f, ",") }
+
+func (f *listFlag) Set(value string) error {
+	*f = append(*f, value)
+	return nil
+}
+
+func main() {
+	if len(os.Args) < 2 {
+		usage()
+	}
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	var err error
+	switch os.Args[1] {
+	case "server":
+		err = runServer(os.Args[2:], ctx)
+	case "client":
+		err = runClient(os.Args[2:], ctx)
+	default:
+		usage()
+	}
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "onynet-forward:", err)
+		os.Exit(1)
+	}
+}
+
+func usage() {
+	fmt.Fprintln(os.Stderr, "usage: onynet-forward server|client [flags]")
+	os.Exit(2)
+}
+
+func runServer(args []string, ctx context.Context) error {
+	flags := flag.NewFlagSet("server", flag.ExitOnError)
+	listen := flags.String("listen", ":7000", "UDP address to accept clients on")
+	keyPath := flags.String("key", "", "PEM file of the server's RSA private key")
+	var allowDial, allowListen listFlag
+	flags.Var(&allowDial, "allow-dial", "host:port clients may forward to, * matches any host or port (repeatable)")
+	flags.Var(&allowListen, "allow-listen", "host:port clients may have the server listen on, * matches any host or port (repeatable)")
+	flags.Parse(args)
+
+	var privateKey *rsa.PrivateKey
+	if *keyPath != "" {
+		key, err := keyfile.LoadPrivateKey(*keyPath)
+		if err != nil {
+			return err
+		}
+		privateKey = key
+	} else {
+		slog.Warn("no key given, clients are not authenticated")
+	}
+
+	addr, err := net.ResolveUDPAddr("udp", *listen)
+	if err != nil {
+		return err
+	}
+	server, err := onynet.NewServer(addr, privateKey, ctx)
+	if err != nil {
+		return err
+	}
+	defer server.Close()
+
+	fwd := forward.NewServer()
+	if len(allowDial) > 0 {
+		fwd.SetDialRule(forward.AllowAddrs(allowDial...))
+	}
+	if len(allowListen) > 0 {
+		fwd.SetListenRule(forward.AllowAddrs(allowListen...))
+	}
+
+	go func() {
+		<-ctx.Done()
+		server.Close()
+	}()
+
+	slog.Info("accepting clients", "addr", server.Addr())
+	for {
+		clientConn, err := server.Accept()
+		if err != nil {
+			if ctx.Err() != nil {
+				return nil
+			}
+			return err
+		}
+		slog.Info("client connected", "addr", clientConn.RemoteAddr())
+		go func() {
+			defer clientConn.Close()
+			err := fwd.Serve(clientConn, ctx)
+			slog.Info("client disconnected", "addr", clientConn.RemoteAddr(), "reason", err, "error_kind", intErrors.Kind(err))
+		}()
+	}
+}
+
+func runClient(args []string, ctx context.Context) error {
+	flags := flag.NewFlagSet("client", flag.ExitOnError)
+	serverAddr := flags.String("server", "", "UDP address of the server")
+	pubKeyPath := flags.String("pubkey", "", "PEM file of the server's RSA public key")
+	var locals, remotes listFlag
+	flags.Var(&locals, "L", "[bind_address:]port:host:hostport forwarded to host:hostport dialed by the server (repeatable)")
+	flags.Var(&remotes, "R", "[bind_address:]port:host:hostport the server listens on, forwarded to host:hostport (repeatable)")
+	flags.Parse(args)
+
+	if *serverAddr == "" || len(locals)+len(remotes) == 0 {
+		flags.Usage()
+		os.Exit(2)
+	}
+
+	var publicKey *rsa.PublicKey
+	if *pubKeyPath != "" {
+		key, err := keyfile.LoadPublicKey(*pubKeyPath)
+		if err != nil {
+			return err
+		}
+		publicKey = key
+	} else {
+		slog.Warn("no public key given, the server is not authenticated")
+	}
+
+	addr, err := net.ResolveUDPAddr("udp", *serverAddr)
+	if err != nil {
+		return err
+	}
+	client, err := onynet.Dial(addr, publicKey, ctx)
+	if err != nil {
+		return err
+	}
+	defer client.Close()
+
+	for _, spec := range locals {
+		bind, target, err := parseMapping(spec)
+		if err != nil {
+			return err
+		}
+		local, err := forward.ListenLocal(client, bind, target, ctx)
+		if err != nil {
+			return err
+		}
+		defer local.Close()
+		slog.Info("forwarding local port", "listen", local.Addr(), "target", target)
+	}
+
+	for _, spec := range remotes {
+		bind, target, err := parseMapping(spec)
+		if err != nil {
+			return err
+		}
+		remote, err := forward.ListenRemote(client, bind, target, ctx)
+		if err != nil {
+			return err
+		}
+		defer remote.Close()
+		slog.Info("forwarding remote port", "listen", remote.Addr(), "target", target)
+	}
+
+	select {
+	case <-ctx.Done():
+	case <-client.Context().Done():
+		return fmt.Errorf("disconnected from %s", addr)
+	}
+	return nil
+}
+
+// parseMapping parses [bind_address:]port:host:hostport into the address to listen on, loopback when the bind
+// address is omitted, and the target. IPv6 addresses are written in brackets.
+func parseMapping(spec string) (bind, target string, err error) {
+	var fields []string
+	start, depth := 0, 0
+	for i, r := range spec {
+		switch r {
+		case '[':
+			depth++
+		case ']':
+			depth--
+		case ':':
+			if depth == 0 {
+				fields = append(fields, spec[start:i])
+				start = i + 1
+			}
+		}
+	}
+	fields = append(fields, spec[start:])
+
+	switch len(fields) {
+	case 3:
+		bind = net.JoinHostPort("localhost", fields[0])
+	case 4:
+		bind = net.JoinHostPort(strings.Trim(fields[0], "[]"), fields[1])
+	default:
+		return "", "", fmt.Errorf("invalid forward %q, expected [bind_address:]port:host:hostport", spec)
+	}
+	host, port := fields[len(fields)-2], fields[len(fields)-1]
+	return bind, net.JoinHostPort(strings.Trim(host, "[]"), port), nil
+}
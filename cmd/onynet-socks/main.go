@@ -0,0 +1,186 @@
+// Command onynet-socks runs a SOCKS5 proxy whose connections are tunneled over an onynet connection,
+// so any TCP or UDP application supporting SOCKS5 benefits from it.
+//
+// The server side connects to the destinations its clients ask for, within the addresses it allows:
+//
+//	onynet-socks server -listen :7000 -key server.pem -allow-connect '*:443' -allow-udp '1.1.1.1:53'
+//
+// The client side accepts SOCKS5 clients, without authentication, on a local address:
+//
+//	onynet-socks client -server example.com:7000 -pubkey server.pub.pem -listen 127.0.0.1:1080 -datagrams
+//
+// Keys are RSA keys in PEM files, such as the ones created by:
+//
+//	openssl genrsa -out server.pem 2048
+//	openssl rsa -in server.pem -pubout -out server.pub.pem
+//
+// Without keys the connection is not authenticated nor encrypted.
+package main
+
+import (
+	"context"
+	"crypto/rsa"
+	"flag"
+	"fmt"
+	"log/slog"
+	"net"
+	"os"
+	"os/signal"
+	"strings"
+	"syscall"
+
+	"github.com/Onyz107/onynet"
+	intErrors "github.com/Onyz107/onynet/errors"
+	"github.com/Onyz107/onynet/forward"
+	"github.com/Onyz107/onynet/internal/keyfile"
+	"github.com/Onyz107/onynet/socks"
+)
+
+// listFlag is a flag which may be given several times.
+type listFlag []string
+
+func (f *listFlag) String() string { return strings.Join(*f, ",") }
+
+func (f *listFlag) Set(value string) error {
+	*f = append(*f, value)
+	return nil
+}
+
+func main() {
+	if len(os.Args) < 2 {
+		usage()
+	}
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	var err error
+	switch os.Args[1] {
+	case "server":
+		err = runServer(os.Args[2:], ctx)
+	case "client":
+		err = runClient(os.Args[2:], ctx)
+	default:
+		usage()
+	}
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "onynet-socks:", err)
+		os.Exit(1)
+	}
+}
+
+func usage() {
+	fmt.Fprintln(os.Stderr, "usage: onynet-socks server|client [flags]")
+	os.Exit(2)
+}
+
+func runServer(args []string, ctx context.Context) error {
+	flags := flag.NewFlagSet("server", flag.ExitOnError)
+	listen := flags.String("listen", ":7000", "UDP address to accept clients on")
+	keyPath := flags.String("key", "", "PEM file of the server's RSA private key")
+	var allowConnect, allowUDP listFlag
+	flags.Var(&allowConnect, "allow-connect", "host:port clients may connect to, * matches any host or port (repeatable)")
+	flags.Var(&allowUDP, "allow-udp", "host:port clients may send UDP packets to, * matches any host or port (repeatable)")
+	flags.Parse(args)
+
+	var privateKey *rsa.PrivateKey
+	if *keyPath != "" {
+		key, err := keyfile.LoadPrivateKey(*keyPath)
+		if err != nil {
+			return err
+		}
+		privateKey = key
+	} else {
+		slog.Warn("no key given, clients are not authenticated")
+	}
+
+	addr, err := net.ResolveUDPAddr("udp", *listen)
+	if err != nil {
+		return err
+	}
+	server, err := onynet.NewServer(addr, privateKey, ctx)
+	if err != nil {
+		return err
+	}
+	defer server.Close()
+
+	proxy := socks.NewServer()
+	if len(allowConnect) > 0 {
+		proxy.SetConnectRule(forward.AllowAddrs(allowConnect...))
+	}
+	if len(allowUDP) > 0 {
+		proxy.SetAssociateRule(forward.AllowAddrs(allowUDP...))
+	}
+
+	go func() {
+		<-ctx.Done()
+		server.Close()
+	}()
+
+	slog.Info("accepting clients", "addr", server.Addr())
+	for {
+		clientConn, err := server.Accept()
+		if err != nil {
+			if ctx.Err() != nil {
+				return nil
+			}
+			return err
+		}
+		slog.Info("client connected", "addr", clientConn.RemoteAddr())
+		go func() {
+			defer clientConn.Close()
+			err := proxy.Serve(clientConn, ctx)
+			slog.Info("client disconnected", "addr", clientConn.RemoteAddr(), "reason", err, "error_kind", intErrors.Kind(err))
+		}()
+	}
+}
+
+func runClient(args []string, ctx context.Context) error {
+	flags := flag.NewFlagSet("client", flag.ExitOnError)
+	serverAddr := flags.String("server", "", "UDP address of the server")
+	pubKeyPath := flags.String("pubkey", "", "PEM file of the server's RSA public key")
+	listen := flags.String("listen", "127.0.0.1:1080", "address to accept SOCKS5 clients on")
+	datagrams := flags.Bool("datagrams", false, "relay UDP packets as datagrams instead of on a stream")
+	flags.Parse(args)
+
+	if *serverAddr == "" {
+		flags.Usage()
+		os.Exit(2)
+	}
+
+	var publicKey *rsa.PublicKey
+	if *pubKeyPath != "" {
+		key, err := keyfile.LoadPublicKey(*pubKeyPath)
+		if err != nil {
+			return err
+		}
+		publicKey = key
+	} else {
+		slog.Warn("no public key given, the server is not authenticated")
+	}
+
+	addr, err := net.ResolveUDPAddr("udp", *serverAddr)
+	if err != nil {
+		return err
+	}
+	client, err := onynet.Dial(addr, publicKey, ctx)
+	if err != nil {
+		return err
+	}
+	defer client.Close()
+
+	proxy, err := socks.Listen(client, *listen, ctx)
+	if err != nil {
+		return err
+	}
+	defer proxy.Close()
+	proxy.SetDatagrams(*datagrams)
+	slog.Info("accepting SOCKS5 clients", "addr", proxy.Addr())
+
+	select {
+	case <-ctx.Done():
+	case <-client.Context().Done():
+		return fmt.Errorf("disconnected from %s", addr)
+	}
+	return nil
+}
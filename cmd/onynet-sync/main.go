@@ -0,0 +1,171 @@
+// Command onynet-sync mirrors directory trees between a client and a server over an onynet connection,
+// sending only the blocks of files which changed, like rsync.
+//
+// The server side serves the directories under a root, which clients may only pull unless it is writable:
+//
+//	onynet-sync server -listen :7000 -key server.pem -root /srv/mirrors -writable
+//
+// The client side pushes a local directory to a directory under the server's root, or pulls one:
+//
+//	onynet-sync push -server example.com:7000 -pubkey server.pub.pem -delete ./site site
+//	onynet-sync pull -server example.com:7000 -pubkey server.pub.pem site ./site
+//
+// Keys are RSA keys in PEM files, such as the ones created by:
+//
+//	openssl genrsa -out server.pem 2048
+//	openssl rsa -in server.pem -pubout -out server.pub.pem
+//
+// Without keys the connection is not authenticated nor encrypted.
+package main
+
+import (
+	"context"
+	"crypto/rsa"
+	"flag"
+	"fmt"
+	"log/slog"
+	"net"
+	"os"
+	"os/signal"
+	"syscall"
+
+	"github.com/Onyz107/onynet"
+	"github.com/Onyz107/onynet/dirsync"
+	intErrors "github.com/Onyz107/onynet/errors"
+	"github.com/Onyz107/onynet/internal/keyfile"
+)
+
+func main() {
+	if len(os.Args) < 2 {
+		usage()
+	}
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	var err error
+	switch os.Args[1] {
+	case "server":
+		err = runServer(os.Args[2:], ctx)
+	case "push", "pull":
+		err = runClient(os.Args[1], os.Args[2:], ctx)
+	default:
+		usage()
+	}
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "onynet-sync:", err)
+		os.Exit(1)
+	}
+}
+
+func usage() {
+	fmt.Fprintln(os.Stderr, "usage: onynet-sync server [flags]")
+	fmt.Fprintln(os.Stderr, "       onynet-sync push [flags] local-dir remote-dir")
+	fmt.Fprintln(os.Stderr, "       onynet-sync pull [flags] remote-dir local-dir")
+	os.Exit(2)
+}
+
+func runServer(args []string, ctx context.Context) error {
+	flags := flag.NewFlagSet("server", flag.ExitOnError)
+	listen := flags.String("listen", ":7000", "UDP address to accept clients on")
+	keyPath := flags.String("key", "", "PEM file of the server's RSA private key")
+	root := flags.String("root", ".", "directory holding the synchronized directories")
+	writable := flags.Bool("writable", false, "let clients push to the directories under the root")
+	flags.Parse(args)
+
+	var privateKey *rsa.PrivateKey
+	if *keyPath != "" {
+		key, err := keyfile.LoadPrivateKey(*keyPath)
+		if err != nil {
+			return err
+		}
+		privateKey = key
+	} else {
+		slog.Warn("no key given, clients are not authenticated")
+	}
+
+	addr, err := net.ResolveUDPAddr("udp", *listen)
+	if err != nil {
+		return err
+	}
+	server, err := onynet.NewServer(addr, privateKey, ctx)
+	if err != nil {
+		return err
+	}
+	defer server.Close()
+
+	syncServer := dirsync.NewServer(*root)
+	syncServer.SetWritable(*writable)
+
+	go func() {
+		<-ctx.Done()
+		server.Close()
+	}()
+
+	slog.Info("accepting clients", "addr", server.Addr(), "root", *root, "writable", *writable)
+	for {
+		clientConn, err := server.Accept()
+		if err != nil {
+			if ctx.Err() != nil {
+				return nil
+			}
+			return err
+		}
+		slog.Info("client connected", "addr", clientConn.RemoteAddr())
+		go func() {
+			defer clientConn.Close()
+			err := syncServer.Serve(clientConn, ctx)
+			slog.Info("client disconnected", "addr", clientConn.RemoteAddr(), "reason", err, "error_kind", intErrors.Kind(err))
+		}()
+	}
+}
+
+func runClient(command string, args []string, ctx context.Context) error {
+	flags := flag.NewFlagSet(command, flag.ExitOnError)
+	serverAddr := flags.String("server", "", "UDP address of the server")
+	pubKeyPath := flags.String("pubkey", "", "PEM file of the server's RSA public key")
+	remove := flags.Bool("delete", false, "delete the files of the destination which the source does not have")
+	workers := flags.Int("workers", dirsync.DefaultWorkers, "number of files transferred in parallel")
+	flags.Parse(args)
+
+	if *serverAddr == "" || flags.NArg() != 2 {
+		usage()
+	}
+
+	var publicKey *rsa.PublicKey
+	if *pubKeyPath != "" {
+		key, err := keyfile.LoadPublicKey(*pubKeyPath)
+		if err != nil {
+			return err
+		}
+		publicKey = key
+	} else {
+		slog.Warn("no public key given, the server is not authenticated")
+	}
+
+	addr, err := net.ResolveUDPAddr("udp", *serverAddr)
+	if err != nil {
+		return err
+	}
+	client, err := onynet.Dial(addr, publicKey, ctx)
+	if err != nil {
+		return err
+	}
+	defer client.Close()
+
+	opts := []dirsync.Option{dirsync.WithWorkers(*workers)}
+	if *remove {
+		opts = append(opts, dirsync.WithDelete())
+	}
+
+	var stats *dirsync.Stats
+	if command == "push" {
+		stats, err = dirsync.Push(client, flags.Arg(0), flags.Arg(1), ctx, opts...)
+	} else {
+		stats, err = dirsync.Pull(client, flags.Arg(0), flags.Arg(1), ctx, opts...)
+	}
+	if stats != nil {
+		slog.Info("synchronized", "files", stats.Files, "sent", stats.Literal, "matched", stats.Matched, "deleted", stats.Deleted)
+	}
+	return err
+}
@@ -1,9 +1,118 @@
 package onynet
 
 import (
+	"time"
+
+	intErrors "github.com/Onyz107/onynet/errors"
+	"github.com/Onyz107/onynet/internal/kcp"
+	"github.com/Onyz107/onynet/internal/ratelimit"
 	"github.com/Onyz107/onynet/internal/smux"
 )
 
+const (
+	// broadcastWorkers is the maximum number of clients a broadcast sends to concurrently.
+	broadcastWorkers = 16
+	// broadcastTimeout is the deadline for opening a broadcast stream and sending data through it.
+	broadcastTimeout = 5 * time.Second
+	// idleCheckInterval is the longest time between two checks of a connection's idle timeout.
+	idleCheckInterval = time.Second
+	// closeAckTimeout is how long a graceful close waits for the peer to acknowledge the close frame.
+	closeAckTimeout = time.Second
+	// happyEyeballsDelay is the head start KCP gets over TCP when they are raced by Dial.
+	happyEyeballsDelay = 250 * time.Millisecond
+	// webSocketNetwork is the network of a WebSocketAddr.
+	webSocketNetwork = "websocket"
+	// relayTimeout bounds the relay handshake, from the request of the opening client to the key exchange.
+	relayTimeout = 10 * time.Second
+)
+
+// Stream is a named stream of a connection, as returned by OpenStream and AcceptStream.
+type Stream = smux.Stream
+
+// Priority defines how a stream's writes are scheduled against the other streams of the connection.
+// Lower values are written first.
+type Priority = smux.Priority
+
+const (
+	// PriorityControl is for control messages that must never wait behind data.
+	PriorityControl = smux.PriorityControl
+	// PriorityInteractive is for latency sensitive traffic such as chat or game input.
+	PriorityInteractive = smux.PriorityInteractive
+	// PriorityNormal is the default priority.
+	PriorityNormal = smux.PriorityNormal
+	// PriorityBulk is for large transfers which should only use otherwise idle capacity.
+	PriorityBulk = smux.PriorityBulk
+)
+
+// PacketTransport creates the packet sockets connections run on, UDP unless another one is given
+// to NewServerWithTransport or WithPacketTransport.
+type PacketTransport = kcp.Transport
+
+// RateLimit defines a token bucket applied to each direction of a transfer:
+// BytesPerSecond is the sustained rate and Burst the number of bytes that can be transferred at once after being idle.
+// A BytesPerSecond lower or equal to 0 means unlimited, a Burst lower or equal to 0 means one second worth of bytes.
+type RateLimit = ratelimit.Limit
+
+// Unlimited is a RateLimit which never throttles.
+var Unlimited = ratelimit.Unlimited
+
+// CloseCode is the numeric code a connection is closed gracefully with, it is sent to the peer along with a reason.
+// The codes follow the ones of WebSocket close frames, applications may use the range 4000 to 4999 for their own.
+type CloseCode = intErrors.CloseCode
+
+// CloseError carries the code and reason of a graceful close, it is joined with the disconnect reason
+// given to the OnDisconnect hook of both ends and can be retrieved with errors.As.
+type CloseError = intErrors.CloseError
+
+const (
+	// CloseNormal means the connection was done and closed on purpose, it is used by Close.
+	CloseNormal CloseCode = 1000
+	// CloseGoingAway means the endpoint is going away, such as a server shutting down or an idle connection being closed.
+	CloseGoingAway CloseCode = 1001
+	// CloseProtocolError means the peer did not follow the application's protocol.
+	CloseProtocolError CloseCode = 1002
+	// ClosePolicyViolation means the peer broke a policy of the application.
+	ClosePolicyViolation CloseCode = 1008
+	// CloseInternalError means the endpoint hit an unexpected condition.
+	CloseInternalError CloseCode = 1011
+	// CloseServiceRestart means the endpoint is restarting and the peer may reconnect.
+	CloseServiceRestart CloseCode = 1012
+	// CloseTryAgainLater means the endpoint is overloaded and the peer should reconnect later.
+	CloseTryAgainLater CloseCode = 1013
+)
+
+// heartbeatStreamName is the name of the stream used for heartbeats, it is never rate limited.
+const heartbeatStreamName = "heartbeatStream"
+
+const (
+	// relayStreamName is the name of the stream a client asks the server to relay through.
+	relayStreamName = "onynet/relay"
+	// relayStreamPrefix prefixes the name of a relayed stream on the client accepting it.
+	relayStreamPrefix = "onynet/relay/"
+)
+
+const (
+	relayOK byte = iota
+	relayDenied
+	relayNotFound
+	relayUnreachable
+)
+
+const (
+	// relayPublicKeySize is the size of an X25519 public key.
+	relayPublicKeySize = 32
+	// relayKeySize is the size of the AES-256 key of a relayed stream.
+	relayKeySize = 32
+	// relayKeyInfo binds the derived key to its use.
+	relayKeyInfo = "onynet relay stream"
+	// relayMaxSignatureSize bounds the signature of a relayed key, the size of a 8192 bit RSA key.
+	relayMaxSignatureSize = 1024
+	// relayOpenerLabel and relayAccepterLabel prefix what each side of a relayed stream signs,
+	// so the signature of one side cannot be passed off as the other's.
+	relayOpenerLabel   = "onynet relay opener"
+	relayAccepterLabel = "onynet relay accepter"
+)
+
 type Handler interface {
 	smux.Handler
 }
@@ -0,0 +1,19 @@
+package onynet
+
+import "context"
+
+type clientConnKey struct{}
+
+type clientKey struct{}
+
+// ClientConnFromContext returns the ClientConn whose context, or the context of one of its streams, is ctx.
+func ClientConnFromContext(ctx context.Context) (*ClientConn, bool) {
+	cn, ok := ctx.Value(clientConnKey{}).(*ClientConn)
+	return cn, ok
+}
+
+// ClientFromContext returns the Client whose context, or the context of one of its streams, is ctx.
+func ClientFromContext(ctx context.Context) (*Client, bool) {
+	c, ok := ctx.Value(clientKey{}).(*Client)
+	return c, ok
+}
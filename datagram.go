@@ -0,0 +1,205 @@
+package onynet
+
+import (
+	"context"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"log/slog"
+	"sync"
+	"sync/atomic"
+
+	intErrors "github.com/Onyz107/onynet/errors"
+	"github.com/Onyz107/onynet/internal/crypto"
+)
+
+const (
+	// datagramOverhead is the number of bytes added to an encrypted datagram (sequence number + nonce + tag).
+	datagramOverhead = 36
+	// datagramWindow is how far behind the highest sequence number received a datagram can arrive and still be accepted.
+	datagramWindow = 64
+)
+
+const (
+	datagramToServer byte = iota
+	datagramToClient
+)
+
+// datagramState numbers the encrypted datagrams a connection sends and rejects the ones it receives twice.
+// The sequence number and the direction of a datagram are authenticated along with it, so a datagram
+// captured on the path can neither be delivered again nor reflected back to its sender.
+type datagramState struct {
+	direction byte // direction of the datagrams sent
+	sent      atomic.Uint64
+
+	mu      sync.Mutex
+	highest uint64 // highest sequence number received
+	window  uint64 // bit i is set when highest-i was received
+}
+
+func newDatagramState(direction byte) *datagramState {
+	return &datagramState{direction: direction}
+}
+
+// datagramAdditionalData returns the data authenticated along with the datagram numbered seq sent in direction.
+func datagramAdditionalData(direction byte, seq uint64) []byte {
+	return binary.BigEndian.AppendUint64([]byte{direction}, seq)
+}
+
+func (d *datagramState) seal(b, aesKey []byte) ([]byte, error) {
+	seq := d.sent.Add(1)
+	ciphertext, err := crypto.EncryptAESGCMWithData(b, aesKey, datagramAdditionalData(d.direction, seq))
+	if err != nil {
+		return nil, err
+	}
+	return append(binary.BigEndian.AppendUint64(make([]byte, 0, 8+len(ciphertext)), seq), ciphertext...), nil
+}
+
+func (d *datagramState) open(packet, aesKey []byte) ([]byte, error) {
+	if len(packet) < 8 {
+		return nil, intErrors.ErrShort
+	}
+	seq := binary.BigEndian.Uint64(packet)
+	plaintext, err := crypto.DecryptAESGCMWithData(packet[8:], aesKey, datagramAdditionalData(d.direction^1, seq))
+	if err != nil {
+		return nil, err
+	}
+	if !d.accept(seq) {
+		return nil, fmt.Errorf("replayed datagram: sequence number: %d", seq)
+	}
+	return plaintext, nil
+}
+
+// accept records seq as received, it returns false if it already was or if it is too old to tell.
+func (d *datagramState) accept(seq uint64) bool {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	if seq == 0 {
+		return false
+	}
+	if seq > d.highest {
+		if shift := seq - d.highest; shift < datagramWindow {
+			d.window = d.window<<shift | 1
+		} else {
+			d.window = 1
+		}
+		d.highest = seq
+		return true
+	}
+
+	offset := d.highest - seq
+	if offset >= datagramWindow || d.window&(1<<offset) != 0 {
+		return false
+	}
+	d.window |= 1 << offset
+	return true
+}
+
+// SendDatagram sends b to the server as a single unreliable, unordered datagram.
+// Datagrams share the UDP socket of the connection but bypass KCP, so they are never retransmitted
+// and never wait behind stream data. They are encrypted and authenticated with the session key
+// when authentication is enabled, in which case the server drops the ones it already received.
+//
+// Possible errors:
+//   - ErrDatagramTooLarge: b is bigger than MaxDatagramSize
+//   - ErrDatagramUnsupported: the connection is over TCP or WebSocket, which carry no datagrams
+//   - ErrWrite: failed to send the datagram
+//   - ErrShortWrite: datagram sent was shorter than expected
+func (c *Client) SendDatagram(b []byte) error {
+	packet, err := sealDatagram(b, c.client.MaxDatagramSize(), c.aesKey, c.datagrams)
+	if err != nil {
+		return err
+	}
+	return c.client.WriteDatagram(packet)
+}
+
+// ReceiveDatagram waits for the next datagram sent by the server.
+// Datagrams that fail authentication or were already received are silently dropped.
+//
+// Possible errors:
+//   - ErrCtxCancelled: context was cancelled while waiting for a datagram
+//   - ErrConnClosed: the connection was closed
+//   - ErrDatagramUnsupported: the connection is over TCP or WebSocket, which carry no datagrams
+func (c *Client) ReceiveDatagram(ctx context.Context) ([]byte, error) {
+	return receiveDatagram(c.client.ReadDatagram, c.aesKey, c.datagrams, ctx, c.logger)
+}
+
+// MaxDatagramSize returns the largest payload SendDatagram accepts, 0 over TCP and WebSocket.
+func (c *Client) MaxDatagramSize() int {
+	return maxDatagramSize(c.client.MaxDatagramSize(), c.aesKey)
+}
+
+// SendDatagram sends b to the client as a single unreliable, unordered datagram.
+// Datagrams share the UDP socket of the connection but bypass KCP, so they are never retransmitted
+// and never wait behind stream data. They are encrypted and authenticated with the session key
+// when authentication is enabled, in which case the client drops the ones it already received.
+//
+// Possible errors:
+//   - ErrDatagramTooLarge: b is bigger than MaxDatagramSize
+//   - ErrDatagramUnsupported: the connection is over TCP or WebSocket, which carry no datagrams
+//   - ErrWrite: failed to send the datagram
+//   - ErrShortWrite: datagram sent was shorter than expected
+func (cn *ClientConn) SendDatagram(b []byte) error {
+	packet, err := sealDatagram(b, cn.client.MaxDatagramSize(), cn.aesKey, cn.datagrams)
+	if err != nil {
+		return err
+	}
+	return cn.client.WriteDatagram(packet)
+}
+
+// ReceiveDatagram waits for the next datagram sent by the client.
+// Datagrams that fail authentication or were already received are silently dropped.
+//
+// Possible errors:
+//   - ErrCtxCancelled: context was cancelled while waiting for a datagram
+//   - ErrConnClosed: the connection was closed
+//   - ErrDatagramUnsupported: the connection is over TCP or WebSocket, which carry no datagrams
+func (cn *ClientConn) ReceiveDatagram(ctx context.Context) ([]byte, error) {
+	return receiveDatagram(cn.client.ReadDatagram, cn.aesKey, cn.datagrams, ctx, cn.logger)
+}
+
+// MaxDatagramSize returns the largest payload SendDatagram accepts, 0 over TCP and WebSocket.
+func (cn *ClientConn) MaxDatagramSize() int {
+	return maxDatagramSize(cn.client.MaxDatagramSize(), cn.aesKey)
+}
+
+// maxDatagramSize returns the largest payload of a datagram sent over a transport carrying datagrams of up to size bytes.
+func maxDatagramSize(size int, aesKey []byte) int {
+	if size == 0 || aesKey == nil {
+		return size
+	}
+	return size - datagramOverhead
+}
+
+func sealDatagram(b []byte, size int, aesKey []byte, state *datagramState) ([]byte, error) {
+	if size == 0 {
+		return nil, intErrors.ErrDatagramUnsupported
+	}
+	if max := maxDatagramSize(size, aesKey); len(b) > max {
+		return nil, errors.Join(intErrors.ErrDatagramTooLarge, fmt.Errorf("max size: %d: datagram length: %d", max, len(b)))
+	}
+	if aesKey == nil {
+		return b, nil
+	}
+	return state.seal(b, aesKey)
+}
+
+func receiveDatagram(read func(context.Context) ([]byte, error), aesKey []byte, state *datagramState, ctx context.Context, logger *slog.Logger) ([]byte, error) {
+	for {
+		packet, err := read(ctx)
+		if err != nil {
+			return nil, err
+		}
+		if aesKey == nil {
+			return packet, nil
+		}
+
+		plaintext, err := state.open(packet, aesKey)
+		if err != nil {
+			logger.Debug("dropping datagram", "error", err, "error_kind", intErrors.Kind(err))
+			continue
+		}
+		return plaintext, nil
+	}
+}
@@ -0,0 +1,137 @@
+package onynet
+
+import (
+	"context"
+	"crypto/rsa"
+	"errors"
+	"net"
+	"time"
+
+	intErrors "github.com/Onyz107/onynet/errors"
+	"github.com/Onyz107/onynet/internal/auth"
+	"github.com/Onyz107/onynet/internal/kcp"
+	intTransport "github.com/Onyz107/onynet/internal/transport"
+)
+
+// WebSocketAddr is the URL of a server's WebSocket handler, such as "wss://example.com/onynet".
+// Dialing it connects over WebSocket instead of KCP, see Server.WebSocketHandler.
+type WebSocketAddr string
+
+// Network returns "websocket".
+func (a WebSocketAddr) Network() string {
+	return webSocketNetwork
+}
+
+func (a WebSocketAddr) String() string {
+	return string(a)
+}
+
+// DialConn connects to an OnyNet server over conn, a reliable connection established elsewhere such as a
+// stream relayed by another server, whose other end is handed to Server.ServeConn. The client is set up
+// as with Dial, conn is closed along with it or when DialConn fails, and its connection carries no datagrams.
+//
+// Possible errors are the same as the ones returned by Dial.
+func DialConn(conn net.Conn, publicKey *rsa.PublicKey, ctx context.Context, opts ...DialOption) (*Client, error) {
+	return Dial(conn.RemoteAddr(), publicKey, ctx, append(opts, withConn(conn))...)
+}
+
+// connect dials addr over the transport its network selects, TCP for TCP addresses, WebSocket for
+// a WebSocketAddr and KCP otherwise, then sends the client's hello and waits to be admitted.
+// With happy eyeballs, KCP is raced against TCP to the same address.
+func connect(addr net.Addr, ctx context.Context, options dialOptions) (intTransport.Conn, error) {
+	if options.conn != nil {
+		return sendHello(intTransport.Stream(options.conn), nil)
+	}
+
+	dialTCP := func() (intTransport.Conn, error) {
+		return sendHello(intTransport.DialTCP(addr, ctx))
+	}
+
+	switch addr.Network() {
+	case "tcp", "tcp4", "tcp6":
+		return dialTCP()
+	case webSocketNetwork:
+		return sendHello(intTransport.DialWebSocket(addr.String(), ctx))
+	}
+
+	dialKCP := func() (intTransport.Conn, error) {
+		return sendHello(kcp.Dial(addr, options.transport, ctx, options.logger))
+	}
+	if !options.happyEyeballs {
+		return dialKCP()
+	}
+	return happyEyeballs(dialKCP, dialTCP)
+}
+
+// sendHello sends the client's hello over a freshly dialed connection and waits for the server to admit it.
+func sendHello(conn intTransport.Conn, err error) (intTransport.Conn, error) {
+	if err != nil {
+		return nil, errors.Join(intErrors.ErrDial, err)
+	}
+	if err := auth.SendHello(conn); err != nil {
+		conn.Close()
+		return nil, errors.Join(intErrors.ErrAuth, err)
+	}
+	return conn, nil
+}
+
+type dialResult struct {
+	conn intTransport.Conn
+	err  error
+}
+
+// happyEyeballs starts the attempts one after the other, each happyEyeballsDelay after the previous one
+// or as soon as it fails, and returns the first connection established. The connections established
+// by the other attempts are closed. If every attempt fails, their errors are joined.
+func happyEyeballs(attempts ...func() (intTransport.Conn, error)) (intTransport.Conn, error) {
+	results := make(chan dialResult, len(attempts))
+	start := func(attempt func() (intTransport.Conn, error)) {
+		go func() {
+			conn, err := attempt()
+			results <- dialResult{conn, err}
+		}()
+	}
+
+	start(attempts[0])
+	started, failed := 1, 0
+	timer := time.NewTimer(happyEyeballsDelay)
+	defer timer.Stop()
+
+	var errs []error
+	for {
+		select {
+		case <-timer.C:
+			if started < len(attempts) {
+				start(attempts[started])
+				started++
+				timer.Reset(happyEyeballsDelay)
+			}
+
+		case result := <-results:
+			if result.err == nil {
+				go closeLosers(results, started-failed-1)
+				return result.conn, nil
+			}
+
+			errs = append(errs, result.err)
+			failed++
+			if failed == len(attempts) {
+				return nil, errors.Join(errs...)
+			}
+			if failed == started {
+				start(attempts[started])
+				started++
+				timer.Reset(happyEyeballsDelay)
+			}
+		}
+	}
+}
+
+// closeLosers closes the connections established by the n attempts still running once another one won.
+func closeLosers(results <-chan dialResult, n int) {
+	for range n {
+		if result := <-results; result.err == nil {
+			result.conn.Close()
+		}
+	}
+}
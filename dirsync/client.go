@@ -0,0 +1,171 @@
+package dirsync
+
+import (
+	"context"
+	"encoding/binary"
+	"errors"
+	"sync"
+
+	"github.com/Onyz107/onynet"
+	intErrors "github.com/Onyz107/onynet/errors"
+)
+
+// Push mirrors the local directory dir to path, a directory under the root of the server which is created
+// if needed. Only the files whose size or modification time differ are transferred: a file the server does
+// not have is sent whole, resuming an interrupted transfer of it, and a file it has a version of is sent as
+// the blocks which changed, found with rolling checksums like rsync. Modes and modification times are preserved.
+// Several files are transferred in parallel, each on its own stream of h.
+//
+// Possible errors:
+//   - ErrSyncDenied: the server is not writable
+//   - ErrBadFileName: path is not a relative path within the server's root
+//   - ErrSync: the directory cannot be read, or files failed to be transferred
+//   - the errors returned by OpenStream and of the streams' transfer methods
+func Push(h onynet.Handler, dir, path string, ctx context.Context, opts ...Option) (*Stats, error) {
+	return run(h, directionPush, dir, path, ctx, newOptions(opts))
+}
+
+// Pull mirrors path, a directory under the root of the server, to the local directory dir which is created
+// if needed, the same way Push does the other way around.
+//
+// Possible errors:
+//   - ErrBadFileName: path is not a relative path within the server's root
+//   - ErrSync: path is not a directory, dir cannot be written, or files failed to be transferred
+//   - the errors returned by OpenStream and of the streams' transfer methods
+func Pull(h onynet.Handler, path, dir string, ctx context.Context, opts ...Option) (*Stats, error) {
+	return run(h, directionPull, dir, path, ctx, newOptions(opts))
+}
+
+func run(h onynet.Handler, direction byte, dir, path string, ctx context.Context, o options) (*Stats, error) {
+	sess := &session{root: dir, source: direction == directionPush, timeout: o.timeout}
+	if sess.source {
+		var err error
+		if sess.entries, err = scan(dir); err != nil {
+			return nil, err
+		}
+	}
+
+	control, err := h.OpenStream(StreamName, ctx, o.timeout)
+	if err != nil {
+		return nil, err
+	}
+	defer control.Close()
+
+	var remove byte
+	if o.remove {
+		remove = 1
+	}
+	if err := writeFrame(control, append([]byte{streamControl, direction, remove}, path...), o.timeout); err != nil {
+		return nil, err
+	}
+
+	// The control stream waits for the transfers, which may take any time, so it has no timeout
+	reply, err := readFrame(control, make([]byte, 0xFFFF+frameOverhead), 0)
+	if err != nil {
+		return nil, err
+	}
+	if err := frameError(reply); err != nil {
+		return nil, err
+	}
+	if len(reply) != 5 {
+		return nil, intErrors.ErrMalformedFrame
+	}
+	id := binary.BigEndian.Uint32(reply[1:])
+
+	var needs []need
+	if sess.source {
+		if err := writeEntries(control, sess.entries); err != nil {
+			return nil, err
+		}
+		if err := readStatus(control, 0); err != nil {
+			return nil, err
+		}
+		if needs, err = readNeeds(control); err != nil {
+			return nil, err
+		}
+	} else {
+		if sess.entries, err = readEntries(control); err != nil {
+			return nil, err
+		}
+		if needs, err = plan(dir, sess.entries); err != nil {
+			return nil, err
+		}
+	}
+
+	transferErr := transferAll(h, sess, id, needs, ctx, o)
+
+	if err := writeStatus(control, nil, 0); err != nil {
+		return nil, err
+	}
+	if !sess.source {
+		stats, err := sess.finish(o.remove)
+		return stats, errors.Join(err, transferErr)
+	}
+
+	frame, err := readFrame(control, make([]byte, 0xFFFF+frameOverhead), 0)
+	if err != nil {
+		return nil, err
+	}
+	if err := frameError(frame); err != nil {
+		return nil, errors.Join(err, transferErr)
+	}
+	stats, err := decodeStats(frame)
+	if err != nil {
+		return nil, err
+	}
+	return stats, transferErr
+}
+
+// transferAll transfers the files of needs on o.workers streams at once, and returns the errors of the ones which failed.
+func transferAll(h onynet.Handler, sess *session, id uint32, needs []need, ctx context.Context, o options) error {
+	queue := make(chan need)
+	go func() {
+		defer close(queue)
+		for _, n := range needs {
+			select {
+			case queue <- n:
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+
+	var mu sync.Mutex
+	var errs []error
+	var wg sync.WaitGroup
+	for range o.workers {
+		wg.Go(func() {
+			for n := range queue {
+				if err := transferOne(h, sess, id, n, ctx, o); err != nil {
+					mu.Lock()
+					errs = append(errs, err)
+					mu.Unlock()
+				}
+			}
+		})
+	}
+	wg.Wait()
+
+	if err := ctx.Err(); err != nil {
+		errs = append(errs, intErrors.ErrCtxCancelled)
+	}
+	if len(errs) > 0 {
+		return errors.Join(append([]error{intErrors.ErrSync}, errs...)...)
+	}
+	return nil
+}
+
+func transferOne(h onynet.Handler, sess *session, id uint32, n need, ctx context.Context, o options) error {
+	stream, err := h.OpenStream(StreamName, ctx, o.timeout)
+	if err != nil {
+		return err
+	}
+	defer stream.Close()
+
+	header := binary.BigEndian.AppendUint32([]byte{streamFile}, id)
+	header = binary.BigEndian.AppendUint32(header, n.index)
+	if err := writeFrame(stream, append(header, n.transfer), o.timeout); err != nil {
+		return err
+	}
+	return sess.transfer(stream, n.index, n.transfer)
+}
@@ -0,0 +1,71 @@
+package dirsync
+
+import "time"
+
+// StreamName is the name of the streams a synchronization runs on: a control stream, and one stream
+// for every file transferred.
+const StreamName = "onynet/sync"
+
+const (
+	// DefaultWorkers is the number of files transferred in parallel when no number is given.
+	DefaultWorkers = 4
+	// DefaultTimeout bounds every message of a file transfer when no timeout is given.
+	DefaultTimeout = 30 * time.Second
+	// pollInterval is the longest time Serve waits for a stream before checking it was not stopped.
+	pollInterval = time.Second
+)
+
+const (
+	// maxFrameSize is the largest frame of records, such as entries or delta operations.
+	maxFrameSize = 1024 * 1024
+	// frameOverhead is the most the framing and encryption of a frame add to it.
+	frameOverhead = 64
+	// maxLiteralSize is the largest literal operation of a delta.
+	maxLiteralSize = 32 * 1024
+	// maxEntries is the most directories and files a synchronized tree may hold.
+	maxEntries = 1 << 20
+)
+
+const (
+	// minBlockSize and maxBlockSize bound the size of the blocks a file is compared in,
+	// which grows with the square root of the file's size.
+	minBlockSize = 2 * 1024
+	maxBlockSize = 128 * 1024
+	// strongHashSize is the size of the truncated SHA-256 identifying a block once its rolling checksum matches.
+	strongHashSize = 16
+	// maxBlocks is the most blocks the signature of a file holds, enough for 128GiB in the largest blocks.
+	maxBlocks = 1 << 20
+)
+
+// tempSuffix ends the name of the file a delta is applied to until it is verified.
+const tempSuffix = ".sync"
+
+// The first frame of a stream tells what it is for.
+const (
+	streamControl byte = iota
+	streamFile
+)
+
+const (
+	directionPush byte = iota
+	directionPull
+)
+
+// The ways a file is transferred: whole, or as the differences with the destination's version of it.
+const (
+	transferFull byte = iota
+	transferDelta
+)
+
+// The status a request is answered with, the errors a caller may check for have their own.
+const (
+	statusOK byte = iota
+	statusError
+	statusDenied
+	statusBadFileName
+)
+
+const (
+	opCopy byte = iota
+	opLiteral
+)
@@ -0,0 +1,280 @@
+package dirsync
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/sha256"
+	"encoding/binary"
+	"errors"
+	"io"
+	"math"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/Onyz107/onynet"
+	intErrors "github.com/Onyz107/onynet/errors"
+)
+
+// block is a block of the destination's version of a file.
+type block struct {
+	index  uint32
+	length int
+	strong [strongHashSize]byte
+}
+
+// blockSize returns the size of the blocks a file of size bytes is compared in.
+func blockSize(size int64) int {
+	return min(max(int(math.Sqrt(float64(size)))&^7, minBlockSize), maxBlockSize)
+}
+
+func strongHash(b []byte) (hash [strongHashSize]byte) {
+	sum := sha256.Sum256(b)
+	copy(hash[:], sum[:])
+	return hash
+}
+
+// sendSignature sends the signature of basis, the destination's version of a file: block size(4) + basis size(8),
+// followed by a batch of the rolling checksum(4) + strong hash of every block. Only the first maxBlocks blocks
+// are sent, the source sends the rest of the file as literal bytes.
+func sendSignature(stream *onynet.Stream, basis *os.File, timeout time.Duration) (int, int64, error) {
+	info, err := basis.Stat()
+	if err != nil {
+		return 0, 0, errors.Join(intErrors.ErrSync, err)
+	}
+	size := blockSize(info.Size())
+	header := binary.BigEndian.AppendUint32(nil, uint32(size))
+	if err := writeFrame(stream, binary.BigEndian.AppendUint64(header, uint64(info.Size())), timeout); err != nil {
+		return 0, 0, err
+	}
+
+	batch := newBatchWriter(stream, timeout)
+	reader := bufio.NewReaderSize(basis, maxBlockSize)
+	buf := make([]byte, size)
+	for range maxBlocks {
+		n, err := io.ReadFull(reader, buf)
+		if n > 0 {
+			record := binary.BigEndian.AppendUint32(nil, newRolling(buf[:n]).sum())
+			strong := strongHash(buf[:n])
+			if err := batch.add(append(record, strong[:]...)); err != nil {
+				return 0, 0, err
+			}
+		}
+		if err == io.EOF || err == io.ErrUnexpectedEOF {
+			break
+		}
+		if err != nil {
+			return 0, 0, errors.Join(intErrors.ErrSync, err)
+		}
+	}
+	return size, info.Size(), batch.close()
+}
+
+// receiveSignature receives a signature sent with sendSignature, indexing the blocks by their rolling checksum,
+// and returns it with the block size.
+func receiveSignature(stream *onynet.Stream, timeout time.Duration) (map[uint32][]block, int, error) {
+	frame, err := readFrame(stream, make([]byte, 12+frameOverhead), timeout)
+	if err != nil {
+		return nil, 0, err
+	}
+	if len(frame) != 12 {
+		return nil, 0, intErrors.ErrMalformedFrame
+	}
+	size := int(binary.BigEndian.Uint32(frame))
+	basisSize := int64(binary.BigEndian.Uint64(frame[4:]))
+	if size < minBlockSize || size > maxBlockSize {
+		return nil, 0, intErrors.ErrMalformedFrame
+	}
+
+	blocks := make(map[uint32][]block)
+	var index uint32
+	err = readBatch(stream, timeout, func(record []byte) error {
+		if len(record) != 4+strongHashSize || index == maxBlocks {
+			return intErrors.ErrMalformedFrame
+		}
+		b := block{index: index, length: int(min(int64(size), basisSize-int64(index)*int64(size)))}
+		if b.length <= 0 {
+			return intErrors.ErrMalformedFrame
+		}
+		copy(b.strong[:], record[4:])
+		weak := binary.BigEndian.Uint32(record)
+		blocks[weak] = append(blocks[weak], b)
+		index++
+		return nil
+	})
+	return blocks, size, err
+}
+
+// sendDelta sends file as a batch of operations rebuilding it from the blocks the destination already has:
+// the index(4) of a block to copy, or literal bytes. The SHA-256 of the whole file follows the batch.
+func sendDelta(stream *onynet.Stream, file *os.File, blocks map[uint32][]block, size int, timeout time.Duration) error {
+	batch := newBatchWriter(stream, timeout)
+	fileHash := sha256.New()
+	reader := bufio.NewReaderSize(io.TeeReader(file, fileHash), maxBlockSize)
+
+	var literal []byte
+	flush := func() error {
+		if len(literal) == 0 {
+			return nil
+		}
+		err := batch.add(append([]byte{opLiteral}, literal...))
+		literal = literal[:0]
+		return err
+	}
+
+	// fill reads the next window of the size of a block, sliding the window reduces its capacity
+	var window []byte
+	fill := func() error {
+		if cap(window) < size {
+			window = make([]byte, size, 2*size)
+		}
+		n, err := io.ReadFull(reader, window[:size])
+		window = window[:n]
+		if err == io.EOF || err == io.ErrUnexpectedEOF {
+			return nil
+		}
+		return err
+	}
+	if err := fill(); err != nil {
+		return errors.Join(intErrors.ErrSync, err)
+	}
+	weak := newRolling(window)
+
+	eof := false
+	for len(window) > 0 {
+		if b, ok := match(blocks, weak.sum(), window); ok {
+			if err := flush(); err != nil {
+				return err
+			}
+			if err := batch.add(binary.BigEndian.AppendUint32([]byte{opCopy}, b.index)); err != nil {
+				return err
+			}
+			if err := fill(); err != nil {
+				return errors.Join(intErrors.ErrSync, err)
+			}
+			weak = newRolling(window)
+			continue
+		}
+
+		out := window[0]
+		literal = append(literal, out)
+		if len(literal) == maxLiteralSize {
+			if err := flush(); err != nil {
+				return err
+			}
+		}
+
+		var in byte
+		var err error
+		if !eof {
+			in, err = reader.ReadByte()
+			if err == io.EOF {
+				eof = true
+			} else if err != nil {
+				return errors.Join(intErrors.ErrSync, err)
+			}
+		}
+		if eof {
+			weak.shrink(out)
+			window = window[1:]
+		} else {
+			weak.roll(out, in)
+			window = append(window[1:], in)
+		}
+	}
+
+	if err := flush(); err != nil {
+		return err
+	}
+	if err := batch.close(); err != nil {
+		return err
+	}
+	return writeFrame(stream, fileHash.Sum(nil), timeout)
+}
+
+// match returns the block of blocks holding the same bytes as window.
+func match(blocks map[uint32][]block, weak uint32, window []byte) (block, bool) {
+	candidates := blocks[weak]
+	if len(candidates) == 0 {
+		return block{}, false
+	}
+	strong := strongHash(window)
+	for _, b := range candidates {
+		if b.length == len(window) && b.strong == strong {
+			return b, true
+		}
+	}
+	return block{}, false
+}
+
+// receiveDelta rebuilds the file at path from basis, its current version, and the delta sent with sendDelta.
+// The file is written next to path and only replaces it once its SHA-256 is verified, and has the mode and
+// modification time of e. It returns the number of bytes received as literals and copied from basis.
+func receiveDelta(stream *onynet.Stream, path string, basis *os.File, blockSize int, e *entry, timeout time.Duration) (int64, int64, error) {
+	temp := filepath.Join(filepath.Dir(path), "."+filepath.Base(path)+tempSuffix)
+	file, err := os.OpenFile(temp, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0o600)
+	if err != nil {
+		return 0, 0, errors.Join(intErrors.ErrSync, err)
+	}
+	defer os.Remove(temp)
+	defer file.Close()
+
+	fileHash := sha256.New()
+	writer := bufio.NewWriterSize(io.MultiWriter(file, fileHash), maxBlockSize)
+	buf := make([]byte, blockSize)
+
+	var literal, matched int64
+	err = readBatch(stream, timeout, func(record []byte) error {
+		if len(record) == 0 {
+			return intErrors.ErrMalformedFrame
+		}
+		switch record[0] {
+		case opLiteral:
+			literal += int64(len(record) - 1)
+			_, err := writer.Write(record[1:])
+			return err
+		case opCopy:
+			if len(record) != 5 {
+				return intErrors.ErrMalformedFrame
+			}
+			offset := int64(binary.BigEndian.Uint32(record[1:])) * int64(blockSize)
+			n, err := basis.ReadAt(buf, offset)
+			if n == 0 && err != nil {
+				return errors.Join(intErrors.ErrMalformedFrame, err)
+			}
+			matched += int64(n)
+			_, err = writer.Write(buf[:n])
+			return err
+		default:
+			return intErrors.ErrMalformedFrame
+		}
+	})
+	if err != nil {
+		return 0, 0, err
+	}
+	if err := writer.Flush(); err != nil {
+		return 0, 0, errors.Join(intErrors.ErrSync, err)
+	}
+
+	expected, err := readFrame(stream, make([]byte, sha256.Size+frameOverhead), timeout)
+	if err != nil {
+		return 0, 0, err
+	}
+	if !bytes.Equal(expected, fileHash.Sum(nil)) {
+		return 0, 0, errors.Join(intErrors.ErrIntegrity, errors.New(e.path))
+	}
+
+	if err := file.Chmod(e.mode); err != nil {
+		return 0, 0, errors.Join(intErrors.ErrSync, err)
+	}
+	if err := file.Close(); err != nil {
+		return 0, 0, errors.Join(intErrors.ErrSync, err)
+	}
+	if err := os.Chtimes(temp, e.modTime, e.modTime); err != nil {
+		return 0, 0, errors.Join(intErrors.ErrSync, err)
+	}
+	if err := os.Rename(temp, path); err != nil {
+		return 0, 0, errors.Join(intErrors.ErrSync, err)
+	}
+	return literal, matched, nil
+}
@@ -0,0 +1,54 @@
+package dirsync
+
+import (
+	"encoding/binary"
+	"errors"
+	"math"
+	"testing"
+	"time"
+
+	intErrors "github.com/Onyz107/onynet/errors"
+	"github.com/Onyz107/onynet/onynettest"
+)
+
+func TestSignatureBlockLimit(t *testing.T) {
+	pair := onynettest.NewPair(t, nil)
+
+	received := make(chan error, 1)
+	go func() {
+		stream, err := pair.ClientConn.AcceptStream(StreamName, t.Context(), 5*time.Second)
+		if err != nil {
+			received <- err
+			return
+		}
+		defer stream.Close()
+		_, _, err = receiveSignature(stream, 10*time.Second)
+		received <- err
+	}()
+
+	stream, err := pair.Client.OpenStream(StreamName, t.Context(), 5*time.Second)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer stream.Close()
+
+	// A destination claiming the largest basis sends one block more than a signature holds
+	header := binary.BigEndian.AppendUint32(nil, minBlockSize)
+	if err := writeFrame(stream, binary.BigEndian.AppendUint64(header, math.MaxInt64), 5*time.Second); err != nil {
+		t.Fatal(err)
+	}
+	go func() {
+		batch := newBatchWriter(stream, 5*time.Second)
+		record := make([]byte, 4+strongHashSize)
+		for range maxBlocks + 1 {
+			if batch.add(record) != nil {
+				return
+			}
+		}
+		batch.close()
+	}()
+
+	if err := <-received; !errors.Is(err, intErrors.ErrMalformedFrame) {
+		t.Fatalf("expected ErrMalformedFrame past %d blocks, got: %v", maxBlocks, err)
+	}
+}
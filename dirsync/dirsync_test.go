@@ -0,0 +1,183 @@
+package dirsync_test
+
+import (
+	"bytes"
+	"crypto/rand"
+	"errors"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/Onyz107/onynet/dirsync"
+	intErrors "github.com/Onyz107/onynet/errors"
+	"github.com/Onyz107/onynet/onynettest"
+)
+
+var modTime = time.Date(2024, 3, 1, 12, 0, 0, 0, time.UTC)
+
+// writeFile writes data to the file at name under root, creating its directories.
+func writeFile(tb testing.TB, root, name string, data []byte, mode fs.FileMode) {
+	tb.Helper()
+
+	path := filepath.Join(root, filepath.FromSlash(name))
+	if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+		tb.Fatal(err)
+	}
+	if err := os.WriteFile(path, data, mode); err != nil {
+		tb.Fatal(err)
+	}
+	if err := os.Chmod(path, mode); err != nil {
+		tb.Fatal(err)
+	}
+	if err := os.Chtimes(path, modTime, modTime); err != nil {
+		tb.Fatal(err)
+	}
+}
+
+func randomBytes(size int) []byte {
+	b := make([]byte, size)
+	rand.Read(b)
+	return b
+}
+
+// newTree returns a directory holding a few files and directories.
+func newTree(tb testing.TB) string {
+	tb.Helper()
+
+	root := tb.TempDir()
+	writeFile(tb, root, "big.bin", randomBytes(1024*1024), 0o644)
+	writeFile(tb, root, "small.txt", []byte("hello"), 0o600)
+	writeFile(tb, root, "empty", nil, 0o644)
+	writeFile(tb, root, "nested/deeper/script.sh", []byte("#!/bin/sh\n"), 0o755)
+	if err := os.Mkdir(filepath.Join(root, "nested", "empty-dir"), 0o750); err != nil {
+		tb.Fatal(err)
+	}
+	return root
+}
+
+// assertMirror checks dst holds the same directories and files as src, with the same modes and modification times.
+func assertMirror(tb testing.TB, src, dst string) {
+	tb.Helper()
+
+	list := func(root string) map[string]fs.FileInfo {
+		infos := make(map[string]fs.FileInfo)
+		filepath.WalkDir(root, func(path string, d fs.DirEntry, err error) error {
+			if err != nil {
+				tb.Fatal(err)
+			}
+			if path != root {
+				rel, _ := filepath.Rel(root, path)
+				infos[rel], _ = d.Info()
+			}
+			return nil
+		})
+		return infos
+	}
+
+	want, got := list(src), list(dst)
+	if len(want) != len(got) {
+		tb.Fatalf("expected %d entries, got %d", len(want), len(got))
+	}
+	for name, info := range want {
+		other, ok := got[name]
+		switch {
+		case !ok:
+			tb.Fatalf("%s is missing", name)
+		case info.Mode() != other.Mode():
+			tb.Fatalf("%s: expected mode %v, got %v", name, info.Mode(), other.Mode())
+		case !info.ModTime().Equal(other.ModTime()):
+			tb.Fatalf("%s: expected modification time %v, got %v", name, info.ModTime(), other.ModTime())
+		case info.Mode().IsRegular():
+			a, _ := os.ReadFile(filepath.Join(src, name))
+			b, _ := os.ReadFile(filepath.Join(dst, name))
+			if !bytes.Equal(a, b) {
+				tb.Fatalf("%s differs", name)
+			}
+		}
+	}
+}
+
+func newServer(tb testing.TB, writable bool) (*onynettest.Pair, string) {
+	tb.Helper()
+
+	root := tb.TempDir()
+	server := dirsync.NewServer(root)
+	server.SetWritable(writable)
+
+	pair := onynettest.NewPair(tb, nil)
+	go server.Serve(pair.ClientConn, tb.Context())
+	return pair, root
+}
+
+func TestPush(t *testing.T) {
+	src := newTree(t)
+	pair, root := newServer(t, true)
+	dst := filepath.Join(root, "mirror")
+
+	stats, err := dirsync.Push(pair.Client, src, "mirror", t.Context())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if stats.Files != 4 {
+		t.Fatalf("expected 4 files transferred, got %d", stats.Files)
+	}
+	assertMirror(t, src, dst)
+
+	// Change a few bytes of the big file, add a file and remove one
+	big, _ := os.ReadFile(filepath.Join(src, "big.bin"))
+	copy(big[500*1024:], "changed in the middle")
+	writeFile(t, src, "big.bin", big, 0o644)
+	os.Chtimes(filepath.Join(src, "big.bin"), modTime.Add(time.Hour), modTime.Add(time.Hour))
+	writeFile(t, src, "nested/new.txt", []byte("new"), 0o644)
+	os.Remove(filepath.Join(src, "small.txt"))
+
+	stats, err = dirsync.Push(pair.Client, src, "mirror", t.Context(), dirsync.WithDelete(), dirsync.WithWorkers(2))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if stats.Files != 2 || stats.Deleted != 1 {
+		t.Fatalf("expected 2 files transferred and 1 deleted, got %+v", stats)
+	}
+	if stats.Literal > 64*1024 || stats.Matched < 900*1024 {
+		t.Fatalf("expected the big file to be sent as a delta, got %+v", stats)
+	}
+	assertMirror(t, src, dst)
+
+	// Nothing changed since
+	stats, err = dirsync.Push(pair.Client, src, "mirror", t.Context())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if stats.Files != 0 {
+		t.Fatalf("expected no file transferred, got %d", stats.Files)
+	}
+}
+
+func TestPull(t *testing.T) {
+	pair, root := newServer(t, false)
+	src := filepath.Join(root, "data")
+	writeFile(t, src, "big.bin", randomBytes(300*1024), 0o640)
+	writeFile(t, src, "dir/file.txt", []byte("content"), 0o600)
+
+	dst := filepath.Join(t.TempDir(), "copy")
+	writeFile(t, dst, "stale.txt", []byte("stale"), 0o644)
+
+	if _, err := dirsync.Pull(pair.Client, "data", dst, t.Context(), dirsync.WithDelete()); err != nil {
+		t.Fatal(err)
+	}
+	assertMirror(t, src, dst)
+}
+
+func TestDenied(t *testing.T) {
+	src := newTree(t)
+	pair, _ := newServer(t, false)
+
+	if _, err := dirsync.Push(pair.Client, src, "mirror", t.Context()); !errors.Is(err, intErrors.ErrSyncDenied) {
+		t.Fatalf("expected ErrSyncDenied, got: %v", err)
+	}
+	if _, err := dirsync.Pull(pair.Client, "../outside", t.TempDir(), t.Context()); !errors.Is(err, intErrors.ErrBadFileName) {
+		t.Fatalf("expected ErrBadFileName, got: %v", err)
+	}
+}
@@ -0,0 +1,221 @@
+package dirsync
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"time"
+
+	intErrors "github.com/Onyz107/onynet/errors"
+)
+
+// entry is a directory or a regular file of the synchronized tree. Other files, such as symbolic links,
+// are not synchronized.
+type entry struct {
+	// path is relative to the root of the tree and uses forward slashes.
+	path    string
+	dir     bool
+	size    int64
+	mode    fs.FileMode
+	modTime time.Time
+}
+
+// need is a file the destination needs, and how it is transferred.
+type need struct {
+	index    uint32
+	transfer byte
+}
+
+// scan lists the directories and regular files under root, parents before their children.
+func scan(root string) ([]entry, error) {
+	var entries []entry
+	err := filepath.WalkDir(root, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if path == root || !(d.IsDir() || d.Type().IsRegular()) {
+			return nil
+		}
+		info, err := d.Info()
+		if err != nil {
+			return err
+		}
+
+		rel, err := filepath.Rel(root, path)
+		if err != nil {
+			return err
+		}
+		entries = append(entries, entry{
+			path:    filepath.ToSlash(rel),
+			dir:     d.IsDir(),
+			size:    info.Size(),
+			mode:    info.Mode().Perm(),
+			modTime: info.ModTime(),
+		})
+		return nil
+	})
+	if err != nil {
+		return nil, errors.Join(intErrors.ErrSync, err)
+	}
+	return entries, nil
+}
+
+// encodeEntry encodes an entry as directory(1) + size(8) + mode(4) + modification time(8) + path.
+func encodeEntry(e *entry) []byte {
+	var dir byte
+	if e.dir {
+		dir = 1
+	}
+	record := binary.BigEndian.AppendUint64([]byte{dir}, uint64(e.size))
+	record = binary.BigEndian.AppendUint32(record, uint32(e.mode))
+	record = binary.BigEndian.AppendUint64(record, uint64(e.modTime.UnixNano()))
+	return append(record, e.path...)
+}
+
+func decodeEntry(record []byte) (entry, error) {
+	if len(record) < 21 {
+		return entry{}, intErrors.ErrMalformedFrame
+	}
+	e := entry{
+		dir:     record[0] == 1,
+		size:    int64(binary.BigEndian.Uint64(record[1:])),
+		mode:    fs.FileMode(binary.BigEndian.Uint32(record[9:])).Perm(),
+		modTime: time.Unix(0, int64(binary.BigEndian.Uint64(record[13:]))),
+		path:    string(record[21:]),
+	}
+	if e.size < 0 || !validPath(e.path) {
+		return entry{}, errors.Join(intErrors.ErrBadFileName, errors.New(e.path))
+	}
+	return e, nil
+}
+
+// validPath reports whether path is a relative path which stays within the root of the tree.
+func validPath(path string) bool {
+	return fs.ValidPath(path) && path != "." && filepath.IsLocal(filepath.FromSlash(path))
+}
+
+// parentDir returns the path of the directory holding the entry at path, "." for the root of the tree.
+func parentDir(path string) string {
+	if i := strings.LastIndexByte(path, '/'); i >= 0 {
+		return path[:i]
+	}
+	return "."
+}
+
+// plan prepares root to receive entries: it creates their directories, replacing what stands in their way,
+// updates the mode of the files which are otherwise up to date, and returns the files which must be transferred.
+// A file is up to date when its size and modification time match, like rsync's quick check.
+// Every entry must follow the entry of its parent directory, as scan lists them, so nothing is written
+// through a directory plan did not check, such as a symbolic link leading out of root.
+func plan(root string, entries []entry) ([]need, error) {
+	if err := os.MkdirAll(root, 0o755); err != nil {
+		return nil, errors.Join(intErrors.ErrSync, err)
+	}
+
+	checked := map[string]bool{".": true}
+	var needs []need
+	for i, e := range entries {
+		if !checked[parentDir(e.path)] {
+			return nil, errors.Join(intErrors.ErrBadFileName, fmt.Errorf("%s: parent directory not listed before it", e.path))
+		}
+		delete(checked, e.path)
+
+		path := filepath.Join(root, filepath.FromSlash(e.path))
+		info, err := os.Lstat(path)
+		if err != nil && !errors.Is(err, fs.ErrNotExist) {
+			return nil, errors.Join(intErrors.ErrSync, err)
+		}
+		if info != nil && (e.dir != info.IsDir() || !e.dir && !info.Mode().IsRegular()) {
+			if err := os.RemoveAll(path); err != nil {
+				return nil, errors.Join(intErrors.ErrSync, err)
+			}
+			info = nil
+		}
+
+		switch {
+		case e.dir:
+			// The directory gets its own mode once synchronized, until then it must be writable
+			if info == nil {
+				if err := os.Mkdir(path, 0o755); err != nil {
+					return nil, errors.Join(intErrors.ErrSync, err)
+				}
+			} else if info.Mode().Perm()&0o700 != 0o700 {
+				if err := os.Chmod(path, info.Mode().Perm()|0o700); err != nil {
+					return nil, errors.Join(intErrors.ErrSync, err)
+				}
+			}
+			checked[e.path] = true
+		case info == nil:
+			needs = append(needs, need{uint32(i), transferFull})
+		case info.Size() != e.size || !info.ModTime().Equal(e.modTime):
+			needs = append(needs, need{uint32(i), transferDelta})
+		case info.Mode().Perm() != e.mode:
+			if err := os.Chmod(path, e.mode); err != nil {
+				return nil, errors.Join(intErrors.ErrSync, err)
+			}
+		}
+	}
+	return needs, nil
+}
+
+// finish deletes what root holds beyond entries when remove is set, then gives the directories of entries their
+// mode and modification time, children first as changing a directory's content changes its modification time.
+func finish(root string, entries []entry, remove bool) (int, error) {
+	deleted := 0
+	if remove {
+		keep := make(map[string]bool, len(entries))
+		for _, e := range entries {
+			keep[e.path] = true
+		}
+
+		var extra []string
+		err := filepath.WalkDir(root, func(path string, d fs.DirEntry, err error) error {
+			if err != nil {
+				return err
+			}
+			if path == root {
+				return nil
+			}
+			rel, err := filepath.Rel(root, path)
+			if err != nil {
+				return err
+			}
+			if !keep[filepath.ToSlash(rel)] {
+				extra = append(extra, path)
+				if d.IsDir() {
+					return filepath.SkipDir
+				}
+			}
+			return nil
+		})
+		if err != nil {
+			return 0, errors.Join(intErrors.ErrSync, err)
+		}
+
+		for _, path := range extra {
+			if err := os.RemoveAll(path); err != nil {
+				return 0, errors.Join(intErrors.ErrSync, err)
+			}
+		}
+		deleted = len(extra)
+	}
+
+	for _, e := range slices.Backward(entries) {
+		if !e.dir {
+			continue
+		}
+		path := filepath.Join(root, filepath.FromSlash(e.path))
+		if err := os.Chmod(path, e.mode); err != nil {
+			return 0, errors.Join(intErrors.ErrSync, err)
+		}
+		if err := os.Chtimes(path, e.modTime, e.modTime); err != nil {
+			return 0, errors.Join(intErrors.ErrSync, err)
+		}
+	}
+	return deleted, nil
+}
@@ -0,0 +1,38 @@
+package dirsync
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+
+	intErrors "github.com/Onyz107/onynet/errors"
+)
+
+func TestPlanSymlinkedParent(t *testing.T) {
+	root, outside := t.TempDir(), t.TempDir()
+	if err := os.Symlink(outside, filepath.Join(root, "sub")); err != nil {
+		t.Skip(err)
+	}
+
+	// A peer lists a file of a directory without listing the directory, which is a symbolic link here
+	_, err := plan(root, []entry{{path: "sub/inner", dir: true, mode: 0o755}, {path: "sub/x", mode: 0o644}})
+	if !errors.Is(err, intErrors.ErrBadFileName) {
+		t.Fatalf("expected ErrBadFileName, got: %v", err)
+	}
+	if files, _ := os.ReadDir(outside); len(files) != 0 {
+		t.Fatalf("expected nothing written outside the root, got %d files", len(files))
+	}
+
+	// Listed, the directory replaces the link
+	needs, err := plan(root, []entry{{path: "sub", dir: true, mode: 0o755}, {path: "sub/x", mode: 0o644}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(needs) != 1 || needs[0].index != 1 {
+		t.Fatalf("expected sub/x to be needed, got %+v", needs)
+	}
+	if info, err := os.Lstat(filepath.Join(root, "sub")); err != nil || !info.IsDir() {
+		t.Fatalf("expected sub to be a directory, got: %v, %v", info, err)
+	}
+}
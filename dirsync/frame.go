@@ -0,0 +1,97 @@
+package dirsync
+
+import (
+	"encoding/binary"
+	"time"
+
+	"github.com/Onyz107/onynet"
+	intErrors "github.com/Onyz107/onynet/errors"
+)
+
+// writeFrame sends b on stream, encrypted and authenticated when the stream has a key.
+func writeFrame(stream *onynet.Stream, b []byte, timeout time.Duration) error {
+	if stream.IsEncrypted() {
+		return stream.SendEncrypted(b, timeout)
+	}
+	return stream.SendSerialized(b, timeout)
+}
+
+// readFrame receives a frame sent with writeFrame into buf, which must have room for the frame's overhead.
+func readFrame(stream *onynet.Stream, buf []byte, timeout time.Duration) ([]byte, error) {
+	var n uint64
+	var err error
+	if stream.IsEncrypted() {
+		n, err = stream.ReceiveEncrypted(buf, timeout)
+	} else {
+		n, err = stream.ReceiveSerialized(buf, timeout)
+	}
+	if err != nil {
+		return nil, err
+	}
+	return buf[:n], nil
+}
+
+// batchWriter packs records into frames of up to maxFrameSize bytes, each record being prefixed by its
+// length(4). The batch ends with an empty frame.
+type batchWriter struct {
+	stream  *onynet.Stream
+	timeout time.Duration
+	frame   []byte
+}
+
+func newBatchWriter(stream *onynet.Stream, timeout time.Duration) *batchWriter {
+	return &batchWriter{stream: stream, timeout: timeout}
+}
+
+func (w *batchWriter) add(record []byte) error {
+	if len(w.frame)+4+len(record) > maxFrameSize {
+		if err := w.flush(); err != nil {
+			return err
+		}
+	}
+	w.frame = binary.BigEndian.AppendUint32(w.frame, uint32(len(record)))
+	w.frame = append(w.frame, record...)
+	return nil
+}
+
+func (w *batchWriter) flush() error {
+	if len(w.frame) == 0 {
+		return nil
+	}
+	err := writeFrame(w.stream, w.frame, w.timeout)
+	w.frame = w.frame[:0]
+	return err
+}
+
+// close sends the records left and ends the batch.
+func (w *batchWriter) close() error {
+	if err := w.flush(); err != nil {
+		return err
+	}
+	return writeFrame(w.stream, nil, w.timeout)
+}
+
+// readBatch calls fn with every record of a batch sent with a batchWriter, the record is only valid during the call.
+func readBatch(stream *onynet.Stream, timeout time.Duration, fn func(record []byte) error) error {
+	buf := make([]byte, maxFrameSize+frameOverhead)
+	for {
+		frame, err := readFrame(stream, buf, timeout)
+		if err != nil {
+			return err
+		}
+		if len(frame) == 0 {
+			return nil
+		}
+
+		for len(frame) > 0 {
+			if len(frame) < 4 || len(frame)-4 < int(binary.BigEndian.Uint32(frame)) {
+				return intErrors.ErrMalformedFrame
+			}
+			length := binary.BigEndian.Uint32(frame)
+			if err := fn(frame[4 : 4+length]); err != nil {
+				return err
+			}
+			frame = frame[4+length:]
+		}
+	}
+}
@@ -0,0 +1,48 @@
+package dirsync
+
+import "time"
+
+// Option configures a synchronization.
+type Option func(*options)
+
+type options struct {
+	workers int
+	remove  bool
+	timeout time.Duration
+}
+
+func newOptions(opts []Option) options {
+	o := options{
+		workers: DefaultWorkers,
+		timeout: DefaultTimeout,
+	}
+	for _, opt := range opts {
+		opt(&o)
+	}
+	return o
+}
+
+// WithWorkers sets the number of files transferred in parallel, each on its own stream.
+// Numbers lower or equal to 0 mean DefaultWorkers.
+func WithWorkers(n int) Option {
+	return func(o *options) {
+		if n > 0 {
+			o.workers = n
+		}
+	}
+}
+
+// WithDelete deletes the files and directories of the destination which the source does not have,
+// once every file was transferred, making the destination an exact mirror of the source.
+func WithDelete() Option {
+	return func(o *options) {
+		o.remove = true
+	}
+}
+
+// WithTimeout bounds every message of a file transfer.
+func WithTimeout(timeout time.Duration) Option {
+	return func(o *options) {
+		o.timeout = timeout
+	}
+}
@@ -0,0 +1,34 @@
+package dirsync
+
+// rolling is the weak checksum of rsync over a window of bytes, which can slide over a file one byte at
+// a time: a is the sum of the bytes and b the sum of the bytes weighted by their distance to the window's end.
+type rolling struct {
+	a, b   uint16
+	length int
+}
+
+func newRolling(window []byte) rolling {
+	r := rolling{length: len(window)}
+	for i, x := range window {
+		r.a += uint16(x)
+		r.b += uint16(len(window)-i) * uint16(x)
+	}
+	return r
+}
+
+func (r rolling) sum() uint32 {
+	return uint32(r.a) | uint32(r.b)<<16
+}
+
+// roll slides the window by one byte, out leaving it and in entering it.
+func (r *rolling) roll(out, in byte) {
+	r.a += uint16(in) - uint16(out)
+	r.b += r.a - uint16(r.length)*uint16(out)
+}
+
+// shrink removes out, the first byte of the window, from it.
+func (r *rolling) shrink(out byte) {
+	r.a -= uint16(out)
+	r.b -= uint16(r.length) * uint16(out)
+	r.length--
+}
@@ -0,0 +1,235 @@
+package dirsync
+
+import (
+	"context"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sync"
+	"sync/atomic"
+
+	"github.com/Onyz107/onynet"
+	intErrors "github.com/Onyz107/onynet/errors"
+)
+
+// Server synchronizes the directories under its root with the clients it serves.
+// A single Server is shared by every client of an onynet.Server.
+type Server struct {
+	root     string
+	writable atomic.Bool
+	nextID   atomic.Uint32
+}
+
+// NewServer returns a Server for the directories under root, which clients may pull but not push to
+// until SetWritable(true) is called.
+func NewServer(root string) *Server {
+	return &Server{root: root}
+}
+
+// SetWritable sets whether clients may push to the directories under the server's root.
+func (s *Server) SetWritable(writable bool) {
+	s.writable.Store(writable)
+}
+
+// Serve accepts the synchronization streams of clientConn and serves them until ctx is cancelled or the
+// connection is closed. Serve blocks, so it is usually run in its own goroutine for every accepted client.
+//
+// Possible errors:
+//   - ErrCtxCancelled: ctx was cancelled
+//   - the errors returned by AcceptStream
+func (s *Server) Serve(clientConn *onynet.ClientConn, ctx context.Context) error {
+	// The sessions of the client, file streams may only join them
+	var mu sync.Mutex
+	sessions := make(map[uint32]*session)
+
+	for {
+		stream, err := clientConn.AcceptStream(StreamName, ctx, pollInterval)
+		if err != nil {
+			if errors.Is(err, intErrors.ErrTimeout) {
+				continue
+			}
+			return err
+		}
+
+		go func() {
+			defer stream.Close()
+			logger := stream.Logger()
+
+			frame, err := readFrame(stream, make([]byte, 0xFFFF+frameOverhead), DefaultTimeout)
+			if err != nil {
+				logger.Debug("failed to read sync request", "error", err, "error_kind", intErrors.Kind(err))
+				return
+			}
+
+			switch {
+			case len(frame) >= 3 && frame[0] == streamControl:
+				err = s.control(stream, frame[1], frame[2] == 1, string(frame[3:]), &mu, sessions)
+			case len(frame) == 10 && frame[0] == streamFile:
+				mu.Lock()
+				sess := sessions[binary.BigEndian.Uint32(frame[1:])]
+				mu.Unlock()
+				if sess == nil {
+					err = errors.Join(intErrors.ErrSync, errors.New("unknown session"))
+					break
+				}
+				err = sess.transfer(stream, binary.BigEndian.Uint32(frame[5:]), frame[9])
+			default:
+				err = intErrors.ErrMalformedFrame
+			}
+			if err != nil {
+				logger.Debug("sync failed", "error", err, "error_kind", intErrors.Kind(err))
+			}
+		}()
+	}
+}
+
+// control serves a synchronization of path, a directory under the server's root, from its control stream.
+func (s *Server) control(stream *onynet.Stream, direction byte, remove bool, path string, mu *sync.Mutex, sessions map[uint32]*session) error {
+	root, err := s.resolve(direction, path)
+	if err != nil {
+		writeStatus(stream, err, DefaultTimeout)
+		return err
+	}
+
+	id := s.nextID.Add(1)
+	sess := &session{root: root, source: direction == directionPull, timeout: DefaultTimeout}
+
+	// The control stream waits for the transfers, which may take any time, so it has no timeout
+	if direction == directionPull {
+		if sess.entries, err = scan(root); err != nil {
+			writeStatus(stream, err, 0)
+			return err
+		}
+	}
+	if err := writeFrame(stream, binary.BigEndian.AppendUint32([]byte{statusOK}, id), 0); err != nil {
+		return err
+	}
+
+	// The session is registered once its entries are known and before the client may start transfers
+	register := func() {
+		mu.Lock()
+		sessions[id] = sess
+		mu.Unlock()
+	}
+	defer func() {
+		mu.Lock()
+		delete(sessions, id)
+		mu.Unlock()
+	}()
+
+	if direction == directionPull {
+		register()
+		if err := writeEntries(stream, sess.entries); err != nil {
+			return err
+		}
+	} else {
+		if sess.entries, err = readEntries(stream); err != nil {
+			return err
+		}
+		needs, err := plan(root, sess.entries)
+		if err != nil {
+			writeStatus(stream, err, 0)
+			return err
+		}
+		register()
+		if err := writeStatus(stream, nil, 0); err != nil {
+			return err
+		}
+		if err := writeNeeds(stream, needs); err != nil {
+			return err
+		}
+	}
+
+	// The client tells once every file was transferred
+	if err := readStatus(stream, 0); err != nil {
+		return err
+	}
+	if direction == directionPull {
+		return nil
+	}
+
+	stats, err := sess.finish(remove)
+	if err != nil {
+		writeStatus(stream, err, 0)
+		return err
+	}
+	return writeFrame(stream, encodeStats(stats), 0)
+}
+
+// resolve returns the directory path names under the server's root, if the client may synchronize it in direction.
+func (s *Server) resolve(direction byte, path string) (string, error) {
+	if path != "" && path != "." && !validPath(path) {
+		return "", errors.Join(intErrors.ErrBadFileName, errors.New(path))
+	}
+	root := filepath.Join(s.root, filepath.FromSlash(path))
+
+	switch direction {
+	case directionPush:
+		if !s.writable.Load() {
+			return "", intErrors.ErrSyncDenied
+		}
+		return root, nil
+	case directionPull:
+		info, err := os.Stat(root)
+		if err != nil {
+			return "", errors.Join(intErrors.ErrSync, err)
+		}
+		if !info.IsDir() {
+			return "", errors.Join(intErrors.ErrSync, errors.New("not a directory"))
+		}
+		return root, nil
+	default:
+		return "", intErrors.ErrMalformedFrame
+	}
+}
+
+func writeEntries(stream *onynet.Stream, entries []entry) error {
+	batch := newBatchWriter(stream, 0)
+	for i := range entries {
+		if err := batch.add(encodeEntry(&entries[i])); err != nil {
+			return err
+		}
+	}
+	return batch.close()
+}
+
+func readEntries(stream *onynet.Stream) ([]entry, error) {
+	var entries []entry
+	err := readBatch(stream, 0, func(record []byte) error {
+		if len(entries) == maxEntries {
+			return errors.Join(intErrors.ErrSync, fmt.Errorf("more than %d entries", maxEntries))
+		}
+		e, err := decodeEntry(record)
+		if err != nil {
+			return err
+		}
+		entries = append(entries, e)
+		return nil
+	})
+	return entries, err
+}
+
+// writeNeeds sends the files the destination needs as a batch of index(4) + transfer(1).
+func writeNeeds(stream *onynet.Stream, needs []need) error {
+	batch := newBatchWriter(stream, 0)
+	for _, n := range needs {
+		if err := batch.add(append(binary.BigEndian.AppendUint32(nil, n.index), n.transfer)); err != nil {
+			return err
+		}
+	}
+	return batch.close()
+}
+
+func readNeeds(stream *onynet.Stream) ([]need, error) {
+	var needs []need
+	err := readBatch(stream, 0, func(record []byte) error {
+		if len(record) != 5 || len(needs) == maxEntries {
+			return intErrors.ErrMalformedFrame
+		}
+		needs = append(needs, need{binary.BigEndian.Uint32(record), record[4]})
+		return nil
+	})
+	return needs, err
+}
@@ -0,0 +1,199 @@
+package dirsync
+
+import (
+	"encoding/binary"
+	"errors"
+	"os"
+	"path/filepath"
+	"sync/atomic"
+	"time"
+
+	"github.com/Onyz107/onynet"
+	intErrors "github.com/Onyz107/onynet/errors"
+	"github.com/Onyz107/onynet/filetransfer"
+)
+
+// Stats describes what a synchronization did, as seen by the destination.
+type Stats struct {
+	// Files is the number of files transferred.
+	Files int64
+	// Literal is the number of bytes of the transferred files sent over the connection.
+	Literal int64
+	// Matched is the number of bytes of the transferred files the destination already had.
+	Matched int64
+	// Deleted is the number of files and directories deleted from the destination.
+	Deleted int64
+}
+
+func encodeStats(s *Stats) []byte {
+	frame := binary.BigEndian.AppendUint64([]byte{statusOK}, uint64(s.Files))
+	frame = binary.BigEndian.AppendUint64(frame, uint64(s.Literal))
+	frame = binary.BigEndian.AppendUint64(frame, uint64(s.Matched))
+	return binary.BigEndian.AppendUint64(frame, uint64(s.Deleted))
+}
+
+func decodeStats(frame []byte) (*Stats, error) {
+	if len(frame) != 33 {
+		return nil, intErrors.ErrMalformedFrame
+	}
+	return &Stats{
+		Files:   int64(binary.BigEndian.Uint64(frame[1:])),
+		Literal: int64(binary.BigEndian.Uint64(frame[9:])),
+		Matched: int64(binary.BigEndian.Uint64(frame[17:])),
+		Deleted: int64(binary.BigEndian.Uint64(frame[25:])),
+	}, nil
+}
+
+// session is one end of a synchronization: the source of the tree or its destination.
+type session struct {
+	root    string
+	entries []entry
+	source  bool
+	timeout time.Duration
+
+	// The destination counts what it received, and whether a file failed
+	files   atomic.Int64
+	literal atomic.Int64
+	matched atomic.Int64
+	failed  atomic.Bool
+}
+
+// transfer transfers the file at index of the session's entries on stream, sending or receiving it.
+func (s *session) transfer(stream *onynet.Stream, index uint32, kind byte) error {
+	if int(index) >= len(s.entries) || s.entries[index].dir {
+		return intErrors.ErrMalformedFrame
+	}
+	e := &s.entries[index]
+	path := filepath.Join(s.root, filepath.FromSlash(e.path))
+	stream.SetPriority(onynet.PriorityBulk)
+
+	var err error
+	switch {
+	case kind == transferFull && s.source:
+		_, err = filetransfer.SendFile(stream, path, filetransfer.WithName(e.path), filetransfer.WithTimeout(s.timeout))
+	case kind == transferFull:
+		err = s.receiveFull(stream, e)
+	case kind == transferDelta && s.source:
+		err = s.sendDelta(stream, path)
+	case kind == transferDelta:
+		err = s.receiveDelta(stream, path, e)
+	default:
+		err = intErrors.ErrMalformedFrame
+	}
+	if err != nil && !s.source {
+		s.failed.Store(true)
+	}
+	return err
+}
+
+func (s *session) receiveFull(stream *onynet.Stream, e *entry) error {
+	// The name is checked before anything is written, the directories of the entry are the only ones plan checked
+	meta, err := filetransfer.ReceiveFile(stream, s.root, filetransfer.WithName(e.path), filetransfer.WithTimeout(s.timeout))
+	if err != nil {
+		return err
+	}
+	s.files.Add(1)
+	s.literal.Add(meta.Size)
+	return nil
+}
+
+func (s *session) sendDelta(stream *onynet.Stream, path string) error {
+	file, err := os.Open(path)
+	if err != nil {
+		return errors.Join(intErrors.ErrSync, err)
+	}
+	defer file.Close()
+
+	blocks, size, err := receiveSignature(stream, s.timeout)
+	if err != nil {
+		return err
+	}
+	if err := sendDelta(stream, file, blocks, size, s.timeout); err != nil {
+		return err
+	}
+	return readStatus(stream, s.timeout)
+}
+
+func (s *session) receiveDelta(stream *onynet.Stream, path string, e *entry) error {
+	basis, err := os.Open(path)
+	if err != nil {
+		return errors.Join(intErrors.ErrSync, err)
+	}
+	defer basis.Close()
+
+	size, _, err := sendSignature(stream, basis, s.timeout)
+	if err != nil {
+		return err
+	}
+	literal, matched, err := receiveDelta(stream, path, basis, size, e, s.timeout)
+	writeStatus(stream, err, s.timeout)
+	if err != nil {
+		return err
+	}
+	s.files.Add(1)
+	s.literal.Add(literal)
+	s.matched.Add(matched)
+	return nil
+}
+
+// finish completes the destination once every file was transferred, see finish.
+func (s *session) finish(remove bool) (*Stats, error) {
+	deleted, err := finish(s.root, s.entries, remove && !s.failed.Load())
+	if err != nil {
+		return nil, err
+	}
+	stats := &Stats{
+		Files:   s.files.Load(),
+		Literal: s.literal.Load(),
+		Matched: s.matched.Load(),
+		Deleted: int64(deleted),
+	}
+	if s.failed.Load() {
+		return stats, errors.Join(intErrors.ErrSync, errors.New("some files failed to transfer"))
+	}
+	return stats, nil
+}
+
+// writeStatus answers a request with statusOK, or the status of err followed by its message.
+func writeStatus(stream *onynet.Stream, err error, timeout time.Duration) error {
+	if err == nil {
+		return writeFrame(stream, []byte{statusOK}, timeout)
+	}
+	status := statusError
+	switch {
+	case errors.Is(err, intErrors.ErrSyncDenied):
+		status = statusDenied
+	case errors.Is(err, intErrors.ErrBadFileName):
+		status = statusBadFileName
+	}
+	message := err.Error()
+	if len(message) > 0xFFFF {
+		message = message[:0xFFFF]
+	}
+	return writeFrame(stream, append([]byte{status}, message...), timeout)
+}
+
+// readStatus reads the answer written with writeStatus.
+func readStatus(stream *onynet.Stream, timeout time.Duration) error {
+	frame, err := readFrame(stream, make([]byte, 0xFFFF+frameOverhead), timeout)
+	if err != nil {
+		return err
+	}
+	return frameError(frame)
+}
+
+// frameError returns the error a status frame carries.
+func frameError(frame []byte) error {
+	switch {
+	case len(frame) == 0:
+		return intErrors.ErrMalformedFrame
+	case frame[0] == statusOK:
+		return nil
+	case frame[0] == statusDenied:
+		return errors.Join(intErrors.ErrSync, intErrors.ErrSyncDenied, errors.New(string(frame[1:])))
+	case frame[0] == statusBadFileName:
+		return errors.Join(intErrors.ErrSync, intErrors.ErrBadFileName, errors.New(string(frame[1:])))
+	default:
+		return errors.Join(intErrors.ErrSync, errors.New(string(frame[1:])))
+	}
+}
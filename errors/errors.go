@@ -1,6 +1,9 @@
 package errors
 
-import "errors"
+import (
+	"errors"
+	"fmt"
+)
 
 // Miscellaneous error
 var (
@@ -22,7 +25,7 @@ var (
 // KCP error
 var (
 	ErrBadAddr = errors.New("invalid or unreachable address")
-	ErrAccept  = errors.New("accept failed on KCP listener")
+	ErrAccept  = errors.New("accept failed on listener")
 )
 
 // Stream error
@@ -32,6 +35,7 @@ var (
 	ErrNameMismatch = errors.New("name mismatch")
 	ErrTimeout      = errors.New("timeout")
 	ErrNameTooLong  = errors.New("name too long")
+	ErrConnClosing  = errors.New("connection is closing")
 )
 
 // Transfer error
@@ -60,7 +64,199 @@ var (
 	ErrPrivateKey = errors.New("private key malformed or does not match public key")
 )
 
+// Admission error
+var (
+	ErrRejected        = errors.New("connection rejected by server")
+	ErrTooManyClients  = errors.New("too many clients")
+	ErrTooManyFromIP   = errors.New("too many clients from the same address")
+	ErrHandshakeRate   = errors.New("handshake rate exceeded")
+	ErrConnDenied      = errors.New("connection denied by filter")
+	ErrBadCIDR         = errors.New("invalid CIDR prefix or IP address")
+	ErrInvalidCookie   = errors.New("invalid or expired handshake cookie")
+	ErrUnknownRejected = errors.New("unknown rejection reason")
+)
+
+// Disconnect reason
+var (
+	ErrHeartbeatTimeout = errors.New("heartbeat timeout")
+	ErrPeerClosed       = errors.New("connection closed by peer")
+	ErrIdleTimeout      = errors.New("idle timeout")
+	ErrClosedLocally    = errors.New("connection closed locally")
+)
+
+// CloseCode is the numeric code a connection is closed gracefully with.
+type CloseCode uint16
+
+// CloseError carries the code and reason of a graceful close, it is joined with the disconnect reason.
+type CloseError struct {
+	Code   CloseCode
+	Reason string
+}
+
+func (e *CloseError) Error() string {
+	if e.Reason == "" {
+		return fmt.Sprintf("closed with code %d", e.Code)
+	}
+	return fmt.Sprintf("closed with code %d: %s", e.Code, e.Reason)
+}
+
 // Heartbeat error
 var (
 	ErrUnexpectedMsg = errors.New("unexpected message received")
 )
+
+// RPC error
+var (
+	ErrUnknownMethod  = errors.New("unknown method")
+	ErrMethodTooLong  = errors.New("method name too long")
+	ErrRemote         = errors.New("remote error")
+	ErrMessageTooLong = errors.New("message too long")
+	ErrMalformedFrame = errors.New("malformed frame")
+	ErrConnClosed     = errors.New("connection closed")
+	ErrTooManyCalls   = errors.New("too many concurrent calls")
+)
+
+// Pub/Sub error
+var (
+	ErrTopicTooLong = errors.New("topic too long")
+	ErrSlowConsumer = errors.New("subscriber queue full")
+)
+
+// Datagram error
+var (
+	ErrDatagramTooLarge    = errors.New("datagram too large")
+	ErrDatagramUnsupported = errors.New("datagrams are not supported by the transport")
+)
+
+// Rendezvous error
+var (
+	ErrNameTaken    = errors.New("peer name already registered")
+	ErrPeerNotFound = errors.New("peer not registered")
+	ErrHolePunch    = errors.New("failed to connect directly to the peer")
+)
+
+// Relay error
+var (
+	ErrRelay       = errors.New("failed to relay the connection")
+	ErrRelayDenied = errors.New("relay denied by the server")
+	ErrRelayKey    = errors.New("the key of the relayed client is not signed by its identity")
+)
+
+// Forward error
+var (
+	ErrForward       = errors.New("failed to forward the connection")
+	ErrForwardDenied = errors.New("forward denied by the server")
+)
+
+// File transfer error
+var (
+	ErrIntegrity    = errors.New("file integrity check failed")
+	ErrBadFileName  = errors.New("invalid file name")
+	ErrFileTransfer = errors.New("failed to transfer the file")
+)
+
+// Sync error
+var (
+	ErrSync       = errors.New("failed to synchronize the directory")
+	ErrSyncDenied = errors.New("synchronization denied by the server")
+)
+
+// kinds maps the sentinel errors to the kind Kind reports, in the order they are matched.
+var kinds = []struct {
+	err  error
+	kind string
+}{
+	{ErrCtxCancelled, "ctx_cancelled"},
+	{ErrWrite, "write"},
+	{ErrRead, "read"},
+	{ErrShortWrite, "short_write"},
+	{ErrHeartbeatStream, "heartbeat_stream"},
+	{ErrCipher, "cipher"},
+	{ErrGCM, "gcm"},
+	{ErrShort, "short"},
+	{ErrDecrypt, "decrypt"},
+	{ErrBadAddr, "bad_addr"},
+	{ErrAccept, "accept"},
+	{ErrAcceptStream, "accept_stream"},
+	{ErrOpenStream, "open_stream"},
+	{ErrNameMismatch, "name_mismatch"},
+	{ErrTimeout, "timeout"},
+	{ErrNameTooLong, "name_too_long"},
+	{ErrConnClosing, "conn_closing"},
+	{ErrSmallBuffer, "small_buffer"},
+	{ErrAESKey, "aes_key"},
+	{ErrStreamCipher, "stream_cipher"},
+	{ErrNewServer, "new_server"},
+	{ErrAcceptClient, "accept_client"},
+	{ErrCreateSession, "create_session"},
+	{ErrDial, "dial"},
+	{ErrAuth, "auth"},
+	{ErrPublickey, "public_key"},
+	{ErrPrivateKey, "private_key"},
+	{ErrRejected, "rejected"},
+	{ErrTooManyClients, "too_many_clients"},
+	{ErrTooManyFromIP, "too_many_from_ip"},
+	{ErrHandshakeRate, "handshake_rate"},
+	{ErrConnDenied, "conn_denied"},
+	{ErrBadCIDR, "bad_cidr"},
+	{ErrInvalidCookie, "invalid_cookie"},
+	{ErrUnknownRejected, "unknown_rejected"},
+	{ErrHeartbeatTimeout, "heartbeat_timeout"},
+	{ErrPeerClosed, "peer_closed"},
+	{ErrIdleTimeout, "idle_timeout"},
+	{ErrClosedLocally, "closed_locally"},
+	{ErrUnexpectedMsg, "unexpected_msg"},
+	{ErrUnknownMethod, "unknown_method"},
+	{ErrMethodTooLong, "method_too_long"},
+	{ErrRemote, "remote"},
+	{ErrMessageTooLong, "message_too_long"},
+	{ErrMalformedFrame, "malformed_frame"},
+	{ErrConnClosed, "conn_closed"},
+	{ErrTooManyCalls, "too_many_calls"},
+	{ErrTopicTooLong, "topic_too_long"},
+	{ErrSlowConsumer, "slow_consumer"},
+	{ErrDatagramTooLarge, "datagram_too_large"},
+	{ErrDatagramUnsupported, "datagram_unsupported"},
+	{ErrNameTaken, "name_taken"},
+	{ErrPeerNotFound, "peer_not_found"},
+	{ErrHolePunch, "hole_punch"},
+	{ErrRelay, "relay"},
+	{ErrRelayDenied, "relay_denied"},
+	{ErrRelayKey, "relay_key"},
+	{ErrForward, "forward"},
+	{ErrForwardDenied, "forward_denied"},
+	{ErrIntegrity, "integrity"},
+	{ErrBadFileName, "bad_file_name"},
+	{ErrFileTransfer, "file_transfer"},
+	{ErrSync, "sync"},
+	{ErrSyncDenied, "sync_denied"},
+}
+
+// Kind returns a short name of the first sentinel error of this package found in err, such as "timeout"
+// for ErrTimeout, "close" for a CloseError and "unknown" if err carries none. The errors joined by
+// errors.Join are searched in order, so the outermost sentinel wins. Failure logs carry it as "error_kind".
+func Kind(err error) string {
+	if err == nil {
+		return ""
+	}
+	for _, k := range kinds {
+		if err == k.err {
+			return k.kind
+		}
+	}
+	if _, ok := err.(*CloseError); ok {
+		return "close"
+	}
+
+	switch wrapped := err.(type) {
+	case interface{ Unwrap() []error }:
+		for _, err := range wrapped.Unwrap() {
+			if kind := Kind(err); kind != "unknown" {
+				return kind
+			}
+		}
+	case interface{ Unwrap() error }:
+		return Kind(wrapped.Unwrap())
+	}
+	return "unknown"
+}
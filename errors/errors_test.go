@@ -0,0 +1,29 @@
+package errors_test
+
+import (
+	"errors"
+	"fmt"
+	"testing"
+
+	intErrors "github.com/Onyz107/onynet/errors"
+)
+
+func TestKind(t *testing.T) {
+	for _, test := range []struct {
+		err  error
+		kind string
+	}{
+		{nil, ""},
+		{intErrors.ErrTimeout, "timeout"},
+		{errors.Join(intErrors.ErrAuth, intErrors.ErrRead, errors.New("EOF")), "auth"},
+		{errors.Join(errors.New("EOF"), intErrors.ErrRead), "read"},
+		{fmt.Errorf("dialing: %w", intErrors.ErrTooManyFromIP), "too_many_from_ip"},
+		{errors.Join(intErrors.ErrPeerClosed, &intErrors.CloseError{Code: 1000}), "peer_closed"},
+		{&intErrors.CloseError{Code: 1000}, "close"},
+		{errors.New("EOF"), "unknown"},
+	} {
+		if kind := intErrors.Kind(test.err); kind != test.kind {
+			t.Errorf("expected %q for %v, got: %q", test.kind, test.err, kind)
+		}
+	}
+}
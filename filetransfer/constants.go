@@ -0,0 +1,26 @@
+package filetransfer
+
+import "time"
+
+// StreamName is the name of the stream Send and Receive transfer a file through.
+const StreamName = "onynet/filetransfer"
+
+const (
+	// DefaultChunkSize is the size of the chunks a file is sent in when no size is given.
+	DefaultChunkSize = 256 * 1024
+	// maxChunkSize is the largest chunk size a receiver accepts.
+	maxChunkSize = 16 * 1024 * 1024
+	// DefaultTimeout bounds every message of a transfer when no timeout is given.
+	DefaultTimeout = 30 * time.Second
+)
+
+// partialSuffix ends the name of the file a transfer is received into until it is verified.
+const partialSuffix = ".part"
+
+const (
+	resultOK byte = iota
+	resultMismatch
+)
+
+// frameOverhead is the most the framing and encryption of a message add to it.
+const frameOverhead = 64
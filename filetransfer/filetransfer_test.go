@@ -0,0 +1,188 @@
+package filetransfer_test
+
+import (
+	"bytes"
+	"crypto/rand"
+	"crypto/rsa"
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	intErrors "github.com/Onyz107/onynet/errors"
+	"github.com/Onyz107/onynet/filetransfer"
+	"github.com/Onyz107/onynet/onynettest"
+)
+
+const chunkSize = 16 * 1024
+
+// newFile writes size random bytes to a file and returns its path and content.
+func newFile(tb testing.TB, size int) (string, []byte) {
+	tb.Helper()
+
+	data := make([]byte, size)
+	rand.Read(data)
+	path := filepath.Join(tb.TempDir(), "data.bin")
+	if err := os.WriteFile(path, data, 0o640); err != nil {
+		tb.Fatal(err)
+	}
+	modTime := time.Date(2024, 3, 1, 12, 0, 0, 0, time.UTC)
+	if err := os.Chtimes(path, modTime, modTime); err != nil {
+		tb.Fatal(err)
+	}
+	return path, data
+}
+
+type result struct {
+	meta *filetransfer.Metadata
+	err  error
+}
+
+func TestTransfer(t *testing.T) {
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for name, privateKey := range map[string]*rsa.PrivateKey{"plain": nil, "encrypted": key} {
+		t.Run(name, func(t *testing.T) {
+			pair := onynettest.NewPair(t, privateKey)
+			path, data := newFile(t, 10*chunkSize+123)
+			dir := t.TempDir()
+
+			received := make(chan result, 1)
+			go func() {
+				meta, err := filetransfer.Receive(pair.ClientConn, dir, t.Context())
+				received <- result{meta, err}
+			}()
+
+			var last int64
+			sent, err := filetransfer.Send(pair.Client, path, t.Context(),
+				filetransfer.WithName("nested/copy.bin"),
+				filetransfer.WithChunkSize(chunkSize),
+				filetransfer.WithProgress(func(transferred, total int64) { last = transferred }))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if last != int64(len(data)) {
+				t.Fatalf("expected progress to reach %d, got %d", len(data), last)
+			}
+
+			r := <-received
+			if r.err != nil {
+				t.Fatal(r.err)
+			}
+			if r.meta.Hash != sent.Hash || r.meta.Name != "nested/copy.bin" {
+				t.Fatalf("unexpected metadata: %+v", r.meta)
+			}
+
+			target := filepath.Join(dir, "nested", "copy.bin")
+			got, err := os.ReadFile(target)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !bytes.Equal(got, data) {
+				t.Fatal("received file differs from the sent one")
+			}
+			info, _ := os.Stat(target)
+			if info.Mode().Perm() != 0o640 || !info.ModTime().Equal(sent.ModTime) {
+				t.Fatalf("unexpected mode or modification time: %v %v", info.Mode(), info.ModTime())
+			}
+		})
+	}
+}
+
+func TestResume(t *testing.T) {
+	pair := onynettest.NewPair(t, nil)
+	path, data := newFile(t, 20*chunkSize)
+	dir := t.TempDir()
+
+	// The first transfer is interrupted after a few chunks
+	received := make(chan result, 1)
+	go func() {
+		meta, err := filetransfer.Receive(pair.ClientConn, dir, t.Context())
+		received <- result{meta, err}
+	}()
+	stream, err := pair.Client.OpenStream(filetransfer.StreamName, t.Context(), 5*time.Second)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = filetransfer.SendFile(stream, path,
+		filetransfer.WithChunkSize(chunkSize),
+		filetransfer.WithProgress(func(transferred, total int64) {
+			if transferred >= 5*chunkSize {
+				stream.Close()
+			}
+		}))
+	if err == nil {
+		t.Fatal("expected the interrupted transfer to fail")
+	}
+	if r := <-received; r.err == nil {
+		t.Fatal("expected the interrupted receive to fail")
+	}
+
+	// The second one resumes where the receiver stopped
+	go func() {
+		meta, err := filetransfer.Receive(pair.ClientConn, dir, t.Context())
+		received <- result{meta, err}
+	}()
+	var first int64 = -1
+	if _, err := filetransfer.Send(pair.Client, path, t.Context(),
+		filetransfer.WithChunkSize(chunkSize),
+		filetransfer.WithProgress(func(transferred, total int64) {
+			if first < 0 {
+				first = transferred
+			}
+		})); err != nil {
+		t.Fatal(err)
+	}
+	if r := <-received; r.err != nil {
+		t.Fatal(r.err)
+	}
+	if first <= chunkSize {
+		t.Fatalf("expected the transfer to resume, first progress at %d", first)
+	}
+
+	got, err := os.ReadFile(filepath.Join(dir, "data.bin"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(got, data) {
+		t.Fatal("resumed file differs from the sent one")
+	}
+	entries, _ := os.ReadDir(dir)
+	if len(entries) != 1 {
+		t.Fatalf("expected the partial file to be gone, got %d entries", len(entries))
+	}
+}
+
+func TestBadName(t *testing.T) {
+	pair := onynettest.NewPair(t, nil)
+	path, _ := newFile(t, 10)
+
+	_, err := filetransfer.Send(pair.Client, path, t.Context(), filetransfer.WithName("../escape.bin"))
+	if !errors.Is(err, intErrors.ErrBadFileName) {
+		t.Fatalf("expected ErrBadFileName, got: %v", err)
+	}
+}
+
+func TestUnexpectedName(t *testing.T) {
+	pair := onynettest.NewPair(t, nil)
+	path, _ := newFile(t, 10)
+	dir := t.TempDir()
+
+	received := make(chan result, 1)
+	go func() {
+		meta, err := filetransfer.Receive(pair.ClientConn, dir, t.Context(), filetransfer.WithName("expected.bin"))
+		received <- result{meta, err}
+	}()
+
+	filetransfer.Send(pair.Client, path, t.Context())
+	if r := <-received; !errors.Is(r.err, intErrors.ErrBadFileName) {
+		t.Fatalf("expected ErrBadFileName, got: %v", r.err)
+	}
+	if files, _ := os.ReadDir(dir); len(files) != 0 {
+		t.Fatalf("expected nothing written, got %d files", len(files))
+	}
+}
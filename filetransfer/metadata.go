@@ -0,0 +1,91 @@
+package filetransfer
+
+import (
+	"crypto/sha256"
+	"encoding/binary"
+	"errors"
+	"io/fs"
+	"path/filepath"
+	"time"
+
+	"github.com/Onyz107/onynet"
+	intErrors "github.com/Onyz107/onynet/errors"
+)
+
+// Metadata describes a transferred file.
+type Metadata struct {
+	// Name is the path the file is received at, relative to the receiver's directory and using forward slashes.
+	Name    string
+	Size    int64
+	Mode    fs.FileMode
+	ModTime time.Time
+	// Hash is the SHA-256 of the whole file.
+	Hash [sha256.Size]byte
+}
+
+// encodeMetadata encodes metadata as name length(2) + name + size(8) + mode(4) + modification time(8)
+// + hash(32) + chunk size(4).
+func encodeMetadata(meta *Metadata, chunkSize int) []byte {
+	frame := binary.BigEndian.AppendUint16(nil, uint16(len(meta.Name)))
+	frame = append(frame, meta.Name...)
+	frame = binary.BigEndian.AppendUint64(frame, uint64(meta.Size))
+	frame = binary.BigEndian.AppendUint32(frame, uint32(meta.Mode))
+	frame = binary.BigEndian.AppendUint64(frame, uint64(meta.ModTime.UnixNano()))
+	frame = append(frame, meta.Hash[:]...)
+	return binary.BigEndian.AppendUint32(frame, uint32(chunkSize))
+}
+
+func decodeMetadata(frame []byte) (*Metadata, int, error) {
+	if len(frame) < 2 {
+		return nil, 0, intErrors.ErrMalformedFrame
+	}
+	nameLength := int(binary.BigEndian.Uint16(frame))
+	if len(frame) != 2+nameLength+8+4+8+sha256.Size+4 {
+		return nil, 0, intErrors.ErrMalformedFrame
+	}
+	frame = frame[2:]
+
+	meta := &Metadata{Name: string(frame[:nameLength])}
+	frame = frame[nameLength:]
+	meta.Size = int64(binary.BigEndian.Uint64(frame))
+	meta.Mode = fs.FileMode(binary.BigEndian.Uint32(frame[8:]))
+	meta.ModTime = time.Unix(0, int64(binary.BigEndian.Uint64(frame[12:])))
+	copy(meta.Hash[:], frame[20:])
+	chunkSize := int(binary.BigEndian.Uint32(frame[20+sha256.Size:]))
+
+	if meta.Size < 0 || chunkSize <= 0 || chunkSize > maxChunkSize {
+		return nil, 0, intErrors.ErrMalformedFrame
+	}
+	if !validName(meta.Name) {
+		return nil, 0, errors.Join(intErrors.ErrBadFileName, errors.New(meta.Name))
+	}
+	return meta, chunkSize, nil
+}
+
+// validName reports whether name is a relative path which stays within the receiver's directory.
+func validName(name string) bool {
+	return len(name) <= 0xFFFF && fs.ValidPath(name) && name != "." && filepath.IsLocal(filepath.FromSlash(name))
+}
+
+// writeFrame sends b on stream, encrypted and authenticated when the stream has a key.
+func writeFrame(stream *onynet.Stream, b []byte, timeout time.Duration) error {
+	if stream.IsEncrypted() {
+		return stream.SendEncrypted(b, timeout)
+	}
+	return stream.SendSerialized(b, timeout)
+}
+
+// readFrame receives a frame sent with writeFrame into buf, which must have room for the frame's overhead.
+func readFrame(stream *onynet.Stream, buf []byte, timeout time.Duration) ([]byte, error) {
+	var n uint64
+	var err error
+	if stream.IsEncrypted() {
+		n, err = stream.ReceiveEncrypted(buf, timeout)
+	} else {
+		n, err = stream.ReceiveSerialized(buf, timeout)
+	}
+	if err != nil {
+		return nil, err
+	}
+	return buf[:n], nil
+}
@@ -0,0 +1,61 @@
+package filetransfer
+
+import "time"
+
+// Progress is called as a transfer goes on with the number of bytes of the file which are transferred,
+// including the ones of a resumed transfer sent before, and the size of the file.
+type Progress func(transferred, total int64)
+
+// Option configures a transfer.
+type Option func(*options)
+
+type options struct {
+	name      string
+	chunkSize int
+	timeout   time.Duration
+	progress  Progress
+}
+
+func newOptions(opts []Option) options {
+	o := options{
+		chunkSize: DefaultChunkSize,
+		timeout:   DefaultTimeout,
+	}
+	for _, opt := range opts {
+		opt(&o)
+	}
+	return o
+}
+
+// WithName sends the file under name rather than its base name. The name may be a relative path using
+// forward slashes, which the receiver creates the directories of. Given to the receiver, it refuses
+// a file sent under another name before writing anything.
+func WithName(name string) Option {
+	return func(o *options) {
+		o.name = name
+	}
+}
+
+// WithChunkSize sets the size of the chunks the file is sent in, each of them being verified on its own.
+// Sizes lower or equal to 0 mean DefaultChunkSize.
+func WithChunkSize(size int) Option {
+	return func(o *options) {
+		if size > 0 {
+			o.chunkSize = min(size, maxChunkSize)
+		}
+	}
+}
+
+// WithTimeout bounds every message of the transfer, and accepting the stream of Receive.
+func WithTimeout(timeout time.Duration) Option {
+	return func(o *options) {
+		o.timeout = timeout
+	}
+}
+
+// WithProgress calls progress after every chunk transferred.
+func WithProgress(progress Progress) Option {
+	return func(o *options) {
+		o.progress = progress
+	}
+}
@@ -0,0 +1,181 @@
+package filetransfer
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/hex"
+	"errors"
+	"hash"
+	"io"
+	"os"
+	"path/filepath"
+
+	"github.com/Onyz107/onynet"
+	intErrors "github.com/Onyz107/onynet/errors"
+)
+
+// Receive accepts a stream named StreamName on h and receives a file through it into dir, see ReceiveFile.
+//
+// Possible errors:
+//   - the errors returned by AcceptStream and ReceiveFile
+func Receive(h onynet.Handler, dir string, ctx context.Context, opts ...Option) (*Metadata, error) {
+	o := newOptions(opts)
+	stream, err := h.AcceptStream(StreamName, ctx, o.timeout)
+	if err != nil {
+		return nil, err
+	}
+	defer stream.Close()
+	return ReceiveFile(stream, dir, opts...)
+}
+
+// ReceiveFile receives a file sent with SendFile on stream into dir, under the name it was sent with,
+// and returns its metadata. The file is written to a hidden partial file next to it, which only ever holds
+// verified chunks, then renamed once the SHA-256 of the whole file is verified and its mode and modification
+// time are set. An interrupted transfer resumes from the partial file when the same file is sent again.
+//
+// Possible errors:
+//   - ErrBadFileName: the name the file was sent with is not a local relative path, or not the one given with WithName
+//   - ErrIntegrity: a chunk or the whole file does not match its hash, a corrupted chunk is not written
+//   - ErrFileTransfer: the file cannot be written
+//   - ErrMalformedFrame: the sender does not follow the protocol
+//   - the errors of the stream's transfer methods
+func ReceiveFile(stream *onynet.Stream, dir string, opts ...Option) (*Metadata, error) {
+	o := newOptions(opts)
+
+	buf := make([]byte, 0xFFFF+frameOverhead*2)
+	frame, err := readFrame(stream, buf, o.timeout)
+	if err != nil {
+		return nil, err
+	}
+	meta, chunkSize, err := decodeMetadata(frame)
+	if err != nil {
+		return nil, err
+	}
+	if o.name != "" && meta.Name != o.name {
+		return nil, errors.Join(intErrors.ErrBadFileName, errors.New(meta.Name))
+	}
+
+	target := filepath.Join(dir, filepath.FromSlash(meta.Name))
+	if err := os.MkdirAll(filepath.Dir(target), 0o755); err != nil {
+		return nil, errors.Join(intErrors.ErrFileTransfer, err)
+	}
+	partial := partialPath(target, meta)
+	file, err := os.OpenFile(partial, os.O_RDWR|os.O_CREATE, 0o600)
+	if err != nil {
+		return nil, errors.Join(intErrors.ErrFileTransfer, err)
+	}
+	defer file.Close()
+
+	offset, fileHash, err := resume(stream, file, meta.Size, o)
+	if err != nil {
+		return nil, err
+	}
+
+	if len(buf) < chunkSize+sha256.Size+frameOverhead {
+		buf = make([]byte, chunkSize+sha256.Size+frameOverhead)
+	}
+	for offset < meta.Size {
+		frame, err := readFrame(stream, buf, o.timeout)
+		if err != nil {
+			return nil, err
+		}
+		if len(frame) <= sha256.Size || len(frame)-sha256.Size > chunkSize || offset+int64(len(frame)-sha256.Size) > meta.Size {
+			return nil, intErrors.ErrMalformedFrame
+		}
+
+		chunk, expected := frame[:len(frame)-sha256.Size], frame[len(frame)-sha256.Size:]
+		if hash := sha256.Sum256(chunk); string(hash[:]) != string(expected) {
+			return nil, errors.Join(intErrors.ErrIntegrity, errors.New("chunk hash mismatch"))
+		}
+		if _, err := file.WriteAt(chunk, offset); err != nil {
+			return nil, errors.Join(intErrors.ErrFileTransfer, err)
+		}
+		fileHash.Write(chunk)
+
+		offset += int64(len(chunk))
+		if o.progress != nil {
+			o.progress(offset, meta.Size)
+		}
+	}
+
+	if string(fileHash.Sum(nil)) != string(meta.Hash[:]) {
+		writeFrame(stream, []byte{resultMismatch}, o.timeout)
+		file.Close()
+		os.Remove(partial)
+		return nil, errors.Join(intErrors.ErrIntegrity, errors.New("file hash mismatch"))
+	}
+
+	if err := finish(file, partial, target, meta); err != nil {
+		return nil, err
+	}
+	if err := writeFrame(stream, []byte{resultOK}, o.timeout); err != nil {
+		return nil, err
+	}
+	return meta, nil
+}
+
+// partialPath returns the path a file is received at until it is verified, it depends on the file's hash
+// so a transfer only resumes from the same file.
+func partialPath(target string, meta *Metadata) string {
+	name := "." + filepath.Base(target) + "." + hex.EncodeToString(meta.Hash[:8]) + partialSuffix
+	return filepath.Join(filepath.Dir(target), name)
+}
+
+// resume tells the sender how much of the file is held along with its hash, and returns the offset the
+// sender resumes from with the hash of the file up to it.
+func resume(stream *onynet.Stream, file *os.File, size int64, o options) (int64, hash.Hash, error) {
+	info, err := file.Stat()
+	if err != nil {
+		return 0, nil, errors.Join(intErrors.ErrFileTransfer, err)
+	}
+	held := min(info.Size(), size)
+
+	fileHash := sha256.New()
+	if _, err := io.Copy(fileHash, io.NewSectionReader(file, 0, held)); err != nil {
+		return 0, nil, errors.Join(intErrors.ErrFileTransfer, err)
+	}
+	request := binary.BigEndian.AppendUint64(nil, uint64(held))
+	if err := writeFrame(stream, fileHash.Sum(request), o.timeout); err != nil {
+		return 0, nil, err
+	}
+
+	frame, err := readFrame(stream, make([]byte, 8+frameOverhead), o.timeout)
+	if err != nil {
+		return 0, nil, err
+	}
+	if len(frame) != 8 {
+		return 0, nil, intErrors.ErrMalformedFrame
+	}
+
+	offset := int64(binary.BigEndian.Uint64(frame))
+	switch offset {
+	case held:
+	case 0:
+		fileHash.Reset()
+	default:
+		return 0, nil, intErrors.ErrMalformedFrame
+	}
+	if err := file.Truncate(offset); err != nil {
+		return 0, nil, errors.Join(intErrors.ErrFileTransfer, err)
+	}
+	return offset, fileHash, nil
+}
+
+// finish gives the verified partial file the mode and modification time of meta and renames it to target.
+func finish(file *os.File, partial, target string, meta *Metadata) error {
+	if err := file.Sync(); err != nil {
+		return errors.Join(intErrors.ErrFileTransfer, err)
+	}
+	if err := file.Chmod(meta.Mode.Perm()); err != nil {
+		return errors.Join(intErrors.ErrFileTransfer, err)
+	}
+	file.Close()
+	if err := os.Chtimes(partial, meta.ModTime, meta.ModTime); err != nil {
+		return errors.Join(intErrors.ErrFileTransfer, err)
+	}
+	if err := os.Rename(partial, target); err != nil {
+		return errors.Join(intErrors.ErrFileTransfer, err)
+	}
+	return nil
+}
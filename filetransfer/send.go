@@ -0,0 +1,174 @@
+package filetransfer
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/binary"
+	"errors"
+	"io"
+	"os"
+	"path/filepath"
+
+	"github.com/Onyz107/onynet"
+	intErrors "github.com/Onyz107/onynet/errors"
+)
+
+// Send opens a stream named StreamName on h and sends the file at path through it, see SendFile.
+// The file is read and hashed before the stream is opened.
+//
+// Possible errors:
+//   - the errors returned by OpenStream and SendFile
+func Send(h onynet.Handler, path string, ctx context.Context, opts ...Option) (*Metadata, error) {
+	o := newOptions(opts)
+	file, meta, err := open(path, o.name)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	stream, err := h.OpenStream(StreamName, ctx, o.timeout)
+	if err != nil {
+		return nil, err
+	}
+	defer stream.Close()
+	return sendFile(stream, file, meta, o)
+}
+
+// SendFile sends the file at path on stream to a ReceiveFile on the other end, and returns its metadata once
+// the receiver verified it. When the receiver holds part of the file from an interrupted transfer, the
+// transfer resumes after it if it matches the start of the file. Every chunk is sent along with its SHA-256,
+// and the chunks are encrypted and authenticated with AES-GCM when the stream has a key.
+//
+// Possible errors:
+//   - ErrFileTransfer: the file cannot be read
+//   - ErrBadFileName: the name the file is sent under is not a local relative path
+//   - ErrIntegrity: the file received does not match the file hashed, usually as it changed while it was sent
+//   - the errors of the stream's transfer methods
+func SendFile(stream *onynet.Stream, path string, opts ...Option) (*Metadata, error) {
+	o := newOptions(opts)
+	file, meta, err := open(path, o.name)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+	return sendFile(stream, file, meta, o)
+}
+
+func sendFile(stream *onynet.Stream, file *os.File, meta *Metadata, o options) (*Metadata, error) {
+	stream.SetPriority(onynet.PriorityBulk)
+
+	if err := writeFrame(stream, encodeMetadata(meta, o.chunkSize), o.timeout); err != nil {
+		return nil, err
+	}
+
+	offset, err := resumeOffset(stream, file, meta.Size, o)
+	if err != nil {
+		return nil, err
+	}
+	if _, err := file.Seek(offset, io.SeekStart); err != nil {
+		return nil, errors.Join(intErrors.ErrFileTransfer, err)
+	}
+
+	chunk := make([]byte, o.chunkSize, o.chunkSize+sha256.Size)
+	for transferred := offset; transferred < meta.Size; {
+		n, err := io.ReadFull(file, chunk[:min(int64(o.chunkSize), meta.Size-transferred)])
+		if err != nil {
+			return nil, errors.Join(intErrors.ErrFileTransfer, err)
+		}
+		hash := sha256.Sum256(chunk[:n])
+		if err := writeFrame(stream, append(chunk[:n], hash[:]...), o.timeout); err != nil {
+			return nil, err
+		}
+
+		transferred += int64(n)
+		if o.progress != nil {
+			o.progress(transferred, meta.Size)
+		}
+	}
+
+	result := make([]byte, 1, frameOverhead)
+	if result, err = readFrame(stream, result[:cap(result)], o.timeout); err != nil {
+		return nil, err
+	}
+	if len(result) != 1 || result[0] != resultOK {
+		return nil, intErrors.ErrIntegrity
+	}
+	return meta, nil
+}
+
+// open opens the file at path and returns it with its metadata, hashing it whole.
+func open(path, name string) (*os.File, *Metadata, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, nil, errors.Join(intErrors.ErrFileTransfer, err)
+	}
+	meta, err := describe(file, name)
+	if err != nil {
+		file.Close()
+		return nil, nil, err
+	}
+	return file, meta, nil
+}
+
+// describe returns the metadata of file, hashing it whole.
+func describe(file *os.File, name string) (*Metadata, error) {
+	info, err := file.Stat()
+	if err != nil {
+		return nil, errors.Join(intErrors.ErrFileTransfer, err)
+	}
+	if !info.Mode().IsRegular() {
+		return nil, errors.Join(intErrors.ErrFileTransfer, errors.New("not a regular file"))
+	}
+	if name == "" {
+		name = filepath.Base(file.Name())
+	}
+	if !validName(name) {
+		return nil, errors.Join(intErrors.ErrBadFileName, errors.New(name))
+	}
+
+	hash := sha256.New()
+	if _, err := io.Copy(hash, file); err != nil {
+		return nil, errors.Join(intErrors.ErrFileTransfer, err)
+	}
+
+	meta := &Metadata{
+		Name:    name,
+		Size:    info.Size(),
+		Mode:    info.Mode().Perm(),
+		ModTime: info.ModTime(),
+	}
+	hash.Sum(meta.Hash[:0])
+	return meta, nil
+}
+
+// resumeOffset reads the offset the receiver holds the file up to along with the hash of what it holds,
+// and answers the offset the transfer resumes from: the receiver's one if it matches the start of file, else 0.
+func resumeOffset(stream *onynet.Stream, file *os.File, size int64, o options) (int64, error) {
+	buf := make([]byte, 8+sha256.Size+frameOverhead)
+	resume, err := readFrame(stream, buf, o.timeout)
+	if err != nil {
+		return 0, err
+	}
+	if len(resume) != 8+sha256.Size {
+		return 0, intErrors.ErrMalformedFrame
+	}
+
+	offset := int64(binary.BigEndian.Uint64(resume))
+	if offset < 0 || offset > size {
+		offset = 0
+	}
+	if offset > 0 {
+		hash := sha256.New()
+		if _, err := io.Copy(hash, io.NewSectionReader(file, 0, offset)); err != nil {
+			return 0, errors.Join(intErrors.ErrFileTransfer, err)
+		}
+		if string(hash.Sum(nil)) != string(resume[8:]) {
+			offset = 0
+		}
+	}
+
+	if err := writeFrame(stream, binary.BigEndian.AppendUint64(nil, uint64(offset)), o.timeout); err != nil {
+		return 0, err
+	}
+	return offset, nil
+}
@@ -0,0 +1,147 @@
+package onynet
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"net/netip"
+	"strings"
+	"sync/atomic"
+
+	intErrors "github.com/Onyz107/onynet/errors"
+)
+
+// ConnFilter decides whether a connection from remote may start its handshake.
+type ConnFilter func(remote net.Addr) bool
+
+// IPFilter is a CIDR allow list and deny list whose rules can be reloaded at any time.
+// A connection is denied if its address matches the deny list, or if the allow list is not empty
+// and the address does not match it.
+type IPFilter struct {
+	rules atomic.Pointer[ipRules]
+}
+
+type ipRules struct {
+	allow []netip.Prefix
+	deny  []netip.Prefix
+}
+
+// NewIPFilter returns an IPFilter with the given rules, see Reload.
+//
+// Possible errors:
+//   - ErrBadCIDR: one of the rules is neither a CIDR prefix nor an IP address
+func NewIPFilter(allow, deny []string) (*IPFilter, error) {
+	filter := &IPFilter{}
+	if err := filter.Reload(allow, deny); err != nil {
+		return nil, err
+	}
+	return filter, nil
+}
+
+// Reload atomically replaces the rules. Each rule is a CIDR prefix such as "10.0.0.0/8"
+// or a single IP address. On error the previous rules are kept.
+//
+// Possible errors:
+//   - ErrBadCIDR: one of the rules is neither a CIDR prefix nor an IP address
+func (f *IPFilter) Reload(allow, deny []string) error {
+	allowPrefixes, err := parsePrefixes(allow)
+	if err != nil {
+		return err
+	}
+	denyPrefixes, err := parsePrefixes(deny)
+	if err != nil {
+		return err
+	}
+
+	f.rules.Store(&ipRules{allow: allowPrefixes, deny: denyPrefixes})
+	return nil
+}
+
+// AllowConn reports whether the rules let a connection from remote through.
+// It can be used as a ConnFilter.
+func (f *IPFilter) AllowConn(remote net.Addr) bool {
+	rules := f.rules.Load()
+	if rules == nil {
+		return true
+	}
+
+	ip, ok := addrIP(remote)
+	if !ok {
+		return len(rules.allow) == 0
+	}
+
+	for _, prefix := range rules.deny {
+		if prefix.Contains(ip) {
+			return false
+		}
+	}
+
+	if len(rules.allow) == 0 {
+		return true
+	}
+	for _, prefix := range rules.allow {
+		if prefix.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
+
+func parsePrefixes(rules []string) ([]netip.Prefix, error) {
+	prefixes := make([]netip.Prefix, 0, len(rules))
+	for _, rule := range rules {
+		rule = strings.TrimSpace(rule)
+
+		if strings.Contains(rule, "/") {
+			prefix, err := netip.ParsePrefix(rule)
+			if err != nil {
+				return nil, errors.Join(intErrors.ErrBadCIDR, err)
+			}
+			prefixes = append(prefixes, prefix.Masked())
+			continue
+		}
+
+		addr, err := netip.ParseAddr(rule)
+		if err != nil {
+			return nil, errors.Join(intErrors.ErrBadCIDR, err)
+		}
+		addr = addr.Unmap()
+		prefixes = append(prefixes, netip.PrefixFrom(addr, addr.BitLen()))
+	}
+	return prefixes, nil
+}
+
+func addrIP(addr net.Addr) (netip.Addr, bool) {
+	ip, err := netip.ParseAddr(hostOf(addr))
+	if err != nil {
+		return netip.Addr{}, false
+	}
+	return ip.Unmap().WithZone(""), true
+}
+
+// SetIPRules replaces the server's built-in CIDR allow list and deny list, see IPFilter.Reload.
+// It can be called at any time, connections already accepted are not affected.
+//
+// Possible errors:
+//   - ErrBadCIDR: one of the rules is neither a CIDR prefix nor an IP address
+func (s *Server) SetIPRules(allow, deny []string) error {
+	return s.ipFilter.Reload(allow, deny)
+}
+
+// SetAllowConn sets a hook deciding whether a connection may start its handshake, nil removes it.
+// It runs after the built-in IP rules, before admission control and any authentication work,
+// and must be safe for concurrent use.
+func (s *Server) SetAllowConn(filter ConnFilter) {
+	s.allowConn.Store(&filter)
+}
+
+// allowed returns why a connection from remote is denied, or nil.
+func (s *Server) allowed(remote net.Addr) error {
+	if !s.ipFilter.AllowConn(remote) {
+		return fmt.Errorf("%w: address not allowed by the IP rules", intErrors.ErrConnDenied)
+	}
+	if filter := s.allowConn.Load(); filter != nil && *filter != nil && !(*filter)(remote) {
+		return fmt.Errorf("%w: refused by AllowConn", intErrors.ErrConnDenied)
+	}
+	return nil
+}
@@ -0,0 +1,218 @@
+package forward
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net"
+	"time"
+
+	"github.com/Onyz107/onynet"
+	intErrors "github.com/Onyz107/onynet/errors"
+	"github.com/Onyz107/onynet/internal/forwarding"
+)
+
+// Local forwards the connections accepted on a local address to a target dialed by the server,
+// like the -L option of SSH.
+type Local struct {
+	h        onynet.Handler
+	listener net.Listener
+	target   string
+	ctx      context.Context
+	cancel   context.CancelFunc
+}
+
+// ListenLocal listens on addr and forwards every connection accepted to target through a new stream of h,
+// until Close is called or ctx is cancelled. The server dials target once it is allowed by its dial rule,
+// connections it refuses or fails to dial are closed.
+//
+// Possible errors:
+//   - ErrBadAddr: failed to listen on addr
+func ListenLocal(h onynet.Handler, addr, target string, ctx context.Context) (*Local, error) {
+	var config net.ListenConfig
+	listener, err := config.Listen(ctx, "tcp", addr)
+	if err != nil {
+		return nil, errors.Join(intErrors.ErrBadAddr, err)
+	}
+
+	ctx, cancel := context.WithCancel(ctx)
+	l := &Local{
+		h:        h,
+		listener: listener,
+		target:   target,
+		ctx:      ctx,
+		cancel:   cancel,
+	}
+	go func() {
+		<-ctx.Done()
+		listener.Close()
+	}()
+	go l.acceptLoop()
+	return l, nil
+}
+
+// Addr returns the local address connections are accepted on.
+func (l *Local) Addr() net.Addr {
+	return l.listener.Addr()
+}
+
+// Done returns a channel closed once the forward stops.
+func (l *Local) Done() <-chan struct{} {
+	return l.ctx.Done()
+}
+
+// Close stops accepting connections and closes the ones being forwarded.
+func (l *Local) Close() error {
+	l.cancel()
+	return nil
+}
+
+func (l *Local) acceptLoop() {
+	defer l.cancel()
+	for {
+		conn, err := l.listener.Accept()
+		if err != nil {
+			return
+		}
+		go l.forward(conn)
+	}
+}
+
+// forward asks the server to dial the target and splices conn with the stream it answers on.
+func (l *Local) forward(conn net.Conn) {
+	stream, err := l.h.OpenStream(StreamName, l.ctx, requestTimeout)
+	if err != nil {
+		conn.Close()
+		return
+	}
+	logger := stream.Logger().With("target", l.target)
+
+	stream.SetDeadline(time.Now().Add(requestTimeout))
+	err = writeRequest(stream, requestDial, l.target)
+	if err == nil {
+		_, err = readResponse(stream)
+	}
+	if err != nil {
+		logger.Debug("failed to forward connection", "error", err, "error_kind", intErrors.Kind(err))
+		stream.Close()
+		conn.Close()
+		return
+	}
+	stream.SetDeadline(time.Time{})
+	forwarding.Splice(stream, conn)
+}
+
+// Remote forwards the connections accepted by the server on an address to a target dialed locally,
+// like the -R option of SSH.
+type Remote struct {
+	h       onynet.Handler
+	control *onynet.Stream
+	addr    net.Addr
+	name    string
+	target  string
+	ctx     context.Context
+	cancel  context.CancelFunc
+}
+
+// ListenRemote asks the server to listen on addr and forwards every connection it accepts to target,
+// until Close is called, ctx is cancelled or the server stops listening. Connections to target which
+// fail are closed.
+//
+// Possible errors:
+//   - ErrForwardDenied: the server's listen rule does not allow addr
+//   - ErrForward: the server failed to listen on addr
+//   - ErrBadAddr: addr is too long
+//   - ErrRead / ErrWrite: failed to exchange the request with the server
+//   - the errors returned by OpenStream
+func ListenRemote(h onynet.Handler, addr, target string, ctx context.Context) (*Remote, error) {
+	control, err := h.OpenStream(StreamName, ctx, requestTimeout)
+	if err != nil {
+		return nil, err
+	}
+
+	control.SetDeadline(time.Now().Add(requestTimeout))
+	bound, err := listen(control, addr)
+	if err != nil {
+		control.Close()
+		return nil, err
+	}
+	control.SetDeadline(time.Time{})
+
+	tcpAddr, err := net.ResolveTCPAddr("tcp", bound)
+	if err != nil {
+		control.Close()
+		return nil, errors.Join(intErrors.ErrForward, err)
+	}
+
+	ctx, cancel := context.WithCancel(ctx)
+	r := &Remote{
+		h:       h,
+		control: control,
+		addr:    tcpAddr,
+		name:    remoteStreamPrefix + bound,
+		target:  target,
+		ctx:     ctx,
+		cancel:  cancel,
+	}
+	go func() {
+		// The server closes the stream once it stops listening
+		io.Copy(io.Discard, control)
+		cancel()
+	}()
+	go func() {
+		<-ctx.Done()
+		control.Close()
+	}()
+	go r.acceptLoop()
+	return r, nil
+}
+
+// listen asks the server to listen on addr and returns the address it listens on.
+func listen(control *onynet.Stream, addr string) (string, error) {
+	if err := writeRequest(control, requestListen, addr); err != nil {
+		return "", err
+	}
+	return readResponse(control)
+}
+
+// Addr returns the address the server listens on, with the port it picked if addr had none.
+func (r *Remote) Addr() net.Addr {
+	return r.addr
+}
+
+// Done returns a channel closed once the forward stops.
+func (r *Remote) Done() <-chan struct{} {
+	return r.ctx.Done()
+}
+
+// Close asks the server to stop listening and closes the connections being forwarded.
+func (r *Remote) Close() error {
+	r.cancel()
+	return nil
+}
+
+func (r *Remote) acceptLoop() {
+	for {
+		stream, err := r.h.AcceptStream(r.name, r.ctx, pollInterval)
+		if err != nil {
+			if r.ctx.Err() != nil || !errors.Is(err, intErrors.ErrTimeout) {
+				r.cancel()
+				return
+			}
+			continue
+		}
+		go r.forward(stream)
+	}
+}
+
+// forward dials the target and splices the connection with stream.
+func (r *Remote) forward(stream *onynet.Stream) {
+	dialer := net.Dialer{Timeout: dialTimeout}
+	conn, err := dialer.DialContext(r.ctx, "tcp", r.target)
+	if err != nil {
+		stream.Logger().Debug("failed to dial forward target", "target", r.target, "error", err, "error_kind", intErrors.Kind(err))
+		stream.Close()
+		return
+	}
+	forwarding.Splice(stream, conn)
+}
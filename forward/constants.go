@@ -0,0 +1,32 @@
+package forward
+
+import "time"
+
+// StreamName is the name of the streams a client asks the forward server to dial or listen through.
+const StreamName = "onynet/forward"
+
+// remoteStreamPrefix prefixes the names of the streams carrying the connections accepted by a remote forward,
+// followed by the address the server listens on.
+const remoteStreamPrefix = "onynet/forward/remote/"
+
+const maxAddrLength = 0xFF
+
+const (
+	requestDial byte = iota
+	requestListen
+)
+
+const (
+	statusOK byte = iota
+	statusDenied
+	statusFailed
+)
+
+const (
+	// requestTimeout bounds opening a forward stream and the exchange of its request and response.
+	requestTimeout = 10 * time.Second
+	// dialTimeout bounds dialing the target of a forwarded connection.
+	dialTimeout = 10 * time.Second
+	// pollInterval is the longest time an accept loop waits for a stream before checking it was not stopped.
+	pollInterval = time.Second
+)
@@ -0,0 +1,214 @@
+package forward_test
+
+import (
+	"errors"
+	"io"
+	"net"
+	"testing"
+	"time"
+
+	intErrors "github.com/Onyz107/onynet/errors"
+	"github.com/Onyz107/onynet/forward"
+	"github.com/Onyz107/onynet/onynettest"
+)
+
+// newEcho starts a TCP server echoing what it reads and returns its address.
+func newEcho(tb testing.TB) string {
+	tb.Helper()
+
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		tb.Fatal(err)
+	}
+	tb.Cleanup(func() { listener.Close() })
+
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			go func() {
+				defer conn.Close()
+				io.Copy(conn, conn)
+			}()
+		}
+	}()
+	return listener.Addr().String()
+}
+
+func newPair(tb testing.TB, server *forward.Server) *onynettest.Pair {
+	tb.Helper()
+
+	pair := onynettest.NewPair(tb, nil)
+	go server.Serve(pair.ClientConn, tb.Context())
+	return pair
+}
+
+// roundTrip dials addr and checks what it writes is echoed back.
+func roundTrip(tb testing.TB, addr string) {
+	tb.Helper()
+
+	conn, err := net.DialTimeout("tcp", addr, 5*time.Second)
+	if err != nil {
+		tb.Fatal(err)
+	}
+	defer conn.Close()
+	conn.SetDeadline(time.Now().Add(10 * time.Second))
+
+	message := []byte("through the tunnel")
+	if _, err := conn.Write(message); err != nil {
+		tb.Fatal(err)
+	}
+	echoed := make([]byte, len(message))
+	if _, err := io.ReadFull(conn, echoed); err != nil {
+		tb.Fatal(err)
+	}
+	if string(echoed) != string(message) {
+		tb.Fatalf("expected %q, got %q", message, echoed)
+	}
+}
+
+func TestLocal(t *testing.T) {
+	echo := newEcho(t)
+	server := forward.NewServer()
+	server.SetDialRule(forward.AllowAddrs(echo))
+	pair := newPair(t, server)
+
+	local, err := forward.ListenLocal(pair.Client, "127.0.0.1:0", echo, t.Context())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer local.Close()
+
+	roundTrip(t, local.Addr().String())
+	roundTrip(t, local.Addr().String())
+}
+
+func TestRemote(t *testing.T) {
+	echo := newEcho(t)
+	server := forward.NewServer()
+	server.SetListenRule(forward.AllowAddrs("127.0.0.1:*"))
+	pair := newPair(t, server)
+
+	remote, err := forward.ListenRemote(pair.Client, "127.0.0.1:0", echo, t.Context())
+	if err != nil {
+		t.Fatal(err)
+	}
+	roundTrip(t, remote.Addr().String())
+
+	remote.Close()
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		conn, err := net.Dial("tcp", remote.Addr().String())
+		if err != nil {
+			break
+		}
+		conn.Close()
+		if time.Now().After(deadline) {
+			t.Fatal("server still listens after the forward was closed")
+		}
+		time.Sleep(50 * time.Millisecond)
+	}
+}
+
+func TestHalfClose(t *testing.T) {
+	// The target answers only once the client stops sending, like a shell command behind nc -N
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer listener.Close()
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			go func() {
+				defer conn.Close()
+				request, _ := io.ReadAll(conn)
+				conn.Write(append([]byte("answer to "), request...))
+			}()
+		}
+	}()
+
+	server := forward.NewServer()
+	server.SetDialRule(forward.AllowAddrs(listener.Addr().String()))
+	server.SetListenRule(forward.AllowAddrs("127.0.0.1:*"))
+	pair := newPair(t, server)
+
+	local, err := forward.ListenLocal(pair.Client, "127.0.0.1:0", listener.Addr().String(), t.Context())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer local.Close()
+	remote, err := forward.ListenRemote(pair.Client, "127.0.0.1:0", listener.Addr().String(), t.Context())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer remote.Close()
+
+	for _, addr := range []string{local.Addr().String(), remote.Addr().String()} {
+		conn, err := net.DialTimeout("tcp", addr, 5*time.Second)
+		if err != nil {
+			t.Fatal(err)
+		}
+		conn.SetDeadline(time.Now().Add(10 * time.Second))
+		if _, err := conn.Write([]byte("request")); err != nil {
+			t.Fatal(err)
+		}
+		if err := conn.(*net.TCPConn).CloseWrite(); err != nil {
+			t.Fatal(err)
+		}
+		answer, err := io.ReadAll(conn)
+		conn.Close()
+		if err != nil || string(answer) != "answer to request" {
+			t.Fatalf("expected the answer after closing for writing through %s, got: %q, %v", addr, answer, err)
+		}
+	}
+}
+
+func TestDenied(t *testing.T) {
+	echo := newEcho(t)
+	server := forward.NewServer()
+	server.SetDialRule(forward.AllowAddrs("localhost:*"))
+	pair := newPair(t, server)
+
+	if _, err := forward.ListenRemote(pair.Client, "127.0.0.1:0", echo, t.Context()); !errors.Is(err, intErrors.ErrForwardDenied) {
+		t.Fatalf("expected ErrForwardDenied, got: %v", err)
+	}
+
+	local, err := forward.ListenLocal(pair.Client, "127.0.0.1:0", echo, t.Context())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer local.Close()
+
+	conn, err := net.Dial("tcp", local.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	conn.SetDeadline(time.Now().Add(10 * time.Second))
+	if _, err := conn.Read(make([]byte, 1)); err != io.EOF {
+		t.Fatalf("expected the denied connection to be closed, got: %v", err)
+	}
+}
+
+func TestAllowAddrs(t *testing.T) {
+	rule := forward.AllowAddrs("localhost:22", "*:8080", "10.0.0.1:*")
+	for addr, expected := range map[string]bool{
+		"localhost:22":     true,
+		"LOCALHOST:22":     true,
+		"127.0.0.1:22":     false,
+		"example.com:8080": true,
+		"10.0.0.1:443":     true,
+		"10.0.0.2:443":     false,
+		"localhost":        false,
+	} {
+		if rule(nil, addr) != expected {
+			t.Errorf("%s: expected %v", addr, expected)
+		}
+	}
+}
@@ -0,0 +1,34 @@
+package forward
+
+import (
+	"net"
+	"strings"
+
+	"github.com/Onyz107/onynet"
+)
+
+// Rule decides whether a client may forward through addr, the "host:port" address it asked for:
+// the target the server dials for a local forward, or the address the server listens on for a remote one.
+type Rule func(clientConn *onynet.ClientConn, addr string) bool
+
+// AllowAddrs returns a Rule allowing the addresses matching one of patterns, given as "host:port" where either
+// may be "*" to match any host or port. Hosts are compared as written, so "localhost:22" does not allow
+// "127.0.0.1:22", and ":8080" only allows listening on every interface.
+func AllowAddrs(patterns ...string) Rule {
+	return func(_ *onynet.ClientConn, addr string) bool {
+		host, port, err := net.SplitHostPort(addr)
+		if err != nil {
+			return false
+		}
+		for _, pattern := range patterns {
+			patternHost, patternPort, err := net.SplitHostPort(pattern)
+			if err != nil {
+				continue
+			}
+			if (patternHost == "*" || strings.EqualFold(patternHost, host)) && (patternPort == "*" || patternPort == port) {
+				return true
+			}
+		}
+		return false
+	}
+}
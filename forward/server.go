@@ -0,0 +1,163 @@
+package forward
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net"
+	"sync/atomic"
+	"time"
+
+	"github.com/Onyz107/onynet"
+	intErrors "github.com/Onyz107/onynet/errors"
+	"github.com/Onyz107/onynet/internal/forwarding"
+)
+
+// Server dials and listens on behalf of the clients it serves, within the rules it is given.
+// A single Server is shared by every client of an onynet.Server.
+type Server struct {
+	dial   atomic.Pointer[Rule]
+	listen atomic.Pointer[Rule]
+}
+
+// NewServer returns a Server which denies every forward until SetDialRule or SetListenRule is called.
+func NewServer() *Server {
+	return &Server{}
+}
+
+// SetDialRule sets which targets clients may forward their local connections to, a nil rule denies them all.
+func (s *Server) SetDialRule(rule Rule) {
+	s.dial.Store(&rule)
+}
+
+// SetListenRule sets which addresses clients may have the server listen on, a nil rule denies them all.
+// The connections accepted on these addresses are forwarded to the client which asked for them.
+func (s *Server) SetListenRule(rule Rule) {
+	s.listen.Store(&rule)
+}
+
+// Serve accepts the forward streams of clientConn and serves them until ctx is cancelled or the connection
+// is closed. The listeners opened for the client are closed along with it. Serve blocks, so it is usually
+// run in its own goroutine for every accepted client.
+//
+// Possible errors:
+//   - ErrCtxCancelled: ctx was cancelled
+//   - the errors returned by AcceptStream
+func (s *Server) Serve(clientConn *onynet.ClientConn, ctx context.Context) error {
+	for {
+		stream, err := clientConn.AcceptStream(StreamName, ctx, pollInterval)
+		if err != nil {
+			if errors.Is(err, intErrors.ErrTimeout) {
+				continue
+			}
+			return err
+		}
+		go s.handle(clientConn, stream, ctx)
+	}
+}
+
+// handle reads the request of stream and serves it.
+func (s *Server) handle(clientConn *onynet.ClientConn, stream *onynet.Stream, ctx context.Context) {
+	logger := stream.Logger()
+	stream.SetDeadline(time.Now().Add(requestTimeout))
+
+	requestType, addr, err := readRequest(stream)
+	if err != nil {
+		logger.Debug("failed to read forward request", "error", err, "error_kind", intErrors.Kind(err))
+		stream.Close()
+		return
+	}
+
+	switch requestType {
+	case requestDial:
+		s.forwardDial(clientConn, stream, addr, ctx)
+	case requestListen:
+		s.forwardListen(clientConn, stream, addr, ctx)
+	default:
+		logger.Debug("unknown forward request", "type", requestType)
+		stream.Close()
+	}
+}
+
+// forwardDial dials addr and splices the connection with stream.
+func (s *Server) forwardDial(clientConn *onynet.ClientConn, stream *onynet.Stream, addr string, ctx context.Context) {
+	logger := stream.Logger().With("target", addr)
+
+	if !forwarding.Allowed(s.dial.Load(), clientConn, addr) {
+		logger.Debug("forward denied")
+		writeResponse(stream, statusDenied, "")
+		stream.Close()
+		return
+	}
+
+	dialer := net.Dialer{Timeout: dialTimeout}
+	conn, err := dialer.DialContext(ctx, "tcp", addr)
+	if err != nil {
+		logger.Debug("failed to dial forward target", "error", err, "error_kind", intErrors.Kind(err))
+		writeResponse(stream, statusFailed, err.Error())
+		stream.Close()
+		return
+	}
+
+	if err := writeResponse(stream, statusOK, ""); err != nil {
+		stream.Close()
+		conn.Close()
+		return
+	}
+	stream.SetDeadline(time.Time{})
+	forwarding.Splice(stream, conn)
+}
+
+// forwardListen listens on addr until stream is closed, and forwards the connections accepted to the client.
+func (s *Server) forwardListen(clientConn *onynet.ClientConn, stream *onynet.Stream, addr string, ctx context.Context) {
+	logger := stream.Logger().With("listen", addr)
+
+	if !forwarding.Allowed(s.listen.Load(), clientConn, addr) {
+		logger.Debug("forward denied")
+		writeResponse(stream, statusDenied, "")
+		stream.Close()
+		return
+	}
+
+	var config net.ListenConfig
+	listener, err := config.Listen(ctx, "tcp", addr)
+	if err != nil {
+		logger.Debug("failed to listen for forward", "error", err, "error_kind", intErrors.Kind(err))
+		writeResponse(stream, statusFailed, err.Error())
+		stream.Close()
+		return
+	}
+
+	bound := listener.Addr().String()
+	if err := writeResponse(stream, statusOK, bound); err != nil {
+		stream.Close()
+		listener.Close()
+		return
+	}
+	stream.SetDeadline(time.Time{})
+
+	// The client closes the stream to stop the forward, the stream is also closed along with ctx
+	go func() {
+		io.Copy(io.Discard, stream)
+		stream.Close()
+		listener.Close()
+	}()
+
+	name := remoteStreamPrefix + bound
+	for {
+		conn, err := listener.Accept()
+		if err != nil {
+			logger.Debug("stopped listening for forward", "reason", err, "error_kind", intErrors.Kind(err))
+			return
+		}
+		go func() {
+			forwarded, err := clientConn.OpenStream(name, ctx, requestTimeout)
+			if err != nil {
+				logger.Debug("failed to forward connection", "error", err, "error_kind", intErrors.Kind(err))
+				conn.Close()
+				return
+			}
+			forwarding.Splice(forwarded, conn)
+		}()
+	}
+}
@@ -0,0 +1,67 @@
+package forward
+
+import (
+	"encoding/binary"
+	"errors"
+	"io"
+
+	intErrors "github.com/Onyz107/onynet/errors"
+)
+
+// writeRequest writes a request as type(1) + address length(1) + address.
+func writeRequest(w io.Writer, requestType byte, addr string) error {
+	if len(addr) > maxAddrLength {
+		return errors.Join(intErrors.ErrBadAddr, errors.New("address too long"))
+	}
+	frame := append([]byte{requestType, byte(len(addr))}, addr...)
+	if _, err := w.Write(frame); err != nil {
+		return errors.Join(intErrors.ErrWrite, err)
+	}
+	return nil
+}
+
+func readRequest(r io.Reader) (requestType byte, addr string, err error) {
+	header := make([]byte, 2)
+	if _, err := io.ReadFull(r, header); err != nil {
+		return 0, "", errors.Join(intErrors.ErrRead, err)
+	}
+	buf := make([]byte, header[1])
+	if _, err := io.ReadFull(r, buf); err != nil {
+		return 0, "", errors.Join(intErrors.ErrRead, err)
+	}
+	return header[0], string(buf), nil
+}
+
+// writeResponse writes a response as status(1) + message length(2) + message. The message is the address
+// listened on for a listen request and the reason of the failure for a failed request.
+func writeResponse(w io.Writer, status byte, message string) error {
+	if len(message) > 0xFFFF {
+		message = message[:0xFFFF]
+	}
+	frame := binary.BigEndian.AppendUint16([]byte{status}, uint16(len(message)))
+	if _, err := w.Write(append(frame, message...)); err != nil {
+		return errors.Join(intErrors.ErrWrite, err)
+	}
+	return nil
+}
+
+// readResponse reads a response and returns its message, or the error it carries.
+func readResponse(r io.Reader) (string, error) {
+	header := make([]byte, 3)
+	if _, err := io.ReadFull(r, header); err != nil {
+		return "", errors.Join(intErrors.ErrRead, err)
+	}
+	buf := make([]byte, binary.BigEndian.Uint16(header[1:]))
+	if _, err := io.ReadFull(r, buf); err != nil {
+		return "", errors.Join(intErrors.ErrRead, err)
+	}
+
+	switch header[0] {
+	case statusOK:
+		return string(buf), nil
+	case statusDenied:
+		return "", intErrors.ErrForwardDenied
+	default:
+		return "", errors.Join(intErrors.ErrForward, errors.New(string(buf)))
+	}
+}
@@ -3,9 +3,9 @@ module github.com/Onyz107/onynet
 go 1.25.1
 
 require (
-	github.com/Onyz107/onylogger v0.0.0-20251004111513-fef6e79075c3
 	github.com/xtaci/kcp-go/v5 v5.6.24
 	github.com/xtaci/smux v1.5.35
+	golang.org/x/net v0.44.0
 )
 
 require (
@@ -14,6 +14,5 @@ require (
 	github.com/pkg/errors v0.9.1 // indirect
 	github.com/tjfoc/gmsm v1.4.1 // indirect
 	golang.org/x/crypto v0.42.0 // indirect
-	golang.org/x/net v0.44.0 // indirect
 	golang.org/x/sys v0.36.0 // indirect
 )
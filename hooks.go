@@ -0,0 +1,75 @@
+package onynet
+
+import (
+	"errors"
+	"io"
+	"net"
+
+	intErrors "github.com/Onyz107/onynet/errors"
+	"github.com/xtaci/smux"
+)
+
+// ServerHooks holds functions called on the lifecycle events of a server's connections, nil functions are skipped.
+// Hooks run synchronously on the goroutine the event happened on and should return quickly.
+type ServerHooks struct {
+	// OnConnect is called when a client is accepted, before Accept returns it.
+	OnConnect func(cn *ClientConn)
+	// OnAuthFailed is called when a client fails authentication.
+	OnAuthFailed func(remote net.Addr, err error)
+	// OnDisconnect is called once when a connection is closed, reason is one of ErrHeartbeatTimeout,
+	// ErrCtxCancelled, ErrPeerClosed, ErrIdleTimeout or ErrClosedLocally, possibly joined with the underlying error.
+	// Graceful closes also join a CloseError with the code and reason of the close.
+	OnDisconnect func(cn *ClientConn, reason error)
+	// OnStreamOpened is called when a stream is opened or accepted.
+	OnStreamOpened func(cn *ClientConn, stream *Stream)
+	// OnStreamClosed is called when a stream is closed by either end.
+	OnStreamClosed func(cn *ClientConn, stream *Stream)
+}
+
+// ClientHooks holds functions called on the lifecycle events of a client's connection, nil functions are skipped.
+// Hooks run synchronously on the goroutine the event happened on and should return quickly.
+type ClientHooks struct {
+	// OnConnect is called when the client is connected, before Dial returns it.
+	OnConnect func(c *Client)
+	// OnAuthFailed is called when the server fails authentication.
+	OnAuthFailed func(remote net.Addr, err error)
+	// OnDisconnect is called once when the connection is closed, reason is one of ErrHeartbeatTimeout,
+	// ErrCtxCancelled, ErrPeerClosed, ErrIdleTimeout or ErrClosedLocally, possibly joined with the underlying error.
+	// Graceful closes also join a CloseError with the code and reason of the close.
+	OnDisconnect func(c *Client, reason error)
+	// OnStreamOpened is called when a stream is opened or accepted.
+	OnStreamOpened func(c *Client, stream *Stream)
+	// OnStreamClosed is called when a stream is closed by either end.
+	OnStreamClosed func(c *Client, stream *Stream)
+}
+
+// SetHooks sets the functions called on the lifecycle events of the server's connections.
+// It applies immediately, including to connections already accepted.
+func (s *Server) SetHooks(hooks ServerHooks) {
+	s.hooks.Store(&hooks)
+}
+
+func (s *Server) loadHooks() *ServerHooks {
+	if hooks := s.hooks.Load(); hooks != nil {
+		return hooks
+	}
+	return &ServerHooks{}
+}
+
+// heartbeatReason turns the error which stopped the heartbeat into a disconnect reason.
+func heartbeatReason(err error) error {
+	var netErr net.Error
+	var closeErr *CloseError
+	switch {
+	case errors.As(err, &closeErr):
+		return errors.Join(intErrors.ErrPeerClosed, closeErr)
+	case errors.Is(err, intErrors.ErrCtxCancelled):
+		return intErrors.ErrCtxCancelled
+	case errors.Is(err, io.EOF), errors.Is(err, io.ErrClosedPipe):
+		return errors.Join(intErrors.ErrPeerClosed, err)
+	case errors.Is(err, smux.ErrTimeout), errors.As(err, &netErr) && netErr.Timeout():
+		return errors.Join(intErrors.ErrHeartbeatTimeout, err)
+	default:
+		return err
+	}
+}
@@ -0,0 +1,50 @@
+package onynet
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	intErrors "github.com/Onyz107/onynet/errors"
+	intSmux "github.com/Onyz107/onynet/internal/smux"
+)
+
+// watchIdle closes a connection once none of its streams was opened, read from or written to for
+// the idle timeout, telling the peer with CloseGoingAway. The heartbeat does not count as activity.
+// A timeout of 0 disables the check.
+func watchIdle(ctx context.Context, manager *intSmux.Manager, timeout func() time.Duration, closeWithReason func(error) error) {
+	timer := time.NewTimer(idleCheckInterval)
+	defer timer.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-timer.C:
+		}
+
+		wait := idleCheckInterval
+		if d := timeout(); d > 0 {
+			idle := time.Since(manager.LastActivity())
+			if idle >= d {
+				closeWithReason(errors.Join(intErrors.ErrIdleTimeout, &CloseError{Code: CloseGoingAway, Reason: "idle timeout"}))
+				return
+			}
+			wait = min(wait, d-idle)
+		}
+		timer.Reset(wait)
+	}
+}
+
+// SetIdleTimeout closes connections whose streams were not opened, read from or written to for d,
+// with ErrIdleTimeout as the disconnect reason. A d of 0 disables it, which is the default.
+// It applies immediately, including to connections already accepted.
+func (s *Server) SetIdleTimeout(d time.Duration) {
+	s.idleTimeout.Store(int64(d))
+}
+
+// SetIdleTimeout closes the connection if its streams were not opened, read from or written to for d,
+// with ErrIdleTimeout as the disconnect reason. A d of 0 disables it, which is the default.
+func (c *Client) SetIdleTimeout(d time.Duration) {
+	c.idleTimeout.Store(int64(d))
+}
@@ -13,7 +13,6 @@ import (
 
 	intErrors "github.com/Onyz107/onynet/errors"
 	intCrypto "github.com/Onyz107/onynet/internal/crypto"
-	"github.com/Onyz107/onynet/internal/logger"
 )
 
 // AuthorizeSelfClient performs client-side authentication and returns an AES key.
@@ -22,7 +21,6 @@ func AuthorizeSelfClient(conn net.Conn, publicKey *rsa.PublicKey) (aesKey []byte
 	defer conn.SetDeadline(time.Time{})
 
 	aesKey = intCrypto.GenerateAESKey(256)
-	logger.Log.Debugf("AuthorizeSelfClient: aesKey: length: %d", len(aesKey))
 	ciphertext, err := rsa.EncryptOAEP(sha256.New(), rand.Reader, publicKey, aesKey, nil)
 	if err != nil {
 		return nil, errors.Join(intErrors.ErrPublickey, err)
@@ -35,7 +33,6 @@ func AuthorizeSelfClient(conn net.Conn, publicKey *rsa.PublicKey) (aesKey []byte
 	length := uint64(len(ciphertext))
 	binary.BigEndian.PutUint64(header, length)
 
-	logger.Log.Debugf("AuthorizeSelfClient: sending length: %d", length)
 	n, err := conn.Write(header)
 	if err != nil {
 		return nil, errors.Join(intErrors.ErrWrite, err)
@@ -44,7 +41,6 @@ func AuthorizeSelfClient(conn net.Conn, publicKey *rsa.PublicKey) (aesKey []byte
 		return nil, intErrors.ErrShortWrite
 	}
 
-	logger.Log.Debugf("AuthorizeSelfClient: sending ciphertext: length: %d", len(ciphertext))
 	n, err = conn.Write(ciphertext)
 	if err != nil {
 		return nil, errors.Join(intErrors.ErrWrite, err)
@@ -65,7 +61,6 @@ func AuthorizeServer(conn net.Conn, publicKey *rsa.PublicKey) error {
 	rand.Read(challenge) // never returns an error
 	hash := sha256.Sum256(challenge)
 
-	logger.Log.Debugf("AuthorizeServer: sending challenge: length: %d", len(challenge))
 	n, err := conn.Write(challenge)
 	if err != nil {
 		return errors.Join(intErrors.ErrWrite, err)
@@ -82,13 +77,11 @@ func AuthorizeServer(conn net.Conn, publicKey *rsa.PublicKey) error {
 		return errors.Join(intErrors.ErrRead, err)
 	}
 	length := binary.BigEndian.Uint64(header)
-	logger.Log.Debugf("AuthorizeServer: received length: %d", length)
 
 	signature := make([]byte, length)
 	if _, err := io.ReadFull(conn, signature); err != nil {
 		return errors.Join(intErrors.ErrRead, err)
 	}
-	logger.Log.Debugf("AuthorizeServer: received signature: length: %d", len(signature))
 
 	if err := rsa.VerifyPKCS1v15(publicKey, crypto.SHA256, hash[:], signature); err != nil {
 		return errors.Join(intErrors.ErrPublickey, err)
@@ -2,10 +2,27 @@ package auth
 
 import (
 	"sync"
+	"time"
 )
 
 const serverChallengeLength = 32
 
+// helloTimeout bounds the exchange of the client's hello and the server's admission status.
+const helloTimeout = 5 * time.Second
+
+// clientHello is the first byte sent by a client.
+const clientHello byte = 0x4F
+
+// Status bytes sent by the server before the handshake, any status other than statusAdmitted is a rejection.
+const (
+	statusAdmitted byte = iota
+	statusRejected
+	statusTooManyClients
+	statusTooManyFromIP
+	statusHandshakeRate
+	statusDenied
+)
+
 var serverChallengePool = sync.Pool{
 	New: func() any {
 		buf := make([]byte, serverChallengeLength)
@@ -0,0 +1,107 @@
+package auth
+
+import (
+	"errors"
+	"io"
+	"net"
+	"time"
+
+	intErrors "github.com/Onyz107/onynet/errors"
+)
+
+// ReceiveHello waits for the client's hello, which opens every connection.
+// Over KCP the client proved it receives packets at its address before its session was created,
+// see kcp.Dial, while TCP proves it with its own handshake.
+func ReceiveHello(conn net.Conn) error {
+	conn.SetReadDeadline(time.Now().Add(helloTimeout))
+	defer conn.SetReadDeadline(time.Time{})
+
+	hello := make([]byte, 1)
+	if _, err := io.ReadFull(conn, hello); err != nil {
+		return errors.Join(intErrors.ErrRead, err)
+	}
+	if hello[0] != clientHello {
+		return intErrors.ErrUnexpectedMsg
+	}
+	return nil
+}
+
+// SendHello sends the client's hello and waits for the server to admit the connection,
+// or returns why the server rejected it.
+func SendHello(conn net.Conn) error {
+	conn.SetDeadline(time.Now().Add(helloTimeout))
+	defer conn.SetDeadline(time.Time{})
+
+	// KCP has no handshake of its own, the server only learns about the client once it sends something.
+	n, err := conn.Write([]byte{clientHello})
+	if err != nil {
+		return errors.Join(intErrors.ErrWrite, err)
+	}
+	if n != 1 {
+		return intErrors.ErrShortWrite
+	}
+
+	status := make([]byte, 1)
+	if _, err := io.ReadFull(conn, status); err != nil {
+		return errors.Join(intErrors.ErrRead, err)
+	}
+
+	if status[0] != statusAdmitted {
+		return errors.Join(intErrors.ErrRejected, rejectionError(status[0]))
+	}
+	return nil
+}
+
+// Admit tells the client its connection passed admission control, the authentication follows.
+func Admit(conn net.Conn) error {
+	return writeStatus(conn, statusAdmitted)
+}
+
+// Reject tells the client why its connection is refused. reason must be one of the admission errors.
+func Reject(conn net.Conn, reason error) error {
+	return writeStatus(conn, rejectionStatus(reason))
+}
+
+func writeStatus(conn net.Conn, status byte) error {
+	conn.SetWriteDeadline(time.Now().Add(time.Second))
+	defer conn.SetWriteDeadline(time.Time{})
+
+	n, err := conn.Write([]byte{status})
+	if err != nil {
+		return errors.Join(intErrors.ErrWrite, err)
+	}
+	if n != 1 {
+		return intErrors.ErrShortWrite
+	}
+	return nil
+}
+
+func rejectionStatus(reason error) byte {
+	switch {
+	case errors.Is(reason, intErrors.ErrTooManyClients):
+		return statusTooManyClients
+	case errors.Is(reason, intErrors.ErrTooManyFromIP):
+		return statusTooManyFromIP
+	case errors.Is(reason, intErrors.ErrHandshakeRate):
+		return statusHandshakeRate
+	case errors.Is(reason, intErrors.ErrConnDenied):
+		return statusDenied
+	default:
+		return statusRejected
+	}
+}
+
+func rejectionError(status byte) error {
+	switch status {
+	case statusTooManyClients:
+		return intErrors.ErrTooManyClients
+	case statusTooManyFromIP:
+		return intErrors.ErrTooManyFromIP
+	case statusHandshakeRate:
+		return intErrors.ErrHandshakeRate
+	case statusDenied:
+		return intErrors.ErrConnDenied
+	default:
+		return intErrors.ErrUnknownRejected
+	}
+}
@@ -12,7 +12,6 @@ import (
 	"time"
 
 	intErrors "github.com/Onyz107/onynet/errors"
-	"github.com/Onyz107/onynet/internal/logger"
 )
 
 // AuthorizeSelfServer signs a challenge sent by a client to prove server identity.
@@ -41,7 +40,6 @@ func AuthorizeSelfServer(conn net.Conn, privateKey *rsa.PrivateKey) error {
 	length := uint64(len(signature))
 	binary.BigEndian.PutUint64(header, length)
 
-	logger.Log.Debugf("AuthorizeSelfServer: sending length: %d", length)
 	n, err := conn.Write(header)
 	if err != nil {
 		return errors.Join(intErrors.ErrWrite, err)
@@ -50,7 +48,6 @@ func AuthorizeSelfServer(conn net.Conn, privateKey *rsa.PrivateKey) error {
 		return intErrors.ErrShortWrite
 	}
 
-	logger.Log.Debugf("AuthorizeSelfServer: sending signature: length: %d", len(signature))
 	n, err = conn.Write(signature)
 	if err != nil {
 		return errors.Join(intErrors.ErrWrite, err)
@@ -71,26 +68,20 @@ func AuthorizeClient(conn net.Conn, privateKey *rsa.PrivateKey) (aesKey []byte,
 	defer headerPool.Put(headerPtr)
 	header := *headerPtr
 
-	logger.Log.Debug("AuthorizeClient: receiving header")
 	if _, err := io.ReadFull(conn, header); err != nil {
 		return nil, errors.Join(intErrors.ErrRead, err)
 	}
 	length := binary.BigEndian.Uint64(header)
-	logger.Log.Debugf("AuthorizeClient: received header with length: %d", length)
 
-	logger.Log.Debug("AuthorizeClient: receiving challenge")
 	challenge := make([]byte, length)
 	if _, err := io.ReadFull(conn, challenge); err != nil {
 		return nil, errors.Join(intErrors.ErrRead, err)
 	}
-	logger.Log.Debugf("AuthorizeClient: received challenge: length: %d", len(challenge))
 
-	logger.Log.Debug("AuthorizeClient: computing aesKey")
 	aesKey, err = rsa.DecryptOAEP(sha256.New(), rand.Reader, privateKey, challenge, nil)
 	if err != nil {
 		return nil, errors.Join(intErrors.ErrPrivateKey, err)
 	}
-	logger.Log.Debugf("AuthorizeClient: computed aesKey: length: %d", len(aesKey))
 
 	return aesKey, nil
 }
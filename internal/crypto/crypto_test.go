@@ -20,7 +20,7 @@ func TestEncryptDecrypt(t *testing.T) {
 	originalData := generateTestData(16)
 	key := crypto.GenerateAESKey(256)
 
-	encryptedData, err := crypto.EncryptAESGCM(key, originalData)
+	encryptedData, err := crypto.EncryptAESGCM(originalData, key)
 	if err != nil {
 		t.Fatal(err)
 	}
@@ -29,7 +29,7 @@ func TestEncryptDecrypt(t *testing.T) {
 		t.Fatal("encrypted data is equal to original data")
 	}
 
-	decryptedData, err := crypto.DecryptAESGCM(key, encryptedData)
+	decryptedData, err := crypto.DecryptAESGCM(encryptedData, key)
 	if err != nil {
 		t.Fatal(err)
 	}
@@ -39,6 +39,36 @@ func TestEncryptDecrypt(t *testing.T) {
 	}
 }
 
+func TestDecryptWithData(t *testing.T) {
+	key := crypto.GenerateAESKey(256)
+	first, second := generateTestData(64), generateTestData(64)
+
+	encryptedFirst, err := crypto.EncryptAESGCMWithData(first, key, []byte("first"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	encryptedSecond, err := crypto.EncryptAESGCMWithData(second, key, []byte("second"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := crypto.DecryptAESGCMWithData(encryptedFirst, key, []byte("second")); err == nil {
+		t.Fatal("expected decrypting with other additional data to fail")
+	}
+
+	// Every plaintext has its own buffer, which later decryptions never reuse
+	decryptedFirst, err := crypto.DecryptAESGCMWithData(encryptedFirst, key, []byte("first"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := crypto.DecryptAESGCMWithData(encryptedSecond, key, []byte("second")); err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(decryptedFirst, first) {
+		t.Fatal("decrypted data changed after another decryption")
+	}
+}
+
 // BenchmarkEncryptAESGCM tests AES-GCM encryption performance
 func BenchmarkEncryptAESGCM(b *testing.B) {
 	sizes := []int{64, 512, 1024, 4096, 16384, 65536, 262144} // Various payload sizes
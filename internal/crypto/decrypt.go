@@ -32,3 +32,24 @@ func DecryptAESGCM(data, key []byte) ([]byte, error) {
 
 	return plaintext, nil
 }
+
+// DecryptAESGCMWithData decrypts AES-GCM encrypted data with the given key,
+// failing unless additionalData is the one it was encrypted with.
+// The plaintext is newly allocated, so it stays valid however long the caller keeps it.
+func DecryptAESGCMWithData(data, key, additionalData []byte) ([]byte, error) {
+	gcm, err := getGCM(key)
+	if err != nil {
+		return nil, err
+	}
+
+	nonceSize := gcm.NonceSize()
+	if len(data) < nonceSize {
+		return nil, intErrors.ErrShort
+	}
+
+	plaintext, err := gcm.Open(nil, data[:nonceSize], data[nonceSize:], additionalData)
+	if err != nil {
+		return nil, errors.Join(intErrors.ErrDecrypt, err)
+	}
+	return plaintext, nil
+}
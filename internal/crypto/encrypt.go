@@ -6,6 +6,12 @@ import (
 
 // EncryptAESGCM encrypts plaintext using AES-GCM with the given key.
 func EncryptAESGCM(plaintext, key []byte) ([]byte, error) {
+	return EncryptAESGCMWithData(plaintext, key, nil)
+}
+
+// EncryptAESGCMWithData encrypts plaintext using AES-GCM with the given key, authenticating additionalData along with it.
+// The additional data is not part of the ciphertext, the same one must be given to DecryptAESGCMWithData.
+func EncryptAESGCMWithData(plaintext, key, additionalData []byte) ([]byte, error) {
 	gcm, err := getGCM(key)
 	if err != nil {
 		return nil, err
@@ -14,6 +20,6 @@ func EncryptAESGCM(plaintext, key []byte) ([]byte, error) {
 	nonce := make([]byte, gcm.NonceSize())
 	rand.Read(nonce)
 
-	ciphertext := gcm.Seal(nonce, nonce, plaintext, nil)
+	ciphertext := gcm.Seal(nonce, nonce, plaintext, additionalData)
 	return ciphertext, nil
 }
@@ -0,0 +1,100 @@
+// Package forwarding holds the pieces shared by the packages which forward connections over streams.
+package forwarding
+
+import (
+	"encoding/binary"
+	"errors"
+	"io"
+	"net"
+
+	"github.com/Onyz107/onynet"
+	intErrors "github.com/Onyz107/onynet/errors"
+)
+
+// chunkSize is the most bytes a chunk spliced over a stream holds.
+const chunkSize = 32 * 1024
+
+// Splice copies stream to conn and back until both directions end, then closes both. The end of a direction
+// is passed on as a half close, so a client which stops sending after its request still receives the response:
+// conn is closed for writing, and stream, whose other end is spliced too, carries chunks of length(2) + data
+// where an empty chunk ends the direction. An error in either direction closes both at once.
+func Splice(stream *onynet.Stream, conn net.Conn) {
+	errs := make(chan error, 2)
+	go func() {
+		errs <- sendChunks(stream, conn)
+	}()
+	go func() {
+		err := receiveChunks(conn, stream)
+		if err == nil {
+			err = closeWrite(conn)
+		}
+		errs <- err
+	}()
+
+	for range 2 {
+		if err := <-errs; err != nil {
+			break
+		}
+	}
+	stream.Close()
+	conn.Close()
+}
+
+// sendChunks sends what is read from r on stream as chunks, followed by an empty chunk once r ends.
+func sendChunks(stream *onynet.Stream, r io.Reader) error {
+	buf := make([]byte, 2+chunkSize)
+	for {
+		n, err := r.Read(buf[2:])
+		if n > 0 {
+			binary.BigEndian.PutUint16(buf, uint16(n))
+			if _, err := stream.Write(buf[:2+n]); err != nil {
+				return err
+			}
+		}
+		if err == io.EOF {
+			_, err := stream.Write([]byte{0, 0})
+			return err
+		}
+		if err != nil {
+			return err
+		}
+	}
+}
+
+// receiveChunks writes the chunks received on stream to w until the empty chunk.
+func receiveChunks(w io.Writer, stream *onynet.Stream) error {
+	header := make([]byte, 2)
+	buf := make([]byte, chunkSize)
+	for {
+		if _, err := io.ReadFull(stream, header); err != nil {
+			return err
+		}
+		n := int(binary.BigEndian.Uint16(header))
+		if n == 0 {
+			return nil
+		}
+		if n > chunkSize {
+			return intErrors.ErrMalformedFrame
+		}
+		if _, err := io.ReadFull(stream, buf[:n]); err != nil {
+			return err
+		}
+		if _, err := w.Write(buf[:n]); err != nil {
+			return err
+		}
+	}
+}
+
+// closeWrite closes conn for writing, a connection which cannot be half closed fails so both ends are closed.
+func closeWrite(conn net.Conn) error {
+	c, ok := conn.(interface{ CloseWrite() error })
+	if !ok {
+		return errors.ErrUnsupported
+	}
+	return c.CloseWrite()
+}
+
+// Allowed reports whether rule allows clientConn to reach addr, a missing rule allows nothing.
+func Allowed[R ~func(*onynet.ClientConn, string) bool](rule *R, clientConn *onynet.ClientConn, addr string) bool {
+	return rule != nil && *rule != nil && (*rule)(clientConn, addr)
+}
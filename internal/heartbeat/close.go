@@ -0,0 +1,61 @@
+package heartbeat
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"strings"
+	"time"
+
+	intErrors "github.com/Onyz107/onynet/errors"
+)
+
+// SendClose tells the peer the connection is being closed gracefully, with the given code and reason.
+// The close frame is the close message followed by the code, the length of the reason and the reason,
+// it can be sent by either end while the heartbeat is running.
+func SendClose(conn net.Conn, code intErrors.CloseCode, reason string) error {
+	if len(reason) > MaxCloseReason {
+		reason = strings.ToValidUTF8(reason[:MaxCloseReason], "")
+	}
+
+	frame := make([]byte, 0, len(closeMsg)+4+len(reason))
+	frame = append(frame, closeMsg...)
+	frame = binary.BigEndian.AppendUint16(frame, uint16(code))
+	frame = binary.BigEndian.AppendUint16(frame, uint16(len(reason)))
+	frame = append(frame, reason...)
+
+	conn.SetWriteDeadline(time.Now().Add(closeTimeout))
+	n, err := conn.Write(frame)
+	if err != nil {
+		return errors.Join(intErrors.ErrWrite, err)
+	}
+	if n != len(frame) {
+		return intErrors.ErrShortWrite
+	}
+	return nil
+}
+
+// readClose reads the rest of a close frame whose close message was already read.
+func readClose(conn net.Conn) error {
+	header := make([]byte, 4)
+	if _, err := io.ReadFull(conn, header); err != nil {
+		return errors.Join(intErrors.ErrRead, err)
+	}
+
+	length := binary.BigEndian.Uint16(header[2:])
+	if length > MaxCloseReason {
+		return errors.Join(intErrors.ErrUnexpectedMsg, fmt.Errorf("close reason of %d bytes", length))
+	}
+
+	reason := make([]byte, length)
+	if _, err := io.ReadFull(conn, reason); err != nil {
+		return errors.Join(intErrors.ErrRead, err)
+	}
+
+	return &intErrors.CloseError{
+		Code:   intErrors.CloseCode(binary.BigEndian.Uint16(header)),
+		Reason: string(reason),
+	}
+}
@@ -2,11 +2,24 @@ package heartbeat
 
 import (
 	"sync"
+	"time"
 )
 
 const (
 	senderMsg   = "ping"
 	receiverMsg = "pong"
+	closeMsg    = "clos"
+)
+
+const (
+	// interval is the time between two pings.
+	interval = 5 * time.Second
+	// timeout is how long a ping may wait for its pong, and the receiver for the next ping.
+	timeout = 15 * time.Second
+	// closeTimeout is the deadline for sending a close frame.
+	closeTimeout = 5 * time.Second
+	// MaxCloseReason is the longest reason a close frame carries, longer reasons are truncated.
+	MaxCloseReason = 123
 )
 
 var bufPool = sync.Pool{
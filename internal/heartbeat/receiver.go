@@ -9,47 +9,42 @@ import (
 	"time"
 
 	intErrors "github.com/Onyz107/onynet/errors"
-	"github.com/Onyz107/onynet/internal/logger"
 )
 
 // ReceiveHeartbeat handles incoming heartbeat messages and responds.
+// It returns a *CloseError when the peer sends a close frame.
 func ReceiveHeartbeat(conn net.Conn, ctx context.Context) error {
 	defer conn.SetDeadline(time.Time{})
 
-	ticker := time.NewTicker(5 * time.Second)
-	defer ticker.Stop()
-
 	bufPtr := bufPool.Get().(*[]byte)
 	defer bufPool.Put(bufPtr)
 	buf := *bufPtr
 
 	for {
-		select {
-
-		case <-ctx.Done():
-			return intErrors.ErrCtxCancelled
-
-		case <-ticker.C:
-			conn.SetDeadline(time.Now().Add(15 * time.Second))
-			if _, err := io.ReadFull(conn, buf); err != nil {
-				return errors.Join(intErrors.ErrRead, err)
-			}
-			heartbeatMsg := string(buf)
-
-			if heartbeatMsg != senderMsg {
-				return errors.Join(intErrors.ErrUnexpectedMsg, fmt.Errorf("expected: %s: got: %s", senderMsg, heartbeatMsg))
+		conn.SetReadDeadline(time.Now().Add(timeout))
+		if _, err := io.ReadFull(conn, buf); err != nil {
+			if ctx.Err() != nil {
+				return intErrors.ErrCtxCancelled
 			}
-			logger.Log.Debugf("ReceiveHeartbeat: heartbeat received")
+			return errors.Join(intErrors.ErrRead, err)
+		}
+		heartbeatMsg := string(buf)
+
+		switch heartbeatMsg {
+		case closeMsg:
+			return readClose(conn)
+		case senderMsg:
+		default:
+			return errors.Join(intErrors.ErrUnexpectedMsg, fmt.Errorf("expected: %s: got: %s", senderMsg, heartbeatMsg))
+		}
 
-			n, err := conn.Write([]byte(receiverMsg))
-			if err != nil {
-				return errors.Join(intErrors.ErrWrite, err)
-			}
-			if n != len(buf) {
-				return intErrors.ErrShortWrite
-			}
-			logger.Log.Debugf("ReceiveHeartbeat: heartbeat sent")
-			conn.SetDeadline(time.Time{})
+		conn.SetWriteDeadline(time.Now().Add(timeout))
+		n, err := conn.Write([]byte(receiverMsg))
+		if err != nil {
+			return errors.Join(intErrors.ErrWrite, err)
+		}
+		if n != len(buf) {
+			return intErrors.ErrShortWrite
 		}
 	}
 }
@@ -6,22 +6,25 @@ import (
 	"fmt"
 	"io"
 	"net"
+	"sync/atomic"
 	"time"
 
 	intErrors "github.com/Onyz107/onynet/errors"
-	"github.com/Onyz107/onynet/internal/logger"
 )
 
 // SendHeartbeat sends periodic heartbeat messages and checks for responses.
-func SendHeartbeat(conn net.Conn, ctx context.Context) error {
+// onRTT, if not nil, is called with the round trip time of every heartbeat.
+// It returns a *CloseError when the peer sends a close frame.
+func SendHeartbeat(conn net.Conn, ctx context.Context, onRTT func(time.Duration)) error {
 	defer conn.SetDeadline(time.Time{})
 
-	ticker := time.NewTicker(5 * time.Second)
+	ticker := time.NewTicker(interval)
 	defer ticker.Stop()
 
-	bufPtr := bufPool.Get().(*[]byte)
-	defer bufPool.Put(bufPtr)
-	buf := *bufPtr
+	// Replies are read continuously so a close frame is noticed as soon as it arrives.
+	var sentAt atomic.Int64
+	replies := make(chan error, 1)
+	go func() { replies <- readReplies(conn, &sentAt, onRTT) }()
 
 	for {
 		select {
@@ -29,8 +32,16 @@ func SendHeartbeat(conn net.Conn, ctx context.Context) error {
 		case <-ctx.Done():
 			return intErrors.ErrCtxCancelled
 
+		case err := <-replies:
+			if ctx.Err() != nil {
+				return intErrors.ErrCtxCancelled
+			}
+			return err
+
 		case <-ticker.C:
-			conn.SetDeadline(time.Now().Add(15 * time.Second))
+			// The deadline also bounds the wait for the pong, which readReplies is blocked on.
+			conn.SetDeadline(time.Now().Add(timeout))
+			sentAt.Store(time.Now().UnixNano())
 			n, err := conn.Write([]byte(senderMsg))
 			if err != nil {
 				return errors.Join(intErrors.ErrWrite, err)
@@ -38,18 +49,31 @@ func SendHeartbeat(conn net.Conn, ctx context.Context) error {
 			if n != len(senderMsg) {
 				return intErrors.ErrShortWrite
 			}
-			logger.Log.Debugf("SendHeartbeat: sent heartbeat")
+		}
+	}
+}
 
-			if _, err := io.ReadFull(conn, buf); err != nil {
-				return errors.Join(intErrors.ErrRead, err)
-			}
-			heartbeatMsg := string(buf)
+// readReplies reads the pongs until an error or a close frame, timing them from the last ping sent.
+func readReplies(conn net.Conn, sentAt *atomic.Int64, onRTT func(time.Duration)) error {
+	bufPtr := bufPool.Get().(*[]byte)
+	defer bufPool.Put(bufPtr)
+	buf := *bufPtr
+
+	for {
+		if _, err := io.ReadFull(conn, buf); err != nil {
+			return errors.Join(intErrors.ErrRead, err)
+		}
+		heartbeatMsg := string(buf)
 
-			if heartbeatMsg != receiverMsg {
-				return errors.Join(intErrors.ErrUnexpectedMsg, fmt.Errorf("expected: %s: got: %s", receiverMsg, heartbeatMsg))
+		switch heartbeatMsg {
+		case closeMsg:
+			return readClose(conn)
+		case receiverMsg:
+			if onRTT != nil {
+				onRTT(time.Since(time.Unix(0, sentAt.Load())))
 			}
-			logger.Log.Debugf("SendHeartbeat: heartbeat received")
-			conn.SetDeadline(time.Time{})
+		default:
+			return errors.Join(intErrors.ErrUnexpectedMsg, fmt.Errorf("expected: %s: got: %s", receiverMsg, heartbeatMsg))
 		}
 	}
 }
@@ -3,20 +3,23 @@ package kcp
 import (
 	"context"
 	"errors"
+	"log/slog"
 	"net"
 	"sync"
 	"time"
 
 	intErrors "github.com/Onyz107/onynet/errors"
-	"github.com/Onyz107/onynet/internal/logger"
 	"github.com/xtaci/kcp-go/v5"
 )
 
 type Client struct {
-	conn *kcp.UDPSession
-	ctx  context.Context
-	done chan struct{}
-	once sync.Once
+	conn      *kcp.UDPSession
+	pconn     *packetConn
+	datagrams chan []byte
+	ctx       context.Context
+	done      chan struct{}
+	once      sync.Once
+	logger    *slog.Logger
 }
 
 func (c *Client) Read(b []byte) (n int, err error) {
@@ -37,10 +40,23 @@ func (c *Client) Write(b []byte) (n int, err error) {
 	}
 }
 
+// ReadDatagram waits for the next datagram sent by the server.
+func (c *Client) ReadDatagram(ctx context.Context) ([]byte, error) {
+	return readDatagram(c.datagrams, ctx, c.ctx, c.done)
+}
+
+// WriteDatagram sends b to the server as a single datagram on the socket shared with KCP.
+func (c *Client) WriteDatagram(b []byte) error {
+	return c.pconn.writeDatagram(b, c.conn.RemoteAddr())
+}
+
 func (c *Client) Close() error {
-	c.once.Do(func() { close(c.done) })
-	logger.Log.Debugf("kcp.Client Close: closing client connection")
-	return c.conn.Close()
+	c.once.Do(func() {
+		c.pconn.unroute(c.conn.RemoteAddr())
+		close(c.done)
+	})
+	c.logger.Debug("closing kcp connection")
+	return errors.Join(c.conn.Close(), c.pconn.Close())
 }
 
 func (c *Client) LocalAddr() net.Addr {
@@ -63,25 +79,45 @@ func (c *Client) SetWriteDeadline(t time.Time) error {
 	return c.conn.SetWriteDeadline(t)
 }
 
-// Dial connects to a KCP server and returns a client wrapper.
-func Dial(addr net.Addr, ctx context.Context) (*Client, error) {
-	logger.Log.Debugf("kcp/client Dial: connecting to %s", addr.String())
-	conn, err := kcp.DialWithOptions(addr.String(), nil, 0, 0)
+// Dial connects to a KCP server over a socket of transport and returns a client wrapper.
+// Before starting its session, the client echoes a cookie the server binds to its address,
+// proving it receives packets at that address.
+func Dial(addr net.Addr, transport Transport, ctx context.Context, logger *slog.Logger) (*Client, error) {
+	logger = logger.With("remote_addr", addr.String())
+	logger.Debug("dialing kcp server")
+	packetConn, remote, err := transport.Dial(addr)
+	if err != nil {
+		return nil, errors.Join(intErrors.ErrBadAddr, err)
+	}
+	if err := requestCookie(packetConn, remote, ctx); err != nil {
+		packetConn.Close()
+		return nil, err
+	}
+	pconn := newPacketConn(packetConn, func() *slog.Logger { return logger })
+
+	conn, err := kcp.NewConn2(remote, nil, 0, 0, pconn)
 	if err != nil {
+		pconn.Close()
 		return nil, errors.Join(intErrors.ErrBadAddr, err)
 	}
-	logger.Log.Debugf("kcp/client Dial: connected to %s", addr.String())
 
 	// Performance optimizations
 	conn.SetWindowSize(512, 512)
 	conn.SetNoDelay(1, 40, 2, 1)
 
-	client := &Client{conn: conn, ctx: ctx, done: make(chan struct{}, 1)}
+	client := &Client{
+		conn:      conn,
+		pconn:     pconn,
+		datagrams: pconn.route(remote),
+		ctx:       ctx,
+		done:      make(chan struct{}, 1),
+		logger:    logger,
+	}
 
 	go func() {
 		select {
 		case <-client.ctx.Done():
-			logger.Log.Debug("kcp/client Dial: closing client because of context canceled")
+			logger.Debug("closing kcp connection because of context cancellation")
 			client.Close()
 			return
 		case <-client.done:
@@ -91,3 +127,8 @@ func Dial(addr net.Addr, ctx context.Context) (*Client, error) {
 
 	return client, nil
 }
+
+// MaxDatagramSize returns the largest payload WriteDatagram accepts.
+func (c *Client) MaxDatagramSize() int {
+	return MaxDatagramSize
+}
@@ -2,20 +2,23 @@ package kcp
 
 import (
 	"context"
+	"log/slog"
 	"net"
 	"sync"
 	"time"
 
 	intErrors "github.com/Onyz107/onynet/errors"
-	"github.com/Onyz107/onynet/internal/logger"
 	"github.com/xtaci/kcp-go/v5"
 )
 
 type ClientConn struct {
-	conn *kcp.UDPSession
-	ctx  context.Context
-	done chan struct{}
-	once sync.Once
+	conn      *kcp.UDPSession
+	pconn     *packetConn
+	datagrams chan []byte
+	ctx       context.Context
+	done      chan struct{}
+	once      sync.Once
+	logger    *slog.Logger
 }
 
 func (c *ClientConn) Read(p []byte) (n int, err error) {
@@ -36,9 +39,22 @@ func (c *ClientConn) Write(p []byte) (n int, err error) {
 	}
 }
 
+// ReadDatagram waits for the next datagram sent by the client.
+func (c *ClientConn) ReadDatagram(ctx context.Context) ([]byte, error) {
+	return readDatagram(c.datagrams, ctx, c.ctx, c.done)
+}
+
+// WriteDatagram sends b to the client as a single datagram on the socket shared with KCP.
+func (c *ClientConn) WriteDatagram(b []byte) error {
+	return c.pconn.writeDatagram(b, c.conn.RemoteAddr())
+}
+
 func (c *ClientConn) Close() error {
-	c.once.Do(func() { close(c.done) })
-	logger.Log.Debugf("kcp.ClientConn Close: closing client connection")
+	c.once.Do(func() {
+		c.pconn.unroute(c.conn.RemoteAddr())
+		close(c.done)
+	})
+	c.logger.Debug("closing kcp connection")
 	return c.conn.Close()
 }
 
@@ -61,3 +77,8 @@ func (c *ClientConn) SetReadDeadline(t time.Time) error {
 func (c *ClientConn) SetWriteDeadline(t time.Time) error {
 	return c.conn.SetWriteDeadline(t)
 }
+
+// MaxDatagramSize returns the largest payload WriteDatagram accepts.
+func (c *ClientConn) MaxDatagramSize() int {
+	return MaxDatagramSize
+}
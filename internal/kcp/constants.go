@@ -0,0 +1,72 @@
+package kcp
+
+import (
+	"sync"
+	"time"
+)
+
+const (
+	// mtu is the default MTU used by KCP sessions.
+	mtu = 1400
+	// datagramHeaderSize is the length of datagramHeader.
+	datagramHeaderSize = 5
+	// datagramQueueSize is the number of datagrams queued per session before new ones are dropped.
+	datagramQueueSize = 128
+)
+
+// MaxDatagramSize is the largest payload a single datagram can carry.
+const MaxDatagramSize = mtu - datagramHeaderSize
+
+// datagramHeader prefixes every datagram, 0xD0 is never a KCP command nor a FEC packet type.
+var datagramHeader = []byte{'O', 'N', 'Y', 'D', 0xD0}
+
+const (
+	// cookieSecretLength is the length of the secret cookies are authenticated with.
+	cookieSecretLength = 32
+	// cookieLength is the length of a cookie: an 8 bytes issue time followed by a SHA-256 HMAC.
+	cookieLength = 8 + 32
+	// cookiePacketSize is the size of the packets carrying a cookie: the header, the packet type and the cookie.
+	cookiePacketSize = 5 + 1 + cookieLength
+	// cookieLifetime is how long a cookie stays valid after being issued, and an address after echoing it.
+	cookieLifetime = 10 * time.Second
+	// cookieTimeout bounds the cookie exchange of a client.
+	cookieTimeout = 5 * time.Second
+	// cookieRetryInterval is how long a client waits for an answer before sending a cookie packet again.
+	cookieRetryInterval = 250 * time.Millisecond
+)
+
+// cookieHeader prefixes every cookie packet, 0xC0 is never a KCP command nor a FEC packet type.
+var cookieHeader = []byte{'O', 'N', 'Y', 'C', 0xC0}
+
+// Types of the cookie packets, following cookieHeader.
+const (
+	cookieHello byte = iota + 1
+	cookieIssue
+	cookieEcho
+	cookieAccepted
+	cookieInvalid
+)
+
+var datagramPool = sync.Pool{
+	New: func() any {
+		buf := make([]byte, 0, mtu)
+		return &buf
+	},
+}
+
+const (
+	// memoryQueueSize is the number of packets queued per memory socket before new ones are dropped.
+	memoryQueueSize = 4096
+	// memoryFirstPort and memoryLastPort bound the ports picked for memory sockets bound to port 0.
+	memoryFirstPort = 49152
+	memoryLastPort  = 65535
+)
+
+const (
+	// sharedQueueSize is the number of packets queued per socket of a SharedSocket before new ones are dropped.
+	sharedQueueSize = 4096
+	// sharedBufferSize is the size of the buffer packets are read into, the largest UDP payload.
+	sharedBufferSize = 65507
+	// sharedPeerTimeout is how long an address stays assigned to a listening socket of a SharedSocket after its last packet.
+	sharedPeerTimeout = 2 * time.Minute
+)
@@ -0,0 +1,192 @@
+package kcp
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/binary"
+	"errors"
+	"net"
+	"os"
+	"sync"
+	"time"
+
+	intErrors "github.com/Onyz107/onynet/errors"
+)
+
+// cookieGate keeps a server from creating KCP sessions for addresses which did not prove they receive
+// packets at that address. A client first asks for a cookie bound to its address, which the server issues
+// without keeping any state, then echoes it. Only once the echo is verified does the server let the KCP
+// packets of the address through, so spoofed addresses never get a session.
+type cookieGate struct {
+	secret []byte
+
+	mu       sync.Mutex
+	verified map[string]time.Time // address -> when its cookie expires
+}
+
+func newCookieGate() *cookieGate {
+	secret := make([]byte, cookieSecretLength)
+	if _, err := rand.Read(secret); err != nil {
+		panic(err)
+	}
+	return &cookieGate{secret: secret, verified: make(map[string]time.Time)}
+}
+
+// handle answers a cookie packet of a client, the answer is never larger than the packet.
+func (g *cookieGate) handle(packet []byte, addr net.Addr, conn net.PacketConn) {
+	if len(packet) < cookiePacketSize {
+		return
+	}
+
+	switch packet[len(cookieHeader)] {
+	case cookieHello:
+		answer := make([]byte, cookiePacketSize)
+		copy(answer, cookieHeader)
+		answer[len(cookieHeader)] = cookieIssue
+		issueCookie(answer[len(cookieHeader)+1:], g.secret, addr, time.Now())
+		conn.WriteTo(answer, addr)
+
+	case cookieEcho:
+		answer := append(cookieHeader[:len(cookieHeader):len(cookieHeader)], cookieAccepted)
+		if !verifyCookie(packet[len(cookieHeader)+1:cookiePacketSize], g.secret, addr, time.Now()) {
+			answer[len(cookieHeader)] = cookieInvalid
+		} else {
+			g.verify(addr)
+		}
+		conn.WriteTo(answer, addr)
+	}
+}
+
+// verify lets the KCP packets of addr through for cookieLifetime, enough for the client to start its session.
+func (g *cookieGate) verify(addr net.Addr) {
+	now := time.Now()
+
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	for key, expires := range g.verified {
+		if now.After(expires) {
+			delete(g.verified, key)
+		}
+	}
+	g.verified[addr.String()] = now.Add(cookieLifetime)
+}
+
+// forget stops letting the KCP packets of addr through, such as once its session is closed.
+func (g *cookieGate) forget(addr net.Addr) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	delete(g.verified, addr.String())
+}
+
+// allowed reports whether addr echoed a valid cookie recently.
+func (g *cookieGate) allowed(addr net.Addr) bool {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	expires, ok := g.verified[addr.String()]
+	return ok && time.Now().Before(expires)
+}
+
+// requestCookie asks the server at addr for a cookie over conn and echoes it, retrying both
+// until the server accepts the echo, ctx is done or cookieTimeout passes.
+//
+// Possible errors:
+//   - ErrInvalidCookie: the server refused the echoed cookie
+//   - ErrTimeout: the server did not answer
+//   - ErrCtxCancelled: ctx was cancelled
+//   - ErrWrite: failed to send a cookie packet
+func requestCookie(conn net.PacketConn, addr net.Addr, ctx context.Context) error {
+	deadline := time.Now().Add(cookieTimeout)
+	defer conn.SetReadDeadline(time.Time{})
+
+	// The hello is as large as the cookie sent back, so the server cannot be used to amplify traffic
+	request := make([]byte, cookiePacketSize)
+	copy(request, cookieHeader)
+	request[len(cookieHeader)] = cookieHello
+
+	buf := make([]byte, mtu)
+	for {
+		if _, err := conn.WriteTo(request, addr); err != nil {
+			return errors.Join(intErrors.ErrWrite, err)
+		}
+
+		retry := time.Now().Add(cookieRetryInterval)
+		if retry.After(deadline) {
+			retry = deadline
+		}
+		conn.SetReadDeadline(retry)
+		for {
+			n, from, err := conn.ReadFrom(buf)
+			if err != nil {
+				if !errors.Is(err, os.ErrDeadlineExceeded) {
+					return errors.Join(intErrors.ErrRead, err)
+				}
+				break
+			}
+			if from.String() != addr.String() || n <= len(cookieHeader) || !bytes.HasPrefix(buf, cookieHeader) {
+				continue
+			}
+
+			switch buf[len(cookieHeader)] {
+			case cookieIssue:
+				if n == cookiePacketSize {
+					copy(request, buf[:n])
+					request[len(cookieHeader)] = cookieEcho
+				}
+			case cookieAccepted:
+				if request[len(cookieHeader)] == cookieEcho {
+					return nil
+				}
+			case cookieInvalid:
+				return intErrors.ErrInvalidCookie
+			}
+			break
+		}
+
+		select {
+		case <-ctx.Done():
+			return intErrors.ErrCtxCancelled
+		default:
+		}
+		if time.Now().After(deadline) {
+			return errors.Join(intErrors.ErrTimeout, errors.New("no handshake cookie from the server"))
+		}
+	}
+}
+
+// isCookiePacket reports whether packet is a cookie packet rather than a KCP one.
+func isCookiePacket(packet []byte) bool {
+	return bytes.HasPrefix(packet, cookieHeader)
+}
+
+// isCookieAnswer reports whether packet is a cookie packet sent by a server, as opposed to a client's.
+func isCookieAnswer(packet []byte) bool {
+	if !isCookiePacket(packet) || len(packet) <= len(cookieHeader) {
+		return false
+	}
+	kind := packet[len(cookieHeader)]
+	return kind == cookieIssue || kind == cookieAccepted || kind == cookieInvalid
+}
+
+// issueCookie writes the issue time followed by a MAC of the time and the address into cookie.
+func issueCookie(cookie, secret []byte, addr net.Addr, now time.Time) {
+	binary.BigEndian.PutUint64(cookie[:8], uint64(now.UnixNano()))
+	copy(cookie[8:], cookieMAC(secret, addr, cookie[:8]))
+}
+
+func verifyCookie(cookie, secret []byte, addr net.Addr, now time.Time) bool {
+	issued := time.Unix(0, int64(binary.BigEndian.Uint64(cookie[:8])))
+	if now.Before(issued) || now.Sub(issued) > cookieLifetime {
+		return false
+	}
+	return hmac.Equal(cookie[8:], cookieMAC(secret, addr, cookie[:8]))
+}
+
+func cookieMAC(secret []byte, addr net.Addr, issued []byte) []byte {
+	mac := hmac.New(sha256.New, secret)
+	mac.Write(issued)
+	mac.Write([]byte(addr.String()))
+	return mac.Sum(nil)
+}
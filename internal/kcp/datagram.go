@@ -0,0 +1,118 @@
+package kcp
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"log/slog"
+	"net"
+	"sync"
+
+	intErrors "github.com/Onyz107/onynet/errors"
+)
+
+// packetConn wraps the UDP socket shared with KCP and separates datagrams and cookie packets from KCP packets.
+// Datagrams start with datagramHeader, its fifth byte is never a valid KCP command nor FEC type.
+// On a server, gate answers the cookie packets and holds back the KCP packets of unverified addresses.
+type packetConn struct {
+	net.PacketConn
+	routes sync.Map // addr string -> chan []byte
+	gate   *cookieGate
+	logger func() *slog.Logger
+}
+
+func newPacketConn(conn net.PacketConn, logger func() *slog.Logger) *packetConn {
+	return &packetConn{PacketConn: conn, logger: logger}
+}
+
+// ReadFrom returns the next KCP packet, delivering every datagram read in the meantime to its route.
+// On a server, the KCP packets of addresses which neither have a session nor echoed a cookie are dropped.
+func (p *packetConn) ReadFrom(b []byte) (int, net.Addr, error) {
+	for {
+		n, addr, err := p.PacketConn.ReadFrom(b)
+		if err != nil {
+			return n, addr, err
+		}
+
+		if isCookiePacket(b[:n]) {
+			if p.gate != nil {
+				p.gate.handle(b[:n], addr, p.PacketConn)
+			}
+			continue
+		}
+
+		if n < len(datagramHeader) || !bytes.Equal(b[:len(datagramHeader)], datagramHeader) {
+			if p.gate != nil && !p.routed(addr) && !p.gate.allowed(addr) {
+				continue
+			}
+			return n, addr, nil
+		}
+
+		val, ok := p.routes.Load(addr.String())
+		if !ok {
+			p.logger().Debug("dropping datagram from unknown address", "remote_addr", addr.String())
+			continue
+		}
+
+		payload := make([]byte, n-len(datagramHeader))
+		copy(payload, b[len(datagramHeader):n])
+
+		select {
+		case val.(chan []byte) <- payload:
+		default:
+			p.logger().Debug("dropping datagram because the queue is full", "remote_addr", addr.String())
+		}
+	}
+}
+
+func (p *packetConn) route(addr net.Addr) chan []byte {
+	ch := make(chan []byte, datagramQueueSize)
+	p.routes.Store(addr.String(), ch)
+	return ch
+}
+
+func (p *packetConn) routed(addr net.Addr) bool {
+	_, ok := p.routes.Load(addr.String())
+	return ok
+}
+
+// unroute stops delivering the datagrams of addr, on a server its KCP packets are dropped until it echoes a new cookie.
+func (p *packetConn) unroute(addr net.Addr) {
+	p.routes.Delete(addr.String())
+	if p.gate != nil {
+		p.gate.forget(addr)
+	}
+}
+
+func (p *packetConn) writeDatagram(b []byte, addr net.Addr) error {
+	if len(b) > MaxDatagramSize {
+		return intErrors.ErrDatagramTooLarge
+	}
+
+	bufPtr := datagramPool.Get().(*[]byte)
+	defer datagramPool.Put(bufPtr)
+	packet := append((*bufPtr)[:0], datagramHeader...)
+	packet = append(packet, b...)
+
+	n, err := p.PacketConn.WriteTo(packet, addr)
+	if err != nil {
+		return errors.Join(intErrors.ErrWrite, err)
+	}
+	if n != len(packet) {
+		return intErrors.ErrShortWrite
+	}
+	return nil
+}
+
+func readDatagram(queue <-chan []byte, ctx, connCtx context.Context, done <-chan struct{}) ([]byte, error) {
+	select {
+	case b := <-queue:
+		return b, nil
+	case <-ctx.Done():
+		return nil, intErrors.ErrCtxCancelled
+	case <-connCtx.Done():
+		return nil, intErrors.ErrCtxCancelled
+	case <-done:
+		return nil, intErrors.ErrConnClosed
+	}
+}
@@ -0,0 +1,114 @@
+package kcp
+
+import (
+	"errors"
+	"net"
+	"os"
+	"sync"
+	"time"
+)
+
+// errDeadlineChanged wakes up a read waiting on a deadline which is no longer the current one.
+var errDeadlineChanged = errors.New("read deadline changed")
+
+type inboundPacket struct {
+	data []byte
+	from net.Addr
+}
+
+// inbox queues the packets delivered to a socket which has no kernel buffer of its own,
+// and lets them be read with a deadline. Like UDP, packets delivered while the queue is full are dropped.
+type inbox struct {
+	queue chan inboundPacket
+	done  chan struct{}
+	once  sync.Once
+
+	mu           sync.Mutex
+	readDeadline time.Time
+	deadline     chan struct{} // closed and replaced when the read deadline changes
+}
+
+func newInbox(size int) *inbox {
+	return &inbox{
+		queue:    make(chan inboundPacket, size),
+		done:     make(chan struct{}),
+		deadline: make(chan struct{}),
+	}
+}
+
+// deliver queues a copy of b, it reports whether the packet was queued.
+func (i *inbox) deliver(b []byte, from net.Addr) bool {
+	packet := inboundPacket{data: make([]byte, len(b)), from: from}
+	copy(packet.data, b)
+
+	select {
+	case <-i.done:
+		return false
+	case i.queue <- packet:
+		return true
+	default:
+		return false
+	}
+}
+
+func (i *inbox) ReadFrom(b []byte) (int, net.Addr, error) {
+	for {
+		i.mu.Lock()
+		readDeadline, changed := i.readDeadline, i.deadline
+		i.mu.Unlock()
+
+		n, addr, err := i.read(b, readDeadline, changed)
+		if err != errDeadlineChanged {
+			return n, addr, err
+		}
+	}
+}
+
+// read waits for a packet until readDeadline, it returns errDeadlineChanged if the deadline changed in the meantime.
+func (i *inbox) read(b []byte, readDeadline time.Time, changed <-chan struct{}) (int, net.Addr, error) {
+	var expired <-chan time.Time
+	if !readDeadline.IsZero() {
+		timer := time.NewTimer(time.Until(readDeadline))
+		defer timer.Stop()
+		expired = timer.C
+	}
+
+	select {
+	case packet := <-i.queue:
+		return copy(b, packet.data), packet.from, nil
+	case <-i.done:
+		return 0, nil, net.ErrClosed
+	case <-expired:
+		return 0, nil, os.ErrDeadlineExceeded
+	case <-changed:
+		return 0, nil, errDeadlineChanged
+	}
+}
+
+func (i *inbox) SetReadDeadline(t time.Time) error {
+	i.mu.Lock()
+	defer i.mu.Unlock()
+	i.readDeadline = t
+	close(i.deadline)
+	i.deadline = make(chan struct{})
+	return nil
+}
+
+func (i *inbox) isClosed() bool {
+	select {
+	case <-i.done:
+		return true
+	default:
+		return false
+	}
+}
+
+// close wakes up the pending reads and makes the next ones fail, it reports whether the inbox was open.
+func (i *inbox) close() bool {
+	closing := false
+	i.once.Do(func() {
+		closing = true
+		close(i.done)
+	})
+	return closing
+}
@@ -2,9 +2,14 @@ package kcp_test
 
 import (
 	"context"
+	"errors"
+	"io"
+	"log/slog"
 	"net"
 	"testing"
+	"time"
 
+	intErrors "github.com/Onyz107/onynet/errors"
 	"github.com/Onyz107/onynet/internal/kcp"
 )
 
@@ -16,7 +21,7 @@ func BenchmarkDial(b *testing.B) {
 
 	ctx := context.Background()
 
-	server, err := kcp.NewServer(addr, ctx)
+	server, err := kcp.NewServer(addr, kcp.UDP, ctx, slog.New(slog.DiscardHandler))
 	if err != nil {
 		b.Fatal(err)
 	}
@@ -24,7 +29,7 @@ func BenchmarkDial(b *testing.B) {
 
 	b.ResetTimer()
 	for b.Loop() {
-		kcp.Dial(addr, ctx)
+		kcp.Dial(addr, kcp.UDP, ctx, slog.New(slog.DiscardHandler))
 	}
 }
 
@@ -37,7 +42,7 @@ func BenchmarkNewServer(b *testing.B) {
 		if err != nil {
 			b.Fatal(err)
 		}
-		if _, err := kcp.NewServer(addr, ctx); err != nil {
+		if _, err := kcp.NewServer(addr, kcp.UDP, ctx, slog.New(slog.DiscardHandler)); err != nil {
 			b.Fatal(err)
 		}
 	}
@@ -51,7 +56,7 @@ func BenchmarkServer_Accept(b *testing.B) {
 
 	ctx := context.Background()
 
-	server, err := kcp.NewServer(addr, ctx)
+	server, err := kcp.NewServer(addr, kcp.UDP, ctx, slog.New(slog.DiscardHandler))
 	if err != nil {
 		b.Fatal(err)
 	}
@@ -60,12 +65,337 @@ func BenchmarkServer_Accept(b *testing.B) {
 	b.ResetTimer()
 	for b.Loop() {
 		go func() {
-			client, err := kcp.Dial(addr, ctx)
+			client, err := kcp.Dial(addr, kcp.UDP, ctx, slog.New(slog.DiscardHandler))
 			if err != nil {
-				b.Fatal(err)
+				b.Error(err)
+				return
 			}
 			client.Write([]byte("1"))
 		}()
 		server.Accept()
 	}
 }
+
+func TestDatagram(t *testing.T) {
+	network := kcp.NewMemoryNetwork()
+	addr, err := net.ResolveUDPAddr("udp", "127.0.0.1:9494")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	server, err := kcp.NewServer(addr, network, t.Context(), slog.New(slog.DiscardHandler))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer server.Close()
+
+	client, err := kcp.Dial(addr, network, t.Context(), slog.New(slog.DiscardHandler))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer client.Close()
+	client.Write([]byte("0")) // Need to write something for it to be accepted
+
+	clientConn, err := server.Accept()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer clientConn.Close()
+
+	ctx, cancel := context.WithTimeout(t.Context(), 5*time.Second)
+	defer cancel()
+
+	if err := client.WriteDatagram([]byte("ping")); err != nil {
+		t.Fatal(err)
+	}
+	b, err := clientConn.ReadDatagram(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(b) != "ping" {
+		t.Fatalf("unexpected datagram: %s", b)
+	}
+
+	if err := clientConn.WriteDatagram([]byte("pong")); err != nil {
+		t.Fatal(err)
+	}
+	b, err = client.ReadDatagram(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(b) != "pong" {
+		t.Fatalf("unexpected datagram: %s", b)
+	}
+
+	// Stream data still flows through KCP
+	buf := make([]byte, 1)
+	if _, err := clientConn.Read(buf); err != nil {
+		t.Fatal(err)
+	}
+	if string(buf) != "0" {
+		t.Fatalf("unexpected stream data: %s", buf)
+	}
+
+	if err := client.WriteDatagram(make([]byte, kcp.MaxDatagramSize+1)); !errors.Is(err, intErrors.ErrDatagramTooLarge) {
+		t.Fatalf("expected ErrDatagramTooLarge, got: %v", err)
+	}
+}
+
+func TestCookie(t *testing.T) {
+	network := kcp.NewMemoryNetwork()
+	addr, err := net.ResolveUDPAddr("udp", "127.0.0.1:9595")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	server, err := kcp.NewServer(addr, network, t.Context(), slog.New(slog.DiscardHandler))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer server.Close()
+
+	accepted := make(chan *kcp.ClientConn, 2)
+	go func() {
+		for {
+			clientConn, err := server.Accept()
+			if err != nil {
+				return
+			}
+			accepted <- clientConn
+		}
+	}()
+
+	// A KCP push segment from an address which never echoed a cookie, as sent with a spoofed address
+	spoofed, err := network.Listen(&net.UDPAddr{IP: net.IPv4(127, 0, 0, 2)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer spoofed.Close()
+	segment := make([]byte, 24+4)
+	segment[0], segment[4] = 1, 81 // conversation 1, IKCP_CMD_PUSH
+	segment[20] = 4                // length of the payload
+	copy(segment[24:], "fake")
+	if _, err := spoofed.WriteTo(segment, addr); err != nil {
+		t.Fatal(err)
+	}
+
+	select {
+	case <-accepted:
+		t.Fatal("expected no session for an address without a cookie")
+	case <-time.After(200 * time.Millisecond):
+	}
+
+	client, err := kcp.Dial(addr, network, t.Context(), slog.New(slog.DiscardHandler))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer client.Close()
+	client.Write([]byte("0"))
+
+	select {
+	case clientConn := <-accepted:
+		clientConn.Close()
+	case <-time.After(5 * time.Second):
+		t.Fatal("expected the server to accept the client")
+	}
+}
+
+func TestMemoryNetwork(t *testing.T) {
+	network := kcp.NewMemoryNetwork()
+	addr, err := net.ResolveUDPAddr("udp", ":0")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	server, err := kcp.NewServer(addr, network, t.Context(), slog.New(slog.DiscardHandler))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer server.Close()
+
+	if _, err := kcp.NewServer(server.Addr(), network, t.Context(), slog.New(slog.DiscardHandler)); !errors.Is(err, intErrors.ErrBadAddr) {
+		t.Fatalf("expected ErrBadAddr for an address in use, got: %v", err)
+	}
+
+	client, err := kcp.Dial(server.Addr(), network, t.Context(), slog.New(slog.DiscardHandler))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer client.Close()
+	client.Write([]byte("0")) // Need to write something for it to be accepted
+
+	clientConn, err := server.Accept()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer clientConn.Close()
+
+	if clientConn.RemoteAddr().String() != client.LocalAddr().String() {
+		t.Fatalf("expected remote address %s, got: %s", client.LocalAddr(), clientConn.RemoteAddr())
+	}
+
+	data := make([]byte, 1<<20)
+	go client.Write(data)
+
+	buf := make([]byte, 1+len(data))
+	clientConn.SetReadDeadline(time.Now().Add(5 * time.Second))
+	if _, err := io.ReadFull(clientConn, buf); err != nil {
+		t.Fatal(err)
+	}
+
+	clientConn.SetReadDeadline(time.Now().Add(50 * time.Millisecond))
+	if _, err := clientConn.Read(buf); err == nil {
+		t.Fatal("expected a read timeout")
+	}
+}
+
+func TestSharedSocket(t *testing.T) {
+	network := kcp.NewMemoryNetwork()
+	logger := slog.New(slog.DiscardHandler)
+	newShared := func() *kcp.SharedSocket {
+		conn, err := network.Listen(&net.UDPAddr{IP: net.IPv4(127, 0, 0, 1)})
+		if err != nil {
+			t.Fatal(err)
+		}
+		shared := kcp.NewSharedSocket(conn)
+		t.Cleanup(func() { shared.Close() })
+		return shared
+	}
+	newServer := func(shared *kcp.SharedSocket) *kcp.Server {
+		server, err := kcp.NewServer(shared.LocalAddr(), shared, t.Context(), logger)
+		if err != nil {
+			t.Fatal(err)
+		}
+		t.Cleanup(func() { server.Close() })
+		return server
+	}
+	dial := func(addr net.Addr, transport kcp.Transport) *kcp.Client {
+		client, err := kcp.Dial(addr, transport, t.Context(), logger)
+		if err != nil {
+			t.Fatal(err)
+		}
+		t.Cleanup(func() { client.Close() })
+		return client
+	}
+	// exchange writes message through client and checks server accepts it
+	exchange := func(client *kcp.Client, server *kcp.Server, message string) {
+		t.Helper()
+		if _, err := client.Write([]byte(message)); err != nil {
+			t.Fatal(err)
+		}
+
+		accepted := make(chan *kcp.ClientConn, 1)
+		go func() {
+			clientConn, err := server.Accept()
+			if err == nil {
+				accepted <- clientConn
+			}
+		}()
+
+		select {
+		case clientConn := <-accepted:
+			defer clientConn.Close()
+			buf := make([]byte, len(message))
+			clientConn.SetReadDeadline(time.Now().Add(5 * time.Second))
+			if _, err := io.ReadFull(clientConn, buf); err != nil || string(buf) != message {
+				t.Fatalf("expected %q, got: %q, %v", message, buf, err)
+			}
+		case <-time.After(5 * time.Second):
+			t.Fatalf("expected the server to accept %q", message)
+		}
+	}
+
+	a, b := newShared(), newShared()
+	serversA := []*kcp.Server{newServer(a), newServer(a)}
+	serverB := newServer(b)
+
+	// Each socket runs a server and a client talking to the other one
+	toA := dial(a.LocalAddr(), b)
+	toB := dial(b.LocalAddr(), a)
+	exchange(toA, serversA[0], "b to a")
+	exchange(toB, serverB, "a to b")
+
+	// New addresses are assigned to the next server
+	exchange(dial(a.LocalAddr(), network), serversA[1], "other to a")
+	exchange(dial(a.LocalAddr(), network), serversA[0], "another to a")
+
+	serversA[0].Close()
+	serversA[1].Close()
+	// Packets no socket takes are dropped
+	if _, err := toA.Write([]byte("after close")); err != nil {
+		t.Fatal(err)
+	}
+	exchange(dial(b.LocalAddr(), a), serverB, "a to b again")
+
+	a.Close()
+	if _, err := a.Listen(a.LocalAddr()); !errors.Is(err, net.ErrClosed) {
+		t.Fatalf("expected net.ErrClosed, got: %v", err)
+	}
+}
+
+func TestNAT(t *testing.T) {
+	network := kcp.NewMemoryNetwork()
+	listen := func(transport kcp.Transport, ip net.IP) net.PacketConn {
+		conn, err := transport.Listen(&net.UDPAddr{IP: ip})
+		if err != nil {
+			t.Fatal(err)
+		}
+		t.Cleanup(func() { conn.Close() })
+		return conn
+	}
+	// received reports whether conn receives a packet from its peer
+	received := func(conn net.PacketConn) bool {
+		conn.SetReadDeadline(time.Now().Add(50 * time.Millisecond))
+		_, _, err := conn.ReadFrom(make([]byte, 16))
+		return err == nil
+	}
+
+	for _, test := range []struct {
+		name        string
+		behavior    kcp.NATBehavior
+		unsolicited bool // a packet from an address never written to gets in
+		sameIP      bool // a packet from another port of an IP written to gets in
+		samePublic  bool // writes to two addresses leave from the same public address
+	}{
+		{"FullCone", kcp.FullCone, true, true, true},
+		{"RestrictedCone", kcp.RestrictedCone, false, true, true},
+		{"PortRestrictedCone", kcp.PortRestrictedCone, false, false, true},
+		{"Symmetric", kcp.Symmetric, false, false, false},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			inside := listen(kcp.NewNAT(network, net.IPv4(203, 0, 113, 1), test.behavior), nil)
+			peer := listen(network, net.IPv4(198, 51, 100, 1))
+			peerOtherPort := listen(network, net.IPv4(198, 51, 100, 1))
+			stranger := listen(network, net.IPv4(198, 51, 100, 2))
+
+			stranger.WriteTo([]byte("unsolicited"), inside.LocalAddr())
+			if received(inside) != test.unsolicited {
+				t.Fatalf("expected unsolicited packets getting in to be %v", test.unsolicited)
+			}
+
+			inside.WriteTo([]byte("out"), peer.LocalAddr())
+			_, public, err := peer.ReadFrom(make([]byte, 16))
+			if err != nil {
+				t.Fatal(err)
+			}
+			peer.WriteTo([]byte("in"), public)
+			if !received(inside) {
+				t.Fatal("expected the answer of the peer to get in")
+			}
+			peerOtherPort.WriteTo([]byte("in"), public)
+			if received(inside) != test.sameIP {
+				t.Fatalf("expected packets from another port getting in to be %v", test.sameIP)
+			}
+
+			inside.WriteTo([]byte("out"), stranger.LocalAddr())
+			stranger.SetReadDeadline(time.Now().Add(time.Second))
+			_, otherPublic, err := stranger.ReadFrom(make([]byte, 16))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if (public.String() == otherPublic.String()) != test.samePublic {
+				t.Fatalf("expected the same public address for every destination to be %v, got: %s and %s", test.samePublic, public, otherPublic)
+			}
+		})
+	}
+}
@@ -0,0 +1,141 @@
+package kcp
+
+import (
+	"fmt"
+	"net"
+	"sync"
+	"time"
+)
+
+// MemoryNetwork is a Transport whose sockets exchange packets in memory, without touching the network.
+// Its addresses are UDP addresses, so IP based rules behave as they would over UDP, but no port is ever bound.
+// Like UDP, packets sent to a closed address or to a socket whose queue is full are dropped.
+type MemoryNetwork struct {
+	mu       sync.Mutex
+	conns    map[string]*memoryConn
+	nextPort int
+}
+
+// NewMemoryNetwork returns an empty in-memory network.
+func NewMemoryNetwork() *MemoryNetwork {
+	return &MemoryNetwork{
+		conns:    make(map[string]*memoryConn),
+		nextPort: memoryFirstPort,
+	}
+}
+
+// Listen binds a socket to addr, a port of 0 picks a free one.
+// The memory network has no wildcard addresses, an unspecified IP is bound as the loopback address of its family.
+func (n *MemoryNetwork) Listen(addr net.Addr) (net.PacketConn, error) {
+	udpAddr, err := net.ResolveUDPAddr("udp", addr.String())
+	if err != nil {
+		return nil, err
+	}
+	switch {
+	case udpAddr.IP == nil || udpAddr.IP.Equal(net.IPv4zero):
+		udpAddr.IP = net.IPv4(127, 0, 0, 1)
+	case udpAddr.IP.IsUnspecified():
+		udpAddr.IP = net.IPv6loopback
+	}
+	return n.bind(udpAddr)
+}
+
+// Dial binds a socket to a free port of the loopback address of addr's family.
+func (n *MemoryNetwork) Dial(addr net.Addr) (net.PacketConn, net.Addr, error) {
+	udpAddr, err := net.ResolveUDPAddr("udp", addr.String())
+	if err != nil {
+		return nil, nil, err
+	}
+
+	local := &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1)}
+	if udpAddr.IP != nil && udpAddr.IP.To4() == nil {
+		local.IP = net.IPv6loopback
+	}
+
+	conn, err := n.bind(local)
+	if err != nil {
+		return nil, nil, err
+	}
+	return conn, udpAddr, nil
+}
+
+func (n *MemoryNetwork) bind(addr *net.UDPAddr) (*memoryConn, error) {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+
+	if addr.Port == 0 {
+		for {
+			candidate := &net.UDPAddr{IP: addr.IP, Port: n.nextPort}
+			n.nextPort++
+			if n.nextPort > memoryLastPort {
+				n.nextPort = memoryFirstPort
+			}
+			if _, ok := n.conns[candidate.String()]; !ok {
+				addr = candidate
+				break
+			}
+		}
+	}
+
+	if _, ok := n.conns[addr.String()]; ok {
+		return nil, fmt.Errorf("address already in use: %s", addr)
+	}
+
+	conn := &memoryConn{
+		inbox:   newInbox(memoryQueueSize),
+		network: n,
+		addr:    addr,
+	}
+	n.conns[addr.String()] = conn
+	return conn, nil
+}
+
+func (n *MemoryNetwork) deliver(b []byte, from, to net.Addr) {
+	n.mu.Lock()
+	conn, ok := n.conns[to.String()]
+	n.mu.Unlock()
+	if ok {
+		conn.deliver(b, from)
+	}
+}
+
+func (n *MemoryNetwork) unbind(addr net.Addr) {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	delete(n.conns, addr.String())
+}
+
+// memoryConn is a socket of a MemoryNetwork.
+type memoryConn struct {
+	*inbox
+	network *MemoryNetwork
+	addr    *net.UDPAddr
+}
+
+func (c *memoryConn) WriteTo(b []byte, addr net.Addr) (int, error) {
+	if c.isClosed() {
+		return 0, net.ErrClosed
+	}
+	c.network.deliver(b, c.addr, addr)
+	return len(b), nil
+}
+
+func (c *memoryConn) Close() error {
+	if c.close() {
+		c.network.unbind(c.addr)
+	}
+	return nil
+}
+
+func (c *memoryConn) LocalAddr() net.Addr {
+	return c.addr
+}
+
+func (c *memoryConn) SetDeadline(t time.Time) error {
+	return c.SetReadDeadline(t)
+}
+
+// SetWriteDeadline does nothing, writes to a memory socket never block.
+func (c *memoryConn) SetWriteDeadline(t time.Time) error {
+	return nil
+}
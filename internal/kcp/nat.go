@@ -0,0 +1,196 @@
+package kcp
+
+import (
+	"net"
+	"sync"
+	"time"
+)
+
+// NATBehavior is how a NAT maps the sockets behind it to public ports and which packets it lets in.
+type NATBehavior int
+
+const (
+	// FullCone maps a socket to a single public port and lets in the packets of any address.
+	FullCone NATBehavior = iota
+	// RestrictedCone maps a socket to a single public port and only lets in the packets of IPs it wrote to.
+	RestrictedCone
+	// PortRestrictedCone maps a socket to a single public port and only lets in the packets of addresses it wrote to.
+	PortRestrictedCone
+	// Symmetric maps a socket to a new public port for every address it writes to, and only lets in
+	// the packets of that address on that port. Hole punching does not get through it.
+	Symmetric
+)
+
+// NAT is a Transport putting its sockets behind a network address translator with a public IP,
+// whose public ports are sockets of another transport. The sockets' own addresses are their public ones,
+// as the peers never see anything else.
+type NAT struct {
+	transport Transport
+	ip        net.IP
+	behavior  NATBehavior
+}
+
+// NewNAT returns a NAT with the given behavior whose public ports are bound to ip on transport.
+func NewNAT(transport Transport, ip net.IP, behavior NATBehavior) *NAT {
+	return &NAT{transport: transport, ip: ip, behavior: behavior}
+}
+
+// Listen returns a socket behind the NAT, the address given is ignored as the NAT picks the public port.
+func (n *NAT) Listen(addr net.Addr) (net.PacketConn, error) {
+	return n.newConn()
+}
+
+// Dial returns a socket behind the NAT along with addr resolved.
+func (n *NAT) Dial(addr net.Addr) (net.PacketConn, net.Addr, error) {
+	udpAddr, err := net.ResolveUDPAddr("udp", addr.String())
+	if err != nil {
+		return nil, nil, err
+	}
+	conn, err := n.newConn()
+	if err != nil {
+		return nil, nil, err
+	}
+	return conn, udpAddr, nil
+}
+
+func (n *NAT) newConn() (*natConn, error) {
+	public, err := n.transport.Listen(&net.UDPAddr{IP: n.ip})
+	if err != nil {
+		return nil, err
+	}
+
+	c := &natConn{
+		inbox:    newInbox(memoryQueueSize),
+		nat:      n,
+		public:   public,
+		mappings: make(map[string]*natMapping),
+	}
+	c.mappings[""] = c.newMapping(public)
+	return c, nil
+}
+
+// natConn is a socket behind a NAT, which reads the packets let in by all its public ports.
+type natConn struct {
+	*inbox
+	nat    *NAT
+	public net.PacketConn // first public port, the only one unless the NAT is symmetric
+
+	mu       sync.Mutex
+	mappings map[string]*natMapping // destination address -> public port, "" for the first one
+}
+
+// natMapping is a public port along with the destinations written to through it.
+type natMapping struct {
+	conn    net.PacketConn
+	mu      sync.Mutex
+	written map[string]struct{} // IPs or addresses, depending on the NAT behavior
+}
+
+func (c *natConn) newMapping(conn net.PacketConn) *natMapping {
+	m := &natMapping{conn: conn, written: make(map[string]struct{})}
+	go c.readLoop(m)
+	return m
+}
+
+// mapping returns the public port packets to addr are sent from, mapping a new one if the NAT is symmetric.
+func (c *natConn) mapping(addr net.Addr) (*natMapping, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if c.nat.behavior != Symmetric {
+		return c.mappings[""], nil
+	}
+	if m, ok := c.mappings[addr.String()]; ok {
+		return m, nil
+	}
+
+	// The first destination takes the first public port
+	if m := c.mappings[""]; len(c.mappings) == 1 {
+		c.mappings[addr.String()] = m
+		return m, nil
+	}
+
+	conn, err := c.nat.transport.Listen(&net.UDPAddr{IP: c.nat.ip})
+	if err != nil {
+		return nil, err
+	}
+	m := c.newMapping(conn)
+	c.mappings[addr.String()] = m
+	return m, nil
+}
+
+// filterKey returns what the NAT remembers of addr to decide which packets to let in.
+func (c *natConn) filterKey(addr net.Addr) string {
+	switch c.nat.behavior {
+	case FullCone:
+		return ""
+	case RestrictedCone:
+		if udpAddr, ok := addr.(*net.UDPAddr); ok {
+			return udpAddr.IP.String()
+		}
+	}
+	return addr.String()
+}
+
+func (c *natConn) readLoop(m *natMapping) {
+	buf := make([]byte, sharedBufferSize)
+	for {
+		n, addr, err := m.conn.ReadFrom(buf)
+		if err != nil {
+			return
+		}
+
+		m.mu.Lock()
+		_, ok := m.written[c.filterKey(addr)]
+		m.mu.Unlock()
+		if ok || c.nat.behavior == FullCone {
+			c.deliver(buf[:n], addr)
+		}
+	}
+}
+
+func (c *natConn) WriteTo(b []byte, addr net.Addr) (int, error) {
+	if c.isClosed() {
+		return 0, net.ErrClosed
+	}
+
+	m, err := c.mapping(addr)
+	if err != nil {
+		return 0, err
+	}
+	m.mu.Lock()
+	m.written[c.filterKey(addr)] = struct{}{}
+	m.mu.Unlock()
+	return m.conn.WriteTo(b, addr)
+}
+
+func (c *natConn) Close() error {
+	if !c.close() {
+		return nil
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	closed := make(map[*natMapping]struct{})
+	for _, m := range c.mappings {
+		if _, ok := closed[m]; !ok {
+			closed[m] = struct{}{}
+			m.conn.Close()
+		}
+	}
+	return nil
+}
+
+// LocalAddr returns the first public address of the socket.
+func (c *natConn) LocalAddr() net.Addr {
+	return c.public.LocalAddr()
+}
+
+func (c *natConn) SetDeadline(t time.Time) error {
+	return c.SetReadDeadline(t)
+}
+
+// SetWriteDeadline does nothing, writes through a NAT never block.
+func (c *natConn) SetWriteDeadline(t time.Time) error {
+	return nil
+}
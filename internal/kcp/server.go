@@ -3,36 +3,47 @@ package kcp
 import (
 	"context"
 	"errors"
+	"log/slog"
 	"net"
 	"sync"
+	"sync/atomic"
 
 	intErrors "github.com/Onyz107/onynet/errors"
-	"github.com/Onyz107/onynet/internal/logger"
 	"github.com/xtaci/kcp-go/v5"
 )
 
 type Server struct {
 	listener *kcp.Listener
+	conn     *packetConn
 	ctx      context.Context
 	done     chan struct{}
 	once     sync.Once
+	logger   atomic.Pointer[slog.Logger]
 }
 
-// NewServer creates a KCP listener for accepting client connections.
-func NewServer(addr net.Addr, ctx context.Context) (*Server, error) {
-	logger.Log.Debugf("kcp/server NewServer: listening on %s", addr.String())
-	listener, err := kcp.ListenWithOptions(addr.String(), nil, 0, 0)
+// NewServer creates a KCP listener on a socket of transport bound to addr for accepting client connections.
+// Only the clients which echoed a cookie bound to their address get a session, see Dial.
+func NewServer(addr net.Addr, transport Transport, ctx context.Context, logger *slog.Logger) (*Server, error) {
+	logger.Debug("kcp server listening", "addr", addr.String())
+	conn, err := transport.Listen(addr)
 	if err != nil {
 		return nil, errors.Join(intErrors.ErrBadAddr, err)
 	}
+	server := &Server{ctx: ctx, done: make(chan struct{}, 1)}
+	server.logger.Store(logger)
+	server.conn = newPacketConn(conn, server.Logger)
+	server.conn.gate = newCookieGate()
 
-	server := &Server{listener: listener, ctx: ctx, done: make(chan struct{}, 1)}
-	logger.Log.Debugf("kcp/server NewServer: created new server on %s", addr.String())
+	server.listener, err = kcp.ServeConn(nil, 0, 0, server.conn)
+	if err != nil {
+		server.conn.Close()
+		return nil, errors.Join(intErrors.ErrBadAddr, err)
+	}
 
 	go func() {
 		select {
 		case <-server.ctx.Done():
-			logger.Log.Debugf("kcp/server NewServer: closing server because of context canceled")
+			server.Logger().Debug("closing kcp server because of context cancellation")
 			server.Close()
 			return
 		case <-server.done:
@@ -45,23 +56,30 @@ func NewServer(addr net.Addr, ctx context.Context) (*Server, error) {
 
 // Accept waits for a new KCP client connection.
 func (s *Server) Accept() (*ClientConn, error) {
-	logger.Log.Debugf("kcp.Server AcceptStream: accepting client connection")
 	conn, err := s.listener.AcceptKCP()
 	if err != nil {
 		return nil, errors.Join(intErrors.ErrAccept, err)
 	}
-	logger.Log.Debug("kcp.Server AcceptStream: accepted client connection")
+	logger := s.Logger().With("remote_addr", conn.RemoteAddr().String())
+	logger.Debug("accepted kcp connection")
 
 	// Performance optimizations
 	conn.SetWindowSize(512, 512)
 	conn.SetNoDelay(1, 40, 2, 1)
 
-	client := &ClientConn{conn: conn, ctx: s.ctx, done: make(chan struct{}, 1)}
+	client := &ClientConn{
+		conn:      conn,
+		pconn:     s.conn,
+		datagrams: s.conn.route(conn.RemoteAddr()),
+		ctx:       s.ctx,
+		done:      make(chan struct{}, 1),
+		logger:    logger,
+	}
 
 	go func() {
 		select {
 		case <-client.ctx.Done():
-			logger.Log.Debug("kcp.Server AcceptStream: closing client connection because of context canceled")
+			logger.Debug("closing kcp connection because of context cancellation")
 			client.Close()
 			return
 		case <-client.done:
@@ -72,8 +90,23 @@ func (s *Server) Accept() (*ClientConn, error) {
 	return client, nil
 }
 
+// Addr returns the address the server is bound to.
+func (s *Server) Addr() net.Addr {
+	return s.conn.LocalAddr()
+}
+
+// Logger returns the logger of the server.
+func (s *Server) Logger() *slog.Logger {
+	return s.logger.Load()
+}
+
+// SetLogger replaces the logger of the server, connections already accepted keep theirs.
+func (s *Server) SetLogger(logger *slog.Logger) {
+	s.logger.Store(logger)
+}
+
 func (s *Server) Close() error {
 	s.once.Do(func() { close(s.done) })
-	logger.Log.Debugf("kcp.Server Close: closing server connection")
-	return s.listener.Close()
+	s.Logger().Debug("closing kcp server")
+	return errors.Join(s.listener.Close(), s.conn.Close())
 }
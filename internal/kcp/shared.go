@@ -0,0 +1,264 @@
+package kcp
+
+import (
+	"bytes"
+	"encoding/binary"
+	"net"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// SharedSocket lets several KCP servers and clients use one socket, much like SO_REUSEPORT.
+// It is a Transport: Listen returns a socket serving the peers assigned to it and Dial returns a socket
+// talking to a single peer. Packets are routed by the address they come from:
+//   - to a dialed socket talking to that address, if the packet belongs to its KCP conversation,
+//     so a server and a client on the same socket can both talk to the same peer,
+//   - otherwise to the listening socket the address was assigned to, new addresses being assigned
+//     to the listening sockets in turn.
+//
+// Datagrams belong to no conversation, the ones coming from a dialed address go to the dialed socket,
+// and the answers to cookie packets go to every socket dialing the address, since cookies are bound to the address alone.
+// Addresses silent for sharedPeerTimeout are forgotten, the heartbeat keeps live connections from going silent.
+type SharedSocket struct {
+	conn net.PacketConn
+
+	mu        sync.Mutex
+	listeners []*sharedConn
+	next      int                      // index of the listening socket the next new address is assigned to
+	dialers   map[string][]*sharedConn // dialed address -> sockets
+	peers     map[string]*sharedPeer   // address -> assigned listening socket
+	lastSweep time.Time
+	closed    bool
+}
+
+type sharedPeer struct {
+	conn     *sharedConn
+	lastSeen time.Time
+}
+
+// NewSharedSocket starts routing the packets read from conn, which is closed along with the shared socket.
+func NewSharedSocket(conn net.PacketConn) *SharedSocket {
+	s := &SharedSocket{
+		conn:      conn,
+		dialers:   make(map[string][]*sharedConn),
+		peers:     make(map[string]*sharedPeer),
+		lastSweep: time.Now(),
+	}
+	go s.readLoop()
+	return s
+}
+
+// Listen returns a socket sharing the shared socket's address, the address given is ignored.
+func (s *SharedSocket) Listen(addr net.Addr) (net.PacketConn, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.closed {
+		return nil, net.ErrClosed
+	}
+
+	conn := s.newConn("")
+	s.listeners = append(s.listeners, conn)
+	return conn, nil
+}
+
+// Dial returns a socket sharing the shared socket's address which receives the packets of addr.
+func (s *SharedSocket) Dial(addr net.Addr) (net.PacketConn, net.Addr, error) {
+	udpAddr, err := net.ResolveUDPAddr("udp", addr.String())
+	if err != nil {
+		return nil, nil, err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.closed {
+		return nil, nil, net.ErrClosed
+	}
+
+	conn := s.newConn(udpAddr.String())
+	s.dialers[conn.remote] = append(s.dialers[conn.remote], conn)
+	return conn, udpAddr, nil
+}
+
+// LocalAddr returns the address of the underlying socket.
+func (s *SharedSocket) LocalAddr() net.Addr {
+	return s.conn.LocalAddr()
+}
+
+// WriteTo sends b to addr as is from the underlying socket, for example to open a NAT mapping towards a peer.
+// Packets shorter than a KCP header are ignored by the KCP sessions receiving them.
+func (s *SharedSocket) WriteTo(b []byte, addr net.Addr) (int, error) {
+	return s.conn.WriteTo(b, addr)
+}
+
+// Close closes the underlying socket and every socket sharing it.
+func (s *SharedSocket) Close() error {
+	s.mu.Lock()
+	s.closed = true
+	conns := append([]*sharedConn(nil), s.listeners...)
+	for _, dialers := range s.dialers {
+		conns = append(conns, dialers...)
+	}
+	s.listeners, s.dialers, s.peers = nil, nil, nil
+	s.mu.Unlock()
+
+	for _, conn := range conns {
+		conn.close()
+	}
+	return s.conn.Close()
+}
+
+func (s *SharedSocket) newConn(remote string) *sharedConn {
+	return &sharedConn{inbox: newInbox(sharedQueueSize), socket: s, remote: remote}
+}
+
+func (s *SharedSocket) readLoop() {
+	buf := make([]byte, sharedBufferSize)
+	for {
+		n, addr, err := s.conn.ReadFrom(buf)
+		if err != nil {
+			s.Close()
+			return
+		}
+		if isCookieAnswer(buf[:n]) {
+			for _, conn := range s.dialed(addr) {
+				conn.deliver(buf[:n], addr)
+			}
+			continue
+		}
+		if conn := s.route(buf[:n], addr); conn != nil {
+			conn.deliver(buf[:n], addr)
+		}
+	}
+}
+
+// route returns the socket a packet coming from addr belongs to, or nil if no socket takes it.
+func (s *SharedSocket) route(packet []byte, addr net.Addr) *sharedConn {
+	key := addr.String()
+	now := time.Now()
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for _, conn := range s.dialers[key] {
+		if conn.owns(packet) {
+			return conn
+		}
+	}
+
+	if now.Sub(s.lastSweep) > sharedPeerTimeout {
+		for peerKey, peer := range s.peers {
+			if now.Sub(peer.lastSeen) > sharedPeerTimeout {
+				delete(s.peers, peerKey)
+			}
+		}
+		s.lastSweep = now
+	}
+
+	peer, ok := s.peers[key]
+	if !ok {
+		if len(s.listeners) == 0 {
+			return nil
+		}
+		peer = &sharedPeer{conn: s.listeners[s.next%len(s.listeners)]}
+		s.next++
+		s.peers[key] = peer
+	}
+	peer.lastSeen = now
+	return peer.conn
+}
+
+// dialed returns the sockets dialing addr.
+func (s *SharedSocket) dialed(addr net.Addr) []*sharedConn {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return append([]*sharedConn(nil), s.dialers[addr.String()]...)
+}
+
+// remove stops routing packets to conn.
+func (s *SharedSocket) remove(conn *sharedConn) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.closed {
+		return
+	}
+
+	if conn.remote != "" {
+		dialers := without(s.dialers[conn.remote], conn)
+		if len(dialers) == 0 {
+			delete(s.dialers, conn.remote)
+		} else {
+			s.dialers[conn.remote] = dialers
+		}
+		return
+	}
+
+	s.listeners = without(s.listeners, conn)
+	for key, peer := range s.peers {
+		if peer.conn == conn {
+			delete(s.peers, key)
+		}
+	}
+}
+
+func without(conns []*sharedConn, conn *sharedConn) []*sharedConn {
+	kept := conns[:0:0]
+	for _, c := range conns {
+		if c != conn {
+			kept = append(kept, c)
+		}
+	}
+	return kept
+}
+
+// sharedConn is a socket of a SharedSocket, its writes go straight to the underlying socket.
+type sharedConn struct {
+	*inbox
+	socket *SharedSocket
+	remote string        // dialed address, empty for listening sockets
+	conv   atomic.Uint64 // KCP conversation of a dialed socket, with convKnown set once it is written
+}
+
+// convKnown marks the conversation of a sharedConn as known.
+const convKnown = 1 << 32
+
+// owns reports whether a packet coming from the dialed address belongs to the socket.
+func (c *sharedConn) owns(packet []byte) bool {
+	if bytes.HasPrefix(packet, datagramHeader) {
+		return true
+	}
+	// The peer only answers a conversation after the socket wrote to it
+	conv := c.conv.Load()
+	return conv&convKnown != 0 && len(packet) >= 4 && binary.LittleEndian.Uint32(packet) == uint32(conv)
+}
+
+func (c *sharedConn) WriteTo(b []byte, addr net.Addr) (int, error) {
+	if c.isClosed() {
+		return 0, net.ErrClosed
+	}
+	if c.remote != "" && len(b) >= 4 && !bytes.HasPrefix(b, datagramHeader) && !isCookiePacket(b) && c.conv.Load()&convKnown == 0 {
+		c.conv.Store(convKnown | uint64(binary.LittleEndian.Uint32(b)))
+	}
+	return c.socket.conn.WriteTo(b, addr)
+}
+
+// Close stops the socket from receiving packets, the underlying socket is left open.
+func (c *sharedConn) Close() error {
+	if c.close() {
+		c.socket.remove(c)
+	}
+	return nil
+}
+
+func (c *sharedConn) LocalAddr() net.Addr {
+	return c.socket.conn.LocalAddr()
+}
+
+func (c *sharedConn) SetDeadline(t time.Time) error {
+	return c.SetReadDeadline(t)
+}
+
+// SetWriteDeadline does nothing, the write deadline of the underlying socket is shared by every socket.
+func (c *sharedConn) SetWriteDeadline(t time.Time) error {
+	return nil
+}
@@ -0,0 +1,65 @@
+package kcp
+
+import "net"
+
+// Transport creates the packet sockets KCP sessions run on.
+type Transport interface {
+	// Listen returns a socket bound to addr which receives the packets of every client.
+	Listen(addr net.Addr) (net.PacketConn, error)
+	// Dial returns a socket to reach addr from, along with addr resolved for that socket.
+	Dial(addr net.Addr) (net.PacketConn, net.Addr, error)
+}
+
+// UDP is the Transport of real UDP sockets, it is the default.
+var UDP Transport = udpTransport{}
+
+type udpTransport struct{}
+
+func (udpTransport) Listen(addr net.Addr) (net.PacketConn, error) {
+	udpAddr, err := net.ResolveUDPAddr("udp", addr.String())
+	if err != nil {
+		return nil, err
+	}
+	return net.ListenUDP("udp", udpAddr)
+}
+
+func (udpTransport) Dial(addr net.Addr) (net.PacketConn, net.Addr, error) {
+	udpAddr, err := net.ResolveUDPAddr("udp", addr.String())
+	if err != nil {
+		return nil, nil, err
+	}
+
+	network := "udp4"
+	if udpAddr.IP.To4() == nil {
+		network = "udp"
+	}
+
+	conn, err := net.ListenUDP(network, nil)
+	if err != nil {
+		return nil, nil, err
+	}
+	return conn, udpAddr, nil
+}
+
+// PacketConnTransport returns a Transport whose Listen and Dial both return conn, the address given to Listen
+// is ignored since conn is already bound. It lets KCP run on a socket created elsewhere, which is closed along
+// with the server or client using it.
+func PacketConnTransport(conn net.PacketConn) Transport {
+	return connTransport{conn: conn}
+}
+
+type connTransport struct {
+	conn net.PacketConn
+}
+
+func (t connTransport) Listen(addr net.Addr) (net.PacketConn, error) {
+	return t.conn, nil
+}
+
+func (t connTransport) Dial(addr net.Addr) (net.PacketConn, net.Addr, error) {
+	udpAddr, err := net.ResolveUDPAddr("udp", addr.String())
+	if err != nil {
+		return nil, nil, err
+	}
+	return t.conn, udpAddr, nil
+}
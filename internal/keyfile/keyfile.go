@@ -0,0 +1,73 @@
+// Package keyfile loads the RSA keys of the onynet commands from PEM files.
+package keyfile
+
+import (
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"os"
+
+	intErrors "github.com/Onyz107/onynet/errors"
+)
+
+// LoadPrivateKey reads a PKCS #1 or PKCS #8 RSA private key, such as one created by "openssl genrsa".
+//
+// Possible errors:
+//   - ErrPrivateKey: the file cannot be read or holds no RSA private key
+func LoadPrivateKey(path string) (*rsa.PrivateKey, error) {
+	block, err := readPEM(path)
+	if err != nil {
+		return nil, errors.Join(intErrors.ErrPrivateKey, err)
+	}
+
+	if key, err := x509.ParsePKCS1PrivateKey(block.Bytes); err == nil {
+		return key, nil
+	}
+	key, err := x509.ParsePKCS8PrivateKey(block.Bytes)
+	if err != nil {
+		return nil, errors.Join(intErrors.ErrPrivateKey, err)
+	}
+	rsaKey, ok := key.(*rsa.PrivateKey)
+	if !ok {
+		return nil, errors.Join(intErrors.ErrPrivateKey, fmt.Errorf("not an RSA key: %T", key))
+	}
+	return rsaKey, nil
+}
+
+// LoadPublicKey reads a PKIX or PKCS #1 RSA public key, such as one created by "openssl rsa -pubout".
+//
+// Possible errors:
+//   - ErrPublickey: the file cannot be read or holds no RSA public key
+func LoadPublicKey(path string) (*rsa.PublicKey, error) {
+	block, err := readPEM(path)
+	if err != nil {
+		return nil, errors.Join(intErrors.ErrPublickey, err)
+	}
+
+	if key, err := x509.ParsePKCS1PublicKey(block.Bytes); err == nil {
+		return key, nil
+	}
+	key, err := x509.ParsePKIXPublicKey(block.Bytes)
+	if err != nil {
+		return nil, errors.Join(intErrors.ErrPublickey, err)
+	}
+	rsaKey, ok := key.(*rsa.PublicKey)
+	if !ok {
+		return nil, errors.Join(intErrors.ErrPublickey, fmt.Errorf("not an RSA key: %T", key))
+	}
+	return rsaKey, nil
+}
+
+func readPEM(path string) (*pem.Block, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	block, _ := pem.Decode(data)
+	if block == nil {
+		return nil, fmt.Errorf("no PEM data in %s", path)
+	}
+	return block, nil
+}
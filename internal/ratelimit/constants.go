@@ -0,0 +1,23 @@
+package ratelimit
+
+// Limit defines a token bucket: BytesPerSecond is the sustained rate and Burst the
+// number of bytes that can be transferred at once after being idle.
+// A BytesPerSecond lower or equal to 0 means unlimited, a Burst lower or equal to 0 means one second worth of bytes.
+type Limit struct {
+	BytesPerSecond int
+	Burst          int
+}
+
+// Unlimited is a Limit which never throttles.
+var Unlimited = Limit{}
+
+func (l Limit) unlimited() bool {
+	return l.BytesPerSecond <= 0
+}
+
+func (l Limit) burst() float64 {
+	if l.Burst <= 0 {
+		return float64(l.BytesPerSecond)
+	}
+	return float64(l.Burst)
+}
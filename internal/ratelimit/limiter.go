@@ -0,0 +1,212 @@
+package ratelimit
+
+import (
+	"io"
+	"math"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/xtaci/smux"
+)
+
+// Limiter is a token bucket whose limit can be changed at any time.
+type Limiter struct {
+	mu        sync.Mutex
+	limit     Limit
+	tokens    float64
+	last      time.Time
+	throttled atomic.Int64
+}
+
+// NewLimiter returns a Limiter with a full bucket.
+func NewLimiter(limit Limit) *Limiter {
+	return &Limiter{limit: limit, tokens: limit.burst(), last: time.Now()}
+}
+
+// SetLimit changes the limit, keeping the tokens already accumulated up to the new burst.
+func (l *Limiter) SetLimit(limit Limit) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	l.refillLocked(time.Now())
+	l.limit = limit
+	l.tokens = min(l.tokens, limit.burst())
+}
+
+// Limit returns the current limit.
+func (l *Limiter) Limit() Limit {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return l.limit
+}
+
+// Throttled returns the total time transfers were delayed by this limiter.
+func (l *Limiter) Throttled() time.Duration {
+	return time.Duration(l.throttled.Load())
+}
+
+// Allow takes n tokens if they are available right now, it never goes into debt.
+func (l *Limiter) Allow(n int) bool {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if l.limit.unlimited() {
+		return true
+	}
+
+	l.refillLocked(time.Now())
+	if l.tokens < float64(n) {
+		return false
+	}
+	l.tokens -= float64(n)
+	return true
+}
+
+// reserve takes n tokens, going into debt if needed, and returns how long the caller must wait
+// before transferring them.
+func (l *Limiter) reserve(n int) time.Duration {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if l.limit.unlimited() {
+		return 0
+	}
+
+	l.refillLocked(time.Now())
+	l.tokens -= float64(n)
+	if l.tokens >= 0 {
+		return 0
+	}
+
+	delay := time.Duration(-l.tokens / float64(l.limit.BytesPerSecond) * float64(time.Second))
+	l.throttled.Add(int64(delay))
+	return delay
+}
+
+// refund gives back n tokens taken by a reservation whose transfer was given up.
+func (l *Limiter) refund(n int) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if l.limit.unlimited() {
+		return
+	}
+
+	l.refillLocked(time.Now())
+	l.tokens = min(l.tokens+float64(n), l.limit.burst())
+}
+
+func (l *Limiter) refillLocked(now time.Time) {
+	elapsed := now.Sub(l.last)
+	l.last = now
+	if l.limit.unlimited() {
+		// A full bucket for whichever limit is set next.
+		l.tokens = math.Inf(1)
+		return
+	}
+	l.tokens = min(l.tokens+elapsed.Seconds()*float64(l.limit.BytesPerSecond), l.limit.burst())
+}
+
+// Wait takes n tokens from every limiter and waits for the slowest one.
+// It gives up when die is closed or when deadline passes, giving the tokens back to every limiter.
+func Wait(n int, die <-chan struct{}, deadline time.Time, limiters ...*Limiter) error {
+	var delay time.Duration
+	for _, l := range limiters {
+		if l == nil {
+			continue
+		}
+		delay = max(delay, l.reserve(n))
+	}
+	if delay <= 0 {
+		return nil
+	}
+
+	timer := time.NewTimer(delay)
+	defer timer.Stop()
+
+	var timeout <-chan time.Time
+	if !deadline.IsZero() {
+		if time.Until(deadline) < delay {
+			deadlineTimer := time.NewTimer(time.Until(deadline))
+			defer deadlineTimer.Stop()
+			timeout = deadlineTimer.C
+		}
+	}
+
+	var err error
+	select {
+	case <-timer.C:
+		return nil
+	case <-die:
+		err = io.ErrClosedPipe
+	case <-timeout:
+		err = smux.ErrTimeout
+	}
+
+	for _, l := range limiters {
+		if l != nil {
+			l.refund(n)
+		}
+	}
+	return err
+}
+
+// Pair holds the limiters of both directions of a transfer.
+type Pair struct {
+	Send    *Limiter
+	Receive *Limiter
+}
+
+// NewPair returns a Pair whose directions are both limited by limit.
+func NewPair(limit Limit) *Pair {
+	return &Pair{Send: NewLimiter(limit), Receive: NewLimiter(limit)}
+}
+
+// SetLimit changes the limit of both directions.
+func (p *Pair) SetLimit(limit Limit) {
+	p.Send.SetLimit(limit)
+	p.Receive.SetLimit(limit)
+}
+
+// Limit returns the current limit.
+func (p *Pair) Limit() Limit {
+	return p.Send.Limit()
+}
+
+// Throttled returns the total time transfers in both directions were delayed.
+func (p *Pair) Throttled() time.Duration {
+	return p.Send.Throttled() + p.Receive.Throttled()
+}
+
+// Rules holds the limits applied to streams by name.
+type Rules struct {
+	mu     sync.RWMutex
+	limits map[string]Limit
+}
+
+// NewRules returns an empty Rules.
+func NewRules() *Rules {
+	return &Rules{limits: make(map[string]Limit)}
+}
+
+// Set sets the limit applied to streams with the given name, Unlimited removes it.
+func (r *Rules) Set(name string, limit Limit) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if limit.unlimited() {
+		delete(r.limits, name)
+		return
+	}
+	r.limits[name] = limit
+}
+
+// Get returns the limit applied to streams with the given name.
+func (r *Rules) Get(name string) Limit {
+	if r == nil {
+		return Unlimited
+	}
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	return r.limits[name]
+}
@@ -0,0 +1,72 @@
+package ratelimit_test
+
+import (
+	"errors"
+	"io"
+	"testing"
+	"time"
+
+	"github.com/Onyz107/onynet/internal/ratelimit"
+)
+
+func TestWait(t *testing.T) {
+	limiter := ratelimit.NewLimiter(ratelimit.Limit{BytesPerSecond: 100 * 1024, Burst: 10 * 1024})
+
+	start := time.Now()
+	for range 6 {
+		if err := ratelimit.Wait(10*1024, nil, time.Time{}, limiter); err != nil {
+			t.Fatal(err)
+		}
+	}
+	elapsed := time.Since(start)
+
+	// The first chunk uses the burst, the five others are paced at 100 KiB/s.
+	if elapsed < 400*time.Millisecond || elapsed > 1500*time.Millisecond {
+		t.Fatalf("expected transfer to take about 500ms, took %s", elapsed)
+	}
+	if limiter.Throttled() == 0 {
+		t.Fatal("expected throttled time to be recorded")
+	}
+}
+
+func TestSetLimit(t *testing.T) {
+	limiter := ratelimit.NewLimiter(ratelimit.Limit{BytesPerSecond: 1024})
+	limiter.SetLimit(ratelimit.Unlimited)
+
+	start := time.Now()
+	if err := ratelimit.Wait(1024*1024, nil, time.Time{}, limiter); err != nil {
+		t.Fatal(err)
+	}
+	if elapsed := time.Since(start); elapsed > 100*time.Millisecond {
+		t.Fatalf("expected unlimited transfer not to wait, waited %s", elapsed)
+	}
+}
+
+func TestWaitSlowest(t *testing.T) {
+	fast := ratelimit.NewLimiter(ratelimit.Limit{BytesPerSecond: 1024 * 1024})
+	slow := ratelimit.NewLimiter(ratelimit.Limit{BytesPerSecond: 1024, Burst: 1})
+
+	die := make(chan struct{})
+	close(die)
+
+	if err := ratelimit.Wait(1024, die, time.Time{}, fast, slow); !errors.Is(err, io.ErrClosedPipe) {
+		t.Fatalf("expected io.ErrClosedPipe, got: %v", err)
+	}
+	if slow.Throttled() < 900*time.Millisecond {
+		t.Fatalf("expected the slowest limiter to be waited for, throttled %s", slow.Throttled())
+	}
+}
+
+func TestWaitRefund(t *testing.T) {
+	limiter := ratelimit.NewLimiter(ratelimit.Limit{BytesPerSecond: 1024, Burst: 1024})
+
+	die := make(chan struct{})
+	close(die)
+
+	if err := ratelimit.Wait(10*1024, die, time.Time{}, limiter); !errors.Is(err, io.ErrClosedPipe) {
+		t.Fatalf("expected io.ErrClosedPipe, got: %v", err)
+	}
+	if !limiter.Allow(1024) {
+		t.Fatal("expected the tokens of the aborted wait to be given back")
+	}
+}
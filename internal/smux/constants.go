@@ -6,8 +6,62 @@ import (
 	"net"
 	"sync"
 	"time"
+
+	"github.com/xtaci/smux"
+)
+
+// Priority defines how a stream's writes are scheduled against the other streams of the session.
+// Lower values are written first.
+type Priority uint8
+
+const (
+	// PriorityControl is for control messages that must never wait behind data.
+	PriorityControl Priority = iota
+	// PriorityInteractive is for latency sensitive traffic such as chat or game input.
+	PriorityInteractive
+	// PriorityNormal is the default priority.
+	PriorityNormal
+	// PriorityBulk is for large transfers which should only use otherwise idle capacity.
+	PriorityBulk
+
+	numPriorities
 )
 
+// clampPriority returns priority, or PriorityBulk if it is not a valid priority.
+func clampPriority(priority Priority) Priority {
+	if priority >= numPriorities {
+		return PriorityBulk
+	}
+	return priority
+}
+
+// Config returns the smux configuration used by both ends of a session.
+// Version 2 gives every stream its own flow control window, which keeps a bulk stream
+// from filling the transport's send queue ahead of higher priority streams.
+func Config() *smux.Config {
+	config := smux.DefaultConfig()
+	config.Version = 2
+	return config
+}
+
+// writeQuantum is the largest chunk a stream writes before giving the session to a higher priority stream.
+const writeQuantum = 8 * 1024
+
+// writeStallTimeout is how long a chunk's write may block before the stream gives up its turn to the others.
+const writeStallTimeout = 10 * time.Millisecond
+
+// streamHeaderTimeout bounds the read of the header a stream is opened with.
+const streamHeaderTimeout = 10 * time.Second
+
+// pendingStreamTimeout is how long a stream nobody accepts yet waits for an AcceptStream call before being refused.
+const pendingStreamTimeout = time.Second
+
+// maxPendingStreams is the most streams a session holds while they wait for an AcceptStream call.
+const maxPendingStreams = 64
+
+// drainPollInterval is how often Drain checks whether the streams of a session are closed.
+const drainPollInterval = 50 * time.Millisecond
+
 type Handler interface {
 	OpenStream(name string, ctx context.Context, timeout time.Duration) (*Stream, error)
 	AcceptStream(name string, ctx context.Context, timeout time.Duration) (*Stream, error)
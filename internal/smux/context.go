@@ -0,0 +1,27 @@
+package smux
+
+import "context"
+
+// streamContext is the context of a stream, it is cancelled when either the context given when
+// opening or accepting the stream or the session's context is done. Values are looked up in the
+// former first, then in the latter, so streams carry the values attached to their connection.
+type streamContext struct {
+	context.Context
+	session context.Context
+}
+
+func (c *streamContext) Value(key any) any {
+	if v := c.Context.Value(key); v != nil {
+		return v
+	}
+	return c.session.Value(key)
+}
+
+func mergeContext(ctx, session context.Context) (context.Context, context.CancelFunc) {
+	inner, cancel := context.WithCancel(ctx)
+	stop := context.AfterFunc(session, cancel)
+	return &streamContext{Context: inner, session: session}, func() {
+		stop()
+		cancel()
+	}
+}
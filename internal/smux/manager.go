@@ -5,27 +5,62 @@ import (
 	"encoding/binary"
 	"errors"
 	"io"
+	"log/slog"
+	"sync/atomic"
 	"time"
 
 	intErrors "github.com/Onyz107/onynet/errors"
-	"github.com/Onyz107/onynet/internal/logger"
+	"github.com/Onyz107/onynet/internal/ratelimit"
+	"github.com/Onyz107/onynet/metrics"
+	"github.com/Onyz107/onynet/tracing"
 	"github.com/xtaci/smux"
 )
 
 type Manager struct {
-	session *smux.Session
-	aesKey  []byte
-	ctx     context.Context
+	session   *smux.Session
+	aesKey    []byte
+	ctx       context.Context
+	scheduler *scheduler
+	router    *router
+
+	limits   *ratelimit.Pair
+	parents  []*ratelimit.Pair
+	rules    *ratelimit.Rules
+	internal map[string]bool
+
+	onOpened     func(*Stream)
+	onClosed     func(*Stream)
+	lastActivity atomic.Int64 // unix nanoseconds
+
+	streams atomic.Int64 // open streams other than the internal ones
+	closing atomic.Bool
+
+	metrics *metrics.Metrics
+	side    string
+	tracer  tracing.Tracer
+	logger  *slog.Logger
 }
 
 // NewManager wraps a smux session with AES key and context.
-func NewManager(session *smux.Session, aesKey []byte, ctx context.Context) *Manager {
-	manager := &Manager{session: session, aesKey: aesKey, ctx: ctx}
+// Streams log through logger with their name as the "stream" attribute.
+func NewManager(session *smux.Session, aesKey []byte, ctx context.Context, logger *slog.Logger) *Manager {
+	manager := &Manager{
+		session:   session,
+		aesKey:    aesKey,
+		ctx:       ctx,
+		logger:    logger,
+		scheduler: &scheduler{},
+		router:    newRouter(),
+		limits:    ratelimit.NewPair(ratelimit.Unlimited),
+		tracer:    tracing.Noop,
+	}
+	manager.lastActivity.Store(time.Now().UnixNano())
+	go manager.acceptLoop()
 
 	go func() {
 		select {
 		case <-manager.ctx.Done():
-			logger.Log.Debug("closing smux session because of context cancellation")
+			manager.logger.Debug("closing smux session because of context cancellation")
 			manager.Close()
 			return
 		case <-manager.CloseChan():
@@ -36,133 +71,82 @@ func NewManager(session *smux.Session, aesKey []byte, ctx context.Context) *Mana
 	return manager
 }
 
-// AcceptStream waits for a stream with a given name.
+// AcceptStream waits for a stream with a given name, a timeout of 0 waits until ctx is done.
+// The streams of a session are routed by name, so concurrent calls waiting for different names
+// never take each other's streams.
 func (m *Manager) AcceptStream(name string, ctx context.Context, timeout time.Duration) (*Stream, error) {
 	if len(name) > 0xFFFF {
 		return nil, intErrors.ErrNameTooLong
 	}
-
-	logger.Log.Debugf("smux/manager AcceptStream: timeout is: %f", timeout.Seconds())
-
-	start := time.Now()
-	for {
-		time.Sleep(50 * time.Millisecond)
-		select {
-
-		case <-ctx.Done():
-			return nil, intErrors.ErrCtxCancelled
-
-		default:
-			if timeout > 0 && time.Since(start) >= timeout {
-				return nil, intErrors.ErrTimeout
-			}
-			stream, err := m.accept(name, ctx, timeout/3)
-			if err != nil {
-				if errors.Is(err, smux.ErrTimeout) || errors.Is(err, intErrors.ErrNameMismatch) {
-					logger.Log.Debugf("smux/manager AcceptStream: got non crticial error: %v continuing", err)
-					continue
-				}
-				return nil, err
-			}
-			return stream, nil
-		}
-	}
-}
-
-func (m *Manager) accept(name string, ctx context.Context, timeout time.Duration) (*Stream, error) {
-	if timeout > 0 {
-		logger.Log.Debugf("smux/manager accept: session timeout is: %f", timeout.Seconds())
-		m.session.SetDeadline(time.Now().Add(timeout))
-		defer m.session.SetDeadline(time.Time{})
+	if m.closing.Load() && !m.internal[name] {
+		return nil, intErrors.ErrConnClosing
 	}
 
-	stream, err := m.session.AcceptStream()
+	in, err := m.router.wait(name, ctx, timeout)
 	if err != nil {
-		return nil, errors.Join(intErrors.ErrAcceptStream, err)
+		return nil, err
 	}
+	return m.answer(in, ctx)
+}
 
-	if timeout > 0 {
-		logger.Log.Debugf("smux/manager accept: stream timeout is: %f", timeout.Seconds())
-		stream.SetDeadline(time.Now().Add(timeout))
-	}
-
-	headerPtr := headerPool.Get().(*[]byte)
-	defer headerPool.Put(headerPtr)
-	header := *headerPtr
-
-	logger.Log.Debugf("smux/manager accept: reading header")
+// readCarrier reads the trace context of the stream open header, prefixed by its length.
+func readCarrier(stream *smux.Stream, header []byte) (tracing.Carrier, error) {
 	if _, err := io.ReadFull(stream, header); err != nil {
-		stream.Close()
 		return nil, errors.Join(intErrors.ErrRead, err)
 	}
-	length := binary.BigEndian.Uint16(header)
-	logger.Log.Debugf("smux/manager accept: read header and found length: %d", length)
-
-	logger.Log.Debugf("smux/manager accept: reading name")
-	buf := make([]byte, length)
-	if _, err := io.ReadFull(stream, buf); err != nil {
-		stream.Close()
+	trace := make([]byte, binary.BigEndian.Uint16(header))
+	if _, err := io.ReadFull(stream, trace); err != nil {
 		return nil, errors.Join(intErrors.ErrRead, err)
 	}
-	logger.Log.Debugf("smux/manager accept: read name as: %s", string(buf))
 
-	if string(buf) != name {
-		logger.Log.Debugf("smux/manager accept: name mismatch")
-		logger.Log.Debugf("smux/manager accept: writing no ok")
-		stream.Write([]byte{0})
-		stream.Close()
-		return nil, intErrors.ErrNameMismatch
-	}
-
-	logger.Log.Debugf("smux/manager accept: writing ok")
-	if _, err := stream.Write([]byte{1}); err != nil {
-		stream.Close()
-		return nil, errors.Join(intErrors.ErrWrite, err)
+	carrier := tracing.Carrier{}
+	if err := carrier.UnmarshalBinary(trace); err != nil {
+		return nil, errors.Join(intErrors.ErrRead, err)
 	}
-	logger.Log.Debugf("smux/manager accept: wrote ok")
-
-	stream.SetDeadline(time.Time{})
-
-	wrapped := &Stream{stream: stream, aesKey: m.aesKey, ctx: ctx}
-	go func() {
-		select {
-		case <-wrapped.ctx.Done():
-			logger.Log.Debug("closing smux stream because of context cancellation")
-			wrapped.Close()
-			return
-		case <-wrapped.stream.GetDieCh():
-			return
-		}
-	}()
-
-	return wrapped, nil
+	return carrier, nil
 }
 
-// OpenStream creates a new stream with a given name.
+// OpenStream creates a new stream with a given name and PriorityNormal.
 func (m *Manager) OpenStream(name string, ctx context.Context, timeout time.Duration) (*Stream, error) {
+	return m.OpenStreamWithPriority(name, PriorityNormal, ctx, timeout)
+}
+
+// OpenStreamWithPriority creates a new stream with a given name and priority.
+// The priority applies to the writes of both ends of the stream, an invalid priority is replaced by PriorityBulk.
+func (m *Manager) OpenStreamWithPriority(name string, priority Priority, ctx context.Context, timeout time.Duration) (*Stream, error) {
+	priority = clampPriority(priority)
 	if len(name) > 0xFFFF {
 		return nil, intErrors.ErrNameTooLong
 	}
+	if m.closing.Load() && !m.internal[name] {
+		return nil, intErrors.ErrConnClosing
+	}
+
+	tracer := m.tracerFor(name)
+	ctx, span := tracer.Start(ctx, "onynet.OpenStream", tracing.String("onynet.stream", name), tracing.Int("onynet.priority", int(priority)))
+	defer span.End()
 
-	logger.Log.Debugf("smux/manager OpenStream: timeout is: %f", timeout.Seconds())
 	start := time.Now()
 	for {
 		time.Sleep(50 * time.Millisecond)
 		select {
 
 		case <-ctx.Done():
+			span.RecordError(intErrors.ErrCtxCancelled)
 			return nil, intErrors.ErrCtxCancelled
 
 		default:
 			if timeout > 0 && time.Since(start) >= timeout {
+				span.RecordError(intErrors.ErrTimeout)
 				return nil, intErrors.ErrTimeout
 			}
-			stream, err := m.open(name, ctx, timeout/3)
+			stream, err := m.open(name, priority, ctx, timeout/3)
 			if err != nil {
 				if errors.Is(err, smux.ErrTimeout) || errors.Is(err, intErrors.ErrNameMismatch) {
-					logger.Log.Debugf("smux/manager OpenStream: got noncrticial error: %v continuing", err)
+					m.logger.Debug("retrying stream open", "stream", name, "error", err, "error_kind", intErrors.Kind(err))
 					continue
 				}
+				span.RecordError(err)
 				return nil, err
 			}
 			return stream, nil
@@ -170,9 +154,8 @@ func (m *Manager) OpenStream(name string, ctx context.Context, timeout time.Dura
 	}
 }
 
-func (m *Manager) open(name string, ctx context.Context, timeout time.Duration) (*Stream, error) {
+func (m *Manager) open(name string, priority Priority, ctx context.Context, timeout time.Duration) (*Stream, error) {
 	if timeout > 0 {
-		logger.Log.Debugf("smux/manager open: session timeout is: %f", timeout.Seconds())
 		m.session.SetDeadline(time.Now().Add(timeout))
 		defer m.session.SetDeadline(time.Time{})
 	}
@@ -183,7 +166,6 @@ func (m *Manager) open(name string, ctx context.Context, timeout time.Duration)
 	}
 
 	if timeout > 0 {
-		logger.Log.Debugf("smux/manager open: stream timeout is: %f", timeout.Seconds())
 		stream.SetDeadline(time.Now().Add(timeout))
 	}
 
@@ -192,10 +174,8 @@ func (m *Manager) open(name string, ctx context.Context, timeout time.Duration)
 	header := *headerPtr
 
 	length := uint16(len(name))
-	logger.Log.Debugf("smux/manager open: found length: %d", length)
 	binary.BigEndian.PutUint16(header, length)
 
-	logger.Log.Debugf("smux/manager open: writing header")
 	n, err := stream.Write(header)
 	if err != nil {
 		stream.Close()
@@ -205,9 +185,7 @@ func (m *Manager) open(name string, ctx context.Context, timeout time.Duration)
 		stream.Close()
 		return nil, intErrors.ErrShortWrite
 	}
-	logger.Log.Debugf("smux/manager open: wrote header")
 
-	logger.Log.Debugf("smux/manager open: writing: %s", name)
 	n, err = stream.Write([]byte(name))
 	if err != nil {
 		stream.Close()
@@ -217,9 +195,24 @@ func (m *Manager) open(name string, ctx context.Context, timeout time.Duration)
 		stream.Close()
 		return nil, intErrors.ErrShortWrite
 	}
-	logger.Log.Debugf("smux/manager open: wrote: %s", name)
 
-	logger.Log.Debug("smux/manager open: reading ok/no ok")
+	if _, err := stream.Write([]byte{byte(priority)}); err != nil {
+		stream.Close()
+		return nil, errors.Join(intErrors.ErrWrite, err)
+	}
+
+	carrier := tracing.Carrier{}
+	m.tracerFor(name).Inject(ctx, carrier)
+	trace, _ := carrier.MarshalBinary()
+	if _, err := stream.Write(binary.BigEndian.AppendUint16(nil, uint16(len(trace)))); err != nil {
+		stream.Close()
+		return nil, errors.Join(intErrors.ErrWrite, err)
+	}
+	if _, err := stream.Write(trace); err != nil {
+		stream.Close()
+		return nil, errors.Join(intErrors.ErrWrite, err)
+	}
+
 	buf := make([]byte, 1)
 	if _, err := io.ReadFull(stream, buf); err != nil {
 		stream.Close()
@@ -227,26 +220,158 @@ func (m *Manager) open(name string, ctx context.Context, timeout time.Duration)
 	}
 
 	if buf[0] != 1 {
-		logger.Log.Debug("smux/manager open: received no ok")
 		stream.Close()
 		return nil, intErrors.ErrNameMismatch
 	}
 
 	stream.SetDeadline(time.Time{})
 
-	wrapped := &Stream{stream: stream, aesKey: m.aesKey, ctx: ctx}
+	return m.wrap(stream, name, priority, ctx), nil
+}
+
+func (m *Manager) wrap(stream *smux.Stream, name string, priority Priority, ctx context.Context) *Stream {
+	ctx, cancel := mergeContext(ctx, m.ctx)
+	wrapped := &Stream{
+		stream: stream,
+		name:   name,
+		aesKey: m.aesKey,
+		ctx:    ctx,
+		limits: ratelimit.NewPair(m.rules.Get(name)),
+		logger: m.logger.With("stream", name),
+	}
+	wrapped.SetPriority(priority)
+
+	internal := m.internal[name]
+	wrapped.sendLimiters = []*ratelimit.Limiter{wrapped.limits.Send}
+	wrapped.receiveLimiters = []*ratelimit.Limiter{wrapped.limits.Receive}
+	if !internal {
+		m.streams.Add(1)
+		wrapped.scheduler = m.scheduler
+		wrapped.activity = &m.lastActivity
+		wrapped.touch()
+		wrapped.metrics = m.metrics
+		wrapped.side = m.side
+		m.metrics.StreamOpened(m.side, name)
+		wrapped.tracer = m.tracer
+
+		wrapped.sendLimiters = append(wrapped.sendLimiters, m.limits.Send)
+		wrapped.receiveLimiters = append(wrapped.receiveLimiters, m.limits.Receive)
+		for _, parent := range m.parents {
+			wrapped.sendLimiters = append(wrapped.sendLimiters, parent.Send)
+			wrapped.receiveLimiters = append(wrapped.receiveLimiters, parent.Receive)
+		}
+
+		if m.onOpened != nil {
+			m.onOpened(wrapped)
+		}
+	}
+
 	go func() {
 		select {
 		case <-wrapped.ctx.Done():
-			logger.Log.Debug("closing smux stream because of context cancellation")
+			wrapped.logger.Debug("closing smux stream because of context cancellation")
 			wrapped.Close()
-			return
 		case <-wrapped.stream.GetDieCh():
+		}
+		cancel()
+
+		if internal {
 			return
 		}
+		m.streams.Add(-1)
+		if m.onClosed != nil {
+			m.onClosed(wrapped)
+		}
 	}()
 
-	return wrapped, nil
+	return wrapped
+}
+
+// Limits returns the rate limits shared by every stream of the session.
+func (m *Manager) Limits() *ratelimit.Pair {
+	return m.limits
+}
+
+// SetParentLimits sets rate limits shared with other sessions, such as a server wide limit.
+// It only affects streams opened or accepted afterwards.
+func (m *Manager) SetParentLimits(parents ...*ratelimit.Pair) {
+	m.parents = parents
+}
+
+// SetStreamRules sets the rate limits applied to streams by name.
+// It only affects streams opened or accepted afterwards.
+func (m *Manager) SetStreamRules(rules *ratelimit.Rules) {
+	m.rules = rules
+}
+
+// SetInternal marks the streams with the given names as internal to the library, such as the heartbeat.
+// Internal streams escape the limits of the session and parents, which keeps them alive on a saturated
+// connection, do not count as activity and do not trigger the stream hooks.
+func (m *Manager) SetInternal(names ...string) {
+	m.internal = make(map[string]bool, len(names))
+	for _, name := range names {
+		m.internal[name] = true
+	}
+}
+
+// SetStreamHooks sets functions called when a stream is opened or accepted and when it is closed.
+// It only affects streams opened or accepted afterwards.
+func (m *Manager) SetStreamHooks(opened, closed func(*Stream)) {
+	m.onOpened = opened
+	m.onClosed = closed
+}
+
+// SetMetrics records the streams opened and the bytes transferred by the streams other than the internal ones
+// in m, labelled with side. It only affects streams opened or accepted afterwards.
+func (m *Manager) SetMetrics(metrics *metrics.Metrics, side string) {
+	m.metrics = metrics
+	m.side = side
+}
+
+// SetTracer sets the tracer spans are started with when opening and accepting streams other than the internal
+// ones, and whose span context is carried in the stream open header. A nil tracer means tracing.Noop.
+// It only affects streams opened or accepted afterwards.
+func (m *Manager) SetTracer(tracer tracing.Tracer) {
+	if tracer == nil {
+		tracer = tracing.Noop
+	}
+	m.tracer = tracer
+}
+
+func (m *Manager) tracerFor(name string) tracing.Tracer {
+	if m.internal[name] {
+		return tracing.Noop
+	}
+	return m.tracer
+}
+
+// LastActivity returns when a stream other than the internal ones was last opened, read from or written to.
+func (m *Manager) LastActivity() time.Time {
+	return time.Unix(0, m.lastActivity.Load())
+}
+
+// SetClosing makes OpenStream and AcceptStream refuse new streams other than the internal ones
+// with ErrConnClosing, for the session to be drained before it is closed.
+func (m *Manager) SetClosing() {
+	m.closing.Store(true)
+}
+
+// Drain waits until every stream other than the internal ones is closed, the session is terminated
+// or timeout elapses. It reports whether every stream was closed.
+func (m *Manager) Drain(timeout time.Duration) bool {
+	deadline := time.Now().Add(timeout)
+	for m.streams.Load() > 0 {
+		wait := time.Until(deadline)
+		if wait <= 0 {
+			return false
+		}
+		select {
+		case <-m.CloseChan():
+			return m.streams.Load() == 0
+		case <-time.After(min(wait, drainPollInterval)):
+		}
+	}
+	return true
 }
 
 // Close terminates the session.
@@ -0,0 +1,244 @@
+package smux
+
+import (
+	"context"
+	"encoding/binary"
+	"errors"
+	"io"
+	"sync"
+	"time"
+
+	intErrors "github.com/Onyz107/onynet/errors"
+	"github.com/Onyz107/onynet/tracing"
+	"github.com/xtaci/smux"
+)
+
+// router accepts every stream of a session and hands it to the AcceptStream call or the handler
+// waiting for its name, so callers waiting for different names never take each other's streams.
+// A stream nobody waits for is held for pendingStreamTimeout, then refused with a name mismatch
+// and the opener tries again.
+type router struct {
+	mu       sync.Mutex
+	waiters  map[string][]chan *incoming
+	pending  map[string][]*incoming
+	held     int // number of pending streams
+	handlers map[string]func(*Stream)
+
+	done chan struct{} // closed when the session stops accepting streams
+	err  error
+}
+
+// incoming is an accepted stream whose open header was read but not answered yet.
+type incoming struct {
+	stream   *smux.Stream
+	name     string
+	priority Priority
+	carrier  tracing.Carrier
+}
+
+func newRouter() *router {
+	return &router{
+		waiters:  make(map[string][]chan *incoming),
+		pending:  make(map[string][]*incoming),
+		handlers: make(map[string]func(*Stream)),
+		done:     make(chan struct{}),
+	}
+}
+
+// acceptLoop accepts the streams of the session until it is closed.
+func (m *Manager) acceptLoop() {
+	for {
+		stream, err := m.session.AcceptStream()
+		if err != nil {
+			// OpenStream sets a deadline on the whole session, which only bounds this call
+			if errors.Is(err, smux.ErrTimeout) {
+				continue
+			}
+			m.router.err = err
+			close(m.router.done)
+			return
+		}
+		go m.route(stream)
+	}
+}
+
+// route reads the open header of stream and hands it to whoever waits for its name.
+func (m *Manager) route(stream *smux.Stream) {
+	stream.SetDeadline(time.Now().Add(streamHeaderTimeout))
+	in, err := readHeader(stream)
+	if err != nil {
+		m.logger.Debug("dropping stream with a malformed header", "error", err, "error_kind", intErrors.Kind(err))
+		stream.Close()
+		return
+	}
+
+	r := m.router
+	r.mu.Lock()
+	if m.closing.Load() && !m.internal[in.name] {
+		r.mu.Unlock()
+		refuse(in)
+		return
+	}
+
+	if handler := r.handlers[in.name]; handler != nil {
+		r.mu.Unlock()
+		wrapped, err := m.answer(in, m.ctx)
+		if err != nil {
+			return
+		}
+		handler(wrapped)
+		return
+	}
+
+	if waiters := r.waiters[in.name]; len(waiters) > 0 {
+		r.waiters[in.name] = waiters[1:]
+		r.mu.Unlock()
+		waiters[0] <- in
+		return
+	}
+
+	if r.held >= maxPendingStreams {
+		r.mu.Unlock()
+		refuse(in)
+		return
+	}
+	r.pending[in.name] = append(r.pending[in.name], in)
+	r.held++
+	r.mu.Unlock()
+
+	time.AfterFunc(pendingStreamTimeout, func() {
+		if r.take(in) {
+			refuse(in)
+		}
+	})
+}
+
+// take removes in from the pending streams, it reports whether in was still pending.
+func (r *router) take(in *incoming) bool {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	pending := r.pending[in.name]
+	for i, p := range pending {
+		if p == in {
+			r.pending[in.name] = append(pending[:i:i], pending[i+1:]...)
+			r.held--
+			return true
+		}
+	}
+	return false
+}
+
+// wait returns the next stream named name, a pending one or the next one to arrive.
+func (r *router) wait(name string, ctx context.Context, timeout time.Duration) (*incoming, error) {
+	r.mu.Lock()
+	if pending := r.pending[name]; len(pending) > 0 {
+		in := pending[0]
+		r.pending[name] = pending[1:]
+		r.held--
+		r.mu.Unlock()
+		return in, nil
+	}
+	ch := make(chan *incoming, 1)
+	r.waiters[name] = append(r.waiters[name], ch)
+	r.mu.Unlock()
+
+	var expired <-chan time.Time
+	if timeout > 0 {
+		timer := time.NewTimer(timeout)
+		defer timer.Stop()
+		expired = timer.C
+	}
+
+	var err error
+	select {
+	case in := <-ch:
+		return in, nil
+	case <-ctx.Done():
+		err = intErrors.ErrCtxCancelled
+	case <-expired:
+		err = intErrors.ErrTimeout
+	case <-r.done:
+		err = errors.Join(intErrors.ErrAcceptStream, r.err)
+	}
+
+	r.mu.Lock()
+	waiters := r.waiters[name]
+	for i, w := range waiters {
+		if w == ch {
+			r.waiters[name] = append(waiters[:i:i], waiters[i+1:]...)
+			r.mu.Unlock()
+			return nil, err
+		}
+	}
+	r.mu.Unlock()
+	// A stream was handed over in the meantime
+	return <-ch, nil
+}
+
+// Handle hands the streams named name to handler, in their own goroutine, instead of AcceptStream.
+// The streams inherit the session's context. A nil handler stops handling name, its streams are
+// refused until AcceptStream waits for them again.
+func (m *Manager) Handle(name string, handler func(*Stream)) {
+	m.router.mu.Lock()
+	defer m.router.mu.Unlock()
+	if handler == nil {
+		delete(m.router.handlers, name)
+		return
+	}
+	m.router.handlers[name] = handler
+}
+
+// answer accepts in and wraps it with ctx.
+func (m *Manager) answer(in *incoming, ctx context.Context) (*Stream, error) {
+	if _, err := in.stream.Write([]byte{1}); err != nil {
+		in.stream.Close()
+		return nil, errors.Join(intErrors.ErrWrite, err)
+	}
+	in.stream.SetDeadline(time.Time{})
+
+	tracer := m.tracerFor(in.name)
+	ctx, span := tracer.Start(tracer.Extract(ctx, in.carrier), "onynet.AcceptStream", tracing.String("onynet.stream", in.name))
+	span.End()
+
+	return m.wrap(in.stream, in.name, in.priority, ctx), nil
+}
+
+// refuse tells the opener of in that nobody accepts its name.
+func refuse(in *incoming) {
+	in.stream.Write([]byte{0})
+	in.stream.Close()
+}
+
+// readHeader reads the name length(2) + name + priority(1) + trace context length(2) + trace context
+// a stream is opened with.
+func readHeader(stream *smux.Stream) (*incoming, error) {
+	headerPtr := headerPool.Get().(*[]byte)
+	defer headerPool.Put(headerPtr)
+	header := *headerPtr
+
+	if _, err := io.ReadFull(stream, header); err != nil {
+		return nil, errors.Join(intErrors.ErrRead, err)
+	}
+
+	name := make([]byte, binary.BigEndian.Uint16(header))
+	if _, err := io.ReadFull(stream, name); err != nil {
+		return nil, errors.Join(intErrors.ErrRead, err)
+	}
+
+	priority := make([]byte, 1)
+	if _, err := io.ReadFull(stream, priority); err != nil {
+		return nil, errors.Join(intErrors.ErrRead, err)
+	}
+
+	carrier, err := readCarrier(stream, header)
+	if err != nil {
+		return nil, err
+	}
+
+	return &incoming{
+		stream:   stream,
+		name:     string(name),
+		priority: clampPriority(Priority(priority[0])),
+		carrier:  carrier,
+	}, nil
+}
@@ -0,0 +1,98 @@
+package smux
+
+import (
+	"io"
+	"sync"
+	"time"
+
+	"github.com/xtaci/smux"
+)
+
+// scheduler hands out the right to write one chunk to the session, always to the waiting
+// writer with the highest priority, so a chunk of a bulk stream is the longest a control stream waits.
+type scheduler struct {
+	mu      sync.Mutex
+	busy    bool
+	waiters [numPriorities][]chan struct{}
+}
+
+// acquire waits for the right to write a chunk.
+// It gives up when die is closed or when deadline passes.
+func (s *scheduler) acquire(priority Priority, die <-chan struct{}, deadline time.Time) error {
+	s.mu.Lock()
+	if !s.busy {
+		s.busy = true
+		s.mu.Unlock()
+		return nil
+	}
+	ch := make(chan struct{})
+	s.waiters[priority] = append(s.waiters[priority], ch)
+	s.mu.Unlock()
+
+	var timeout <-chan time.Time
+	if !deadline.IsZero() {
+		timer := time.NewTimer(time.Until(deadline))
+		defer timer.Stop()
+		timeout = timer.C
+	}
+
+	var err error
+	select {
+	case <-ch:
+		return nil
+	case <-die:
+		err = io.ErrClosedPipe
+	case <-timeout:
+		err = smux.ErrTimeout
+	}
+
+	s.mu.Lock()
+	if !s.removeLocked(priority, ch) {
+		// The turn was handed to us while giving up, pass it on.
+		s.mu.Unlock()
+		s.release()
+		return err
+	}
+	s.mu.Unlock()
+	return err
+}
+
+// release hands the right to write to the next waiting writer.
+func (s *scheduler) release() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for priority := range s.waiters {
+		if len(s.waiters[priority]) > 0 {
+			ch := s.waiters[priority][0]
+			s.waiters[priority] = s.waiters[priority][1:]
+			close(ch)
+			return
+		}
+	}
+	s.busy = false
+}
+
+// write writes chunk with write, which the caller must have acquired the turn for.
+// The turn is handed on once the write returns or once it has been blocked for writeStallTimeout,
+// such as when the peer stops reading the stream and its window is full, so a stalled stream
+// never holds back the writes of the other streams.
+func (s *scheduler) write(chunk []byte, write func([]byte) (int, error)) (int, error) {
+	var once sync.Once
+	release := func() { once.Do(s.release) }
+	stall := time.AfterFunc(writeStallTimeout, release)
+	n, err := write(chunk)
+	stall.Stop()
+	release()
+	return n, err
+}
+
+func (s *scheduler) removeLocked(priority Priority, ch chan struct{}) bool {
+	for i, waiter := range s.waiters[priority] {
+		if waiter == ch {
+			s.waiters[priority] = append(s.waiters[priority][:i], s.waiters[priority][i+1:]...)
+			return true
+		}
+	}
+	return false
+}
@@ -3,23 +3,33 @@ package smux_test
 import (
 	"context"
 	"crypto/rand"
+	"errors"
+	"io"
+	"log/slog"
 	"net"
+	"slices"
 	"testing"
+	"time"
 
+	intErrors "github.com/Onyz107/onynet/errors"
 	"github.com/Onyz107/onynet/internal/kcp"
 	intSmux "github.com/Onyz107/onynet/internal/smux"
+	"github.com/Onyz107/onynet/tracing"
 	"github.com/xtaci/smux"
 )
 
+// network carries the packets of the test servers and clients in memory.
+var network = kcp.NewMemoryNetwork()
+
 func newServer(tb testing.TB) *kcp.Server {
 	tb.Helper()
 
-	addr, err := net.ResolveUDPAddr("udp", "127.0.0.1:9090")
+	addr, err := net.ResolveUDPAddr("udp", "127.0.0.1:9595")
 	if err != nil {
 		tb.Fatal(err)
 	}
 
-	server, err := kcp.NewServer(addr, context.Background())
+	server, err := kcp.NewServer(addr, network, context.Background(), slog.New(slog.DiscardHandler))
 	if err != nil {
 		tb.Fatal(err)
 	}
@@ -30,12 +40,12 @@ func newServer(tb testing.TB) *kcp.Server {
 func newClient(tb testing.TB) *kcp.Client {
 	tb.Helper()
 
-	addr, err := net.ResolveUDPAddr("udp", "127.0.0.1:9090")
+	addr, err := net.ResolveUDPAddr("udp", "127.0.0.1:9595")
 	if err != nil {
 		tb.Fatal(err)
 	}
 
-	client, err := kcp.Dial(addr, context.Background())
+	client, err := kcp.Dial(addr, network, context.Background(), slog.New(slog.DiscardHandler))
 	if err != nil {
 		tb.Fatal(err)
 	}
@@ -46,7 +56,9 @@ func newClient(tb testing.TB) *kcp.Client {
 
 func establishSession(tb testing.TB) (serverManager *intSmux.Manager, clientManager *intSmux.Manager) {
 	server := newServer(tb)
+	tb.Cleanup(func() { server.Close() })
 	client := newClient(tb)
+	tb.Cleanup(func() { client.Close() })
 
 	clientConn, err := server.Accept()
 	if err != nil {
@@ -56,22 +68,286 @@ func establishSession(tb testing.TB) (serverManager *intSmux.Manager, clientMana
 	buf := make([]byte, 1)
 	clientConn.Read(buf)
 
-	serverSession, err := smux.Server(clientConn, nil)
+	serverSession, err := smux.Server(clientConn, intSmux.Config())
 	if err != nil {
 		tb.Fatal(err)
 	}
 
-	clientSession, err := smux.Client(client, nil)
+	clientSession, err := smux.Client(client, intSmux.Config())
 	if err != nil {
 		tb.Fatal(err)
 	}
 
-	serverManager = intSmux.NewManager(serverSession, []byte("23456789abcdeffedcba987654321021"), tb.Context())
-	clientManager = intSmux.NewManager(clientSession, []byte("23456789abcdeffedcba987654321021"), tb.Context())
+	serverManager = intSmux.NewManager(serverSession, []byte("23456789abcdeffedcba987654321021"), tb.Context(), slog.New(slog.DiscardHandler))
+	clientManager = intSmux.NewManager(clientSession, []byte("23456789abcdeffedcba987654321021"), tb.Context(), slog.New(slog.DiscardHandler))
 
 	return serverManager, clientManager
 }
 
+func establishStream(tb testing.TB, serverManager, clientManager *intSmux.Manager, name string, priority intSmux.Priority) (serverStream, clientStream *intSmux.Stream) {
+	tb.Helper()
+
+	acceptDone := make(chan *intSmux.Stream, 1)
+	go func() {
+		stream, err := serverManager.AcceptStream(name, context.Background(), 5*time.Second)
+		if err != nil {
+			tb.Error(err)
+		}
+		acceptDone <- stream
+	}()
+
+	clientStream, err := clientManager.OpenStreamWithPriority(name, priority, context.Background(), 5*time.Second)
+	if err != nil {
+		tb.Fatal(err)
+	}
+	tb.Cleanup(func() { clientStream.Close() })
+
+	serverStream = <-acceptDone
+	if serverStream == nil {
+		tb.FailNow()
+	}
+	tb.Cleanup(func() { serverStream.Close() })
+
+	return serverStream, clientStream
+}
+
+func TestPriority(t *testing.T) {
+	serverManager, clientManager := establishSession(t)
+	defer serverManager.Close()
+	defer clientManager.Close()
+
+	bulkServer, bulkClient := establishStream(t, serverManager, clientManager, "bulk", intSmux.PriorityBulk)
+	controlServer, controlClient := establishStream(t, serverManager, clientManager, "control", intSmux.PriorityControl)
+
+	if bulkServer.Priority() != intSmux.PriorityBulk || controlServer.Priority() != intSmux.PriorityControl {
+		t.Fatalf("priority was not sent to the accepting side: bulk: %d: control: %d", bulkServer.Priority(), controlServer.Priority())
+	}
+
+	go io.Copy(io.Discard, bulkServer)
+	go func() {
+		buf := make([]byte, 64)
+		for {
+			if err := controlServer.Receive(buf, 0); err != nil {
+				return
+			}
+			if err := controlServer.Send(buf, 0); err != nil {
+				return
+			}
+		}
+	}()
+
+	// Saturate the session with the bulk stream
+	done := make(chan struct{})
+	defer close(done)
+	go func() {
+		data := make([]byte, 256*1024)
+		rand.Read(data)
+		for {
+			select {
+			case <-done:
+				return
+			default:
+				if _, err := bulkClient.Write(data); err != nil {
+					return
+				}
+			}
+		}
+	}()
+	time.Sleep(200 * time.Millisecond)
+
+	msg := make([]byte, 64)
+	latencies := make([]time.Duration, 0, 50)
+	for range 50 {
+		start := time.Now()
+		if err := controlClient.Send(msg, 5*time.Second); err != nil {
+			t.Fatal(err)
+		}
+		if err := controlClient.Receive(msg, 5*time.Second); err != nil {
+			t.Fatal(err)
+		}
+		latencies = append(latencies, time.Since(start))
+	}
+
+	slices.Sort(latencies)
+	t.Logf("control round trip during bulk transfer: p50: %s: max: %s", latencies[len(latencies)/2], latencies[len(latencies)-1])
+	if max := latencies[len(latencies)-1]; max > 250*time.Millisecond {
+		t.Fatalf("control round trip took %s during bulk transfer", max)
+	}
+}
+
+func TestStalledPeer(t *testing.T) {
+	serverManager, clientManager := establishSession(t)
+	defer serverManager.Close()
+	defer clientManager.Close()
+	serverManager.SetInternal("heartbeat")
+	clientManager.SetInternal("heartbeat")
+
+	// The server never reads the bulk stream, so its window fills up and its writes block
+	_, bulkClient := establishStream(t, serverManager, clientManager, "bulk", intSmux.PriorityBulk)
+	controlServer, controlClient := establishStream(t, serverManager, clientManager, "control", intSmux.PriorityControl)
+	heartbeatServer, heartbeatClient := establishStream(t, serverManager, clientManager, "heartbeat", intSmux.PriorityControl)
+
+	go func() {
+		data := make([]byte, 4*1024*1024)
+		bulkClient.Write(data)
+	}()
+	time.Sleep(200 * time.Millisecond)
+
+	for _, pair := range []struct{ client, server *intSmux.Stream }{{controlClient, controlServer}, {heartbeatClient, heartbeatServer}} {
+		start := time.Now()
+		if err := pair.client.Send([]byte("ping"), 5*time.Second); err != nil {
+			t.Fatal(err)
+		}
+		buf := make([]byte, 4)
+		if err := pair.server.Receive(buf, 5*time.Second); err != nil {
+			t.Fatal(err)
+		}
+		if elapsed := time.Since(start); elapsed > 250*time.Millisecond {
+			t.Fatalf("write on %s took %s behind a stalled stream", pair.client.Name(), elapsed)
+		}
+	}
+}
+
+func TestInvalidPriority(t *testing.T) {
+	serverManager, clientManager := establishSession(t)
+	defer serverManager.Close()
+	defer clientManager.Close()
+
+	serverStream, clientStream := establishStream(t, serverManager, clientManager, "invalid", intSmux.Priority(9))
+	if clientStream.Priority() != intSmux.PriorityBulk || serverStream.Priority() != intSmux.PriorityBulk {
+		t.Fatalf("expected PriorityBulk, got: client: %d: server: %d", clientStream.Priority(), serverStream.Priority())
+	}
+
+	if err := clientStream.Send([]byte("data"), 5*time.Second); err != nil {
+		t.Fatal(err)
+	}
+	buf := make([]byte, 4)
+	if err := serverStream.Receive(buf, 5*time.Second); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestStreamContext(t *testing.T) {
+	serverManager, clientManager := establishSession(t)
+
+	type key struct{}
+	ctx := context.WithValue(context.Background(), key{}, "value")
+
+	acceptDone := make(chan *intSmux.Stream, 1)
+	go func() {
+		stream, err := serverManager.AcceptStream("contextStream", context.Background(), 5*time.Second)
+		if err != nil {
+			t.Error(err)
+		}
+		acceptDone <- stream
+	}()
+
+	stream, err := clientManager.OpenStream("contextStream", ctx, 5*time.Second)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if serverStream := <-acceptDone; serverStream != nil {
+		defer serverStream.Close()
+	}
+
+	if v := stream.Context().Value(key{}); v != "value" {
+		t.Fatalf("expected stream context to carry the given value, got: %v", v)
+	}
+
+	stream.Close()
+	select {
+	case <-stream.Context().Done():
+	case <-time.After(time.Second):
+		t.Fatal("stream context was not cancelled when the stream was closed")
+	}
+}
+
+func TestDrain(t *testing.T) {
+	serverManager, clientManager := establishSession(t)
+	serverStream, clientStream := establishStream(t, serverManager, clientManager, "drainStream", intSmux.PriorityNormal)
+
+	clientManager.SetClosing()
+	if _, err := clientManager.OpenStream("lateStream", context.Background(), time.Second); !errors.Is(err, intErrors.ErrConnClosing) {
+		t.Fatalf("expected ErrConnClosing when opening a stream while closing, got: %v", err)
+	}
+
+	if clientManager.Drain(200 * time.Millisecond) {
+		t.Fatal("expected drain to time out while a stream is open")
+	}
+
+	go func() {
+		time.Sleep(200 * time.Millisecond)
+		clientStream.Close()
+		serverStream.Close()
+	}()
+	if !clientManager.Drain(2 * time.Second) {
+		t.Fatal("expected drain to finish once the stream was closed")
+	}
+}
+
+// idTracer propagates the name of the span in ctx as its ID.
+type idTracer struct{}
+
+type spanKey struct{}
+
+type idSpan struct{}
+
+func (idTracer) Start(ctx context.Context, name string, attrs ...tracing.Attribute) (context.Context, tracing.Span) {
+	if parent, ok := ctx.Value(spanKey{}).(string); ok {
+		name = parent + "/" + name
+	}
+	return context.WithValue(ctx, spanKey{}, name), idSpan{}
+}
+
+func (idTracer) Inject(ctx context.Context, carrier tracing.Carrier) {
+	if id, ok := ctx.Value(spanKey{}).(string); ok {
+		carrier.Set("span", id)
+	}
+}
+
+func (idTracer) Extract(ctx context.Context, carrier tracing.Carrier) context.Context {
+	if id := carrier.Get("span"); id != "" {
+		return context.WithValue(ctx, spanKey{}, id)
+	}
+	return ctx
+}
+
+func (idSpan) SetAttributes(attrs ...tracing.Attribute) {}
+func (idSpan) RecordError(err error)                    {}
+func (idSpan) End()                                     {}
+
+func TestStreamTrace(t *testing.T) {
+	serverManager, clientManager := establishSession(t)
+	serverManager.SetTracer(idTracer{})
+	clientManager.SetTracer(idTracer{})
+
+	ctx, _ := idTracer{}.Start(context.Background(), "request")
+	acceptDone := make(chan *intSmux.Stream, 1)
+	go func() {
+		stream, err := serverManager.AcceptStream("traceStream", context.Background(), 5*time.Second)
+		if err != nil {
+			t.Error(err)
+		}
+		acceptDone <- stream
+	}()
+
+	stream, err := clientManager.OpenStream("traceStream", ctx, 5*time.Second)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer stream.Close()
+
+	serverStream := <-acceptDone
+	if serverStream == nil {
+		t.FailNow()
+	}
+	defer serverStream.Close()
+
+	if got := serverStream.Context().Value(spanKey{}); got != "request/onynet.OpenStream/onynet.AcceptStream" {
+		t.Fatalf("expected the accepted stream to continue the trace, got: %v", got)
+	}
+}
+
 func BenchmarkManager_Accept(b *testing.B) {
 	serverManager, clientManager := establishSession(b)
 	defer serverManager.Close()
@@ -441,7 +717,8 @@ func BenchmarkStream_NewStreamedReceiver(b *testing.B) {
 	go func() {
 		stream, err := serverManager.AcceptStream("benchmarkSend", context.Background(), 0)
 		if err != nil {
-			b.Fatal(err)
+			b.Error(err)
+			return
 		}
 		acceptDone <- stream
 	}()
@@ -595,7 +872,8 @@ func BenchmarkStream_NewStreamedEncryptedReceiver(b *testing.B) {
 	go func() {
 		stream, err := serverManager.AcceptStream("benchmarkSend", context.Background(), 0)
 		if err != nil {
-			b.Fatal(err)
+			b.Error(err)
+			return
 		}
 		acceptDone <- stream
 	}()
@@ -647,3 +925,80 @@ func BenchmarkStream_NewStreamedEncryptedReceiver(b *testing.B) {
 	}
 	close(done)
 }
+
+func TestRouting(t *testing.T) {
+	serverManager, clientManager := establishSession(t)
+	defer serverManager.Close()
+	defer clientManager.Close()
+
+	handled := make(chan *intSmux.Stream, 1)
+	serverManager.Handle("handled", func(stream *intSmux.Stream) {
+		handled <- stream
+	})
+
+	// Accepts waiting for different names never take each other's streams
+	names := []string{"first", "second", "third"}
+	accepted := make(chan string, len(names))
+	for _, name := range names {
+		go func() {
+			stream, err := serverManager.AcceptStream(name, context.Background(), 5*time.Second)
+			if err != nil {
+				t.Error(err)
+				accepted <- ""
+				return
+			}
+			defer stream.Close()
+			accepted <- stream.Name()
+		}()
+	}
+
+	for _, name := range append(slices.Clone(names), "handled") {
+		stream, err := clientManager.OpenStream(name, context.Background(), 5*time.Second)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer stream.Close()
+	}
+
+	got := make([]string, 0, len(names))
+	for range names {
+		got = append(got, <-accepted)
+	}
+	slices.Sort(got)
+	if want := []string{"first", "second", "third"}; !slices.Equal(got, want) {
+		t.Fatalf("expected streams %v, got: %v", want, got)
+	}
+
+	select {
+	case stream := <-handled:
+		stream.Close()
+		if stream.Name() != "handled" {
+			t.Fatalf("expected the handler to get its stream, got: %s", stream.Name())
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("the handler did not get its stream")
+	}
+
+	// A stream opened before anything accepts it waits for the accept
+	opened := make(chan error, 1)
+	go func() {
+		stream, err := clientManager.OpenStream("early", context.Background(), 5*time.Second)
+		if err == nil {
+			stream.Close()
+		}
+		opened <- err
+	}()
+	time.Sleep(100 * time.Millisecond)
+	stream, err := serverManager.AcceptStream("early", context.Background(), 5*time.Second)
+	if err != nil {
+		t.Fatal(err)
+	}
+	stream.Close()
+	if err := <-opened; err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := serverManager.AcceptStream("never", context.Background(), 100*time.Millisecond); !errors.Is(err, intErrors.ErrTimeout) {
+		t.Fatalf("expected ErrTimeout, got: %v", err)
+	}
+}
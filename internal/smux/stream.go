@@ -3,18 +3,52 @@ package smux
 import (
 	"context"
 	"io"
+	"log/slog"
 	"net"
+	"sync/atomic"
 	"time"
 
 	intErrors "github.com/Onyz107/onynet/errors"
+	"github.com/Onyz107/onynet/internal/ratelimit"
 	"github.com/Onyz107/onynet/internal/transfer"
+	"github.com/Onyz107/onynet/metrics"
+	"github.com/Onyz107/onynet/tracing"
 	"github.com/xtaci/smux"
 )
 
 type Stream struct {
-	stream *smux.Stream
-	aesKey []byte
-	ctx    context.Context
+	stream    *smux.Stream
+	name      string
+	aesKey    []byte
+	ctx       context.Context
+	scheduler *scheduler // nil for internal streams, whose writes are never scheduled
+	priority  atomic.Uint32
+
+	limits          *ratelimit.Pair
+	sendLimiters    []*ratelimit.Limiter
+	receiveLimiters []*ratelimit.Limiter
+
+	readDeadline  atomic.Value // time.Time
+	writeDeadline atomic.Value // time.Time
+
+	activity *atomic.Int64 // the session's last activity, nil for internal streams
+	metrics  *metrics.Metrics
+	side     string
+	tracer   tracing.Tracer
+	logger   *slog.Logger
+}
+
+// encryptedStream is given to the encrypted transfer methods so their bytes are recorded as encrypted.
+type encryptedStream struct {
+	*Stream
+}
+
+func (e encryptedStream) Read(b []byte) (int, error) {
+	return e.read(b, true)
+}
+
+func (e encryptedStream) Write(b []byte) (int, error) {
+	return e.write(b, true)
 }
 
 // Read reads data from the stream into the provided buffer.
@@ -22,27 +56,113 @@ type Stream struct {
 // Possible errors:
 //   - ErrCtxCancelled: context was cancelled when trying to read data
 func (s *Stream) Read(b []byte) (n int, err error) {
+	return s.read(b, false)
+}
+
+func (s *Stream) read(b []byte, encrypted bool) (n int, err error) {
 	select {
 	case <-s.ctx.Done():
 		return 0, intErrors.ErrCtxCancelled
 	default:
-		return s.stream.Read(b)
 	}
+
+	n, err = s.stream.Read(b)
+	if n > 0 {
+		s.touch()
+		s.metrics.BytesReceived(s.side, n, encrypted)
+		deadline, _ := s.readDeadline.Load().(time.Time)
+		if waitErr := ratelimit.Wait(n, s.stream.GetDieCh(), deadline, s.receiveLimiters...); waitErr != nil && err == nil {
+			err = waitErr
+		}
+	}
+	return n, err
 }
 
 // Write writes data to the connection.
+// Data is written in chunks, each chunk waits for the streams with a higher priority to write theirs first,
+// except on internal streams such as the heartbeat's, which are never scheduled.
 //
 // Possible errors:
 //   - ErrCtxCancelled: context was cancelled when trying to write data
 func (s *Stream) Write(b []byte) (n int, err error) {
+	return s.write(b, false)
+}
+
+func (s *Stream) write(b []byte, encrypted bool) (n int, err error) {
 	select {
 	case <-s.ctx.Done():
 		return 0, intErrors.ErrCtxCancelled
 	default:
-		return s.stream.Write(b)
+	}
+
+	for len(b) > 0 {
+		chunk := b[:min(len(b), writeQuantum)]
+
+		deadline, _ := s.writeDeadline.Load().(time.Time)
+		if err := ratelimit.Wait(len(chunk), s.stream.GetDieCh(), deadline, s.sendLimiters...); err != nil {
+			return n, err
+		}
+		var written int
+		var err error
+		if s.scheduler != nil {
+			if err := s.scheduler.acquire(s.Priority(), s.stream.GetDieCh(), deadline); err != nil {
+				return n, err
+			}
+			written, err = s.scheduler.write(chunk, s.stream.Write)
+		} else {
+			written, err = s.stream.Write(chunk)
+		}
+		s.touch()
+		s.metrics.BytesSent(s.side, written, encrypted)
+
+		n += written
+		if err != nil {
+			return n, err
+		}
+		b = b[len(chunk):]
+	}
+
+	return n, nil
+}
+
+func (s *Stream) touch() {
+	if s.activity != nil {
+		s.activity.Store(time.Now().UnixNano())
 	}
 }
 
+// Name returns the name the stream was opened with.
+func (s *Stream) Name() string {
+	return s.name
+}
+
+// Priority returns the priority the stream's writes are scheduled with.
+func (s *Stream) Priority() Priority {
+	return Priority(s.priority.Load())
+}
+
+// SetPriority changes the priority the stream's writes are scheduled with.
+// Only the local end of the stream is affected.
+func (s *Stream) SetPriority(priority Priority) {
+	s.priority.Store(uint32(clampPriority(priority)))
+}
+
+// RateLimit returns the rate limit applied to the stream in each direction.
+func (s *Stream) RateLimit() ratelimit.Limit {
+	return s.limits.Limit()
+}
+
+// SetRateLimit changes the rate limit applied to the stream in each direction.
+// The limits of the connection and of the server still apply.
+func (s *Stream) SetRateLimit(limit ratelimit.Limit) {
+	s.limits.SetLimit(limit)
+}
+
+// Throttled returns the total time the stream's reads and writes were delayed by its own rate limit.
+func (s *Stream) Throttled() time.Duration {
+	return s.limits.Throttled()
+}
+
 // Send sends raw bytes with timeout.
 //
 // Possible errors:
@@ -50,13 +170,13 @@ func (s *Stream) Write(b []byte) (n int, err error) {
 //   - ErrShortWrite: data sent was shorter than expected
 //   - ErrTimeout: timeout occurred when receiving data from the stream
 func (s *Stream) Send(b []byte, timeout time.Duration) error {
-	return transfer.Send(s.stream, b, timeout)
+	return transfer.Send(s, b, timeout)
 }
 
 // NewStreamedSender returns an io.WriteCloser that allows
 // the caller to directly write data to the stream and set a timeout.
 func (s *Stream) NewStreamedSender(timeout time.Duration) io.WriteCloser {
-	return transfer.NewStreamedSender(s.stream, timeout)
+	return transfer.NewStreamedSender(s, timeout)
 }
 
 // SendSerialized sends serialized data with length header.
@@ -67,7 +187,7 @@ func (s *Stream) NewStreamedSender(timeout time.Duration) io.WriteCloser {
 //   - ErrShortWrite: data sent was shorter than expected
 //   - ErrTimeout: timeout occurred when receiving data from the stream
 func (s *Stream) SendSerialized(b []byte, timeout time.Duration) error {
-	return transfer.SendSerialized(s.stream, b, timeout)
+	return transfer.SendSerialized(s, b, timeout)
 }
 
 // SendEncrypted sends AES-GCM encrypted data.
@@ -81,7 +201,7 @@ func (s *Stream) SendSerialized(b []byte, timeout time.Duration) error {
 //   - ErrGCM: failed to create GCM
 //   - ErrTimeout: timeout occurred when receiving data from the stream
 func (s *Stream) SendEncrypted(b []byte, timeout time.Duration) error {
-	return transfer.SendEncrypted(s.stream, b, s.aesKey, timeout)
+	return transfer.SendEncrypted(encryptedStream{s}, b, s.aesKey, timeout)
 }
 
 // NewStreamedEncryptedSender returns an io.WriteCloser that encrypts data as it is written to the stream.
@@ -93,7 +213,7 @@ func (s *Stream) SendEncrypted(b []byte, timeout time.Duration) error {
 //   - ErrStreamCipher: failed to create an AES-CTR stream
 //   - ErrCipher: invalid key size
 func (s *Stream) NewStreamedEncryptedSender(timeout time.Duration) (io.WriteCloser, error) {
-	return transfer.NewStreamedEncryptedSender(s.stream, s.aesKey, timeout)
+	return transfer.NewStreamedEncryptedSender(encryptedStream{s}, s.aesKey, timeout)
 }
 
 // Receive reads data into buffer with timeout.
@@ -102,13 +222,13 @@ func (s *Stream) NewStreamedEncryptedSender(timeout time.Duration) (io.WriteClos
 //   - ErrRead: failed to receive data from the stream
 //   - ErrTimeout: timeout occurred when receiving data from the stream
 func (s *Stream) Receive(b []byte, timeout time.Duration) error {
-	return transfer.Receive(s.stream, b, timeout)
+	return transfer.Receive(s, b, timeout)
 }
 
 // NewStreamedReceiver returns an io.ReadCloser that allows
 // the caller to directly read data from the stream and set a timeout.
 func (s *Stream) NewStreamedReceiver(timeout time.Duration) io.ReadCloser {
-	return transfer.NewStreamedReceiver(s.stream, timeout)
+	return transfer.NewStreamedReceiver(s, timeout)
 }
 
 // ReceiveSerialized reads serialized data with length header.
@@ -119,7 +239,7 @@ func (s *Stream) NewStreamedReceiver(timeout time.Duration) io.ReadCloser {
 //   - ErrRead: failed to receive data from the stream
 //   - ErrTimeout: timeout occurred when receiving data from the stream
 func (s *Stream) ReceiveSerialized(b []byte, timeout time.Duration) (uint64, error) {
-	return transfer.ReceiveSerialized(s.stream, b, timeout)
+	return transfer.ReceiveSerialized(s, b, timeout)
 }
 
 // ReceiveEncrypted reads AES-GCM encrypted data.
@@ -135,7 +255,7 @@ func (s *Stream) ReceiveSerialized(b []byte, timeout time.Duration) (uint64, err
 //   - ErrDecrypt: failed to decrypt the received data
 //   - ErrTimeout: timeout occurred when receiving data from the stream
 func (s *Stream) ReceiveEncrypted(b []byte, timeout time.Duration) (uint64, error) {
-	return transfer.ReceiveEncrypted(s.stream, b, s.aesKey, timeout)
+	return transfer.ReceiveEncrypted(encryptedStream{s}, b, s.aesKey, timeout)
 }
 
 // NewStreamedEncryptedReceiver returns an io.ReadCloser that decrypts data as it comes from the stream.
@@ -145,7 +265,7 @@ func (s *Stream) ReceiveEncrypted(b []byte, timeout time.Duration) (uint64, erro
 //   - ErrRead: failed to receive the nonce from the stream
 //   - ErrStreamCipher: failed to create an AES-CTR stream
 func (s *Stream) NewStreamedEncryptedReceiver(timeout time.Duration) (io.ReadCloser, error) {
-	return transfer.NewStreamedEncryptedReceiver(s.stream, s.aesKey, timeout)
+	return transfer.NewStreamedEncryptedReceiver(encryptedStream{s}, s.aesKey, timeout)
 }
 
 // Close implements net.Conn
@@ -166,21 +286,59 @@ func (s *Stream) RemoteAddr() net.Addr {
 // SetDeadline sets both read and write deadlines as defined by net.Conn.SetDeadline.
 // A zero time value disables the deadlines.
 func (s *Stream) SetDeadline(t time.Time) error {
+	s.readDeadline.Store(t)
+	s.writeDeadline.Store(t)
 	return s.stream.SetDeadline(t)
 }
 
 // SetReadDeadline sets the read deadline as defined by net.Conn.SetReadDeadline.
 // A zero time value disables the deadline.
 func (s *Stream) SetReadDeadline(t time.Time) error {
+	s.readDeadline.Store(t)
 	return s.stream.SetReadDeadline(t)
 }
 
 // SetWriteDeadline sets the write deadline as defined by net.Conn.SetWriteDeadline.
 // A zero time value disables the deadline.
 func (s *Stream) SetWriteDeadline(t time.Time) error {
+	s.writeDeadline.Store(t)
 	return s.stream.SetWriteDeadline(t)
 }
 
+// SetAESKey replaces the key of the encrypted transfer methods with one both ends of the stream agreed on,
+// such as the end-to-end key of a relayed stream. It must be called before the stream is used.
+func (s *Stream) SetAESKey(key []byte) {
+	s.aesKey = key
+}
+
+// IsEncrypted reports whether the stream has an AES key, meaning authentication is enabled
+// and the encrypted transfer methods can be used.
+func (s *Stream) IsEncrypted() bool {
+	return s.aesKey != nil
+}
+
+// Context returns the stream's context. It is cancelled when the stream is closed, when the context
+// given when opening or accepting the stream is done, or when the connection is closed,
+// and it carries the values of both the given context and the connection's context.
+func (s *Stream) Context() context.Context {
+	return s.ctx
+}
+
+// Tracer returns the tracer of the stream's connection, tracing.Noop if it has none.
+// Protocols built on the stream use it to trace their operations, like the rpc package does for calls.
+func (s *Stream) Tracer() tracing.Tracer {
+	if s.tracer == nil {
+		return tracing.Noop
+	}
+	return s.tracer
+}
+
+// Logger returns the logger of the stream's connection with the stream's name as the "stream" attribute.
+// Protocols built on the stream use it for their own logs, like the rpc and pubsub packages do.
+func (s *Stream) Logger() *slog.Logger {
+	return s.logger
+}
+
 // GetDieCh returns a readonly chan which can be readable when the stream is to be closed.
 func (s *Stream) GetDieCh() <-chan struct{} {
 	return s.stream.GetDieCh()
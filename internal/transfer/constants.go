@@ -13,14 +13,8 @@ var headerPool = sync.Pool{
 	},
 }
 
-var timedReadWriteCloserCache sync.Map // conn net.Conn -> timedReadWriteCloser
-
+// getTimedReadWriteCloser wraps conn with timeout. A wrapper is not shared between calls,
+// so each call applies its own timeout and nothing outlives the connection.
 func getTimedReadWriteCloser(conn net.Conn, timeout time.Duration) *timedReadWriteCloser {
-	if val, ok := timedReadWriteCloserCache.Load(conn); ok {
-		return val.(*timedReadWriteCloser)
-	}
-
-	timedConn := &timedReadWriteCloser{conn: conn, timeout: timeout}
-	timedReadWriteCloserCache.Store(conn, timedConn)
-	return timedConn
+	return &timedReadWriteCloser{conn: conn, timeout: timeout}
 }
@@ -13,14 +13,12 @@ import (
 
 	intErrors "github.com/Onyz107/onynet/errors"
 	"github.com/Onyz107/onynet/internal/crypto"
-	"github.com/Onyz107/onynet/internal/logger"
 )
 
 // Send writes bytes to conn with optional timeout.
 func Send(conn net.Conn, data []byte, timeout time.Duration) error {
 	timedConn := getTimedReadWriteCloser(conn, timeout)
 
-	logger.Log.Debugf("Writing %d bytes of data to %s", len(data), conn.RemoteAddr().String())
 	n, err := timedConn.Write(data)
 	if err != nil {
 		var ne net.Error
@@ -32,7 +30,6 @@ func Send(conn net.Conn, data []byte, timeout time.Duration) error {
 	if n != len(data) {
 		return errors.Join(intErrors.ErrShortWrite, fmt.Errorf("sent %d bytes instead of %d", n, len(data)))
 	}
-	logger.Log.Debugf("Sent %d bytes to %s", len(data), conn.RemoteAddr().String())
 
 	return nil
 }
@@ -0,0 +1,34 @@
+package transfer_test
+
+import (
+	"errors"
+	"net"
+	"testing"
+	"time"
+
+	intErrors "github.com/Onyz107/onynet/errors"
+	"github.com/Onyz107/onynet/internal/transfer"
+)
+
+func TestTimeoutPerCall(t *testing.T) {
+	client, server := net.Pipe()
+	defer client.Close()
+	defer server.Close()
+
+	buf := make([]byte, 4)
+	if err := transfer.Receive(server, buf, 20*time.Millisecond); !errors.Is(err, intErrors.ErrTimeout) {
+		t.Fatalf("expected ErrTimeout, got: %v", err)
+	}
+
+	// The second call waits as long as it was given, not as long as the first one
+	go func() {
+		time.Sleep(200 * time.Millisecond)
+		client.Write([]byte("data"))
+	}()
+	if err := transfer.Receive(server, buf, 5*time.Second); err != nil {
+		t.Fatalf("expected the second timeout to be honoured, got: %v", err)
+	}
+	if string(buf) != "data" {
+		t.Fatalf("expected data, got: %q", buf)
+	}
+}
@@ -0,0 +1,55 @@
+package transport
+
+import (
+	"errors"
+	"net"
+	"sync"
+
+	intErrors "github.com/Onyz107/onynet/errors"
+)
+
+// Stream returns a Conn over conn, a reliable stream established elsewhere such as a relayed stream.
+func Stream(conn net.Conn) Conn {
+	return streamConn{conn}
+}
+
+// ConnListener accepts the connections handed to Serve.
+type ConnListener struct {
+	conns chan Conn
+	done  chan struct{}
+	once  sync.Once
+}
+
+// NewConnListener returns a listener accepting the connections handed to Serve.
+func NewConnListener() *ConnListener {
+	return &ConnListener{
+		conns: make(chan Conn),
+		done:  make(chan struct{}),
+	}
+}
+
+// Serve waits for Accept to take conn, a reliable stream which carries no datagrams.
+func (l *ConnListener) Serve(conn net.Conn) error {
+	select {
+	case l.conns <- Stream(conn):
+		return nil
+	case <-l.done:
+		return errors.Join(intErrors.ErrAccept, net.ErrClosed)
+	}
+}
+
+// Accept waits for the next connection handed to Serve.
+func (l *ConnListener) Accept() (Conn, error) {
+	select {
+	case conn := <-l.conns:
+		return conn, nil
+	case <-l.done:
+		return nil, errors.Join(intErrors.ErrAccept, net.ErrClosed)
+	}
+}
+
+// Close stops handing connections to Accept, Serve fails afterwards.
+func (l *ConnListener) Close() error {
+	l.once.Do(func() { close(l.done) })
+	return nil
+}
@@ -0,0 +1,10 @@
+package transport
+
+import "time"
+
+const (
+	// dialTimeout bounds how long establishing a TCP or WebSocket connection may take.
+	dialTimeout = 10 * time.Second
+	// webSocketOrigin is the origin sent by clients, the server accepts any since peers authenticate themselves.
+	webSocketOrigin = "http://localhost/"
+)
@@ -0,0 +1,51 @@
+package transport
+
+import (
+	"context"
+	"errors"
+	"net"
+
+	intErrors "github.com/Onyz107/onynet/errors"
+)
+
+// TCPListener accepts connections over TCP.
+type TCPListener struct {
+	listener net.Listener
+}
+
+// ListenTCP listens for TCP connections on addr.
+func ListenTCP(addr net.Addr) (*TCPListener, error) {
+	listener, err := net.Listen("tcp", addr.String())
+	if err != nil {
+		return nil, errors.Join(intErrors.ErrBadAddr, err)
+	}
+	return &TCPListener{listener: listener}, nil
+}
+
+// Accept waits for the next TCP connection.
+func (l *TCPListener) Accept() (Conn, error) {
+	conn, err := l.listener.Accept()
+	if err != nil {
+		return nil, errors.Join(intErrors.ErrAccept, err)
+	}
+	return streamConn{conn}, nil
+}
+
+// Addr returns the address the listener is bound to.
+func (l *TCPListener) Addr() net.Addr {
+	return l.listener.Addr()
+}
+
+func (l *TCPListener) Close() error {
+	return l.listener.Close()
+}
+
+// DialTCP connects to addr over TCP.
+func DialTCP(addr net.Addr, ctx context.Context) (Conn, error) {
+	dialer := net.Dialer{Timeout: dialTimeout}
+	conn, err := dialer.DialContext(ctx, "tcp", addr.String())
+	if err != nil {
+		return nil, errors.Join(intErrors.ErrBadAddr, err)
+	}
+	return streamConn{conn}, nil
+}
@@ -0,0 +1,66 @@
+package transport
+
+import (
+	"context"
+	"net"
+
+	intErrors "github.com/Onyz107/onynet/errors"
+	"github.com/Onyz107/onynet/internal/kcp"
+)
+
+// Conn is a reliable, ordered connection the authentication, session and heartbeat stack runs over.
+// KCP connections also carry unreliable datagrams, stream transports such as TCP and WebSocket
+// return ErrDatagramUnsupported instead.
+type Conn interface {
+	net.Conn
+	// ReadDatagram waits for the next datagram sent by the peer.
+	ReadDatagram(ctx context.Context) ([]byte, error)
+	// WriteDatagram sends b to the peer as a single datagram.
+	WriteDatagram(b []byte) error
+	// MaxDatagramSize returns the largest datagram the connection can carry, 0 if it carries none.
+	MaxDatagramSize() int
+}
+
+// Listener accepts the connections of a transport.
+type Listener interface {
+	Accept() (Conn, error)
+	Close() error
+}
+
+// KCP returns the Listener of the connections accepted by server.
+func KCP(server *kcp.Server) Listener {
+	return kcpListener{server}
+}
+
+type kcpListener struct {
+	server *kcp.Server
+}
+
+func (l kcpListener) Accept() (Conn, error) {
+	conn, err := l.server.Accept()
+	if err != nil {
+		return nil, err
+	}
+	return conn, nil
+}
+
+func (l kcpListener) Close() error {
+	return l.server.Close()
+}
+
+// streamConn is a Conn over a stream transport, which cannot carry datagrams.
+type streamConn struct {
+	net.Conn
+}
+
+func (streamConn) ReadDatagram(ctx context.Context) ([]byte, error) {
+	return nil, intErrors.ErrDatagramUnsupported
+}
+
+func (streamConn) WriteDatagram(b []byte) error {
+	return intErrors.ErrDatagramUnsupported
+}
+
+func (streamConn) MaxDatagramSize() int {
+	return 0
+}
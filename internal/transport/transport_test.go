@@ -0,0 +1,110 @@
+package transport_test
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	intErrors "github.com/Onyz107/onynet/errors"
+	"github.com/Onyz107/onynet/internal/transport"
+)
+
+// echo accepts a connection of listener and echoes back the first size bytes it receives.
+func echo(tb testing.TB, listener transport.Listener, size int) <-chan transport.Conn {
+	accepted := make(chan transport.Conn, 1)
+	go func() {
+		conn, err := listener.Accept()
+		if err != nil {
+			tb.Error(err)
+			accepted <- nil
+			return
+		}
+		accepted <- conn
+
+		buf := make([]byte, size)
+		if _, err := io.ReadFull(conn, buf); err != nil {
+			tb.Error(err)
+			return
+		}
+		conn.Write(buf)
+	}()
+	return accepted
+}
+
+func roundTrip(tb testing.TB, conn transport.Conn, data []byte) {
+	tb.Helper()
+
+	if _, err := conn.Write(data); err != nil {
+		tb.Fatal(err)
+	}
+	buf := make([]byte, len(data))
+	if _, err := io.ReadFull(conn, buf); err != nil {
+		tb.Fatal(err)
+	}
+	if string(buf) != string(data) {
+		tb.Fatalf("unexpected echo: %q", buf)
+	}
+
+	if _, err := conn.ReadDatagram(context.Background()); !errors.Is(err, intErrors.ErrDatagramUnsupported) {
+		tb.Fatalf("expected ErrDatagramUnsupported, got: %v", err)
+	}
+	if err := conn.WriteDatagram(data); !errors.Is(err, intErrors.ErrDatagramUnsupported) {
+		tb.Fatalf("expected ErrDatagramUnsupported, got: %v", err)
+	}
+	if conn.MaxDatagramSize() != 0 {
+		tb.Fatalf("expected no datagrams, got a max size of %d", conn.MaxDatagramSize())
+	}
+}
+
+func TestTCP(t *testing.T) {
+	listener, err := transport.ListenTCP(&net.TCPAddr{IP: net.IPv4(127, 0, 0, 1)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer listener.Close()
+
+	data := []byte("hello over tcp")
+	accepted := echo(t, listener, len(data))
+
+	conn, err := transport.DialTCP(listener.Addr(), context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+
+	roundTrip(t, conn, data)
+	if serverConn := <-accepted; serverConn.RemoteAddr().String() != conn.LocalAddr().String() {
+		t.Fatalf("expected remote address %s, got: %s", conn.LocalAddr(), serverConn.RemoteAddr())
+	}
+}
+
+func TestWebSocket(t *testing.T) {
+	listener := transport.NewWebSocketListener()
+	defer listener.Close()
+	server := httptest.NewServer(listener)
+	defer server.Close()
+
+	data := []byte(strings.Repeat("hello over websocket ", 1000))
+	accepted := echo(t, listener, len(data))
+
+	conn, err := transport.DialWebSocket("ws"+strings.TrimPrefix(server.URL, "http"), context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+
+	roundTrip(t, conn, data)
+	serverConn := <-accepted
+	if host, _, _ := net.SplitHostPort(serverConn.RemoteAddr().String()); host != "127.0.0.1" {
+		t.Fatalf("expected the remote address of the TCP connection, got: %s", serverConn.RemoteAddr())
+	}
+
+	listener.Close()
+	if _, err := listener.Accept(); !errors.Is(err, intErrors.ErrAccept) {
+		t.Fatalf("expected ErrAccept, got: %v", err)
+	}
+}
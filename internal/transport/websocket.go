@@ -0,0 +1,132 @@
+package transport
+
+import (
+	"context"
+	"errors"
+	"net"
+	"net/http"
+	"sync"
+
+	intErrors "github.com/Onyz107/onynet/errors"
+	"golang.org/x/net/websocket"
+)
+
+// WebSocketListener accepts the connections upgraded from the HTTP requests it serves,
+// it is mounted on an HTTP server which may terminate TLS.
+type WebSocketListener struct {
+	conns chan Conn
+	done  chan struct{}
+	once  sync.Once
+}
+
+// NewWebSocketListener returns a listener whose ServeHTTP upgrades requests to WebSocket connections.
+func NewWebSocketListener() *WebSocketListener {
+	return &WebSocketListener{
+		conns: make(chan Conn),
+		done:  make(chan struct{}),
+	}
+}
+
+// ServeHTTP upgrades the request and hands the connection to Accept.
+// It returns once the connection is closed, as the HTTP server closes it when the handler returns.
+func (l *WebSocketListener) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	server := websocket.Server{
+		// Any origin is accepted, peers authenticate themselves once connected.
+		Handshake: func(*websocket.Config, *http.Request) error { return nil },
+		Handler:   l.serve,
+	}
+	server.ServeHTTP(w, r)
+}
+
+func (l *WebSocketListener) serve(ws *websocket.Conn) {
+	ws.PayloadType = websocket.BinaryFrame
+
+	conn := &webSocketConn{
+		streamConn: streamConn{ws},
+		remote:     requestAddr(ws.Request().RemoteAddr),
+		closed:     make(chan struct{}),
+	}
+	if local, ok := ws.Request().Context().Value(http.LocalAddrContextKey).(net.Addr); ok {
+		conn.local = local
+	}
+
+	select {
+	case l.conns <- conn:
+	case <-l.done:
+		return
+	}
+	<-conn.closed
+}
+
+// Accept waits for the next upgraded connection.
+func (l *WebSocketListener) Accept() (Conn, error) {
+	select {
+	case conn := <-l.conns:
+		return conn, nil
+	case <-l.done:
+		return nil, errors.Join(intErrors.ErrAccept, net.ErrClosed)
+	}
+}
+
+// Close stops handing connections to Accept, requests served afterwards are closed right away.
+func (l *WebSocketListener) Close() error {
+	l.once.Do(func() { close(l.done) })
+	return nil
+}
+
+// DialWebSocket connects to the WebSocket server at url, such as "wss://example.com/onynet".
+func DialWebSocket(url string, ctx context.Context) (Conn, error) {
+	config, err := websocket.NewConfig(url, webSocketOrigin)
+	if err != nil {
+		return nil, errors.Join(intErrors.ErrBadAddr, err)
+	}
+	config.Dialer = &net.Dialer{Timeout: dialTimeout}
+
+	ws, err := config.DialContext(ctx)
+	if err != nil {
+		return nil, errors.Join(intErrors.ErrBadAddr, err)
+	}
+	ws.PayloadType = websocket.BinaryFrame
+	return streamConn{ws}, nil
+}
+
+// webSocketConn is a connection upgraded by a WebSocketListener. It reports the addresses of the
+// underlying TCP connection, where the WebSocket library reports the origin and the URL.
+type webSocketConn struct {
+	streamConn
+	local  net.Addr
+	remote net.Addr
+	closed chan struct{}
+	once   sync.Once
+}
+
+func (c *webSocketConn) Close() error {
+	c.once.Do(func() { close(c.closed) })
+	return c.streamConn.Close()
+}
+
+func (c *webSocketConn) LocalAddr() net.Addr {
+	if c.local == nil {
+		return c.streamConn.LocalAddr()
+	}
+	return c.local
+}
+
+func (c *webSocketConn) RemoteAddr() net.Addr {
+	return c.remote
+}
+
+// requestAddr parses the remote address of an HTTP request, falling back to the raw string.
+func requestAddr(addr string) net.Addr {
+	if tcpAddr, err := net.ResolveTCPAddr("tcp", addr); err == nil {
+		return tcpAddr
+	}
+	return rawAddr(addr)
+}
+
+// rawAddr is an address which could not be parsed.
+type rawAddr string
+
+func (a rawAddr) Network() string { return "tcp" }
+
+func (a rawAddr) String() string { return string(a) }
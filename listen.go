@@ -0,0 +1,103 @@
+package onynet
+
+import (
+	"errors"
+	"net"
+	"net/http"
+
+	intErrors "github.com/Onyz107/onynet/errors"
+	intTransport "github.com/Onyz107/onynet/internal/transport"
+)
+
+// ListenTCP also accepts clients over TCP on addr, for networks which block UDP, and returns the address
+// it listens on. TCP clients go through the same connection filters, admission control and handshake as
+// KCP ones and are returned by Accept alike, but their connections carry no datagrams.
+// The listener is closed along with the server.
+//
+// Possible errors:
+//   - ErrNewServer: the server is closed
+//   - ErrBadAddr: failed to listen on the given address
+func (s *Server) ListenTCP(addr net.Addr) (net.Addr, error) {
+	listener, err := intTransport.ListenTCP(addr)
+	if err != nil {
+		return nil, err
+	}
+	if err := s.addListener(listener); err != nil {
+		listener.Close()
+		return nil, err
+	}
+	return listener.Addr(), nil
+}
+
+// WebSocketHandler returns an http.Handler which accepts clients over WebSocket, for networks which only let
+// HTTP through. It is mounted on an HTTP server, which may terminate TLS, and dialed with a WebSocketAddr.
+// WebSocket clients go through the same connection filters, admission control and handshake as KCP ones
+// and are returned by Accept alike, but their connections carry no datagrams.
+// Every call returns the same handler, which stops accepting clients once the server is closed.
+func (s *Server) WebSocketHandler() http.Handler {
+	s.listenMu.Lock()
+	defer s.listenMu.Unlock()
+
+	if s.webSocket == nil {
+		s.webSocket = intTransport.NewWebSocketListener()
+		if s.listenersClosed {
+			s.webSocket.Close()
+		} else {
+			s.listeners = append(s.listeners, s.webSocket)
+			go s.admitLoop(s.webSocket, false)
+		}
+	}
+	return s.webSocket
+}
+
+// ServeConn hands conn, a reliable connection established elsewhere such as a stream relayed by another
+// server, to the server as if one of its listeners accepted it. The client dialing the other end with DialConn
+// goes through the same connection filters, admission control and handshake as KCP ones and is returned
+// by Accept alike, but its connection carries no datagrams. ServeConn returns once the server takes conn,
+// which is closed along with the client.
+//
+// Possible errors:
+//   - ErrAccept: the server is closed
+func (s *Server) ServeConn(conn net.Conn) error {
+	s.listenMu.Lock()
+	if s.connListener == nil {
+		s.connListener = intTransport.NewConnListener()
+		if s.listenersClosed {
+			s.connListener.Close()
+		} else {
+			s.listeners = append(s.listeners, s.connListener)
+			go s.admitLoop(s.connListener, false)
+		}
+	}
+	listener := s.connListener
+	s.listenMu.Unlock()
+
+	return listener.Serve(conn)
+}
+
+func (s *Server) addListener(listener intTransport.Listener) error {
+	s.listenMu.Lock()
+	defer s.listenMu.Unlock()
+
+	if s.listenersClosed {
+		return errors.Join(intErrors.ErrNewServer, net.ErrClosed)
+	}
+	s.listeners = append(s.listeners, listener)
+	go s.admitLoop(listener, false)
+	return nil
+}
+
+func (s *Server) closeListeners() error {
+	s.listenMu.Lock()
+	defer s.listenMu.Unlock()
+
+	var errs []error
+	for _, listener := range s.listeners {
+		if err := listener.Close(); err != nil {
+			errs = append(errs, err)
+		}
+	}
+	s.listeners = nil
+	s.listenersClosed = true
+	return errors.Join(errs...)
+}
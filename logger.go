@@ -1,5 +1,37 @@
 package onynet
 
-import "github.com/Onyz107/onynet/internal/logger"
+import "log/slog"
 
-var Log = logger.Log
+// SetLogger sets the logger of the server, its connections and their streams.
+// Connection logs carry the "client_id" and "remote_addr" attributes and stream logs the "stream" attribute.
+// A nil logger means slog.Default(), which is the default.
+// Connections already accepted keep the logger they were accepted with.
+func (s *Server) SetLogger(logger *slog.Logger) {
+	if logger == nil {
+		logger = slog.Default()
+	}
+	s.server.SetLogger(logger)
+}
+
+func (s *Server) loadLogger() *slog.Logger {
+	return s.server.Logger()
+}
+
+// WithLogger sets the logger of the client and its streams.
+// Its logs carry the "remote_addr" attribute and stream logs the "stream" attribute.
+// A nil logger means slog.Default(), which is the default.
+func WithLogger(logger *slog.Logger) DialOption {
+	return func(o *dialOptions) {
+		o.logger = logger
+	}
+}
+
+// Logger returns the logger of the connection, with the "client_id" and "remote_addr" attributes.
+func (cn *ClientConn) Logger() *slog.Logger {
+	return cn.logger
+}
+
+// Logger returns the logger of the client, with the "remote_addr" attribute.
+func (c *Client) Logger() *slog.Logger {
+	return c.logger
+}
@@ -0,0 +1,51 @@
+package onynet
+
+import (
+	"errors"
+
+	intErrors "github.com/Onyz107/onynet/errors"
+	"github.com/Onyz107/onynet/metrics"
+)
+
+// SetMetrics records the server's connections, handshakes, streams and transferred bytes in m, a nil m stops recording.
+// Connections already accepted keep recording in the metrics they were accepted with.
+func (s *Server) SetMetrics(m *metrics.Metrics) {
+	s.metrics.Store(m)
+}
+
+func (s *Server) loadMetrics() *metrics.Metrics {
+	return s.metrics.Load()
+}
+
+// WithMetrics records the client's connection, handshake, streams, transferred bytes and heartbeat round trip times in m.
+func WithMetrics(m *metrics.Metrics) DialOption {
+	return func(o *dialOptions) {
+		o.metrics = m
+	}
+}
+
+// handshakeResult turns the error which failed a handshake into the result label of the handshake metrics.
+func handshakeResult(err error) string {
+	switch {
+	case err == nil:
+		return metrics.HandshakeSucceeded
+	case errors.Is(err, intErrors.ErrConnDenied):
+		return "denied"
+	case errors.Is(err, intErrors.ErrTooManyClients):
+		return "too_many_clients"
+	case errors.Is(err, intErrors.ErrTooManyFromIP):
+		return "too_many_from_ip"
+	case errors.Is(err, intErrors.ErrHandshakeRate):
+		return "handshake_rate"
+	case errors.Is(err, intErrors.ErrRejected):
+		return "rejected"
+	case errors.Is(err, intErrors.ErrInvalidCookie):
+		return "cookie_failed"
+	case errors.Is(err, intErrors.ErrAuth):
+		return "auth_failed"
+	case errors.Is(err, intErrors.ErrHeartbeatStream):
+		return "heartbeat_failed"
+	default:
+		return "error"
+	}
+}
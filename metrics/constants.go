@@ -0,0 +1,21 @@
+package metrics
+
+import "time"
+
+// Sides label the metrics with the end of the connection they were recorded on.
+const (
+	SideServer = "server"
+	SideClient = "client"
+)
+
+// HandshakeSucceeded is the result label of a handshake which connected the client.
+const HandshakeSucceeded = "succeeded"
+
+// OtherStream is the name label of the streams whose name was not registered with RegisterStreams.
+const OtherStream = "other"
+
+// kcpPollInterval is how often the KCP statistics are read.
+const kcpPollInterval = 5 * time.Second
+
+// RTTBuckets are the upper bounds, in seconds, of the heartbeat RTT histogram.
+var RTTBuckets = []float64{0.001, 0.005, 0.01, 0.025, 0.05, 0.1, 0.25, 0.5, 1, 2.5, 5}
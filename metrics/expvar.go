@@ -0,0 +1,74 @@
+package metrics
+
+import (
+	"expvar"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// ExpvarProvider is a Provider publishing the metrics with the standard library's expvar package,
+// which serves them as JSON on /debug/vars of the default HTTP mux.
+// Every metric is an expvar.Map whose keys are the label values joined with commas.
+// Histograms hold, under each key, the count and sum of the observations and the count of each bucket.
+// Metrics published under a name already taken by an expvar.Map reuse it, so several Metrics can be created.
+type ExpvarProvider struct{}
+
+func (ExpvarProvider) Counter(name, help string, labelNames ...string) Counter {
+	return expvarCounter{publish(name)}
+}
+
+func (ExpvarProvider) Gauge(name, help string, labelNames ...string) Gauge {
+	return expvarCounter{publish(name)}
+}
+
+func (ExpvarProvider) Histogram(name, help string, buckets []float64, labelNames ...string) Histogram {
+	return &expvarHistogram{values: publish(name), buckets: buckets}
+}
+
+var publishMu sync.Mutex
+
+// publish returns the expvar.Map published under name, publishing a new one if there is none.
+func publish(name string) *expvar.Map {
+	publishMu.Lock()
+	defer publishMu.Unlock()
+
+	if values, ok := expvar.Get(name).(*expvar.Map); ok {
+		return values
+	}
+	return expvar.NewMap(name)
+}
+
+type expvarCounter struct {
+	values *expvar.Map
+}
+
+func (c expvarCounter) Add(delta float64, labelValues ...string) {
+	c.values.AddFloat(strings.Join(labelValues, ","), delta)
+}
+
+type expvarHistogram struct {
+	values  *expvar.Map
+	buckets []float64
+	mu      sync.Mutex
+}
+
+func (h *expvarHistogram) Observe(value float64, labelValues ...string) {
+	key := strings.Join(labelValues, ",")
+
+	h.mu.Lock()
+	observations, ok := h.values.Get(key).(*expvar.Map)
+	if !ok {
+		observations = new(expvar.Map)
+		h.values.Set(key, observations)
+	}
+	h.mu.Unlock()
+
+	observations.Add("count", 1)
+	observations.AddFloat("sum", value)
+	for _, bucket := range h.buckets {
+		if value <= bucket {
+			observations.Add("le_"+strconv.FormatFloat(bucket, 'g', -1, 64), 1)
+		}
+	}
+}
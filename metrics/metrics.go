@@ -0,0 +1,191 @@
+// Package metrics records counters, gauges and histograms about onynet servers and clients through
+// a small Provider interface, so they can be exported to Prometheus, expvar or anything else
+// without onynet depending on it. ExpvarProvider publishes them with the standard library's expvar.
+package metrics
+
+import (
+	"sync"
+	"time"
+
+	"github.com/xtaci/kcp-go/v5"
+)
+
+// Metrics records what happens on the servers and clients it is given to, see Server.SetMetrics and WithMetrics.
+// A nil *Metrics records nothing.
+type Metrics struct {
+	connections   Gauge
+	handshakes    Counter
+	streams       Counter
+	bytesSent     Counter
+	bytesReceived Counter
+	heartbeatRTT  Histogram
+
+	streamNames   map[string]bool
+	streamNamesMu sync.RWMutex
+
+	kcp     []kcpCounter
+	kcpLast kcp.Snmp
+	kcpMu   sync.Mutex
+
+	done chan struct{}
+	once sync.Once
+}
+
+// kcpCounter exports a field of the KCP statistics as a counter.
+type kcpCounter struct {
+	counter Counter
+	value   func(*kcp.Snmp) uint64
+}
+
+// New creates the onynet metrics with provider and starts reading the KCP statistics in the background
+// until Close is called. The KCP statistics are global to the process, they cover every connection.
+//
+// The metrics are:
+//   - onynet_connections_active{side}: gauge of the connections established and not yet closed
+//   - onynet_handshakes_total{side,result}: counter of the handshakes by result, "succeeded" or the reason they failed
+//   - onynet_streams_opened_total{side,name}: counter of the streams opened or accepted by name, the names
+//     not registered with RegisterStreams are counted under OtherStream
+//   - onynet_bytes_sent_total{side,mode} and onynet_bytes_received_total{side,mode}: counters of the bytes
+//     read from and written to streams, mode is "encrypted" for the encrypted transfer methods and "plain" otherwise
+//   - onynet_heartbeat_rtt_seconds{side}: histogram of the heartbeat round trip times, measured by clients
+//   - onynet_kcp_*_total: counters of the KCP statistics, such as retransmitted and lost segments
+func New(provider Provider) *Metrics {
+	m := &Metrics{
+		connections:   provider.Gauge("onynet_connections_active", "Connections established and not yet closed.", "side"),
+		handshakes:    provider.Counter("onynet_handshakes_total", "Handshakes by result.", "side", "result"),
+		streams:       provider.Counter("onynet_streams_opened_total", "Streams opened or accepted by name.", "side", "name"),
+		bytesSent:     provider.Counter("onynet_bytes_sent_total", "Bytes written to streams.", "side", "mode"),
+		bytesReceived: provider.Counter("onynet_bytes_received_total", "Bytes read from streams.", "side", "mode"),
+		heartbeatRTT:  provider.Histogram("onynet_heartbeat_rtt_seconds", "Heartbeat round trip times.", RTTBuckets, "side"),
+		streamNames:   make(map[string]bool),
+		done:          make(chan struct{}),
+	}
+
+	m.kcp = []kcpCounter{
+		{provider.Counter("onynet_kcp_retransmitted_segments_total", "KCP segments retransmitted."), func(s *kcp.Snmp) uint64 { return s.RetransSegs }},
+		{provider.Counter("onynet_kcp_fast_retransmitted_segments_total", "KCP segments retransmitted before their timeout."), func(s *kcp.Snmp) uint64 { return s.FastRetransSegs }},
+		{provider.Counter("onynet_kcp_lost_segments_total", "KCP segments inferred as lost."), func(s *kcp.Snmp) uint64 { return s.LostSegs }},
+		{provider.Counter("onynet_kcp_duplicate_segments_total", "KCP segments received more than once."), func(s *kcp.Snmp) uint64 { return s.RepeatSegs }},
+		{provider.Counter("onynet_kcp_input_errors_total", "Packets KCP failed to read or process."), func(s *kcp.Snmp) uint64 { return s.InErrs + s.InCsumErrors + s.KCPInErrors }},
+		{provider.Counter("onynet_kcp_udp_bytes_sent_total", "UDP bytes sent by KCP."), func(s *kcp.Snmp) uint64 { return s.OutBytes }},
+		{provider.Counter("onynet_kcp_udp_bytes_received_total", "UDP bytes received by KCP."), func(s *kcp.Snmp) uint64 { return s.InBytes }},
+	}
+	m.kcpLast = *kcp.DefaultSnmp.Copy()
+
+	go m.pollKCP()
+
+	return m
+}
+
+// Close stops reading the KCP statistics.
+func (m *Metrics) Close() {
+	if m == nil {
+		return
+	}
+	m.once.Do(func() { close(m.done) })
+}
+
+func (m *Metrics) pollKCP() {
+	ticker := time.NewTicker(kcpPollInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-m.done:
+			return
+		case <-ticker.C:
+			m.collectKCP()
+		}
+	}
+}
+
+// collectKCP adds the growth of the KCP statistics since the last collection to their counters.
+func (m *Metrics) collectKCP() {
+	m.kcpMu.Lock()
+	defer m.kcpMu.Unlock()
+
+	snmp := kcp.DefaultSnmp.Copy()
+	for _, c := range m.kcp {
+		if now, last := c.value(snmp), c.value(&m.kcpLast); now > last {
+			c.counter.Add(float64(now - last))
+		}
+	}
+	m.kcpLast = *snmp
+}
+
+// ConnectionOpened records a connection established on side.
+func (m *Metrics) ConnectionOpened(side string) {
+	if m != nil {
+		m.connections.Add(1, side)
+	}
+}
+
+// ConnectionClosed records the close of a connection established on side.
+func (m *Metrics) ConnectionClosed(side string) {
+	if m != nil {
+		m.connections.Add(-1, side)
+	}
+}
+
+// Handshake records a handshake on side, result is HandshakeSucceeded or the reason it failed.
+func (m *Metrics) Handshake(side, result string) {
+	if m != nil {
+		m.handshakes.Add(1, side, result)
+	}
+}
+
+// RegisterStreams lets the streams with the given names be counted under their own name.
+// Stream names are chosen by the peer, so the other ones share the OtherStream label
+// and cannot create an unbounded number of series.
+func (m *Metrics) RegisterStreams(names ...string) {
+	if m == nil {
+		return
+	}
+	m.streamNamesMu.Lock()
+	defer m.streamNamesMu.Unlock()
+	for _, name := range names {
+		m.streamNames[name] = true
+	}
+}
+
+// StreamOpened records a stream opened or accepted on side, under OtherStream if name is not registered.
+func (m *Metrics) StreamOpened(side, name string) {
+	if m == nil {
+		return
+	}
+	m.streamNamesMu.RLock()
+	registered := m.streamNames[name]
+	m.streamNamesMu.RUnlock()
+	if !registered {
+		name = OtherStream
+	}
+	m.streams.Add(1, side, name)
+}
+
+// BytesSent records n bytes written to a stream on side.
+func (m *Metrics) BytesSent(side string, n int, encrypted bool) {
+	if m != nil && n > 0 {
+		m.bytesSent.Add(float64(n), side, mode(encrypted))
+	}
+}
+
+// BytesReceived records n bytes read from a stream on side.
+func (m *Metrics) BytesReceived(side string, n int, encrypted bool) {
+	if m != nil && n > 0 {
+		m.bytesReceived.Add(float64(n), side, mode(encrypted))
+	}
+}
+
+// HeartbeatRTT records the round trip time of a heartbeat on side.
+func (m *Metrics) HeartbeatRTT(side string, rtt time.Duration) {
+	if m != nil {
+		m.heartbeatRTT.Observe(rtt.Seconds(), side)
+	}
+}
+
+func mode(encrypted bool) string {
+	if encrypted {
+		return "encrypted"
+	}
+	return "plain"
+}
@@ -0,0 +1,110 @@
+package metrics
+
+import (
+	"expvar"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// recorder is a Provider keeping the values of its metrics in memory.
+type recorder struct {
+	values map[string]float64
+	mu     sync.Mutex
+}
+
+type recorded struct {
+	r    *recorder
+	name string
+}
+
+func (r *recorder) Counter(name, help string, labelNames ...string) Counter { return recorded{r, name} }
+func (r *recorder) Gauge(name, help string, labelNames ...string) Gauge     { return recorded{r, name} }
+func (r *recorder) Histogram(name, help string, buckets []float64, labelNames ...string) Histogram {
+	return recorded{r, name}
+}
+
+func (m recorded) Add(delta float64, labelValues ...string) {
+	m.r.mu.Lock()
+	defer m.r.mu.Unlock()
+	m.r.values[m.key(labelValues)] += delta
+}
+
+func (m recorded) Observe(value float64, labelValues ...string) {
+	m.Add(value, labelValues...)
+}
+
+func (m recorded) key(labelValues []string) string {
+	return m.name + "{" + strings.Join(labelValues, ",") + "}"
+}
+
+func (r *recorder) get(key string) float64 {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.values[key]
+}
+
+func TestMetrics(t *testing.T) {
+	r := &recorder{values: make(map[string]float64)}
+	m := New(r)
+	defer m.Close()
+
+	m.ConnectionOpened(SideServer)
+	m.ConnectionOpened(SideServer)
+	m.ConnectionClosed(SideServer)
+	m.Handshake(SideServer, HandshakeSucceeded)
+	m.RegisterStreams("chat")
+	m.StreamOpened(SideClient, "chat")
+	m.StreamOpened(SideServer, "made-up-1")
+	m.StreamOpened(SideServer, "made-up-2")
+	m.BytesSent(SideClient, 100, true)
+	m.BytesSent(SideClient, 20, false)
+	m.BytesReceived(SideServer, 0, false)
+	m.HeartbeatRTT(SideClient, 250*time.Millisecond)
+
+	for key, want := range map[string]float64{
+		"onynet_connections_active{server}":             1,
+		"onynet_handshakes_total{server,succeeded}":     1,
+		"onynet_streams_opened_total{client,chat}":      1,
+		"onynet_streams_opened_total{server,other}":     2,
+		"onynet_streams_opened_total{server,made-up-1}": 0,
+		"onynet_bytes_sent_total{client,encrypted}":     100,
+		"onynet_bytes_sent_total{client,plain}":         20,
+		"onynet_bytes_received_total{server,plain}":     0,
+		"onynet_heartbeat_rtt_seconds{client}":          0.25,
+		"onynet_kcp_retransmitted_segments_total{}":     0,
+	} {
+		if got := r.get(key); got != want {
+			t.Errorf("expected %s to be %v, got: %v", key, want, got)
+		}
+	}
+
+	var nilMetrics *Metrics
+	nilMetrics.ConnectionOpened(SideServer) // must not panic
+	nilMetrics.Close()
+}
+
+func TestExpvarProvider(t *testing.T) {
+	m := New(ExpvarProvider{})
+	defer m.Close()
+	again := New(ExpvarProvider{}) // publishing the same names twice must not panic
+	defer again.Close()
+
+	m.Handshake(SideServer, HandshakeSucceeded)
+	again.Handshake(SideServer, HandshakeSucceeded)
+	m.HeartbeatRTT(SideClient, 20*time.Millisecond)
+
+	handshakes := expvar.Get("onynet_handshakes_total").(*expvar.Map)
+	if got := handshakes.Get("server,succeeded").String(); got != "2" {
+		t.Fatalf("expected 2 handshakes, got: %s", got)
+	}
+
+	rtt := expvar.Get("onynet_heartbeat_rtt_seconds").(*expvar.Map).Get("client").(*expvar.Map)
+	if got := rtt.Get("count").String(); got != "1" {
+		t.Fatalf("expected 1 observation, got: %s", got)
+	}
+	if rtt.Get("le_0.025") == nil || rtt.Get("le_0.01") != nil {
+		t.Fatalf("expected the observation in the buckets from 0.025 up, got: %s", rtt.String())
+	}
+}
@@ -0,0 +1,28 @@
+package metrics
+
+// Counter is a value which only goes up, such as a number of bytes sent.
+type Counter interface {
+	// Add increases the counter of the given label values by delta.
+	Add(delta float64, labelValues ...string)
+}
+
+// Gauge is a value which goes up and down, such as a number of connected clients.
+type Gauge interface {
+	// Add changes the gauge of the given label values by delta, which may be negative.
+	Add(delta float64, labelValues ...string)
+}
+
+// Histogram counts observations, such as round trip times, in buckets.
+type Histogram interface {
+	// Observe adds value to the histogram of the given label values.
+	Observe(value float64, labelValues ...string)
+}
+
+// Provider creates the metrics onynet records. It is implemented by adapting a metrics library,
+// for example by wrapping a prometheus.CounterVec created with the same name, help and label names
+// and calling WithLabelValues(labelValues...).Add(delta) from Add. Every metric is created once by New.
+type Provider interface {
+	Counter(name, help string, labelNames ...string) Counter
+	Gauge(name, help string, labelNames ...string) Gauge
+	Histogram(name, help string, buckets []float64, labelNames ...string) Histogram
+}
@@ -0,0 +1,284 @@
+package onynettest
+
+import (
+	"container/heap"
+	"math/rand/v2"
+	"net"
+	"sync"
+	"time"
+
+	"github.com/Onyz107/onynet"
+	"github.com/Onyz107/onynet/internal/kcp"
+)
+
+// Profile describes how an Impairment degrades the packets sent through a socket, its zero value changes nothing.
+// Like netem, jitter and reordering both let packets overtake the ones sent before them.
+type Profile struct {
+	// Loss is the probability of a packet being dropped.
+	Loss float64
+	// Delay is added to every packet.
+	Delay time.Duration
+	// Jitter is the upper bound of a random delay added on top of Delay.
+	Jitter time.Duration
+	// Reorder is the probability of a packet skipping the delay and overtaking the packets still delayed.
+	Reorder float64
+	// Duplicate is the probability of a packet being sent twice.
+	Duplicate float64
+	// Bandwidth caps the bytes sent per second by each socket, 0 means unlimited.
+	// Packets over the cap are queued, not dropped.
+	Bandwidth int
+}
+
+var (
+	// Mobile is a mobile data link with moderate latency, some loss and a 1MB/s uplink.
+	Mobile = Profile{Loss: 0.02, Delay: 60 * time.Millisecond, Jitter: 20 * time.Millisecond, Reorder: 0.01, Bandwidth: 1 << 20}
+	// Lossy is a short link dropping, duplicating and reordering many packets.
+	Lossy = Profile{Loss: 0.1, Delay: 10 * time.Millisecond, Jitter: 5 * time.Millisecond, Reorder: 0.05, Duplicate: 0.05}
+	// Satellite is a geostationary satellite link with high latency and a 512KB/s uplink.
+	Satellite = Profile{Loss: 0.01, Delay: 300 * time.Millisecond, Jitter: 10 * time.Millisecond, Bandwidth: 512 << 10}
+)
+
+// Impairment injects loss, delay, reordering, duplication, bandwidth caps and partitions into the packets
+// written to the sockets it wraps. Reads are left untouched, so wrapping both ends impairs both directions.
+// Its random decisions are drawn from a generator seeded by the seed given to NewImpairment,
+// which makes a run reproducible as long as the packets are sent in the same order.
+type Impairment struct {
+	mu          sync.Mutex
+	profile     Profile
+	rng         *rand.Rand
+	partitioned bool
+}
+
+// NewImpairment returns an impairment applying profile with decisions drawn from a generator seeded with seed.
+func NewImpairment(profile Profile, seed uint64) *Impairment {
+	return &Impairment{
+		profile: profile,
+		rng:     rand.New(rand.NewPCG(seed, seed)),
+	}
+}
+
+// SetProfile replaces the profile, it applies to the packets written afterwards.
+func (i *Impairment) SetProfile(profile Profile) {
+	i.mu.Lock()
+	defer i.mu.Unlock()
+	i.profile = profile
+}
+
+// Partition drops every packet, including the ones already delayed, until Heal is called.
+func (i *Impairment) Partition() {
+	i.mu.Lock()
+	defer i.mu.Unlock()
+	i.partitioned = true
+}
+
+// Heal ends a partition.
+func (i *Impairment) Heal() {
+	i.mu.Lock()
+	defer i.mu.Unlock()
+	i.partitioned = false
+}
+
+// Transport wraps every socket created by transport, a nil transport means UDP.
+func (i *Impairment) Transport(transport onynet.PacketTransport) onynet.PacketTransport {
+	if transport == nil {
+		transport = kcp.UDP
+	}
+	return &impairedTransport{transport: transport, impairment: i}
+}
+
+// Conn wraps conn so the packets written to it are impaired.
+func (i *Impairment) Conn(conn net.PacketConn) net.PacketConn {
+	c := &impairedConn{
+		PacketConn: conn,
+		impairment: i,
+		wake:       make(chan struct{}, 1),
+		done:       make(chan struct{}),
+	}
+	go c.sendLoop()
+	return c
+}
+
+// decide draws the fate of a packet: how many copies are sent, whether they skip the delay and how long they are delayed.
+func (i *Impairment) decide() (profile Profile, copies int, reordered bool, delay time.Duration) {
+	i.mu.Lock()
+	defer i.mu.Unlock()
+
+	profile = i.profile
+	if i.partitioned || i.rng.Float64() < profile.Loss {
+		return profile, 0, false, 0
+	}
+
+	copies = 1
+	if i.rng.Float64() < profile.Duplicate {
+		copies = 2
+	}
+	if i.rng.Float64() < profile.Reorder {
+		return profile, copies, true, 0
+	}
+
+	delay = profile.Delay
+	if profile.Jitter > 0 {
+		delay += time.Duration(i.rng.Int64N(int64(profile.Jitter)))
+	}
+	return profile, copies, false, delay
+}
+
+func (i *Impairment) isPartitioned() bool {
+	i.mu.Lock()
+	defer i.mu.Unlock()
+	return i.partitioned
+}
+
+type impairedTransport struct {
+	transport  onynet.PacketTransport
+	impairment *Impairment
+}
+
+func (t *impairedTransport) Listen(addr net.Addr) (net.PacketConn, error) {
+	conn, err := t.transport.Listen(addr)
+	if err != nil {
+		return nil, err
+	}
+	return t.impairment.Conn(conn), nil
+}
+
+func (t *impairedTransport) Dial(addr net.Addr) (net.PacketConn, net.Addr, error) {
+	conn, remote, err := t.transport.Dial(addr)
+	if err != nil {
+		return nil, nil, err
+	}
+	return t.impairment.Conn(conn), remote, nil
+}
+
+// impairedConn delays the packets written to it in a queue ordered by send time, which a single goroutine drains.
+type impairedConn struct {
+	net.PacketConn
+	impairment *Impairment
+
+	mu       sync.Mutex
+	queue    packetQueue
+	seq      uint64
+	nextFree time.Time // when the bandwidth cap lets the next packet start being sent
+
+	wake      chan struct{}
+	done      chan struct{}
+	closeOnce sync.Once
+}
+
+func (c *impairedConn) WriteTo(b []byte, addr net.Addr) (int, error) {
+	select {
+	case <-c.done:
+		return 0, net.ErrClosed
+	default:
+	}
+
+	profile, copies, reordered, delay := c.impairment.decide()
+	if copies == 0 {
+		return len(b), nil
+	}
+
+	if reordered {
+		for range copies {
+			if _, err := c.PacketConn.WriteTo(b, addr); err != nil {
+				return 0, err
+			}
+		}
+		return len(b), nil
+	}
+
+	data := make([]byte, len(b))
+	copy(data, b)
+
+	c.mu.Lock()
+	now := time.Now()
+	sendAt := now
+	if profile.Bandwidth > 0 {
+		start := now
+		if c.nextFree.After(now) {
+			start = c.nextFree
+		}
+		c.nextFree = start.Add(time.Duration(len(b)) * time.Second / time.Duration(profile.Bandwidth))
+		sendAt = c.nextFree
+	}
+	for range copies {
+		c.seq++
+		heap.Push(&c.queue, &delayedPacket{data: data, addr: addr, sendAt: sendAt.Add(delay), seq: c.seq})
+	}
+	c.mu.Unlock()
+
+	select {
+	case c.wake <- struct{}{}:
+	default:
+	}
+	return len(b), nil
+}
+
+func (c *impairedConn) sendLoop() {
+	timer := time.NewTimer(time.Hour)
+	defer timer.Stop()
+
+	for {
+		c.mu.Lock()
+		var next *delayedPacket
+		if len(c.queue) > 0 {
+			next = c.queue[0]
+			if !next.sendAt.After(time.Now()) {
+				heap.Pop(&c.queue)
+				c.mu.Unlock()
+				if !c.impairment.isPartitioned() {
+					c.PacketConn.WriteTo(next.data, next.addr)
+				}
+				continue
+			}
+		}
+		c.mu.Unlock()
+
+		wait := time.Hour
+		if next != nil {
+			wait = time.Until(next.sendAt)
+		}
+		timer.Reset(wait)
+
+		select {
+		case <-timer.C:
+		case <-c.wake:
+		case <-c.done:
+			return
+		}
+	}
+}
+
+func (c *impairedConn) Close() error {
+	c.closeOnce.Do(func() { close(c.done) })
+	return c.PacketConn.Close()
+}
+
+// delayedPacket is a packet waiting in the queue of an impairedConn.
+type delayedPacket struct {
+	data   []byte
+	addr   net.Addr
+	sendAt time.Time
+	seq    uint64 // keeps packets due at the same time in the order they were written
+}
+
+type packetQueue []*delayedPacket
+
+func (q packetQueue) Len() int { return len(q) }
+
+func (q packetQueue) Less(i, j int) bool {
+	if q[i].sendAt.Equal(q[j].sendAt) {
+		return q[i].seq < q[j].seq
+	}
+	return q[i].sendAt.Before(q[j].sendAt)
+}
+
+func (q packetQueue) Swap(i, j int) { q[i], q[j] = q[j], q[i] }
+
+func (q *packetQueue) Push(x any) { *q = append(*q, x.(*delayedPacket)) }
+
+func (q *packetQueue) Pop() any {
+	old := *q
+	packet := old[len(old)-1]
+	*q = old[:len(old)-1]
+	return packet
+}
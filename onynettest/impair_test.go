@@ -0,0 +1,216 @@
+package onynettest_test
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"crypto/rsa"
+	"io"
+	"net"
+	"slices"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/Onyz107/onynet"
+	"github.com/Onyz107/onynet/metrics"
+	"github.com/Onyz107/onynet/onynettest"
+)
+
+var privateKey = sync.OnceValue(func() *rsa.PrivateKey {
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		panic(err)
+	}
+	return key
+})
+
+func newImpairedPair(tb testing.TB, impairment *onynettest.Impairment, opts ...onynet.DialOption) (*onynet.Client, *onynet.ClientConn) {
+	tb.Helper()
+
+	transport := impairment.Transport(onynettest.NewNetwork())
+	server := onynettest.NewServer(tb, transport, privateKey())
+	return onynettest.Connect(tb, transport, server, &privateKey().PublicKey, opts...)
+}
+
+// transfer sends size random bytes from client to clientConn through a stream and checks they all arrived intact.
+func transfer(tb testing.TB, client *onynet.Client, clientConn *onynet.ClientConn, size int, timeout time.Duration) {
+	tb.Helper()
+
+	data := make([]byte, size)
+	rand.Read(data)
+
+	received := make(chan []byte, 1)
+	go func() {
+		stream, err := clientConn.AcceptStream("bulk", context.Background(), timeout)
+		if err != nil {
+			tb.Error(err)
+			received <- nil
+			return
+		}
+		defer stream.Close()
+		stream.SetReadDeadline(time.Now().Add(timeout))
+		buf := make([]byte, size)
+		if _, err := io.ReadFull(stream, buf); err != nil {
+			tb.Error(err)
+		}
+		received <- buf
+	}()
+
+	stream, err := client.OpenStream("bulk", context.Background(), timeout)
+	if err != nil {
+		tb.Fatal(err)
+	}
+	defer stream.Close()
+	if _, err := stream.Write(data); err != nil {
+		tb.Fatal(err)
+	}
+
+	if buf := <-received; !bytes.Equal(buf, data) {
+		tb.Fatal("received data does not match the data sent")
+	}
+}
+
+func TestImpairedTransfer(t *testing.T) {
+	profiles := map[string]onynettest.Profile{
+		"Mobile":    onynettest.Mobile,
+		"Lossy":     onynettest.Lossy,
+		"Satellite": onynettest.Satellite,
+	}
+
+	for name, profile := range profiles {
+		t.Run(name, func(t *testing.T) {
+			t.Parallel()
+			client, clientConn := newImpairedPair(t, onynettest.NewImpairment(profile, 1))
+			transfer(t, client, clientConn, 256<<10, 20*time.Second)
+		})
+	}
+}
+
+func TestImpairedHeartbeat(t *testing.T) {
+	t.Parallel()
+
+	provider := &rttProvider{observed: make(chan float64, 1)}
+	m := metrics.New(provider)
+	defer m.Close()
+
+	client, _ := newImpairedPair(t, onynettest.NewImpairment(onynettest.Lossy, 2), onynet.WithMetrics(m))
+
+	select {
+	case <-provider.observed:
+	case <-time.After(10 * time.Second):
+		t.Fatal("expected a heartbeat round trip")
+	}
+	if !client.IsConnected() {
+		t.Fatal("expected the client to still be connected")
+	}
+}
+
+func TestPartition(t *testing.T) {
+	t.Parallel()
+
+	impairment := onynettest.NewImpairment(onynettest.Profile{}, 3)
+	client, clientConn := newImpairedPair(t, impairment)
+
+	impairment.Partition()
+	go func() {
+		time.Sleep(2 * time.Second)
+		impairment.Heal()
+	}()
+
+	start := time.Now()
+	transfer(t, client, clientConn, 64<<10, 20*time.Second)
+	if elapsed := time.Since(start); elapsed < 2*time.Second {
+		t.Fatalf("expected the transfer to wait for the partition to heal, took: %v", elapsed)
+	}
+	if !client.IsConnected() {
+		t.Fatal("expected the client to survive the partition")
+	}
+}
+
+func TestBandwidth(t *testing.T) {
+	t.Parallel()
+
+	client, clientConn := newImpairedPair(t, onynettest.NewImpairment(onynettest.Profile{Bandwidth: 256 << 10}, 4))
+
+	start := time.Now()
+	transfer(t, client, clientConn, 256<<10, 20*time.Second)
+	if elapsed := time.Since(start); elapsed < 900*time.Millisecond {
+		t.Fatalf("expected the bandwidth cap to slow the transfer down, took: %v", elapsed)
+	}
+}
+
+func TestImpairmentSeed(t *testing.T) {
+	run := func(seed uint64) []byte {
+		network := onynettest.NewNetwork()
+		receiver, err := network.Listen(&net.UDPAddr{IP: net.IPv4(127, 0, 0, 1)})
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer receiver.Close()
+
+		conn, remote, err := network.Dial(receiver.LocalAddr())
+		if err != nil {
+			t.Fatal(err)
+		}
+		sender := onynettest.NewImpairment(onynettest.Profile{Loss: 0.5, Duplicate: 0.2}, seed).Conn(conn)
+		defer sender.Close()
+
+		for i := range 200 {
+			sender.WriteTo([]byte{byte(i)}, remote)
+		}
+
+		var received []byte
+		buf := make([]byte, 1)
+		for {
+			receiver.SetReadDeadline(time.Now().Add(100 * time.Millisecond))
+			if _, _, err := receiver.ReadFrom(buf); err != nil {
+				return received
+			}
+			received = append(received, buf[0])
+		}
+	}
+
+	first, second := run(42), run(42)
+	if !slices.Equal(first, second) {
+		t.Fatal("expected the same seed to impair the same packets")
+	}
+	if len(first) < 50 || len(first) > 170 {
+		t.Fatalf("expected about half the packets to be lost, received: %d", len(first))
+	}
+	if slices.Equal(first, run(43)) {
+		t.Fatal("expected another seed to impair other packets")
+	}
+}
+
+// rttProvider records nothing but the first heartbeat round trip time.
+type rttProvider struct {
+	observed chan float64
+}
+
+type nopMetric struct{}
+
+func (nopMetric) Add(float64, ...string) {}
+
+type rttHistogram struct {
+	observed chan float64
+}
+
+func (h rttHistogram) Observe(value float64, labelValues ...string) {
+	select {
+	case h.observed <- value:
+	default:
+	}
+}
+
+func (p *rttProvider) Counter(name, help string, labelNames ...string) metrics.Counter {
+	return nopMetric{}
+}
+
+func (p *rttProvider) Gauge(name, help string, labelNames ...string) metrics.Gauge {
+	return nopMetric{}
+}
+
+func (p *rttProvider) Histogram(name, help string, buckets []float64, labelNames ...string) metrics.Histogram {
+	return rttHistogram{observed: p.observed}
+}
@@ -0,0 +1,31 @@
+package onynettest
+
+import (
+	"net"
+
+	"github.com/Onyz107/onynet"
+	"github.com/Onyz107/onynet/internal/kcp"
+)
+
+// NAT is a PacketTransport putting its sockets behind a simulated network address translator, so NAT
+// traversal can be tested in-process. Its sockets report their public address as their local one.
+type NAT = kcp.NAT
+
+// NATBehavior is how a NAT maps its sockets to public ports and which packets it lets in.
+type NATBehavior = kcp.NATBehavior
+
+const (
+	// FullCone maps a socket to a single public port and lets in the packets of any address.
+	FullCone = kcp.FullCone
+	// RestrictedCone maps a socket to a single public port and only lets in the packets of IPs it wrote to.
+	RestrictedCone = kcp.RestrictedCone
+	// PortRestrictedCone maps a socket to a single public port and only lets in the packets of addresses it wrote to.
+	PortRestrictedCone = kcp.PortRestrictedCone
+	// Symmetric maps a socket to a new public port for every address it writes to, hole punching does not get through it.
+	Symmetric = kcp.Symmetric
+)
+
+// NewNAT returns a NAT with the given behavior whose public ports are bound to publicIP on transport, usually a Network.
+func NewNAT(transport onynet.PacketTransport, publicIP net.IP, behavior NATBehavior) *NAT {
+	return kcp.NewNAT(transport, publicIP, behavior)
+}
@@ -0,0 +1,99 @@
+// Package onynettest runs OnyNet servers and clients in-process over an in-memory network,
+// so protocols built on OnyNet can be unit tested without binding sockets.
+package onynettest
+
+import (
+	"context"
+	"crypto/rsa"
+	"net"
+	"testing"
+
+	"github.com/Onyz107/onynet"
+	"github.com/Onyz107/onynet/internal/kcp"
+)
+
+// Network is an in-memory packet network. Its addresses are UDP addresses, so IP rules
+// behave as they would over UDP, but packets never leave the process.
+// It can be given to onynet.NewServerWithTransport and onynet.WithPacketTransport directly.
+type Network = kcp.MemoryNetwork
+
+// NewNetwork returns an empty in-memory network.
+func NewNetwork() *Network {
+	return kcp.NewMemoryNetwork()
+}
+
+// Pair is a server and a client connected to it over their own in-memory network.
+type Pair struct {
+	Network    *Network
+	Server     *onynet.Server
+	Client     *onynet.Client
+	ClientConn *onynet.ClientConn
+}
+
+// NewPair starts a server on a new network and connects a client to it, authenticated with privateKey
+// unless it is nil. The opts are given to the client's Dial. Both ends are closed when the test ends.
+func NewPair(tb testing.TB, privateKey *rsa.PrivateKey, opts ...onynet.DialOption) *Pair {
+	tb.Helper()
+
+	network := NewNetwork()
+	server := NewServer(tb, network, privateKey)
+
+	var publicKey *rsa.PublicKey
+	if privateKey != nil {
+		publicKey = &privateKey.PublicKey
+	}
+	client, clientConn := Connect(tb, network, server, publicKey, opts...)
+
+	return &Pair{
+		Network:    network,
+		Server:     server,
+		Client:     client,
+		ClientConn: clientConn,
+	}
+}
+
+// NewServer starts a server on a free port of the loopback address of transport, usually a Network
+// or an impaired one, authenticating clients with privateKey unless it is nil.
+// The server is closed when the test ends.
+func NewServer(tb testing.TB, transport onynet.PacketTransport, privateKey *rsa.PrivateKey) *onynet.Server {
+	tb.Helper()
+
+	server, err := onynet.NewServerWithTransport(&net.UDPAddr{IP: net.IPv4(127, 0, 0, 1)}, privateKey, transport, context.Background())
+	if err != nil {
+		tb.Fatal(err)
+	}
+	tb.Cleanup(func() { server.Close() })
+
+	return server
+}
+
+// Connect dials server over transport and accepts the connection, returning both of its ends.
+// The publicKey must match the server's private key, or be nil if the server has none.
+// Server.Accept must not be called concurrently. The client is closed when the test ends.
+func Connect(tb testing.TB, transport onynet.PacketTransport, server *onynet.Server, publicKey *rsa.PublicKey, opts ...onynet.DialOption) (*onynet.Client, *onynet.ClientConn) {
+	tb.Helper()
+
+	type accepted struct {
+		clientConn *onynet.ClientConn
+		err        error
+	}
+	acceptDone := make(chan accepted, 1)
+	go func() {
+		clientConn, err := server.Accept()
+		acceptDone <- accepted{clientConn, err}
+	}()
+
+	opts = append([]onynet.DialOption{onynet.WithPacketTransport(transport)}, opts...)
+	client, err := onynet.Dial(server.Addr(), publicKey, context.Background(), opts...)
+	if err != nil {
+		tb.Fatal(err)
+	}
+	tb.Cleanup(func() { client.Close() })
+
+	result := <-acceptDone
+	if result.err != nil {
+		tb.Fatal(result.err)
+	}
+
+	return client, result.clientConn
+}
@@ -0,0 +1,159 @@
+package onynettest_test
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/rsa"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/Onyz107/onynet"
+	intErrors "github.com/Onyz107/onynet/errors"
+	"github.com/Onyz107/onynet/onynettest"
+)
+
+func TestPair(t *testing.T) {
+	privateKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	pair := onynettest.NewPair(t, privateKey)
+
+	acceptDone := make(chan error, 1)
+	go func() {
+		stream, err := pair.ClientConn.AcceptStream("echo", context.Background(), 5*time.Second)
+		if err != nil {
+			acceptDone <- err
+			return
+		}
+		buf := make([]byte, 64)
+		n, err := stream.ReceiveEncrypted(buf, 5*time.Second)
+		if err != nil {
+			acceptDone <- err
+			return
+		}
+		acceptDone <- stream.SendEncrypted(buf[:n], 5*time.Second)
+	}()
+
+	stream, err := pair.Client.OpenStream("echo", context.Background(), 5*time.Second)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := stream.SendEncrypted([]byte("hello"), 5*time.Second); err != nil {
+		t.Fatal(err)
+	}
+	buf := make([]byte, 64)
+	n, err := stream.ReceiveEncrypted(buf, 5*time.Second)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(buf[:n]) != "hello" {
+		t.Fatalf("unexpected echo: %q", buf[:n])
+	}
+	if err := <-acceptDone; err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestConnect(t *testing.T) {
+	network := onynettest.NewNetwork()
+	server := onynettest.NewServer(t, network, nil)
+	if err := server.SetIPRules(nil, []string{"10.0.0.0/8"}); err != nil {
+		t.Fatal(err)
+	}
+
+	first, firstConn := onynettest.Connect(t, network, server, nil)
+	second, secondConn := onynettest.Connect(t, network, server, nil)
+
+	if firstConn.ID() == secondConn.ID() {
+		t.Fatal("expected distinct client IDs")
+	}
+	if len(server.GetClients()) != 2 {
+		t.Fatalf("expected 2 clients, got: %d", len(server.GetClients()))
+	}
+	if firstConn.RemoteAddr().String() == secondConn.RemoteAddr().String() {
+		t.Fatal("expected distinct remote addresses")
+	}
+	if !second.IsConnected() {
+		t.Fatal("expected the second client to be connected")
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	if err := first.SendDatagram([]byte("ping")); err != nil {
+		t.Fatal(err)
+	}
+	b, err := firstConn.ReceiveDatagram(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(b) != "ping" {
+		t.Fatalf("unexpected datagram: %q", b)
+	}
+}
+
+func TestDatagramReplay(t *testing.T) {
+	privateKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Every packet the client sends is delivered twice, like an attacker replaying them
+	network := onynettest.NewNetwork()
+	server := onynettest.NewServer(t, network, privateKey)
+	replay := onynettest.NewImpairment(onynettest.Profile{Duplicate: 1}, 1)
+	client, clientConn := onynettest.Connect(t, replay.Transport(network), server, &privateKey.PublicKey)
+
+	for _, msg := range []string{"one", "two", "three"} {
+		if err := client.SendDatagram([]byte(msg)); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	for _, msg := range []string{"one", "two", "three"} {
+		b, err := clientConn.ReceiveDatagram(ctx)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if string(b) != msg {
+			t.Fatalf("expected %q, got: %q", msg, b)
+		}
+	}
+
+	ctx, cancel = context.WithTimeout(context.Background(), 200*time.Millisecond)
+	defer cancel()
+	if b, err := clientConn.ReceiveDatagram(ctx); err == nil {
+		t.Fatalf("expected the replayed datagrams to be dropped, got: %q", b)
+	}
+}
+
+func TestGracefulClose(t *testing.T) {
+	network := onynettest.NewNetwork()
+	server := onynettest.NewServer(t, network, nil)
+	disconnected := make(chan error, 1)
+	server.SetHooks(onynet.ServerHooks{
+		OnDisconnect: func(cn *onynet.ClientConn, reason error) { disconnected <- reason },
+	})
+	client, _ := onynettest.Connect(t, network, server, nil)
+
+	// The close returns once the server acknowledged the close frame, well before the acknowledgement times out
+	start := time.Now()
+	client.CloseWithCode(onynet.CloseGoingAway, "bye")
+	if elapsed := time.Since(start); elapsed > 500*time.Millisecond {
+		t.Fatalf("expected the close to be acknowledged, took: %v", elapsed)
+	}
+
+	select {
+	case reason := <-disconnected:
+		var closeErr *onynet.CloseError
+		if !errors.Is(reason, intErrors.ErrPeerClosed) || !errors.As(reason, &closeErr) || closeErr.Code != onynet.CloseGoingAway || closeErr.Reason != "bye" {
+			t.Fatalf("expected ErrPeerClosed with the close code and reason, got: %v", reason)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("expected the server to see the client disconnect")
+	}
+}
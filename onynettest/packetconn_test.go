@@ -0,0 +1,87 @@
+package onynettest_test
+
+import (
+	"context"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/Onyz107/onynet"
+	"github.com/Onyz107/onynet/onynettest"
+)
+
+func TestPacketConn(t *testing.T) {
+	network := onynettest.NewNetwork()
+	conn, err := network.Listen(&net.UDPAddr{IP: net.IPv4(127, 0, 0, 1)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	server, err := onynet.NewServerFromPacketConn(conn, privateKey(), context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer server.Close()
+	if server.Addr().String() != conn.LocalAddr().String() {
+		t.Fatalf("expected the server to listen on %s, got: %s", conn.LocalAddr(), server.Addr())
+	}
+
+	clientSocket, _, err := network.Dial(server.Addr())
+	if err != nil {
+		t.Fatal(err)
+	}
+	accepted := make(chan *onynet.ClientConn, 1)
+	go func() {
+		clientConn, err := server.Accept()
+		if err != nil {
+			t.Error(err)
+		}
+		accepted <- clientConn
+	}()
+
+	client, err := onynet.DialWithPacketConn(clientSocket, server.Addr(), &privateKey().PublicKey, context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer client.Close()
+	clientConn := <-accepted
+	if clientConn == nil {
+		t.FailNow()
+	}
+
+	if clientConn.RemoteAddr().String() != clientSocket.LocalAddr().String() {
+		t.Fatalf("expected the client to dial from %s, got: %s", clientSocket.LocalAddr(), clientConn.RemoteAddr())
+	}
+	transfer(t, client, clientConn, 64<<10, 10*time.Second)
+}
+
+func TestSharedSocket(t *testing.T) {
+	network := onynettest.NewNetwork()
+	conn, err := network.Listen(&net.UDPAddr{IP: net.IPv4(127, 0, 0, 1)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	shared := onynet.NewSharedSocket(conn)
+	defer shared.Close()
+
+	// A server and a client on the shared socket
+	server := onynettest.NewServer(t, shared, privateKey())
+	peer := onynettest.NewServer(t, network, privateKey())
+
+	client, peerConn := onynettest.Connect(t, shared, peer, &privateKey().PublicKey)
+	if peerConn.RemoteAddr().String() != shared.LocalAddr().String() {
+		t.Fatalf("expected the client to dial from %s, got: %s", shared.LocalAddr(), peerConn.RemoteAddr())
+	}
+	peerClient, clientConn := onynettest.Connect(t, network, server, &privateKey().PublicKey)
+
+	transfer(t, client, peerConn, 64<<10, 10*time.Second)
+	transfer(t, peerClient, clientConn, 64<<10, 10*time.Second)
+
+	if err := client.SendDatagram([]byte("ping")); err != nil {
+		t.Fatal(err)
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	if b, err := peerConn.ReceiveDatagram(ctx); err != nil || string(b) != "ping" {
+		t.Fatalf("expected ping, got: %q, %v", b, err)
+	}
+}
@@ -0,0 +1,192 @@
+package onynettest_test
+
+import (
+	"context"
+	"crypto"
+	"crypto/ecdh"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/binary"
+	"errors"
+	"io"
+	"testing"
+	"time"
+
+	"github.com/Onyz107/onynet"
+	intErrors "github.com/Onyz107/onynet/errors"
+	"github.com/Onyz107/onynet/onynettest"
+)
+
+func newIdentity(tb testing.TB) *rsa.PrivateKey {
+	tb.Helper()
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		tb.Fatal(err)
+	}
+	return key
+}
+
+func TestRelay(t *testing.T) {
+	network := onynettest.NewNetwork()
+	// Without a server key, only the relay's end-to-end key can encrypt the stream
+	server := onynettest.NewServer(t, network, nil)
+	aliceKey, bobKey := newIdentity(t), newIdentity(t)
+	alice, aliceConn := onynettest.Connect(t, network, server, nil, onynet.WithRelayIdentity(aliceKey))
+	bob, bobConn := onynettest.Connect(t, network, server, nil, onynet.WithRelayIdentity(bobKey))
+
+	// Nothing accepts the relay stream while relaying is disabled
+	if _, err := alice.OpenRelayStream(bobConn.ID().String(), "chat", &bobKey.PublicKey, context.Background(), time.Second); !errors.Is(err, intErrors.ErrTimeout) {
+		t.Fatalf("expected ErrTimeout while relaying is disabled, got: %v", err)
+	}
+
+	server.SetRelay(onynet.RelayByID(server))
+	if _, err := alice.OpenRelayStream(onynet.ClientID{1}.String(), "chat", &bobKey.PublicKey, context.Background(), 5*time.Second); !errors.Is(err, intErrors.ErrPeerNotFound) {
+		t.Fatalf("expected ErrPeerNotFound, got: %v", err)
+	}
+
+	type accepted struct {
+		stream *onynet.Stream
+		from   onynet.ClientID
+		err    error
+	}
+	acceptedCh := make(chan accepted, 1)
+	go func() {
+		stream, from, err := bob.AcceptRelayStream("chat", &aliceKey.PublicKey, context.Background(), 5*time.Second)
+		acceptedCh <- accepted{stream, from, err}
+	}()
+
+	stream, err := alice.OpenRelayStream(bobConn.ID().String(), "chat", &bobKey.PublicKey, context.Background(), 5*time.Second)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer stream.Close()
+	result := <-acceptedCh
+	if result.err != nil {
+		t.Fatal(result.err)
+	}
+	defer result.stream.Close()
+
+	if result.from != aliceConn.ID() {
+		t.Fatalf("expected the stream to come from %s, got: %s", aliceConn.ID(), result.from)
+	}
+	if !stream.IsEncrypted() || !result.stream.IsEncrypted() {
+		t.Fatal("expected the relayed stream to be encrypted end to end")
+	}
+
+	if err := stream.SendEncrypted([]byte("hello bob"), 5*time.Second); err != nil {
+		t.Fatal(err)
+	}
+	buf := make([]byte, 64)
+	n, err := result.stream.ReceiveEncrypted(buf, 5*time.Second)
+	if err != nil || string(buf[:n]) != "hello bob" {
+		t.Fatalf("expected hello bob, got: %q, %v", buf[:n], err)
+	}
+
+	if err := result.stream.SendEncrypted([]byte("hello alice"), 5*time.Second); err != nil {
+		t.Fatal(err)
+	}
+	n, err = stream.ReceiveEncrypted(buf, 5*time.Second)
+	if err != nil || string(buf[:n]) != "hello alice" {
+		t.Fatalf("expected hello alice, got: %q, %v", buf[:n], err)
+	}
+
+	// The relay stream of a client is routed to the server without taking the streams the application accepts
+	go func() {
+		if s, err := alice.OpenStream("app", context.Background(), 5*time.Second); err == nil {
+			s.Close()
+		}
+	}()
+	appStream, err := aliceConn.AcceptStream("app", context.Background(), 5*time.Second)
+	if err != nil {
+		t.Fatalf("expected the application to accept its stream while relaying, got: %v", err)
+	}
+	appStream.Close()
+
+	server.SetRelay(func(from *onynet.ClientConn, target string) (*onynet.ClientConn, error) {
+		return nil, errors.New("not allowed")
+	})
+	if _, err := alice.OpenRelayStream(bobConn.ID().String(), "chat", &bobKey.PublicKey, context.Background(), 5*time.Second); !errors.Is(err, intErrors.ErrRelayDenied) {
+		t.Fatalf("expected ErrRelayDenied from the resolver, got: %v", err)
+	}
+}
+
+func TestRelayTampering(t *testing.T) {
+	network := onynettest.NewNetwork()
+	// The server does not relay but swaps the keys of both clients for its own
+	server := onynettest.NewServer(t, network, nil)
+	aliceKey, bobKey, malloryKey := newIdentity(t), newIdentity(t), newIdentity(t)
+	alice, aliceConn := onynettest.Connect(t, network, server, nil, onynet.WithRelayIdentity(aliceKey))
+	bob, bobConn := onynettest.Connect(t, network, server, nil, onynet.WithRelayIdentity(bobKey))
+
+	acceptErr := make(chan error, 1)
+	go func() {
+		_, _, err := bob.AcceptRelayStream("chat", &aliceKey.PublicKey, context.Background(), 5*time.Second)
+		acceptErr <- err
+	}()
+	openErr := make(chan error, 1)
+	go func() {
+		_, err := alice.OpenRelayStream(bobConn.ID().String(), "chat", &bobKey.PublicKey, context.Background(), 5*time.Second)
+		openErr <- err
+	}()
+
+	request, err := aliceConn.AcceptStream("onynet/relay", context.Background(), 5*time.Second)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer request.Close()
+	// target length(1) + target + name length(2) + name + public key(32) + signature length(2) + signature
+	length := make([]byte, 2)
+	readFull(t, request, length[:1])
+	readFull(t, request, make([]byte, length[0]))
+	readFull(t, request, length)
+	readFull(t, request, make([]byte, binary.BigEndian.Uint16(length)))
+	readFull(t, request, make([]byte, 32))
+	readFull(t, request, length)
+	aliceSignature := make([]byte, binary.BigEndian.Uint16(length))
+	readFull(t, request, aliceSignature)
+
+	mallory, err := ecdh.X25519().GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	malloryPublic := mallory.PublicKey().Bytes()
+
+	// Bob is given the key of the server along with the signature of alice's key
+	relayed, err := bobConn.OpenStream("onynet/relay/chat", context.Background(), 5*time.Second)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer relayed.Close()
+	id := aliceConn.ID()
+	header := append(id[:], malloryPublic...)
+	header = binary.BigEndian.AppendUint16(header, uint16(len(aliceSignature)))
+	if _, err := relayed.Write(append(header, aliceSignature...)); err != nil {
+		t.Fatal(err)
+	}
+	if err := <-acceptErr; !errors.Is(err, intErrors.ErrRelayKey) {
+		t.Fatalf("expected ErrRelayKey on the accepting client, got: %v", err)
+	}
+
+	// Alice is given the key of the server signed by the server's own identity
+	digest := sha256.Sum256(malloryPublic)
+	signature, err := rsa.SignPSS(rand.Reader, malloryKey, crypto.SHA256, digest[:], nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	answer := append([]byte{0}, malloryPublic...)
+	answer = binary.BigEndian.AppendUint16(answer, uint16(len(signature)))
+	if _, err := request.Write(append(answer, signature...)); err != nil {
+		t.Fatal(err)
+	}
+	if err := <-openErr; !errors.Is(err, intErrors.ErrRelayKey) {
+		t.Fatalf("expected ErrRelayKey on the opening client, got: %v", err)
+	}
+}
+
+func readFull(tb testing.TB, r io.Reader, b []byte) {
+	tb.Helper()
+	if _, err := io.ReadFull(r, b); err != nil {
+		tb.Fatal(err)
+	}
+}
@@ -0,0 +1,116 @@
+package onynettest_test
+
+import (
+	"context"
+	"errors"
+	"net"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/Onyz107/onynet"
+	intErrors "github.com/Onyz107/onynet/errors"
+	"github.com/Onyz107/onynet/onynettest"
+)
+
+// dialAccepted dials addr and returns both ends of the connection accepted by server.
+func dialAccepted(tb testing.TB, server *onynet.Server, addr net.Addr, opts ...onynet.DialOption) (*onynet.Client, *onynet.ClientConn) {
+	tb.Helper()
+
+	accepted := make(chan *onynet.ClientConn, 1)
+	go func() {
+		clientConn, err := server.Accept()
+		if err != nil {
+			tb.Error(err)
+		}
+		accepted <- clientConn
+	}()
+
+	client, err := onynet.Dial(addr, &privateKey().PublicKey, context.Background(), opts...)
+	if err != nil {
+		tb.Fatal(err)
+	}
+	tb.Cleanup(func() { client.Close() })
+
+	clientConn := <-accepted
+	if clientConn == nil {
+		tb.FailNow()
+	}
+	return client, clientConn
+}
+
+func TestTCPTransport(t *testing.T) {
+	server := onynettest.NewServer(t, onynettest.NewNetwork(), privateKey())
+	addr, err := server.ListenTCP(&net.TCPAddr{IP: net.IPv4(127, 0, 0, 1)})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	client, clientConn := dialAccepted(t, server, addr)
+	if clientConn.RemoteAddr().Network() != "tcp" {
+		t.Fatalf("expected a TCP connection, got: %s", clientConn.RemoteAddr().Network())
+	}
+	transfer(t, client, clientConn, 256<<10, 10*time.Second)
+
+	if err := client.SendDatagram([]byte("ping")); !errors.Is(err, intErrors.ErrDatagramUnsupported) {
+		t.Fatalf("expected ErrDatagramUnsupported, got: %v", err)
+	}
+	if client.MaxDatagramSize() != 0 {
+		t.Fatalf("expected no datagrams, got a max size of %d", client.MaxDatagramSize())
+	}
+}
+
+func TestWebSocketTransport(t *testing.T) {
+	server := onynettest.NewServer(t, onynettest.NewNetwork(), privateKey())
+	httpServer := httptest.NewServer(server.WebSocketHandler())
+	defer httpServer.Close()
+
+	addr := onynet.WebSocketAddr("ws" + strings.TrimPrefix(httpServer.URL, "http"))
+	client, clientConn := dialAccepted(t, server, addr)
+	transfer(t, client, clientConn, 256<<10, 10*time.Second)
+
+	if err := server.SetIPRules(nil, []string{"127.0.0.1"}); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := onynet.Dial(addr, &privateKey().PublicKey, context.Background()); !errors.Is(err, intErrors.ErrConnDenied) {
+		t.Fatalf("expected ErrConnDenied, got: %v", err)
+	}
+}
+
+func TestHappyEyeballs(t *testing.T) {
+	// Both transports must listen on the same port, the memory network has every port free
+	probe, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	port := probe.Addr().(*net.TCPAddr).Port
+	probe.Close()
+
+	impairment := onynettest.NewImpairment(onynettest.Profile{}, 5)
+	transport := impairment.Transport(onynettest.NewNetwork())
+	server, err := onynet.NewServerWithTransport(&net.UDPAddr{IP: net.IPv4(127, 0, 0, 1), Port: port}, privateKey(), transport, context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer server.Close()
+	if _, err := server.ListenTCP(&net.TCPAddr{IP: net.IPv4(127, 0, 0, 1), Port: port}); err != nil {
+		t.Fatal(err)
+	}
+
+	_, clientConn := dialAccepted(t, server, server.Addr(), onynet.WithPacketTransport(transport), onynet.WithHappyEyeballs())
+	if clientConn.RemoteAddr().Network() != "udp" {
+		t.Fatalf("expected KCP to win, got: %s", clientConn.RemoteAddr().Network())
+	}
+
+	// UDP is blocked
+	impairment.Partition()
+	start := time.Now()
+	_, clientConn = dialAccepted(t, server, server.Addr(), onynet.WithPacketTransport(transport), onynet.WithHappyEyeballs())
+	if clientConn.RemoteAddr().Network() != "tcp" {
+		t.Fatalf("expected TCP to win, got: %s", clientConn.RemoteAddr().Network())
+	}
+	if elapsed := time.Since(start); elapsed > 3*time.Second {
+		t.Fatalf("expected TCP to connect shortly after KCP's head start, took: %v", elapsed)
+	}
+}
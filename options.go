@@ -0,0 +1,60 @@
+package onynet
+
+import (
+	"crypto/rsa"
+	"log/slog"
+	"net"
+
+	"github.com/Onyz107/onynet/internal/kcp"
+	"github.com/Onyz107/onynet/metrics"
+	"github.com/Onyz107/onynet/tracing"
+)
+
+// DialOption configures a Client created by Dial.
+type DialOption func(*dialOptions)
+
+type dialOptions struct {
+	hooks     ClientHooks
+	metrics   *metrics.Metrics
+	tracer    tracing.Tracer
+	logger    *slog.Logger
+	transport PacketTransport
+
+	relayIdentity *rsa.PrivateKey
+
+	happyEyeballs bool
+	conn          net.Conn // established connection given to DialConn
+}
+
+// WithHooks sets the functions called on the lifecycle events of the client's connection.
+func WithHooks(hooks ClientHooks) DialOption {
+	return func(o *dialOptions) {
+		o.hooks = hooks
+	}
+}
+
+// WithPacketTransport sets the transport the client's socket is created with, such as
+// an in-memory network of the onynettest package. A nil transport means UDP, which is the default.
+func WithPacketTransport(transport PacketTransport) DialOption {
+	return func(o *dialOptions) {
+		if transport == nil {
+			transport = kcp.UDP
+		}
+		o.transport = transport
+	}
+}
+
+// WithHappyEyeballs races KCP against TCP to the same address, giving KCP a head start of 250ms, and keeps
+// whichever connects first, for networks which may block UDP. The server must also listen on TCP, see
+// Server.ListenTCP. It has no effect when dialing a TCP address or a WebSocketAddr.
+func WithHappyEyeballs() DialOption {
+	return func(o *dialOptions) {
+		o.happyEyeballs = true
+	}
+}
+
+func withConn(conn net.Conn) DialOption {
+	return func(o *dialOptions) {
+		o.conn = conn
+	}
+}
@@ -0,0 +1,39 @@
+package onynet
+
+import (
+	"context"
+	"crypto/rsa"
+	"net"
+
+	"github.com/Onyz107/onynet/internal/kcp"
+)
+
+// SharedSocket lets several servers and clients use one UDP socket, as a PacketTransport given to
+// NewServerWithTransport and WithPacketTransport. Each server listening on it is assigned new client
+// addresses in turn, like SO_REUSEPORT, and each client dialing from it receives the packets of its own
+// connection, so a server and a client on the same socket can even both talk to the same peer.
+// The address given to NewServerWithTransport is ignored, servers listen on the socket's LocalAddr.
+// Closing a server or client leaves the socket open, closing the socket closes them all.
+type SharedSocket = kcp.SharedSocket
+
+// NewSharedSocket returns a SharedSocket routing the packets read from conn, which is closed along with it.
+func NewSharedSocket(conn net.PacketConn) *SharedSocket {
+	return kcp.NewSharedSocket(conn)
+}
+
+// NewServerFromPacketConn starts an OnyNet server on an already bound socket, such as one with
+// SO_REUSEPORT set, bound to a privileged port or passed by systemd socket activation.
+// The socket is closed along with the server.
+//
+// Possible errors are the same as the ones returned by NewServer.
+func NewServerFromPacketConn(conn net.PacketConn, privateKey *rsa.PrivateKey, ctx context.Context) (*Server, error) {
+	return NewServerWithTransport(conn.LocalAddr(), privateKey, kcp.PacketConnTransport(conn), ctx)
+}
+
+// DialWithPacketConn connects to the OnyNet server at the UDP address addr from an already bound socket,
+// such as one a NAT mapping was opened with. The socket is closed along with the client, or when Dial fails.
+//
+// Possible errors are the same as the ones returned by Dial.
+func DialWithPacketConn(conn net.PacketConn, addr net.Addr, publicKey *rsa.PublicKey, ctx context.Context, opts ...DialOption) (*Client, error) {
+	return Dial(addr, publicKey, ctx, append(opts, WithPacketTransport(kcp.PacketConnTransport(conn)))...)
+}
@@ -0,0 +1,237 @@
+package pubsub
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/Onyz107/onynet"
+	intErrors "github.com/Onyz107/onynet/errors"
+	intSmux "github.com/Onyz107/onynet/internal/smux"
+)
+
+// Broker fans out published messages to every client subscribed to a topic.
+// Each subscriber has its own bounded queue so a slow client cannot block the others.
+type Broker struct {
+	queueSize int
+	policy    Policy
+	topics    map[string]map[*subscriber]struct{}
+	mu        sync.RWMutex
+	dropped   atomic.Uint64
+}
+
+type subscriber struct {
+	stream  *intSmux.Stream
+	writeMu sync.Mutex
+	queue   chan *Message
+	evictMu sync.Mutex
+	topics  map[string]struct{}
+	done    chan struct{}
+	once    sync.Once
+	err     error
+}
+
+// NewBroker returns a Broker which queues up to queueSize messages per subscriber
+// and applies policy when a subscriber's queue is full.
+// A queueSize lower or equal to 0 means DefaultQueueSize.
+func NewBroker(queueSize int, policy Policy) *Broker {
+	if queueSize <= 0 {
+		queueSize = DefaultQueueSize
+	}
+	return &Broker{queueSize: queueSize, policy: policy, topics: make(map[string]map[*subscriber]struct{})}
+}
+
+// Serve accepts the pubsub stream on h (usually a ClientConn) and handles the client's
+// subscriptions and publications until the stream is closed or ctx is cancelled.
+// Serve blocks, so it is usually run in its own goroutine for every accepted client.
+//
+// Possible errors:
+//   - ErrSlowConsumer: the client's stream was closed because its queue was full and the policy is Disconnect
+//   - ErrMalformedFrame: the client sent an invalid frame
+//   - ErrCtxCancelled: ctx was cancelled
+//   - the errors returned by AcceptStream and ReceiveEncrypted
+func (b *Broker) Serve(h onynet.Handler, ctx context.Context, timeout time.Duration) error {
+	stream, err := h.AcceptStream(StreamName, ctx, timeout)
+	if err != nil {
+		return err
+	}
+
+	sub := &subscriber{
+		stream: stream,
+		queue:  make(chan *Message, b.queueSize),
+		topics: make(map[string]struct{}),
+		done:   make(chan struct{}),
+	}
+	defer b.remove(sub)
+
+	go sub.writeLoop()
+	go func() {
+		select {
+		case <-ctx.Done():
+			sub.closeWithError(intErrors.ErrCtxCancelled)
+		case <-stream.GetDieCh():
+			sub.closeWithError(intErrors.ErrConnClosed)
+		case <-sub.done:
+		}
+	}()
+
+	bufPtr := bufPool.Get().(*[]byte)
+	defer bufPool.Put(bufPtr)
+	buf := *bufPtr
+
+	for {
+		frame, err := receive(stream, buf)
+		if err != nil {
+			sub.closeWithError(err)
+			return sub.err
+		}
+
+		frameType, topic, data, err := decodeFrame(frame)
+		if err != nil {
+			sub.closeWithError(err)
+			return sub.err
+		}
+
+		switch frameType {
+		case frameSubscribe:
+			b.subscribe(topic, sub)
+		case frameUnsubscribe:
+			b.unsubscribe(topic, sub)
+		case framePublish:
+			b.Publish(topic, data)
+		default:
+			sub.closeWithError(errors.Join(intErrors.ErrMalformedFrame, fmt.Errorf("unexpected frame type: %d", frameType)))
+			return sub.err
+		}
+	}
+}
+
+// Publish queues data for every subscriber of topic and returns the number of subscribers it was queued for.
+func (b *Broker) Publish(topic string, data []byte) int {
+	b.mu.RLock()
+	subs := make([]*subscriber, 0, len(b.topics[topic]))
+	for sub := range b.topics[topic] {
+		subs = append(subs, sub)
+	}
+	b.mu.RUnlock()
+
+	msg := &Message{Topic: topic, Data: data}
+	queued := 0
+	for _, sub := range subs {
+		if b.enqueue(sub, msg) {
+			queued++
+		}
+	}
+	return queued
+}
+
+// Subscribers returns the number of clients subscribed to topic.
+func (b *Broker) Subscribers(topic string) int {
+	b.mu.RLock()
+	defer b.mu.RUnlock()
+	return len(b.topics[topic])
+}
+
+// Dropped returns the number of messages dropped because a subscriber's queue was full.
+func (b *Broker) Dropped() uint64 {
+	return b.dropped.Load()
+}
+
+func (b *Broker) enqueue(sub *subscriber, msg *Message) bool {
+	select {
+	case sub.queue <- msg:
+		return true
+	default:
+	}
+
+	b.dropped.Add(1)
+	switch b.policy {
+
+	case DropOldest:
+		sub.evictMu.Lock()
+		defer sub.evictMu.Unlock()
+		for {
+			select {
+			case sub.queue <- msg:
+				return true
+			default:
+			}
+			select {
+			case <-sub.queue:
+			default:
+			}
+		}
+
+	case Disconnect:
+		sub.stream.Logger().Debug("disconnecting slow subscriber")
+		sub.closeWithError(intErrors.ErrSlowConsumer)
+		return false
+
+	default:
+		return false
+	}
+}
+
+func (b *Broker) subscribe(topic string, sub *subscriber) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	subs, ok := b.topics[topic]
+	if !ok {
+		subs = make(map[*subscriber]struct{})
+		b.topics[topic] = subs
+	}
+	subs[sub] = struct{}{}
+	sub.topics[topic] = struct{}{}
+}
+
+func (b *Broker) unsubscribe(topic string, sub *subscriber) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.unsubscribeLocked(topic, sub)
+}
+
+func (b *Broker) unsubscribeLocked(topic string, sub *subscriber) {
+	delete(sub.topics, topic)
+	subs, ok := b.topics[topic]
+	if !ok {
+		return
+	}
+	delete(subs, sub)
+	if len(subs) == 0 {
+		delete(b.topics, topic)
+	}
+}
+
+func (b *Broker) remove(sub *subscriber) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	for topic := range sub.topics {
+		b.unsubscribeLocked(topic, sub)
+	}
+}
+
+func (s *subscriber) writeLoop() {
+	for {
+		select {
+		case msg := <-s.queue:
+			if err := send(s.stream, &s.writeMu, encodeFrame(frameMessage, msg.Topic, msg.Data)); err != nil {
+				s.closeWithError(err)
+				return
+			}
+		case <-s.done:
+			return
+		}
+	}
+}
+
+func (s *subscriber) closeWithError(reason error) {
+	s.once.Do(func() {
+		s.err = reason
+		s.stream.Close()
+		close(s.done)
+	})
+}
@@ -0,0 +1,157 @@
+package pubsub
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/Onyz107/onynet"
+	intErrors "github.com/Onyz107/onynet/errors"
+	intSmux "github.com/Onyz107/onynet/internal/smux"
+)
+
+// Client subscribes to topics and publishes messages through a Broker.
+type Client struct {
+	stream   *intSmux.Stream
+	writeMu  sync.Mutex
+	messages chan *Message
+	done     chan struct{}
+	once     sync.Once
+	err      error
+}
+
+// Open opens the pubsub stream on h (usually a Client) and starts receiving messages.
+// Up to queueSize messages are buffered locally, once the buffer is full the broker's policy applies.
+// A queueSize lower or equal to 0 means DefaultQueueSize.
+// The ctx argument defines the stream's deadline while timeout defines the handshake's deadline.
+//
+// Possible errors are the same as the ones returned by OpenStream.
+func Open(h onynet.Handler, queueSize int, ctx context.Context, timeout time.Duration) (*Client, error) {
+	stream, err := h.OpenStream(StreamName, ctx, timeout)
+	if err != nil {
+		return nil, err
+	}
+
+	if queueSize <= 0 {
+		queueSize = DefaultQueueSize
+	}
+
+	c := &Client{
+		stream:   stream,
+		messages: make(chan *Message, queueSize),
+		done:     make(chan struct{}),
+	}
+
+	go c.readLoop()
+	go func() {
+		select {
+		case <-ctx.Done():
+			c.closeWithError(intErrors.ErrCtxCancelled)
+		case <-stream.GetDieCh():
+			c.closeWithError(intErrors.ErrConnClosed)
+		case <-c.done:
+		}
+	}()
+
+	return c, nil
+}
+
+// Subscribe starts delivering messages published on topic.
+//
+// Possible errors:
+//   - ErrTopicTooLong: topic is too long
+//   - the errors returned by SendEncrypted
+func (c *Client) Subscribe(topic string) error {
+	if err := validate(topic, nil); err != nil {
+		return err
+	}
+	return send(c.stream, &c.writeMu, encodeFrame(frameSubscribe, topic, nil))
+}
+
+// Unsubscribe stops delivering messages published on topic.
+//
+// Possible errors:
+//   - ErrTopicTooLong: topic is too long
+//   - the errors returned by SendEncrypted
+func (c *Client) Unsubscribe(topic string) error {
+	if err := validate(topic, nil); err != nil {
+		return err
+	}
+	return send(c.stream, &c.writeMu, encodeFrame(frameUnsubscribe, topic, nil))
+}
+
+// Publish publishes data on topic through the broker, delivering it to every subscriber.
+//
+// Possible errors:
+//   - ErrTopicTooLong: topic is too long
+//   - ErrMessageTooLong: data is bigger than MaxMessageSize
+//   - the errors returned by SendEncrypted
+func (c *Client) Publish(topic string, data []byte) error {
+	if err := validate(topic, data); err != nil {
+		return err
+	}
+	return send(c.stream, &c.writeMu, encodeFrame(framePublish, topic, data))
+}
+
+// Receive waits for the next message published on one of the subscribed topics.
+//
+// Possible errors:
+//   - ErrCtxCancelled: ctx was cancelled while waiting for a message
+//   - ErrConnClosed: the client was closed, the error is joined with the reason
+func (c *Client) Receive(ctx context.Context) (*Message, error) {
+	select {
+	case msg := <-c.messages:
+		return msg, nil
+	case <-ctx.Done():
+		return nil, intErrors.ErrCtxCancelled
+	case <-c.done:
+		return nil, errors.Join(intErrors.ErrConnClosed, c.err)
+	}
+}
+
+// Close closes the pubsub stream, dropping every subscription.
+func (c *Client) Close() error {
+	c.closeWithError(intErrors.ErrConnClosed)
+	return nil
+}
+
+func (c *Client) readLoop() {
+	bufPtr := bufPool.Get().(*[]byte)
+	defer bufPool.Put(bufPtr)
+	buf := *bufPtr
+
+	for {
+		frame, err := receive(c.stream, buf)
+		if err != nil {
+			c.stream.Logger().Debug("closing pubsub client because of receive error", "error", err, "error_kind", intErrors.Kind(err))
+			c.closeWithError(err)
+			return
+		}
+
+		frameType, topic, data, err := decodeFrame(frame)
+		if err != nil {
+			c.closeWithError(err)
+			return
+		}
+		if frameType != frameMessage {
+			c.closeWithError(errors.Join(intErrors.ErrMalformedFrame, fmt.Errorf("unexpected frame type: %d", frameType)))
+			return
+		}
+
+		select {
+		case c.messages <- &Message{Topic: topic, Data: data}:
+		case <-c.done:
+			return
+		}
+	}
+}
+
+func (c *Client) closeWithError(reason error) {
+	c.once.Do(func() {
+		c.err = reason
+		c.stream.Close()
+		close(c.done)
+	})
+}
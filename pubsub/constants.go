@@ -0,0 +1,55 @@
+package pubsub
+
+import "sync"
+
+// StreamName is the name of the control stream a client uses to talk to the broker.
+const StreamName = "onynet/pubsub"
+
+// MaxMessageSize is the largest payload a published message can carry.
+const MaxMessageSize = 1 << 20
+
+// DefaultQueueSize is the number of messages queued per subscriber when no size is given.
+const DefaultQueueSize = 64
+
+const maxTopicLength = 0xFF
+
+const (
+	frameSubscribe byte = iota + 1
+	frameUnsubscribe
+	framePublish
+	frameMessage
+)
+
+const (
+	// type(1) + topic length(1)
+	frameHeaderSize = 2
+	// nonce(12) + tag(16)
+	encryptionOverhead = 28
+)
+
+const bufferSize = MaxMessageSize + frameHeaderSize + maxTopicLength + encryptionOverhead
+
+var bufPool = sync.Pool{
+	New: func() any {
+		buf := make([]byte, bufferSize)
+		return &buf
+	},
+}
+
+// Policy decides what happens when a subscriber's queue is full.
+type Policy int
+
+const (
+	// DropNewest discards the message being published.
+	DropNewest Policy = iota
+	// DropOldest discards the oldest queued message to make room for the new one.
+	DropOldest
+	// Disconnect closes the subscriber's stream, ending all of its subscriptions.
+	Disconnect
+)
+
+// Message is a payload published on a topic.
+type Message struct {
+	Topic string
+	Data  []byte
+}
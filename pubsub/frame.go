@@ -0,0 +1,29 @@
+package pubsub
+
+import (
+	intErrors "github.com/Onyz107/onynet/errors"
+)
+
+func encodeFrame(frameType byte, topic string, data []byte) []byte {
+	frame := make([]byte, frameHeaderSize, frameHeaderSize+len(topic)+len(data))
+	frame[0] = frameType
+	frame[1] = byte(len(topic))
+	frame = append(frame, topic...)
+	return append(frame, data...)
+}
+
+func decodeFrame(frame []byte) (frameType byte, topic string, data []byte, err error) {
+	if len(frame) < frameHeaderSize {
+		return 0, "", nil, intErrors.ErrMalformedFrame
+	}
+
+	topicLength := int(frame[1])
+	if len(frame) < frameHeaderSize+topicLength {
+		return 0, "", nil, intErrors.ErrMalformedFrame
+	}
+
+	data = make([]byte, len(frame)-frameHeaderSize-topicLength)
+	copy(data, frame[frameHeaderSize+topicLength:])
+
+	return frame[0], string(frame[frameHeaderSize : frameHeaderSize+topicLength]), data, nil
+}
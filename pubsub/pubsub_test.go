@@ -0,0 +1,147 @@
+package pubsub_test
+
+import (
+	"context"
+	"errors"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/Onyz107/onynet"
+	intErrors "github.com/Onyz107/onynet/errors"
+	"github.com/Onyz107/onynet/onynettest"
+	"github.com/Onyz107/onynet/pubsub"
+)
+
+// network carries the packets of the test servers and clients in memory.
+var network = onynettest.NewNetwork()
+
+func newServer(tb testing.TB) (*onynet.Server, net.Addr) {
+	tb.Helper()
+
+	addr, err := net.ResolveUDPAddr("udp", "127.0.0.1:9292")
+	if err != nil {
+		tb.Fatal(err)
+	}
+
+	server, err := onynet.NewServerWithTransport(addr, nil, network, tb.Context())
+	if err != nil {
+		tb.Fatal(err)
+	}
+	tb.Cleanup(func() { server.Close() })
+
+	return server, addr
+}
+
+func newSubscriber(tb testing.TB, server *onynet.Server, addr net.Addr, broker *pubsub.Broker, queueSize int) (*pubsub.Client, <-chan error) {
+	tb.Helper()
+
+	served := make(chan error, 1)
+	go func() {
+		clientConn, err := server.Accept()
+		if err != nil {
+			served <- err
+			return
+		}
+		defer clientConn.Close()
+		served <- broker.Serve(clientConn, tb.Context(), 5*time.Second)
+	}()
+
+	client, err := onynet.Dial(addr, nil, tb.Context(), onynet.WithPacketTransport(network))
+	if err != nil {
+		tb.Fatal(err)
+	}
+	tb.Cleanup(func() { client.Close() })
+
+	sub, err := pubsub.Open(client, queueSize, tb.Context(), 5*time.Second)
+	if err != nil {
+		tb.Fatal(err)
+	}
+	tb.Cleanup(func() { sub.Close() })
+
+	return sub, served
+}
+
+func waitSubscribers(tb testing.TB, broker *pubsub.Broker, topic string, n int) {
+	tb.Helper()
+
+	deadline := time.Now().Add(5 * time.Second)
+	for broker.Subscribers(topic) != n {
+		if time.Now().After(deadline) {
+			tb.Fatalf("expected %d subscribers, got %d", n, broker.Subscribers(topic))
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func TestPublish(t *testing.T) {
+	server, addr := newServer(t)
+	broker := pubsub.NewBroker(0, pubsub.DropNewest)
+
+	first, _ := newSubscriber(t, server, addr, broker, 0)
+	second, _ := newSubscriber(t, server, addr, broker, 0)
+
+	for _, sub := range []*pubsub.Client{first, second} {
+		if err := sub.Subscribe("news"); err != nil {
+			t.Fatal(err)
+		}
+	}
+	waitSubscribers(t, broker, "news", 2)
+
+	if err := first.Publish("news", []byte("from client")); err != nil {
+		t.Fatal(err)
+	}
+	if n := broker.Publish("news", []byte("from server")); n != 2 {
+		t.Fatalf("expected message to be queued for 2 subscribers, got %d", n)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	for _, sub := range []*pubsub.Client{first, second} {
+		expected := map[string]bool{"from client": true, "from server": true}
+		for range 2 {
+			msg, err := sub.Receive(ctx)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if msg.Topic != "news" || !expected[string(msg.Data)] {
+				t.Fatalf("unexpected message: %s: %s", msg.Topic, msg.Data)
+			}
+			delete(expected, string(msg.Data))
+		}
+	}
+
+	if err := second.Unsubscribe("news"); err != nil {
+		t.Fatal(err)
+	}
+	waitSubscribers(t, broker, "news", 1)
+}
+
+func TestSlowConsumerDisconnect(t *testing.T) {
+	server, addr := newServer(t)
+	broker := pubsub.NewBroker(1, pubsub.Disconnect)
+
+	sub, served := newSubscriber(t, server, addr, broker, 1)
+	if err := sub.Subscribe("flood"); err != nil {
+		t.Fatal(err)
+	}
+	waitSubscribers(t, broker, "flood", 1)
+
+	data := make([]byte, 64*1024)
+	for range 1024 {
+		broker.Publish("flood", data)
+		if broker.Subscribers("flood") == 0 {
+			break
+		}
+	}
+
+	select {
+	case err := <-served:
+		if !errors.Is(err, intErrors.ErrSlowConsumer) {
+			t.Fatalf("expected ErrSlowConsumer, got: %v", err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("slow subscriber was not disconnected")
+	}
+}
@@ -0,0 +1,44 @@
+package pubsub
+
+import (
+	"errors"
+	"fmt"
+	"sync"
+
+	intErrors "github.com/Onyz107/onynet/errors"
+	intSmux "github.com/Onyz107/onynet/internal/smux"
+)
+
+func validate(topic string, data []byte) error {
+	if len(topic) > maxTopicLength {
+		return intErrors.ErrTopicTooLong
+	}
+	if len(data) > MaxMessageSize {
+		return errors.Join(intErrors.ErrMessageTooLong, fmt.Errorf("max size: %d: data length: %d", MaxMessageSize, len(data)))
+	}
+	return nil
+}
+
+func send(stream *intSmux.Stream, mu *sync.Mutex, frame []byte) error {
+	mu.Lock()
+	defer mu.Unlock()
+
+	if stream.IsEncrypted() {
+		return stream.SendEncrypted(frame, 0)
+	}
+	return stream.SendSerialized(frame, 0)
+}
+
+func receive(stream *intSmux.Stream, buf []byte) ([]byte, error) {
+	var n uint64
+	var err error
+	if stream.IsEncrypted() {
+		n, err = stream.ReceiveEncrypted(buf, 0)
+	} else {
+		n, err = stream.ReceiveSerialized(buf, 0)
+	}
+	if err != nil {
+		return nil, err
+	}
+	return buf[:n], nil
+}
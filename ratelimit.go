@@ -0,0 +1,76 @@
+package onynet
+
+import "time"
+
+// SetRateLimit limits the traffic of every client of the server combined, in each direction.
+// It applies immediately, including to streams already open.
+func (s *Server) SetRateLimit(limit RateLimit) {
+	s.limits.SetLimit(limit)
+}
+
+// RateLimit returns the limit set by SetRateLimit.
+func (s *Server) RateLimit() RateLimit {
+	return s.limits.Limit()
+}
+
+// SetConnRateLimit limits the traffic of each client, in each direction.
+// It applies immediately to connected clients and to the ones accepted afterwards,
+// ClientConn.SetRateLimit overrides it for a single client.
+func (s *Server) SetConnRateLimit(limit RateLimit) {
+	s.limitMu.Lock()
+	s.connLimit = limit
+	s.limitMu.Unlock()
+
+	for _, client := range s.GetClients() {
+		client.SetRateLimit(limit)
+	}
+}
+
+// SetStreamRateLimit limits the traffic of each stream with the given name, in each direction.
+// It applies to streams opened or accepted afterwards, Stream.SetRateLimit changes it for a single stream.
+func (s *Server) SetStreamRateLimit(name string, limit RateLimit) {
+	s.streamRules.Set(name, limit)
+}
+
+// Throttled returns the total time transfers were delayed by the limit set by SetRateLimit.
+func (s *Server) Throttled() time.Duration {
+	return s.limits.Throttled()
+}
+
+// SetRateLimit limits the traffic with the client, in each direction.
+// It applies immediately, including to streams already open.
+func (cn *ClientConn) SetRateLimit(limit RateLimit) {
+	cn.manager.Limits().SetLimit(limit)
+}
+
+// RateLimit returns the limit applied to the traffic with the client.
+func (cn *ClientConn) RateLimit() RateLimit {
+	return cn.manager.Limits().Limit()
+}
+
+// Throttled returns the total time transfers with the client were delayed by its connection limit.
+func (cn *ClientConn) Throttled() time.Duration {
+	return cn.manager.Limits().Throttled()
+}
+
+// SetRateLimit limits the traffic with the server, in each direction.
+// It applies immediately, including to streams already open.
+func (c *Client) SetRateLimit(limit RateLimit) {
+	c.manager.Limits().SetLimit(limit)
+}
+
+// RateLimit returns the limit applied to the traffic with the server.
+func (c *Client) RateLimit() RateLimit {
+	return c.manager.Limits().Limit()
+}
+
+// SetStreamRateLimit limits the traffic of each stream with the given name, in each direction.
+// It applies to streams opened or accepted afterwards, Stream.SetRateLimit changes it for a single stream.
+func (c *Client) SetStreamRateLimit(name string, limit RateLimit) {
+	c.streamRules.Set(name, limit)
+}
+
+// Throttled returns the total time transfers with the server were delayed by its connection limit.
+func (c *Client) Throttled() time.Duration {
+	return c.manager.Limits().Throttled()
+}
@@ -0,0 +1,443 @@
+package onynet
+
+import (
+	"context"
+	"crypto"
+	"crypto/ecdh"
+	"crypto/hkdf"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"time"
+
+	intErrors "github.com/Onyz107/onynet/errors"
+)
+
+// RelayResolver returns the client a client addresses as target when opening a relayed stream.
+// Returning an error refuses the relay, ErrPeerNotFound tells the opening client no such client is connected
+// and any other error that it was denied.
+type RelayResolver func(from *ClientConn, target string) (*ClientConn, error)
+
+// RelayByID resolves targets as the String of the ClientID of a client of server, letting every client
+// relay to every other one. The server tells clients the IDs of their peers through its own protocol.
+func RelayByID(server *Server) RelayResolver {
+	return func(from *ClientConn, target string) (*ClientConn, error) {
+		b, err := hex.DecodeString(target)
+		var id ClientID
+		if err != nil || len(b) != len(id) {
+			return nil, intErrors.ErrPeerNotFound
+		}
+		copy(id[:], b)
+
+		to := server.GetClient(id)
+		if to == nil {
+			return nil, intErrors.ErrPeerNotFound
+		}
+		return to, nil
+	}
+}
+
+// SetRelay lets clients open streams to other clients through the server, resolving the clients they address
+// with resolve (see Client.OpenRelayStream). A nil resolve disables relaying, which is the default.
+// While relaying is enabled, the relay streams of every client are handed to the server as they arrive.
+func (s *Server) SetRelay(resolve RelayResolver) {
+	if resolve == nil {
+		s.relay.Store(nil)
+	} else {
+		s.relay.Store(&resolve)
+	}
+	for _, cn := range s.GetClients() {
+		s.handleRelay(cn)
+	}
+}
+
+// handleRelay relays the relay streams of cn while relaying is enabled, and stops accepting them otherwise.
+func (s *Server) handleRelay(cn *ClientConn) {
+	if s.relay.Load() == nil {
+		cn.manager.Handle(relayStreamName, nil)
+		return
+	}
+	cn.manager.Handle(relayStreamName, func(stream *Stream) {
+		s.relayStream(cn, stream)
+	})
+}
+
+// relayStream reads the request of the client which opened stream, opens the relayed stream on the client it
+// addresses and splices both until either is closed. The key exchange of both clients goes through the splice.
+func (s *Server) relayStream(from *ClientConn, stream *Stream) {
+	logger := from.Logger()
+	stream.SetDeadline(time.Now().Add(relayTimeout))
+
+	target, name, publicKey, signature, err := readRelayRequest(stream)
+	if err != nil {
+		logger.Debug("failed to read relay request", "error", err, "error_kind", intErrors.Kind(err))
+		stream.Close()
+		return
+	}
+
+	to, err := s.resolveRelay(from, target)
+	if err != nil {
+		logger.Debug("refusing relay", "target", target, "reason", err, "error_kind", intErrors.Kind(err))
+		stream.Write([]byte{relayStatus(err)})
+		stream.Close()
+		return
+	}
+
+	relayed, err := to.OpenStream(relayStreamPrefix+name, to.ctx, relayTimeout)
+	if err == nil {
+		relayed.SetDeadline(time.Now().Add(relayTimeout))
+		header := append(from.id[:len(from.id):len(from.id)], publicKey...)
+		if _, err = relayed.Write(appendSignature(header, signature)); err != nil {
+			relayed.Close()
+		}
+	}
+	if err != nil {
+		logger.Debug("failed to open relayed stream", "target", target, "error", err, "error_kind", intErrors.Kind(err))
+		stream.Write([]byte{relayUnreachable})
+		stream.Close()
+		return
+	}
+
+	if _, err := stream.Write([]byte{relayOK}); err != nil {
+		stream.Close()
+		relayed.Close()
+		return
+	}
+	stream.SetDeadline(time.Time{})
+	relayed.SetDeadline(time.Time{})
+
+	done := make(chan struct{}, 2)
+	go func() {
+		io.Copy(relayed, stream)
+		done <- struct{}{}
+	}()
+	go func() {
+		io.Copy(stream, relayed)
+		done <- struct{}{}
+	}()
+	<-done
+	stream.Close()
+	relayed.Close()
+}
+
+func (s *Server) resolveRelay(from *ClientConn, target string) (*ClientConn, error) {
+	resolve := s.relay.Load()
+	if resolve == nil {
+		return nil, intErrors.ErrRelayDenied
+	}
+	to, err := (*resolve)(from, target)
+	if err != nil {
+		return nil, err
+	}
+	if to == nil {
+		return nil, intErrors.ErrPeerNotFound
+	}
+	return to, nil
+}
+
+// WithRelayIdentity sets the RSA key the client signs the keys of its relayed streams with, which the other
+// client checks against the public key it expects, see Client.OpenRelayStream. Clients without an identity
+// can neither open nor accept relayed streams.
+func WithRelayIdentity(privateKey *rsa.PrivateKey) DialOption {
+	return func(o *dialOptions) {
+		o.relayIdentity = privateKey
+	}
+}
+
+// OpenRelayStream opens a stream named name to the client the server resolves target to, see Server.SetRelay.
+// The server splices the stream to one it opens on that client, which accepts it with AcceptRelayStream.
+// Both clients agree on an AES key through an X25519 key exchange, which the encrypted transfer methods
+// of the stream use instead of the connection's, so the server relays their payloads without being able
+// to read them. The raw Read and Write methods are not encrypted end to end.
+// Each client signs its half of the exchange with its relay identity (see WithRelayIdentity), and peerKey is
+// the public key of the identity the other client must have, so a server which tampers with the exchange
+// fails it instead of reading the payloads.
+// The ctx argument defines the stream's deadline while timeout defines the handshake's deadline,
+// a server which does not relay never accepts the stream, which times out.
+//
+// Possible errors:
+//   - ErrNameTooLong: target is longer than 255 bytes or name is too long
+//   - ErrRelay: the server did not relay the stream, joined with ErrRelayDenied, ErrPeerNotFound or the reason,
+//     or the client has no relay identity or peerKey is nil
+//   - ErrRelayKey: the key of the other client is not signed by peerKey
+//   - ErrRead: failed to receive the server's answer or the key of the other client
+//   - ErrWrite: failed to send the request
+//   - the errors returned by OpenStream
+func (c *Client) OpenRelayStream(target, name string, peerKey *rsa.PublicKey, ctx context.Context, timeout time.Duration) (*Stream, error) {
+	if len(target) > 0xFF || len(name) > 0xFFFF-len(relayStreamPrefix) {
+		return nil, intErrors.ErrNameTooLong
+	}
+	if err := c.checkRelayIdentity(peerKey); err != nil {
+		return nil, err
+	}
+
+	private, err := ecdh.X25519().GenerateKey(rand.Reader)
+	if err != nil {
+		return nil, errors.Join(intErrors.ErrRelay, err)
+	}
+
+	stream, err := c.OpenStream(relayStreamName, ctx, timeout)
+	if err != nil {
+		return nil, err
+	}
+	if timeout > 0 {
+		stream.SetDeadline(time.Now().Add(timeout))
+	}
+
+	key, err := openRelay(stream, target, name, private, c.relayIdentity, peerKey)
+	if err != nil {
+		stream.Close()
+		return nil, err
+	}
+	stream.SetDeadline(time.Time{})
+	stream.SetAESKey(key)
+	return stream, nil
+}
+
+func openRelay(stream *Stream, target, name string, private *ecdh.PrivateKey, identity *rsa.PrivateKey, peerKey *rsa.PublicKey) ([]byte, error) {
+	publicKey := private.PublicKey().Bytes()
+	signature, err := signRelayKey(identity, relayOpenerLabel, name, publicKey)
+	if err != nil {
+		return nil, err
+	}
+	if _, err := stream.Write(encodeRelayRequest(target, name, publicKey, signature)); err != nil {
+		return nil, errors.Join(intErrors.ErrWrite, err)
+	}
+
+	status := make([]byte, 1)
+	if _, err := io.ReadFull(stream, status); err != nil {
+		return nil, errors.Join(intErrors.ErrRead, err)
+	}
+	if status[0] != relayOK {
+		return nil, errors.Join(intErrors.ErrRelay, relayError(status[0]))
+	}
+
+	accepterKey := make([]byte, len(publicKey))
+	if _, err := io.ReadFull(stream, accepterKey); err != nil {
+		return nil, errors.Join(intErrors.ErrRead, err)
+	}
+	signature, err = readSignature(stream)
+	if err != nil {
+		return nil, err
+	}
+	if err := verifyRelayKey(peerKey, signature, relayAccepterLabel, name, publicKey, accepterKey); err != nil {
+		return nil, err
+	}
+	return relayKey(private, accepterKey, publicKey, accepterKey)
+}
+
+// AcceptRelayStream accepts a stream named name which another client opened with OpenRelayStream,
+// and returns it along with the ID the server knows that client by. The stream's encrypted transfer
+// methods use the key both clients agreed on, peerKey is the public key of the relay identity the
+// other client must have signed its key with.
+// The ctx argument defines the stream's deadline while timeout defines the handshake's deadline.
+//
+// Possible errors:
+//   - ErrRead: failed to receive the key of the other client
+//   - ErrWrite: failed to send the key of this client
+//   - ErrRelay: the key exchange failed, or the client has no relay identity or peerKey is nil
+//   - ErrRelayKey: the key of the other client is not signed by peerKey
+//   - the errors returned by AcceptStream
+func (c *Client) AcceptRelayStream(name string, peerKey *rsa.PublicKey, ctx context.Context, timeout time.Duration) (*Stream, ClientID, error) {
+	if err := c.checkRelayIdentity(peerKey); err != nil {
+		return nil, ClientID{}, err
+	}
+
+	stream, err := c.AcceptStream(relayStreamPrefix+name, ctx, timeout)
+	if err != nil {
+		return nil, ClientID{}, err
+	}
+	if timeout > 0 {
+		stream.SetDeadline(time.Now().Add(timeout))
+	}
+
+	from, key, err := acceptRelay(stream, name, c.relayIdentity, peerKey)
+	if err != nil {
+		stream.Close()
+		return nil, ClientID{}, err
+	}
+	stream.SetDeadline(time.Time{})
+	stream.SetAESKey(key)
+	return stream, from, nil
+}
+
+func acceptRelay(stream *Stream, name string, identity *rsa.PrivateKey, peerKey *rsa.PublicKey) (ClientID, []byte, error) {
+	var from ClientID
+	header := make([]byte, len(from)+relayPublicKeySize)
+	if _, err := io.ReadFull(stream, header); err != nil {
+		return from, nil, errors.Join(intErrors.ErrRead, err)
+	}
+	copy(from[:], header)
+	openerKey := header[len(from):]
+
+	signature, err := readSignature(stream)
+	if err != nil {
+		return from, nil, err
+	}
+	if err := verifyRelayKey(peerKey, signature, relayOpenerLabel, name, openerKey); err != nil {
+		return from, nil, err
+	}
+
+	private, err := ecdh.X25519().GenerateKey(rand.Reader)
+	if err != nil {
+		return from, nil, errors.Join(intErrors.ErrRelay, err)
+	}
+	publicKey := private.PublicKey().Bytes()
+	signature, err = signRelayKey(identity, relayAccepterLabel, name, openerKey, publicKey)
+	if err != nil {
+		return from, nil, err
+	}
+
+	if _, err := stream.Write(appendSignature(publicKey, signature)); err != nil {
+		return from, nil, errors.Join(intErrors.ErrWrite, err)
+	}
+	key, err := relayKey(private, openerKey, openerKey, publicKey)
+	return from, key, err
+}
+
+func (c *Client) checkRelayIdentity(peerKey *rsa.PublicKey) error {
+	if c.relayIdentity == nil {
+		return errors.Join(intErrors.ErrRelay, errors.New("the client has no relay identity"))
+	}
+	if peerKey == nil {
+		return errors.Join(intErrors.ErrRelay, errors.New("no public key to check the other client against"))
+	}
+	return nil
+}
+
+// signRelayKey signs the keys of a relayed key exchange as label, see relayDigest.
+func signRelayKey(identity *rsa.PrivateKey, label, name string, keys ...[]byte) ([]byte, error) {
+	signature, err := rsa.SignPSS(rand.Reader, identity, crypto.SHA256, relayDigest(label, name, keys...), nil)
+	if err != nil {
+		return nil, errors.Join(intErrors.ErrRelay, err)
+	}
+	return signature, nil
+}
+
+func verifyRelayKey(peerKey *rsa.PublicKey, signature []byte, label, name string, keys ...[]byte) error {
+	if err := rsa.VerifyPSS(peerKey, crypto.SHA256, relayDigest(label, name, keys...), signature, nil); err != nil {
+		return errors.Join(intErrors.ErrRelayKey, err)
+	}
+	return nil
+}
+
+// relayDigest hashes label, the name of the relayed stream and the keys of the exchange known so far,
+// the opener's key alone for the opener and both for the accepter.
+func relayDigest(label, name string, keys ...[]byte) []byte {
+	h := sha256.New()
+	h.Write([]byte(label))
+	h.Write(binary.BigEndian.AppendUint16(nil, uint16(len(name))))
+	h.Write([]byte(name))
+	for _, key := range keys {
+		h.Write(key)
+	}
+	return h.Sum(nil)
+}
+
+// appendSignature appends signature length(2) + signature to b.
+func appendSignature(b, signature []byte) []byte {
+	b = binary.BigEndian.AppendUint16(b[:len(b):len(b)], uint16(len(signature)))
+	return append(b, signature...)
+}
+
+func readSignature(stream *Stream) ([]byte, error) {
+	length := make([]byte, 2)
+	if _, err := io.ReadFull(stream, length); err != nil {
+		return nil, errors.Join(intErrors.ErrRead, err)
+	}
+	size := binary.BigEndian.Uint16(length)
+	if size > relayMaxSignatureSize {
+		return nil, errors.Join(intErrors.ErrRelayKey, fmt.Errorf("signature of %d bytes", size))
+	}
+	signature := make([]byte, size)
+	if _, err := io.ReadFull(stream, signature); err != nil {
+		return nil, errors.Join(intErrors.ErrRead, err)
+	}
+	return signature, nil
+}
+
+// relayKey derives the AES key of a relayed stream from the X25519 exchange of both clients.
+func relayKey(private *ecdh.PrivateKey, peerKey, openerKey, accepterKey []byte) ([]byte, error) {
+	public, err := ecdh.X25519().NewPublicKey(peerKey)
+	if err != nil {
+		return nil, errors.Join(intErrors.ErrRelay, err)
+	}
+	secret, err := private.ECDH(public)
+	if err != nil {
+		return nil, errors.Join(intErrors.ErrRelay, err)
+	}
+
+	salt := append(openerKey[:len(openerKey):len(openerKey)], accepterKey...)
+	key, err := hkdf.Key(sha256.New, secret, salt, relayKeyInfo, relayKeySize)
+	if err != nil {
+		return nil, errors.Join(intErrors.ErrRelay, err)
+	}
+	return key, nil
+}
+
+// encodeRelayRequest encodes target length(1) + target + name length(2) + name + public key
+// + signature length(2) + signature.
+func encodeRelayRequest(target, name string, publicKey, signature []byte) []byte {
+	request := make([]byte, 0, 5+len(target)+len(name)+len(publicKey)+len(signature))
+	request = append(request, byte(len(target)))
+	request = append(request, target...)
+	request = binary.BigEndian.AppendUint16(request, uint16(len(name)))
+	request = append(request, name...)
+	return appendSignature(append(request, publicKey...), signature)
+}
+
+func readRelayRequest(stream *Stream) (target, name string, publicKey, signature []byte, err error) {
+	length := make([]byte, 2)
+	if _, err := io.ReadFull(stream, length[:1]); err != nil {
+		return "", "", nil, nil, errors.Join(intErrors.ErrRead, err)
+	}
+	buf := make([]byte, length[0])
+	if _, err := io.ReadFull(stream, buf); err != nil {
+		return "", "", nil, nil, errors.Join(intErrors.ErrRead, err)
+	}
+	target = string(buf)
+
+	if _, err := io.ReadFull(stream, length); err != nil {
+		return "", "", nil, nil, errors.Join(intErrors.ErrRead, err)
+	}
+	buf = make([]byte, binary.BigEndian.Uint16(length))
+	if _, err := io.ReadFull(stream, buf); err != nil {
+		return "", "", nil, nil, errors.Join(intErrors.ErrRead, err)
+	}
+	name = string(buf)
+
+	publicKey = make([]byte, relayPublicKeySize)
+	if _, err := io.ReadFull(stream, publicKey); err != nil {
+		return "", "", nil, nil, errors.Join(intErrors.ErrRead, err)
+	}
+	signature, err = readSignature(stream)
+	if err != nil {
+		return "", "", nil, nil, err
+	}
+	return target, name, publicKey, signature, nil
+}
+
+func relayStatus(err error) byte {
+	if errors.Is(err, intErrors.ErrPeerNotFound) {
+		return relayNotFound
+	}
+	return relayDenied
+}
+
+func relayError(status byte) error {
+	switch status {
+	case relayDenied:
+		return intErrors.ErrRelayDenied
+	case relayNotFound:
+		return intErrors.ErrPeerNotFound
+	case relayUnreachable:
+		return errors.New("the target client did not accept the stream")
+	default:
+		return fmt.Errorf("unknown relay status: %d", status)
+	}
+}
@@ -0,0 +1,38 @@
+package rendezvous
+
+import "time"
+
+// StreamName is the name of the stream a peer talks to the rendezvous server through.
+const StreamName = "onynet/rendezvous"
+
+// relayStreamPrefix prefixes the names of the streams relaying a connection between two peers.
+const relayStreamPrefix = "onynet/rendezvous/relay/"
+
+const maxNameLength = 0xFF
+
+const (
+	methodRegister  = "register"
+	methodConnect   = "connect"
+	methodIntroduce = "introduce"
+	methodRelay     = "relay"
+)
+
+// The status the answers of the rendezvous server start with, the failures a peer may check for have their own.
+const (
+	statusOK byte = iota
+	statusNameTaken
+	statusPeerNotFound
+	statusRelay
+)
+
+const (
+	// DefaultPunchTimeout is how long a peer punches holes towards another one when no timeout is given.
+	DefaultPunchTimeout = 5 * time.Second
+	// punchInterval is the time between two punch packets.
+	punchInterval = 100 * time.Millisecond
+	// callTimeout bounds the calls made to the rendezvous server and the peers.
+	callTimeout = 10 * time.Second
+)
+
+// punchPacket opens NAT mappings, it is shorter than a KCP header so the peer's sessions ignore it.
+var punchPacket = []byte("ONYP")
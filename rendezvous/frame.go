@@ -0,0 +1,96 @@
+package rendezvous
+
+import (
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"net"
+
+	intErrors "github.com/Onyz107/onynet/errors"
+)
+
+// introduction tells a peer about another one: its name, the public address the rendezvous server sees
+// it from and its public key, if it has one.
+type introduction struct {
+	name      string
+	addr      net.Addr
+	publicKey *rsa.PublicKey
+}
+
+// encodeIntroduction encodes an introduction as name length(1) + name + address length(1) + address + key.
+// The address may be empty, for a peer registering itself.
+func encodeIntroduction(name, addr string, publicKey []byte) []byte {
+	frame := make([]byte, 0, 2+len(name)+len(addr)+len(publicKey))
+	frame = append(frame, byte(len(name)))
+	frame = append(frame, name...)
+	frame = append(frame, byte(len(addr)))
+	frame = append(frame, addr...)
+	return append(frame, publicKey...)
+}
+
+func decodeIntroductionFields(frame []byte) (name, addr string, publicKey []byte, err error) {
+	name, frame, err = readString(frame)
+	if err != nil {
+		return "", "", nil, err
+	}
+	addr, frame, err = readString(frame)
+	if err != nil {
+		return "", "", nil, err
+	}
+	return name, addr, frame, nil
+}
+
+func decodeIntroduction(frame []byte) (*introduction, error) {
+	name, addr, publicKey, err := decodeIntroductionFields(frame)
+	if err != nil {
+		return nil, err
+	}
+
+	udpAddr, err := net.ResolveUDPAddr("udp", addr)
+	if err != nil {
+		return nil, errors.Join(intErrors.ErrMalformedFrame, err)
+	}
+	intro := &introduction{name: name, addr: udpAddr}
+
+	if len(publicKey) > 0 {
+		key, err := x509.ParsePKIXPublicKey(publicKey)
+		if err != nil {
+			return nil, errors.Join(intErrors.ErrMalformedFrame, err)
+		}
+		rsaKey, ok := key.(*rsa.PublicKey)
+		if !ok {
+			return nil, errors.Join(intErrors.ErrMalformedFrame, fmt.Errorf("unsupported public key: %T", key))
+		}
+		intro.publicKey = rsaKey
+	}
+	return intro, nil
+}
+
+// encodeRelay encodes the relay of a connection as id(8) + the introduction of the peer connecting.
+func encodeRelay(id uint64, intro []byte) []byte {
+	return append(binary.BigEndian.AppendUint64(nil, id), intro...)
+}
+
+func decodeRelay(frame []byte) (uint64, []byte, error) {
+	if len(frame) < 8 {
+		return 0, nil, intErrors.ErrMalformedFrame
+	}
+	return binary.BigEndian.Uint64(frame), frame[8:], nil
+}
+
+func readString(frame []byte) (string, []byte, error) {
+	if len(frame) < 1 {
+		return "", nil, intErrors.ErrMalformedFrame
+	}
+	length := int(frame[0])
+	if len(frame) < 1+length {
+		return "", nil, intErrors.ErrMalformedFrame
+	}
+	return string(frame[1 : 1+length]), frame[1+length:], nil
+}
+
+func relayStreamName(id uint64) string {
+	return fmt.Sprintf("%s%d", relayStreamPrefix, id)
+}
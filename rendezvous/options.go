@@ -0,0 +1,64 @@
+package rendezvous
+
+import (
+	"net"
+	"time"
+
+	"github.com/Onyz107/onynet"
+	"github.com/Onyz107/onynet/internal/kcp"
+)
+
+// Option configures a Peer created by Join.
+type Option func(*options)
+
+type options struct {
+	transport    onynet.PacketTransport
+	localAddr    net.Addr
+	punchTimeout time.Duration
+	relay        bool
+	dialOptions  []onynet.DialOption
+}
+
+// WithPacketTransport sets the transport the peer's socket is created with, such as a NAT of
+// the onynettest package. A nil transport means UDP, which is the default.
+func WithPacketTransport(transport onynet.PacketTransport) Option {
+	return func(o *options) {
+		if transport == nil {
+			transport = kcp.UDP
+		}
+		o.transport = transport
+	}
+}
+
+// WithLocalAddr sets the address the peer's socket is bound to, a free port of every address by default.
+func WithLocalAddr(addr net.Addr) Option {
+	return func(o *options) {
+		o.localAddr = addr
+	}
+}
+
+// WithPunchTimeout sets how long peers punch holes towards each other when connecting.
+// A duration lower or equal to 0 means DefaultPunchTimeout.
+func WithPunchTimeout(d time.Duration) Option {
+	return func(o *options) {
+		if d <= 0 {
+			d = DefaultPunchTimeout
+		}
+		o.punchTimeout = d
+	}
+}
+
+// WithoutRelay makes Connect fail when the peers cannot connect directly, instead of relaying
+// the connection through the rendezvous server.
+func WithoutRelay() Option {
+	return func(o *options) {
+		o.relay = false
+	}
+}
+
+// WithDialOptions sets the options of the clients the peer dials, to the rendezvous server and to other peers.
+func WithDialOptions(opts ...onynet.DialOption) Option {
+	return func(o *options) {
+		o.dialOptions = opts
+	}
+}
@@ -0,0 +1,311 @@
+// Package rendezvous connects peers behind NATs to each other: a rendezvous server tells registered peers
+// the public address it sees the others from, so they can punch holes through their NATs and establish
+// a direct authenticated onynet connection, relayed through the rendezvous server when that fails.
+package rendezvous
+
+import (
+	"context"
+	"crypto/rsa"
+	"crypto/x509"
+	"errors"
+	"log/slog"
+	"net"
+	"time"
+
+	"github.com/Onyz107/onynet"
+	intErrors "github.com/Onyz107/onynet/errors"
+	"github.com/Onyz107/onynet/internal/kcp"
+	"github.com/Onyz107/onynet/rpc"
+)
+
+// Peer is registered with a rendezvous server under a name, it connects to the other peers registered
+// and accepts their connections. Its server and clients all share one socket, so the NAT mapping the
+// rendezvous server sees the peer from is the one other peers punch holes towards.
+type Peer struct {
+	name       string
+	shared     *onynet.SharedSocket
+	server     *onynet.Server
+	client     *onynet.Client
+	conn       *rpc.Conn
+	publicAddr net.Addr
+	options    options
+	ctx        context.Context
+	cancel     context.CancelFunc
+	logger     *slog.Logger
+}
+
+// Join connects to the rendezvous server at addr, authenticated with serverKey unless it is nil,
+// and registers as name. The peer accepts the connections of other peers, authenticated with
+// privateKey unless it is nil, whose public key is handed to the peers connecting.
+// The ctx argument defines the peer's lifetime.
+//
+// Possible errors:
+//   - ErrNameTooLong: name is longer than 255 bytes
+//   - ErrBadAddr: failed to create the peer's socket
+//   - ErrNameTaken: another peer is registered as name (joined with ErrRemote)
+//   - the errors returned by onynet.NewServer, onynet.Dial and OpenStream
+func Join(addr net.Addr, name string, privateKey *rsa.PrivateKey, serverKey *rsa.PublicKey, ctx context.Context, opts ...Option) (*Peer, error) {
+	if len(name) > maxNameLength {
+		return nil, intErrors.ErrNameTooLong
+	}
+
+	options := options{transport: kcp.UDP, localAddr: &net.UDPAddr{}, punchTimeout: DefaultPunchTimeout, relay: true}
+	for _, opt := range opts {
+		opt(&options)
+	}
+
+	var publicKey []byte
+	if privateKey != nil {
+		var err error
+		if publicKey, err = x509.MarshalPKIXPublicKey(&privateKey.PublicKey); err != nil {
+			return nil, errors.Join(intErrors.ErrPrivateKey, err)
+		}
+	}
+
+	socket, err := options.transport.Listen(options.localAddr)
+	if err != nil {
+		return nil, errors.Join(intErrors.ErrBadAddr, err)
+	}
+
+	ctx, cancel := context.WithCancel(ctx)
+	p := &Peer{
+		name:    name,
+		shared:  onynet.NewSharedSocket(socket),
+		options: options,
+		ctx:     ctx,
+		cancel:  cancel,
+	}
+
+	if err := p.join(addr, privateKey, serverKey, publicKey); err != nil {
+		p.Close()
+		return nil, err
+	}
+	return p, nil
+}
+
+func (p *Peer) join(addr net.Addr, privateKey *rsa.PrivateKey, serverKey *rsa.PublicKey, publicKey []byte) error {
+	var err error
+	p.server, err = onynet.NewServerWithTransport(p.shared.LocalAddr(), privateKey, p.shared, p.ctx)
+	if err != nil {
+		return err
+	}
+
+	p.client, err = onynet.Dial(addr, serverKey, p.ctx, p.dialOptions()...)
+	if err != nil {
+		return err
+	}
+	p.logger = p.client.Logger().With("peer", p.name)
+
+	mux := rpc.NewMux()
+	mux.Register(methodIntroduce, p.punchBack)
+	mux.Register(methodRelay, p.acceptRelay)
+
+	stream, err := p.client.OpenStream(StreamName, p.ctx, callTimeout)
+	if err != nil {
+		return err
+	}
+	p.conn = rpc.NewConn(stream, mux, p.ctx)
+
+	ctx, cancel := context.WithTimeout(p.ctx, callTimeout)
+	defer cancel()
+	public, err := p.call(ctx, methodRegister, encodeIntroduction(p.name, "", publicKey))
+	if err != nil {
+		return err
+	}
+	if p.publicAddr, err = net.ResolveUDPAddr("udp", string(public)); err != nil {
+		return errors.Join(intErrors.ErrMalformedFrame, err)
+	}
+	return nil
+}
+
+// Name returns the name the peer is registered as.
+func (p *Peer) Name() string {
+	return p.name
+}
+
+// PublicAddr returns the address the rendezvous server sees the peer from, the public address of its NAT mapping.
+func (p *Peer) PublicAddr() net.Addr {
+	return p.publicAddr
+}
+
+// Server returns the server accepting the connections of other peers, to configure it.
+// Relayed connections come from the rendezvous server's address, per address admission limits count them together.
+func (p *Peer) Server() *onynet.Server {
+	return p.server
+}
+
+// Accept waits for another peer to connect, directly or relayed.
+//
+// Possible errors are the same as the ones returned by Server.Accept.
+func (p *Peer) Accept() (*onynet.ClientConn, error) {
+	return p.server.Accept()
+}
+
+// Connect connects to the peer registered as name. The rendezvous server introduces the peers to each
+// other, then both punch holes through their NATs towards the address the server sees the other one from,
+// while this peer dials the other one directly. If the direct connection fails, it is relayed through the
+// rendezvous server unless WithoutRelay was given, relayed connections carry no datagrams.
+// Either way the connection is authenticated with the public key the other peer registered, and the
+// ctx argument defines the client's lifetime as with onynet.Dial.
+//
+// Possible errors:
+//   - ErrNameTooLong: name is longer than 255 bytes
+//   - ErrPeerNotFound: no peer is registered as name (joined with ErrRemote)
+//   - ErrHolePunch: the direct connection failed and relaying is disabled, joined with the reason
+//   - ErrRelay: the direct and the relayed connections both failed, joined with ErrHolePunch and both reasons
+//   - the errors returned by Call
+func (p *Peer) Connect(name string, ctx context.Context) (*onynet.Client, error) {
+	if len(name) > maxNameLength {
+		return nil, intErrors.ErrNameTooLong
+	}
+
+	callCtx, cancel := context.WithTimeout(ctx, callTimeout)
+	defer cancel()
+	frame, err := p.call(callCtx, methodConnect, []byte(name))
+	if err != nil {
+		return nil, err
+	}
+	intro, err := decodeIntroduction(frame)
+	if err != nil {
+		return nil, err
+	}
+
+	client, err := p.dialDirect(intro, ctx)
+	if err == nil {
+		return client, nil
+	}
+	err = errors.Join(intErrors.ErrHolePunch, err)
+	if !p.options.relay {
+		return nil, err
+	}
+	p.logger.Debug("relaying connection", "target", name, "error", err, "error_kind", intErrors.Kind(err))
+
+	client, relayErr := p.dialRelayed(intro, ctx)
+	if relayErr != nil {
+		return nil, errors.Join(intErrors.ErrRelay, relayErr, err)
+	}
+	return client, nil
+}
+
+// Close unregisters the peer and closes its server, its connection to the rendezvous server and its socket.
+// The connections to other peers are closed along with the socket, relayed ones along with the rendezvous connection.
+func (p *Peer) Close() error {
+	var errs []error
+	if p.client != nil {
+		errs = append(errs, p.client.Close())
+	}
+	if p.server != nil {
+		errs = append(errs, p.server.Close())
+	}
+	errs = append(errs, p.shared.Close())
+	p.cancel()
+	return errors.Join(errs...)
+}
+
+func (p *Peer) dialDirect(intro *introduction, ctx context.Context) (*onynet.Client, error) {
+	punchCtx, stop := context.WithTimeout(ctx, p.options.punchTimeout)
+	defer stop()
+	go p.punch(intro.addr, punchCtx)
+
+	return onynet.Dial(intro.addr, intro.publicKey, ctx, p.dialOptions()...)
+}
+
+func (p *Peer) dialRelayed(intro *introduction, ctx context.Context) (*onynet.Client, error) {
+	callCtx, cancel := context.WithTimeout(ctx, callTimeout)
+	defer cancel()
+	frame, err := p.call(callCtx, methodRelay, []byte(intro.name))
+	if err != nil {
+		return nil, err
+	}
+	id, _, err := decodeRelay(frame)
+	if err != nil {
+		return nil, err
+	}
+
+	stream, err := p.client.OpenStream(relayStreamName(id), ctx, callTimeout)
+	if err != nil {
+		return nil, err
+	}
+	return onynet.DialConn(stream, intro.publicKey, ctx, p.options.dialOptions...)
+}
+
+// punch sends punch packets to addr until ctx is done, so the NAT of the peer lets in the packets coming from addr.
+func (p *Peer) punch(addr net.Addr, ctx context.Context) {
+	ticker := time.NewTicker(punchInterval)
+	defer ticker.Stop()
+
+	for {
+		p.shared.WriteTo(punchPacket, addr)
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// punchBack punches holes towards the peer the rendezvous server introduces, which is about to connect.
+func (p *Peer) punchBack(ctx context.Context, payload []byte) ([]byte, error) {
+	intro, err := decodeIntroduction(payload)
+	if err != nil {
+		return nil, err
+	}
+
+	punchCtx, stop := context.WithTimeout(p.ctx, p.options.punchTimeout)
+	go func() {
+		defer stop()
+		p.punch(intro.addr, punchCtx)
+	}()
+	return nil, nil
+}
+
+// acceptRelay hands the relay stream the rendezvous server is about to open to the peer's server.
+func (p *Peer) acceptRelay(ctx context.Context, payload []byte) ([]byte, error) {
+	id, _, err := decodeRelay(payload)
+	if err != nil {
+		return nil, err
+	}
+
+	go func() {
+		stream, err := p.client.AcceptStream(relayStreamName(id), p.ctx, callTimeout)
+		if err != nil {
+			p.logger.Debug("failed to accept relayed connection", "error", err, "error_kind", intErrors.Kind(err))
+			return
+		}
+		if err := p.server.ServeConn(stream); err != nil {
+			stream.Close()
+		}
+	}()
+	return nil, nil
+}
+
+func (p *Peer) dialOptions() []onynet.DialOption {
+	return append(p.options.dialOptions[:len(p.options.dialOptions):len(p.options.dialOptions)], onynet.WithPacketTransport(p.shared))
+}
+
+// call calls method of the rendezvous server and returns its answer without its status, a failure is
+// returned as the error of its status joined with ErrRemote and the server's message.
+func (p *Peer) call(ctx context.Context, method string, payload []byte) ([]byte, error) {
+	frame, err := p.conn.Call(ctx, method, payload)
+	if err != nil {
+		return nil, err
+	}
+	if len(frame) == 0 {
+		return nil, intErrors.ErrMalformedFrame
+	}
+
+	var sentinel error
+	switch frame[0] {
+	case statusOK:
+		return frame[1:], nil
+	case statusNameTaken:
+		sentinel = intErrors.ErrNameTaken
+	case statusPeerNotFound:
+		sentinel = intErrors.ErrPeerNotFound
+	case statusRelay:
+		sentinel = intErrors.ErrRelay
+	default:
+		return nil, intErrors.ErrMalformedFrame
+	}
+	return nil, errors.Join(intErrors.ErrRemote, sentinel, errors.New(string(frame[1:])))
+}
@@ -0,0 +1,179 @@
+package rendezvous_test
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/rsa"
+	"errors"
+	"io"
+	"net"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/Onyz107/onynet"
+	intErrors "github.com/Onyz107/onynet/errors"
+	"github.com/Onyz107/onynet/onynettest"
+	"github.com/Onyz107/onynet/rendezvous"
+)
+
+var privateKey = sync.OnceValue(func() *rsa.PrivateKey {
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		panic(err)
+	}
+	return key
+})
+
+func newRendezvous(tb testing.TB, network *onynettest.Network) (*rendezvous.Server, net.Addr) {
+	tb.Helper()
+
+	server := onynettest.NewServer(tb, network, nil)
+	rv := rendezvous.NewServer()
+	go func() {
+		for {
+			clientConn, err := server.Accept()
+			if err != nil {
+				return
+			}
+			go rv.Serve(clientConn, context.Background(), 5*time.Second)
+		}
+	}()
+	return rv, server.Addr()
+}
+
+func join(tb testing.TB, addr net.Addr, name string, transport onynet.PacketTransport, opts ...rendezvous.Option) *rendezvous.Peer {
+	tb.Helper()
+
+	peer, err := rendezvous.Join(addr, name, privateKey(), nil, context.Background(), append(opts, rendezvous.WithPacketTransport(transport))...)
+	if err != nil {
+		tb.Fatal(err)
+	}
+	tb.Cleanup(func() { peer.Close() })
+	return peer
+}
+
+// connect connects from to to and checks a message goes through, it returns the end accepted by to.
+func connect(tb testing.TB, from, to *rendezvous.Peer) *onynet.ClientConn {
+	tb.Helper()
+
+	accepted := make(chan *onynet.ClientConn, 1)
+	go func() {
+		clientConn, err := to.Accept()
+		if err != nil {
+			tb.Error(err)
+		}
+		accepted <- clientConn
+	}()
+
+	client, err := from.Connect(to.Name(), context.Background())
+	if err != nil {
+		tb.Fatal(err)
+	}
+	tb.Cleanup(func() { client.Close() })
+	clientConn := <-accepted
+	if clientConn == nil {
+		tb.FailNow()
+	}
+
+	go func() {
+		stream, err := client.OpenStream("hello", context.Background(), 5*time.Second)
+		if err != nil {
+			tb.Error(err)
+			return
+		}
+		stream.Write([]byte("hello"))
+	}()
+	stream, err := clientConn.AcceptStream("hello", context.Background(), 5*time.Second)
+	if err != nil {
+		tb.Fatal(err)
+	}
+	buf := make([]byte, 5)
+	stream.SetReadDeadline(time.Now().Add(5 * time.Second))
+	if _, err := io.ReadFull(stream, buf); err != nil || string(buf) != "hello" {
+		tb.Fatalf("expected hello, got: %q, %v", buf, err)
+	}
+	return clientConn
+}
+
+func TestHolePunch(t *testing.T) {
+	behaviors := map[string]onynettest.NATBehavior{
+		"FullCone":           onynettest.FullCone,
+		"RestrictedCone":     onynettest.RestrictedCone,
+		"PortRestrictedCone": onynettest.PortRestrictedCone,
+	}
+
+	for name, behavior := range behaviors {
+		t.Run(name, func(t *testing.T) {
+			t.Parallel()
+			network := onynettest.NewNetwork()
+			_, addr := newRendezvous(t, network)
+
+			alice := join(t, addr, "alice", onynettest.NewNAT(network, net.IPv4(203, 0, 113, 1), behavior), rendezvous.WithoutRelay())
+			bob := join(t, addr, "bob", onynettest.NewNAT(network, net.IPv4(198, 51, 100, 1), behavior), rendezvous.WithoutRelay())
+			if !alice.PublicAddr().(*net.UDPAddr).IP.Equal(net.IPv4(203, 0, 113, 1)) {
+				t.Fatalf("expected the public address to be the NAT's, got: %s", alice.PublicAddr())
+			}
+
+			clientConn := connect(t, bob, alice)
+			if clientConn.RemoteAddr().String() != bob.PublicAddr().String() {
+				t.Fatalf("expected a direct connection from %s, got: %s", bob.PublicAddr(), clientConn.RemoteAddr())
+			}
+		})
+	}
+}
+
+func TestRelay(t *testing.T) {
+	t.Parallel()
+	network := onynettest.NewNetwork()
+	rv, addr := newRendezvous(t, network)
+
+	alice := join(t, addr, "alice", onynettest.NewNAT(network, net.IPv4(203, 0, 113, 1), onynettest.Symmetric))
+	bob := join(t, addr, "bob", onynettest.NewNAT(network, net.IPv4(198, 51, 100, 1), onynettest.Symmetric))
+
+	clientConn := connect(t, bob, alice)
+	if clientConn.RemoteAddr().String() == bob.PublicAddr().String() {
+		t.Fatal("expected the connection through symmetric NATs to be relayed")
+	}
+
+	rv.SetRelay(false)
+	if _, err := bob.Connect("alice", context.Background()); !errors.Is(err, intErrors.ErrRelay) || !errors.Is(err, intErrors.ErrHolePunch) {
+		t.Fatalf("expected ErrRelay and ErrHolePunch, got: %v", err)
+	}
+}
+
+func TestRegister(t *testing.T) {
+	network := onynettest.NewNetwork()
+	rv, addr := newRendezvous(t, network)
+
+	alice := join(t, addr, "alice", network)
+	if _, err := rendezvous.Join(addr, "alice", nil, nil, context.Background(), rendezvous.WithPacketTransport(network)); !errors.Is(err, intErrors.ErrNameTaken) {
+		t.Fatalf("expected ErrNameTaken, got: %v", err)
+	}
+	if _, err := alice.Connect("bob", context.Background()); !errors.Is(err, intErrors.ErrPeerNotFound) {
+		t.Fatalf("expected ErrPeerNotFound, got: %v", err)
+	}
+
+	if rv.Peers() != 1 {
+		t.Fatalf("expected 1 peer, got: %d", rv.Peers())
+	}
+	alice.Close()
+	deadline := time.Now().Add(5 * time.Second)
+	for rv.Peers() != 0 {
+		if time.Now().After(deadline) {
+			t.Fatal("expected the peer to be unregistered once closed")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func TestLongestName(t *testing.T) {
+	network := onynettest.NewNetwork()
+	_, addr := newRendezvous(t, network)
+
+	// Names of the longest length allowed register and are introduced to each other
+	alice := join(t, addr, strings.Repeat("a", 255), network)
+	bob := join(t, addr, strings.Repeat("b", 255), network)
+	connect(t, bob, alice)
+}
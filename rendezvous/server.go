@@ -0,0 +1,266 @@
+package rendezvous
+
+import (
+	"context"
+	"encoding/binary"
+	"errors"
+	"io"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/Onyz107/onynet"
+	intErrors "github.com/Onyz107/onynet/errors"
+	intSmux "github.com/Onyz107/onynet/internal/smux"
+	"github.com/Onyz107/onynet/rpc"
+)
+
+// Server introduces the peers registered with it to each other, so they can punch holes through their NATs
+// and connect directly, and relays their connections when they cannot. A single Server is shared by every
+// client of an onynet.Server.
+type Server struct {
+	mux       *rpc.Mux
+	mu        sync.Mutex
+	peers     map[string]*member    // registered name -> peer
+	members   map[*rpc.Conn]*member // rendezvous stream -> peer
+	nextRelay atomic.Uint64
+	relay     atomic.Bool
+}
+
+// member is a peer served by the Server, registered once it has a name.
+type member struct {
+	clientConn *onynet.ClientConn
+	conn       *rpc.Conn
+	name       string
+	publicKey  []byte
+}
+
+// NewServer returns a Server with no peer registered, which relays connections until SetRelay(false) is called.
+func NewServer() *Server {
+	s := &Server{
+		mux:     rpc.NewMux(),
+		peers:   make(map[string]*member),
+		members: make(map[*rpc.Conn]*member),
+	}
+	s.relay.Store(true)
+	s.mux.Register(methodRegister, answer(s.register))
+	s.mux.Register(methodConnect, answer(s.connect))
+	s.mux.Register(methodRelay, answer(s.relayConn))
+	return s
+}
+
+// answer prefixes the answers of fn with statusOK, and answers the failures a peer may check for with
+// their status followed by their message instead of failing the call.
+func answer(fn rpc.HandlerFunc) rpc.HandlerFunc {
+	return func(ctx context.Context, payload []byte) ([]byte, error) {
+		result, err := fn(ctx, payload)
+		var status byte
+		switch {
+		case err == nil:
+			return append([]byte{statusOK}, result...), nil
+		case errors.Is(err, intErrors.ErrNameTaken):
+			status = statusNameTaken
+		case errors.Is(err, intErrors.ErrPeerNotFound):
+			status = statusPeerNotFound
+		case errors.Is(err, intErrors.ErrRelay):
+			status = statusRelay
+		default:
+			return nil, err
+		}
+		return append([]byte{status}, err.Error()...), nil
+	}
+}
+
+// SetRelay sets whether connections which cannot be established directly are relayed through the server.
+// Relayed connections are end-to-end encrypted when the peer connected to has a key, but cost the server their bandwidth.
+func (s *Server) SetRelay(enabled bool) {
+	s.relay.Store(enabled)
+}
+
+// Peers returns the number of registered peers.
+func (s *Server) Peers() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return len(s.peers)
+}
+
+// Serve accepts the rendezvous stream on clientConn and serves the peer until the stream is closed or
+// ctx is cancelled, unregistering it afterwards. Serve blocks, so it is usually run in its own goroutine
+// for every accepted client.
+//
+// Possible errors:
+//   - ErrConnClosed: the stream was closed
+//   - ErrCtxCancelled: ctx was cancelled
+//   - the errors returned by AcceptStream
+func (s *Server) Serve(clientConn *onynet.ClientConn, ctx context.Context, timeout time.Duration) error {
+	stream, err := clientConn.AcceptStream(StreamName, ctx, timeout)
+	if err != nil {
+		return err
+	}
+
+	conn := rpc.NewConn(stream, s.mux, ctx)
+	m := &member{clientConn: clientConn, conn: conn}
+	s.mu.Lock()
+	s.members[conn] = m
+	s.mu.Unlock()
+
+	<-conn.Done()
+
+	s.mu.Lock()
+	delete(s.members, conn)
+	if m.name != "" && s.peers[m.name] == m {
+		delete(s.peers, m.name)
+	}
+	s.mu.Unlock()
+	return conn.Err()
+}
+
+// register registers the calling peer under a name and answers the address it is seen from.
+func (s *Server) register(ctx context.Context, payload []byte) ([]byte, error) {
+	name, _, publicKey, err := decodeIntroductionFields(payload)
+	if err != nil {
+		return nil, err
+	}
+	if name == "" {
+		return nil, intErrors.ErrMalformedFrame
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	m, err := s.caller(ctx)
+	if err != nil {
+		return nil, err
+	}
+	if other, ok := s.peers[name]; ok && other != m {
+		return nil, intErrors.ErrNameTaken
+	}
+	if m.name != "" {
+		delete(s.peers, m.name)
+	}
+	m.name, m.publicKey = name, publicKey
+	s.peers[name] = m
+
+	return []byte(m.clientConn.RemoteAddr().String()), nil
+}
+
+// connect introduces the calling peer to the peer it names, which starts punching towards the caller,
+// then answers the introduction of that peer.
+func (s *Server) connect(ctx context.Context, payload []byte) ([]byte, error) {
+	from, target, err := s.pair(ctx, string(payload))
+	if err != nil {
+		return nil, err
+	}
+	if _, err := target.conn.Call(ctx, methodIntroduce, from.intro); err != nil {
+		return nil, err
+	}
+	return target.intro, nil
+}
+
+// relayConn asks the peer named to accept a relayed connection from the calling peer,
+// then splices the streams both peers open for it, and answers the relay's id.
+func (s *Server) relayConn(ctx context.Context, payload []byte) ([]byte, error) {
+	if !s.relay.Load() {
+		return nil, errors.Join(intErrors.ErrRelay, errors.New("relaying is disabled"))
+	}
+
+	from, target, err := s.pair(ctx, string(payload))
+	if err != nil {
+		return nil, err
+	}
+
+	id := s.nextRelay.Add(1)
+	if _, err := target.conn.Call(ctx, methodRelay, encodeRelay(id, from.intro)); err != nil {
+		return nil, err
+	}
+	go s.splice(from.member, target.member, target.name, id)
+	return binary.BigEndian.AppendUint64(nil, id), nil
+}
+
+// splice copies the relay stream of from to the one of target and back, until either is closed.
+func (s *Server) splice(from, target *member, targetName string, id uint64) {
+	name := relayStreamName(id)
+	logger := from.clientConn.Logger().With("relay", name, "target", targetName)
+
+	type result struct {
+		stream *intSmux.Stream
+		err    error
+	}
+	opened := make(chan result, 1)
+	go func() {
+		stream, err := target.clientConn.OpenStream(name, target.clientConn.Context(), callTimeout)
+		opened <- result{stream, err}
+	}()
+
+	accepted, err := from.clientConn.AcceptStream(name, from.clientConn.Context(), callTimeout)
+	targetStream := <-opened
+	if err != nil || targetStream.err != nil {
+		err = errors.Join(err, targetStream.err)
+		logger.Debug("failed to relay connection", "error", err, "error_kind", intErrors.Kind(err))
+		if accepted != nil {
+			accepted.Close()
+		}
+		if targetStream.stream != nil {
+			targetStream.stream.Close()
+		}
+		return
+	}
+
+	done := make(chan struct{}, 2)
+	go func() {
+		io.Copy(targetStream.stream, accepted)
+		done <- struct{}{}
+	}()
+	go func() {
+		io.Copy(accepted, targetStream.stream)
+		done <- struct{}{}
+	}()
+	<-done
+	accepted.Close()
+	targetStream.stream.Close()
+}
+
+// caller returns the peer which made the call handled with ctx, s.mu must be held.
+func (s *Server) caller(ctx context.Context) (*member, error) {
+	conn, _ := rpc.ConnFromContext(ctx)
+	m, ok := s.members[conn]
+	if !ok {
+		return nil, intErrors.ErrConnClosed
+	}
+	return m, nil
+}
+
+// introduced is a registered peer along with its introduction, taken while it could not change.
+type introduced struct {
+	*member
+	name  string
+	intro []byte
+}
+
+// pair returns the registered peer which made the call handled with ctx and the one registered as name.
+func (s *Server) pair(ctx context.Context, name string) (from, target introduced, err error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	caller, err := s.caller(ctx)
+	if err != nil {
+		return from, target, err
+	}
+	if caller.name == "" {
+		return from, target, errors.Join(intErrors.ErrPeerNotFound, errors.New("the caller is not registered"))
+	}
+	m, ok := s.peers[name]
+	if !ok {
+		return from, target, intErrors.ErrPeerNotFound
+	}
+	return caller.introduced(), m.introduced(), nil
+}
+
+// introduced returns the peer along with its introduction, s.mu must be held.
+func (m *member) introduced() introduced {
+	return introduced{
+		member: m,
+		name:   m.name,
+		intro:  encodeIntroduction(m.name, m.clientConn.RemoteAddr().String(), m.publicKey),
+	}
+}
@@ -0,0 +1,369 @@
+package rpc
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/Onyz107/onynet"
+	intErrors "github.com/Onyz107/onynet/errors"
+	intSmux "github.com/Onyz107/onynet/internal/smux"
+	"github.com/Onyz107/onynet/tracing"
+)
+
+// Conn multiplexes concurrent calls in both directions over a single named stream.
+// Either peer can call methods registered on the other peer's Mux.
+type Conn struct {
+	stream *intSmux.Stream
+	mux    *Mux
+	ctx    context.Context
+	cancel context.CancelFunc
+
+	nextID   atomic.Uint64
+	writeMu  sync.Mutex
+	mu       sync.Mutex
+	pending  map[uint64]chan *response
+	inflight map[uint64]context.CancelFunc
+	serving  chan struct{}
+
+	done      chan struct{}
+	closeOnce sync.Once
+	err       error
+}
+
+// Open opens the rpc stream on h (usually a Client) and starts serving calls using mux.
+// The ctx argument defines the connection's lifetime while timeout defines the handshake's deadline.
+//
+// Possible errors are the same as the ones returned by OpenStream.
+func Open(h onynet.Handler, mux *Mux, ctx context.Context, timeout time.Duration) (*Conn, error) {
+	stream, err := h.OpenStream(StreamName, ctx, timeout)
+	if err != nil {
+		return nil, err
+	}
+	return NewConn(stream, mux, ctx), nil
+}
+
+// Accept accepts the rpc stream on h (usually a ClientConn) and starts serving calls using mux.
+// The ctx argument defines the connection's lifetime while timeout defines the handshake's deadline.
+//
+// Possible errors are the same as the ones returned by AcceptStream.
+func Accept(h onynet.Handler, mux *Mux, ctx context.Context, timeout time.Duration) (*Conn, error) {
+	stream, err := h.AcceptStream(StreamName, ctx, timeout)
+	if err != nil {
+		return nil, err
+	}
+	return NewConn(stream, mux, ctx), nil
+}
+
+// NewConn serves calls over an already established stream.
+// Frames are encrypted when the stream has an AES key. A nil mux means no methods are served.
+func NewConn(stream *intSmux.Stream, mux *Mux, ctx context.Context) *Conn {
+	ctx, cancel := context.WithCancel(ctx)
+
+	c := &Conn{
+		stream:   stream,
+		mux:      mux,
+		ctx:      ctx,
+		cancel:   cancel,
+		pending:  make(map[uint64]chan *response),
+		inflight: make(map[uint64]context.CancelFunc),
+		serving:  make(chan struct{}, MaxConcurrentCalls),
+		done:     make(chan struct{}),
+	}
+
+	go c.readLoop()
+	go func() {
+		select {
+		case <-c.ctx.Done():
+			c.stream.Logger().Debug("closing rpc conn because of context cancellation")
+			c.closeWithError(intErrors.ErrCtxCancelled)
+		case <-c.stream.GetDieCh():
+			c.closeWithError(intErrors.ErrConnClosed)
+		case <-c.done:
+		}
+	}()
+
+	return c
+}
+
+// ConnFromContext returns the Conn which received the call handled with ctx.
+// It can be used by a handler to call back into the peer.
+func ConnFromContext(ctx context.Context) (*Conn, bool) {
+	c, ok := ctx.Value(connKey{}).(*Conn)
+	return c, ok
+}
+
+// Call calls method on the peer and waits for its response.
+// The deadline of ctx, if any, is propagated to the peer's handler.
+//
+// Possible errors:
+//   - ErrMethodTooLong: name for method is too long
+//   - ErrMessageTooLong: payload is bigger than MaxMessageSize
+//   - ErrConnClosed: the connection was closed before a response arrived
+//   - ErrCtxCancelled: ctx was cancelled while waiting for the response
+//   - ErrTimeout: the deadline of ctx passed while waiting for the response
+//   - ErrRemote: the peer's handler returned an error, the error is joined with the message sent by the peer
+//   - ErrUnknownMethod: the peer has no handler registered for method (joined with ErrRemote)
+//   - ErrTooManyCalls: the peer is already serving MaxConcurrentCalls calls (joined with ErrRemote)
+//   - ErrWrite: failed to send the request through the stream
+func (c *Conn) Call(ctx context.Context, method string, payload []byte) ([]byte, error) {
+	tracer := c.stream.Tracer()
+	ctx, span := tracer.Start(ctx, "onynet/rpc.Call", tracing.String("rpc.method", method))
+	carrier := tracing.Carrier{}
+	tracer.Inject(ctx, carrier)
+	trace, _ := carrier.MarshalBinary()
+
+	result, err := c.call(ctx, method, trace, payload)
+	span.RecordError(err)
+	span.End()
+	return result, err
+}
+
+func (c *Conn) call(ctx context.Context, method string, trace []byte, payload []byte) ([]byte, error) {
+	if len(method) > maxMethodLength {
+		return nil, intErrors.ErrMethodTooLong
+	}
+	if len(payload) > MaxMessageSize {
+		return nil, errors.Join(intErrors.ErrMessageTooLong, fmt.Errorf("max size: %d: payload length: %d", MaxMessageSize, len(payload)))
+	}
+
+	var timeout time.Duration
+	if deadline, ok := ctx.Deadline(); ok {
+		timeout = time.Until(deadline)
+		if timeout <= 0 {
+			return nil, intErrors.ErrTimeout
+		}
+	}
+
+	id := c.nextID.Add(1)
+	ch := make(chan *response, 1)
+
+	c.mu.Lock()
+	select {
+	case <-c.done:
+		c.mu.Unlock()
+		return nil, errors.Join(intErrors.ErrConnClosed, c.err)
+	default:
+	}
+	c.pending[id] = ch
+	c.mu.Unlock()
+
+	defer func() {
+		c.mu.Lock()
+		delete(c.pending, id)
+		c.mu.Unlock()
+	}()
+
+	if err := c.send(encodeRequest(id, timeout, method, trace, payload)); err != nil {
+		return nil, err
+	}
+
+	select {
+	case res := <-ch:
+		return res.result(method)
+
+	case <-ctx.Done():
+		c.send(encodeCancel(id))
+		if errors.Is(ctx.Err(), context.DeadlineExceeded) {
+			return nil, intErrors.ErrTimeout
+		}
+		return nil, intErrors.ErrCtxCancelled
+
+	case <-c.done:
+		return nil, errors.Join(intErrors.ErrConnClosed, c.err)
+	}
+}
+
+// Done returns a channel that is closed when the connection is closed.
+func (c *Conn) Done() <-chan struct{} {
+	return c.done
+}
+
+// Err returns the reason the connection was closed, or nil if it is still open.
+func (c *Conn) Err() error {
+	select {
+	case <-c.done:
+		return c.err
+	default:
+		return nil
+	}
+}
+
+// Close closes the connection and its stream, failing every pending call.
+func (c *Conn) Close() error {
+	return c.closeWithError(intErrors.ErrConnClosed)
+}
+
+func (c *Conn) closeWithError(reason error) error {
+	var err error
+	c.closeOnce.Do(func() {
+		c.err = reason
+		c.cancel()
+		err = c.stream.Close()
+		close(c.done)
+	})
+	return err
+}
+
+func (c *Conn) send(frame []byte) error {
+	c.writeMu.Lock()
+	defer c.writeMu.Unlock()
+
+	if c.stream.IsEncrypted() {
+		return c.stream.SendEncrypted(frame, 0)
+	}
+	return c.stream.SendSerialized(frame, 0)
+}
+
+func (c *Conn) receive(buf []byte) ([]byte, error) {
+	var n uint64
+	var err error
+	if c.stream.IsEncrypted() {
+		n, err = c.stream.ReceiveEncrypted(buf, 0)
+	} else {
+		n, err = c.stream.ReceiveSerialized(buf, 0)
+	}
+	if err != nil {
+		return nil, err
+	}
+	return buf[:n], nil
+}
+
+func (c *Conn) readLoop() {
+	bufPtr := bufPool.Get().(*[]byte)
+	defer bufPool.Put(bufPtr)
+	buf := *bufPtr
+
+	for {
+		frame, err := c.receive(buf)
+		if err != nil {
+			c.stream.Logger().Debug("closing rpc conn because of receive error", "error", err, "error_kind", intErrors.Kind(err))
+			c.closeWithError(err)
+			return
+		}
+		if len(frame) == 0 {
+			c.closeWithError(intErrors.ErrMalformedFrame)
+			return
+		}
+
+		switch frame[0] {
+
+		case frameRequest:
+			req, err := decodeRequest(frame)
+			if err != nil {
+				c.closeWithError(err)
+				return
+			}
+			c.serve(req)
+
+		case frameResponse:
+			id, res, err := decodeResponse(frame)
+			if err != nil {
+				c.closeWithError(err)
+				return
+			}
+			// Only the first response to a call is delivered, a duplicate one must not block the loop
+			c.mu.Lock()
+			ch, ok := c.pending[id]
+			delete(c.pending, id)
+			c.mu.Unlock()
+			if ok {
+				select {
+				case ch <- res:
+				default:
+				}
+			}
+
+		case frameCancel:
+			id, err := decodeCancel(frame)
+			if err != nil {
+				c.closeWithError(err)
+				return
+			}
+			c.mu.Lock()
+			cancel, ok := c.inflight[id]
+			c.mu.Unlock()
+			if ok {
+				cancel()
+			}
+
+		default:
+			c.closeWithError(errors.Join(intErrors.ErrMalformedFrame, fmt.Errorf("unknown frame type: %d", frame[0])))
+			return
+		}
+	}
+}
+
+func (c *Conn) serve(req *request) {
+	fn, ok := c.mux.lookup(req.method)
+	if !ok {
+		c.stream.Logger().Debug("unknown rpc method", "method", req.method)
+		c.send(encodeResponse(req.id, statusUnknownMethod, nil))
+		return
+	}
+
+	select {
+	case c.serving <- struct{}{}:
+	default:
+		c.stream.Logger().Debug("too many concurrent rpc calls", "method", req.method)
+		c.send(encodeResponse(req.id, statusBusy, nil))
+		return
+	}
+
+	tracer := c.stream.Tracer()
+	base := tracer.Extract(context.WithValue(c.ctx, connKey{}, c), req.trace)
+	base, span := tracer.Start(base, "onynet/rpc.Serve", tracing.String("rpc.method", req.method))
+	var ctx context.Context
+	var cancel context.CancelFunc
+	if req.timeout > 0 {
+		ctx, cancel = context.WithTimeout(base, req.timeout)
+	} else {
+		ctx, cancel = context.WithCancel(base)
+	}
+
+	c.mu.Lock()
+	c.inflight[req.id] = cancel
+	c.mu.Unlock()
+
+	go func() {
+		defer func() {
+			c.mu.Lock()
+			delete(c.inflight, req.id)
+			c.mu.Unlock()
+			cancel()
+			<-c.serving
+		}()
+
+		result, err := fn(ctx, req.payload)
+		span.RecordError(err)
+		span.End()
+		switch {
+		case errors.Is(ctx.Err(), context.DeadlineExceeded):
+			c.send(encodeResponse(req.id, statusTimeout, nil))
+		case err != nil:
+			c.send(encodeResponse(req.id, statusError, []byte(err.Error())))
+		case len(result) > MaxMessageSize:
+			c.send(encodeResponse(req.id, statusError, []byte(intErrors.ErrMessageTooLong.Error())))
+		default:
+			c.send(encodeResponse(req.id, statusOK, result))
+		}
+	}()
+}
+
+func (r *response) result(method string) ([]byte, error) {
+	switch r.status {
+	case statusOK:
+		return r.payload, nil
+	case statusUnknownMethod:
+		return nil, errors.Join(intErrors.ErrRemote, intErrors.ErrUnknownMethod, fmt.Errorf("method: %s", method))
+	case statusTimeout:
+		return nil, errors.Join(intErrors.ErrRemote, intErrors.ErrTimeout)
+	case statusBusy:
+		return nil, errors.Join(intErrors.ErrRemote, intErrors.ErrTooManyCalls)
+	default:
+		return nil, errors.Join(intErrors.ErrRemote, errors.New(string(r.payload)))
+	}
+}
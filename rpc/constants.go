@@ -0,0 +1,55 @@
+package rpc
+
+import (
+	"sync"
+
+	"github.com/Onyz107/onynet/tracing"
+)
+
+// StreamName is the name of the stream used to carry calls between peers.
+const StreamName = "onynet/rpc"
+
+// MaxMessageSize is the largest payload a request or a response can carry.
+const MaxMessageSize = 1 << 20
+
+// MaxConcurrentCalls is the number of calls a peer can have served at once on a connection,
+// the calls made beyond it fail with ErrTooManyCalls.
+const MaxConcurrentCalls = 256
+
+const maxMethodLength = 0xFF
+
+const (
+	frameRequest byte = iota + 1
+	frameResponse
+	frameCancel
+)
+
+const (
+	statusOK byte = iota
+	statusError
+	statusUnknownMethod
+	statusTimeout
+	statusBusy
+)
+
+const (
+	// type(1) + id(8) + timeout(8) + method length(1) + trace length(2)
+	requestHeaderSize = 20
+	// type(1) + id(8) + status(1)
+	responseHeaderSize = 10
+	// type(1) + id(8)
+	cancelFrameSize = 9
+	// nonce(12) + tag(16)
+	encryptionOverhead = 28
+)
+
+const bufferSize = MaxMessageSize + requestHeaderSize + maxMethodLength + tracing.MaxCarrierSize + encryptionOverhead
+
+var bufPool = sync.Pool{
+	New: func() any {
+		buf := make([]byte, bufferSize)
+		return &buf
+	},
+}
+
+type connKey struct{}
@@ -0,0 +1,97 @@
+package rpc
+
+import (
+	"encoding/binary"
+	"time"
+
+	intErrors "github.com/Onyz107/onynet/errors"
+	"github.com/Onyz107/onynet/tracing"
+)
+
+type request struct {
+	id      uint64
+	timeout time.Duration
+	method  string
+	trace   tracing.Carrier
+	payload []byte
+}
+
+type response struct {
+	status  byte
+	payload []byte
+}
+
+func encodeRequest(id uint64, timeout time.Duration, method string, trace []byte, payload []byte) []byte {
+	frame := make([]byte, requestHeaderSize, requestHeaderSize+len(method)+len(trace)+len(payload))
+	frame[0] = frameRequest
+	binary.BigEndian.PutUint64(frame[1:], id)
+	binary.BigEndian.PutUint64(frame[9:], uint64(timeout))
+	frame[17] = byte(len(method))
+	binary.BigEndian.PutUint16(frame[18:], uint16(len(trace)))
+	frame = append(frame, method...)
+	frame = append(frame, trace...)
+	return append(frame, payload...)
+}
+
+func decodeRequest(frame []byte) (*request, error) {
+	if len(frame) < requestHeaderSize {
+		return nil, intErrors.ErrMalformedFrame
+	}
+
+	methodLength := int(frame[17])
+	traceLength := int(binary.BigEndian.Uint16(frame[18:]))
+	if len(frame) < requestHeaderSize+methodLength+traceLength {
+		return nil, intErrors.ErrMalformedFrame
+	}
+	method := frame[requestHeaderSize : requestHeaderSize+methodLength]
+	trace := frame[requestHeaderSize+methodLength : requestHeaderSize+methodLength+traceLength]
+
+	carrier := tracing.Carrier{}
+	if err := carrier.UnmarshalBinary(trace); err != nil {
+		return nil, err
+	}
+
+	payload := make([]byte, len(frame)-requestHeaderSize-methodLength-traceLength)
+	copy(payload, frame[requestHeaderSize+methodLength+traceLength:])
+
+	return &request{
+		id:      binary.BigEndian.Uint64(frame[1:]),
+		timeout: time.Duration(binary.BigEndian.Uint64(frame[9:])),
+		method:  string(method),
+		trace:   carrier,
+		payload: payload,
+	}, nil
+}
+
+func encodeResponse(id uint64, status byte, payload []byte) []byte {
+	frame := make([]byte, responseHeaderSize, responseHeaderSize+len(payload))
+	frame[0] = frameResponse
+	binary.BigEndian.PutUint64(frame[1:], id)
+	frame[9] = status
+	return append(frame, payload...)
+}
+
+func decodeResponse(frame []byte) (uint64, *response, error) {
+	if len(frame) < responseHeaderSize {
+		return 0, nil, intErrors.ErrMalformedFrame
+	}
+
+	payload := make([]byte, len(frame)-responseHeaderSize)
+	copy(payload, frame[responseHeaderSize:])
+
+	return binary.BigEndian.Uint64(frame[1:]), &response{status: frame[9], payload: payload}, nil
+}
+
+func encodeCancel(id uint64) []byte {
+	frame := make([]byte, cancelFrameSize)
+	frame[0] = frameCancel
+	binary.BigEndian.PutUint64(frame[1:], id)
+	return frame
+}
+
+func decodeCancel(frame []byte) (uint64, error) {
+	if len(frame) < cancelFrameSize {
+		return 0, intErrors.ErrMalformedFrame
+	}
+	return binary.BigEndian.Uint64(frame[1:]), nil
+}
@@ -0,0 +1,57 @@
+package rpc
+
+import (
+	"context"
+	"sync"
+
+	intErrors "github.com/Onyz107/onynet/errors"
+)
+
+// HandlerFunc handles a call made by the peer. The ctx argument is cancelled when the caller
+// gives up on the call, when the caller's deadline passes or when the connection is closed.
+type HandlerFunc func(ctx context.Context, payload []byte) ([]byte, error)
+
+// Mux holds the methods that can be called by a peer.
+// A single Mux can be shared by every connection of a server.
+type Mux struct {
+	handlers map[string]HandlerFunc
+	mu       sync.RWMutex
+}
+
+// NewMux returns an empty Mux.
+func NewMux() *Mux {
+	return &Mux{handlers: make(map[string]HandlerFunc)}
+}
+
+// Register registers fn under the given method name, replacing any previous handler.
+//
+// Possible errors:
+//   - ErrMethodTooLong: name for method is too long
+func (m *Mux) Register(method string, fn HandlerFunc) error {
+	if len(method) > maxMethodLength {
+		return intErrors.ErrMethodTooLong
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.handlers[method] = fn
+	return nil
+}
+
+// Unregister removes the handler registered under the given method name.
+func (m *Mux) Unregister(method string) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	delete(m.handlers, method)
+}
+
+func (m *Mux) lookup(method string) (HandlerFunc, bool) {
+	if m == nil {
+		return nil, false
+	}
+
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	fn, ok := m.handlers[method]
+	return fn, ok
+}
@@ -0,0 +1,187 @@
+package rpc_test
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"crypto/rsa"
+	"errors"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/Onyz107/onynet"
+	intErrors "github.com/Onyz107/onynet/errors"
+	"github.com/Onyz107/onynet/onynettest"
+	"github.com/Onyz107/onynet/rpc"
+)
+
+func newPair(tb testing.TB) (*onynet.ClientConn, *onynet.Client) {
+	tb.Helper()
+
+	privateKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		tb.Fatal(err)
+	}
+
+	pair := onynettest.NewPair(tb, privateKey)
+	tb.Cleanup(func() { pair.ClientConn.Close() })
+
+	return pair.ClientConn, pair.Client
+}
+
+func newConns(tb testing.TB, serverMux, clientMux *rpc.Mux) (serverConn, clientConn *rpc.Conn) {
+	tb.Helper()
+
+	cn, c := newPair(tb)
+
+	accepted := make(chan *rpc.Conn, 1)
+	go func() {
+		conn, err := rpc.Accept(cn, serverMux, tb.Context(), 5*time.Second)
+		if err != nil {
+			tb.Error(err)
+		}
+		accepted <- conn
+	}()
+
+	clientConn, err := rpc.Open(c, clientMux, tb.Context(), 5*time.Second)
+	if err != nil {
+		tb.Fatal(err)
+	}
+	tb.Cleanup(func() { clientConn.Close() })
+
+	serverConn = <-accepted
+	if serverConn == nil {
+		tb.FailNow()
+	}
+	tb.Cleanup(func() { serverConn.Close() })
+
+	return serverConn, clientConn
+}
+
+func TestCall(t *testing.T) {
+	serverMux := rpc.NewMux()
+	serverMux.Register("echo", func(ctx context.Context, payload []byte) ([]byte, error) {
+		return payload, nil
+	})
+	serverMux.Register("fail", func(ctx context.Context, payload []byte) ([]byte, error) {
+		return nil, errors.New("handler failed")
+	})
+	serverMux.Register("slow", func(ctx context.Context, payload []byte) ([]byte, error) {
+		<-ctx.Done()
+		return nil, ctx.Err()
+	})
+
+	release := make(chan struct{})
+	started := make(chan struct{}, rpc.MaxConcurrentCalls)
+	serverMux.Register("block", func(ctx context.Context, payload []byte) ([]byte, error) {
+		started <- struct{}{}
+		<-release
+		return nil, nil
+	})
+
+	clientMux := rpc.NewMux()
+	clientMux.Register("whoami", func(ctx context.Context, payload []byte) ([]byte, error) {
+		return []byte("client"), nil
+	})
+
+	serverConn, clientConn := newConns(t, serverMux, clientMux)
+
+	t.Run("concurrent", func(t *testing.T) {
+		var wg sync.WaitGroup
+		for range 32 {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				payload := make([]byte, 512)
+				rand.Read(payload)
+				res, err := clientConn.Call(context.Background(), "echo", payload)
+				if err != nil {
+					t.Error(err)
+					return
+				}
+				if !bytes.Equal(res, payload) {
+					t.Error("response is not equal to payload")
+				}
+			}()
+		}
+		wg.Wait()
+	})
+
+	t.Run("server to client", func(t *testing.T) {
+		res, err := serverConn.Call(context.Background(), "whoami", nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if string(res) != "client" {
+			t.Fatalf("unexpected response: %s", res)
+		}
+	})
+
+	t.Run("remote error", func(t *testing.T) {
+		_, err := clientConn.Call(context.Background(), "fail", nil)
+		if !errors.Is(err, intErrors.ErrRemote) {
+			t.Fatalf("expected ErrRemote, got: %v", err)
+		}
+	})
+
+	t.Run("unknown method", func(t *testing.T) {
+		_, err := clientConn.Call(context.Background(), "missing", nil)
+		if !errors.Is(err, intErrors.ErrUnknownMethod) {
+			t.Fatalf("expected ErrUnknownMethod, got: %v", err)
+		}
+	})
+
+	t.Run("too many calls", func(t *testing.T) {
+		var wg sync.WaitGroup
+		for range rpc.MaxConcurrentCalls {
+			wg.Go(func() {
+				if _, err := clientConn.Call(context.Background(), "block", nil); err != nil {
+					t.Error(err)
+				}
+			})
+		}
+		for range rpc.MaxConcurrentCalls {
+			<-started
+		}
+
+		_, err := clientConn.Call(context.Background(), "echo", nil)
+		if !errors.Is(err, intErrors.ErrTooManyCalls) {
+			t.Errorf("expected ErrTooManyCalls, got: %v", err)
+		}
+		close(release)
+		wg.Wait()
+
+		if _, err := clientConn.Call(context.Background(), "echo", nil); err != nil {
+			t.Fatal(err)
+		}
+	})
+
+	t.Run("deadline", func(t *testing.T) {
+		ctx, cancel := context.WithTimeout(context.Background(), 200*time.Millisecond)
+		defer cancel()
+		_, err := clientConn.Call(ctx, "slow", nil)
+		if !errors.Is(err, intErrors.ErrTimeout) {
+			t.Fatalf("expected ErrTimeout, got: %v", err)
+		}
+	})
+}
+
+func BenchmarkCall(b *testing.B) {
+	serverMux := rpc.NewMux()
+	serverMux.Register("echo", func(ctx context.Context, payload []byte) ([]byte, error) {
+		return payload, nil
+	})
+
+	_, clientConn := newConns(b, serverMux, nil)
+
+	payload := make([]byte, 1024)
+	rand.Read(payload)
+
+	b.ResetTimer()
+	for b.Loop() {
+		if _, err := clientConn.Call(context.Background(), "echo", payload); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
@@ -4,6 +4,7 @@ import (
 	"context"
 	"crypto/rsa"
 	"errors"
+	"log/slog"
 	"net"
 	"sync"
 	"sync/atomic"
@@ -13,39 +14,93 @@ import (
 	"github.com/Onyz107/onynet/internal/auth"
 	"github.com/Onyz107/onynet/internal/heartbeat"
 	"github.com/Onyz107/onynet/internal/kcp"
-	"github.com/Onyz107/onynet/internal/logger"
+	"github.com/Onyz107/onynet/internal/ratelimit"
 	intSmux "github.com/Onyz107/onynet/internal/smux"
+	intTransport "github.com/Onyz107/onynet/internal/transport"
+	"github.com/Onyz107/onynet/metrics"
+	"github.com/Onyz107/onynet/tracing"
 	"github.com/xtaci/smux"
 )
 
 // Server defines a server which will be listening for incoming connections.
 type Server struct {
 	server     *kcp.Server
-	clients    map[int]*ClientConn
+	clients    map[ClientID]*ClientConn
 	mu         sync.RWMutex
 	privateKey *rsa.PrivateKey
 	ctx        context.Context
-}
 
-var clientCounter int64
+	limits      *ratelimit.Pair
+	connLimit   RateLimit
+	streamRules *ratelimit.Rules
+	limitMu     sync.Mutex
+
+	admission *admission
+	ipFilter  *IPFilter
+	allowConn atomic.Pointer[ConnFilter]
+	admitted  chan admittedConn
+	admitDone chan struct{}
+	admitErr  error
+
+	hooks        atomic.Pointer[ServerHooks]
+	idleTimeout  atomic.Int64 // time.Duration
+	drainTimeout atomic.Int64 // time.Duration
+	metrics      atomic.Pointer[metrics.Metrics]
+	tracer       atomic.Pointer[tracing.Tracer]
+
+	listeners       []intTransport.Listener // listeners other than the KCP one
+	listenersClosed bool
+	webSocket       *intTransport.WebSocketListener
+	connListener    *intTransport.ConnListener
+	listenMu        sync.Mutex
+	relay           atomic.Pointer[RelayResolver]
+}
 
 // NewServer starts an OnyNet server listening on given address.
+// It logs through slog.Default() until SetLogger is called.
 //
 // Possible errors:
 //   - ErrNewServer: failed to start a new kcp server using the given address
 //   - ErrBadAddr: the given address was invalid in the used context
 func NewServer(addr net.Addr, privateKey *rsa.PrivateKey, ctx context.Context) (*Server, error) {
-	server, err := kcp.NewServer(addr, ctx)
+	return NewServerWithTransport(addr, privateKey, kcp.UDP, ctx)
+}
+
+// NewServerWithTransport starts an OnyNet server listening on given address of transport, such as
+// an in-memory network of the onynettest package. A nil transport means UDP, like NewServer.
+//
+// Possible errors are the same as the ones returned by NewServer.
+func NewServerWithTransport(addr net.Addr, privateKey *rsa.PrivateKey, transport PacketTransport, ctx context.Context) (*Server, error) {
+	if transport == nil {
+		transport = kcp.UDP
+	}
+	server, err := kcp.NewServer(addr, transport, ctx, slog.Default())
 	if err != nil {
 		return nil, errors.Join(intErrors.ErrNewServer, err)
 	}
 
-	onynetServer := &Server{server: server, clients: make(map[int]*ClientConn), privateKey: privateKey, ctx: ctx}
+	onynetServer := &Server{
+		server:      server,
+		clients:     make(map[ClientID]*ClientConn),
+		privateKey:  privateKey,
+		ctx:         ctx,
+		limits:      ratelimit.NewPair(Unlimited),
+		streamRules: ratelimit.NewRules(),
+
+		admission: newAdmission(),
+		ipFilter:  &IPFilter{},
+		admitted:  make(chan admittedConn),
+		admitDone: make(chan struct{}),
+	}
+	go onynetServer.admitLoop(intTransport.KCP(server), true)
 
 	return onynetServer, nil
 }
 
 // Accept waits for a new client connection and performs authentication.
+// Connections refused by the connection filters (see SetIPRules and SetAllowConn), by admission control
+// (see SetMaxClients, SetMaxClientsPerIP and SetHandshakeRate) or never sending their hello
+// are logged and skipped, Accept keeps waiting for the next one.
 //
 // Possible errors:
 //   - ErrAcceptClient: failed to accept a client
@@ -59,86 +114,243 @@ func NewServer(addr net.Addr, privateKey *rsa.PrivateKey, ctx context.Context) (
 //   - ErrTimeout: timeout occurred waiting for the heartbeat stream to establish connection
 //   - ErrAcceptStream: failed to accept a multiplexing stream
 func (s *Server) Accept() (*ClientConn, error) {
-	client, err := s.server.Accept()
+	client, release, err := s.admit()
 	if err != nil {
-		return nil, errors.Join(intErrors.ErrAcceptClient, err)
+		return nil, err
 	}
 
+	tracer := s.loadTracer()
+	traceCtx, span := tracer.Start(s.ctx, "onynet.Accept", tracing.String("onynet.remote", client.RemoteAddr().String()))
+	cn, err := s.accept(client, release, tracer, traceCtx)
+	span.RecordError(err)
+	span.End()
+	return cn, err
+}
+
+// accept authenticates an admitted client, then establishes its session and heartbeat.
+func (s *Server) accept(client intTransport.Conn, release func(), tracer tracing.Tracer, traceCtx context.Context) (*ClientConn, error) {
+	metricsRecorder := s.loadMetrics()
+
 	var aesKey []byte
+	var err error
 	if s.privateKey != nil {
+		_, handshakeSpan := tracer.Start(traceCtx, "onynet.handshake")
 		aesKey, err = auth.AuthorizeClient(client, s.privateKey)
-		if err != nil {
-			client.Close()
-			return nil, errors.Join(intErrors.ErrAuth, err)
+		if err == nil {
+			err = auth.AuthorizeSelfServer(client, s.privateKey)
 		}
-
-		if err := auth.AuthorizeSelfServer(client, s.privateKey); err != nil {
+		handshakeSpan.RecordError(err)
+		handshakeSpan.End()
+		if err != nil {
+			release()
 			client.Close()
-			return nil, errors.Join(intErrors.ErrAuth, err)
+			if hooks := s.loadHooks(); hooks.OnAuthFailed != nil {
+				hooks.OnAuthFailed(client.RemoteAddr(), err)
+			}
+			err = errors.Join(intErrors.ErrAuth, err)
+			metricsRecorder.Handshake(metrics.SideServer, handshakeResult(err))
+			return nil, err
 		}
 	}
 
-	session, err := smux.Server(client, nil)
+	session, err := smux.Server(client, intSmux.Config())
 	if err != nil {
+		release()
 		client.Close()
-		return nil, errors.Join(intErrors.ErrCreateSession, err)
+		err = errors.Join(intErrors.ErrCreateSession, err)
+		metricsRecorder.Handshake(metrics.SideServer, handshakeResult(err))
+		return nil, err
 	}
-	manager := intSmux.NewManager(session, aesKey, s.ctx)
+	onynetClientConn := &ClientConn{id: newClientID()}
+	ctx, cancel := context.WithCancel(context.WithValue(s.ctx, clientConnKey{}, onynetClientConn))
+	logger := s.loadLogger().With("client_id", onynetClientConn.id.String(), "remote_addr", client.RemoteAddr().String())
 
-	onynetClientConn := &ClientConn{
-		client:    client,
-		connected: true,
-		manager:   manager,
-		ctx:       s.ctx,
+	manager := intSmux.NewManager(session, aesKey, ctx, logger)
+	manager.SetParentLimits(s.limits)
+	manager.SetStreamRules(s.streamRules)
+	manager.SetInternal(heartbeatStreamName)
+	manager.SetMetrics(metricsRecorder, metrics.SideServer)
+	manager.SetTracer(tracer)
+	s.limitMu.Lock()
+	manager.Limits().SetLimit(s.connLimit)
+	s.limitMu.Unlock()
+
+	*onynetClientConn = ClientConn{
+		id:            onynetClientConn.id,
+		client:        client,
+		manager:       manager,
+		aesKey:        aesKey,
+		datagrams:     newDatagramState(datagramToClient),
+		heartbeatDone: make(chan struct{}),
+		ctx:           ctx,
+		cancel:        cancel,
+		cachedStreams: make(map[string]*cachedStream),
+		hooks:         s.loadHooks,
+		drain:         s.loadDrainTimeout,
+		metrics:       metricsRecorder,
+		logger:        logger,
 	}
+	onynetClientConn.connected.Store(true)
+	manager.SetStreamHooks(onynetClientConn.streamOpened, onynetClientConn.streamClosed)
 
-	id := int(atomic.AddInt64(&clientCounter, 1))
-	s.mu.Lock()
-	s.clients[id] = onynetClientConn
-	s.mu.Unlock()
+	id := s.register(onynetClientConn)
+	onynetClientConn.release = func() {
+		s.unregister(id)
+		release()
+	}
 
-	heartbeatStream, err := onynetClientConn.AcceptStream("heartbeatStream", onynetClientConn.ctx, 5*time.Second)
+	heartbeatStream, err := onynetClientConn.AcceptStream(heartbeatStreamName, onynetClientConn.ctx, 5*time.Second)
 	if err != nil {
-		delete(s.clients, id)
 		onynetClientConn.Close()
-		return nil, errors.Join(intErrors.ErrHeartbeatStream, err)
+		err = errors.Join(intErrors.ErrHeartbeatStream, err)
+		metricsRecorder.Handshake(metrics.SideServer, handshakeResult(err))
+		return nil, err
 	}
 
+	onynetClientConn.heartbeat.Store(heartbeatStream)
+
 	go func() {
+		defer close(onynetClientConn.heartbeatDone)
 		defer heartbeatStream.Close()
-		if err := heartbeat.ReceiveHeartbeat(heartbeatStream, s.ctx); err != nil {
-			logger.Log.Debugf("closing client because of heartbeat err: %v", err)
-			s.CloseClient(id)
+		if err := heartbeat.ReceiveHeartbeat(heartbeatStream, ctx); err != nil {
+			logger.Debug("closing client because of heartbeat error", "error", err, "error_kind", intErrors.Kind(err))
+			onynetClientConn.closeWithReason(heartbeatReason(err))
 		}
 	}()
+	go watchIdle(ctx, manager, s.loadIdleTimeout, onynetClientConn.closeWithReason)
+	s.handleRelay(onynetClientConn)
+
+	metricsRecorder.Handshake(metrics.SideServer, metrics.HandshakeSucceeded)
+	metricsRecorder.ConnectionOpened(metrics.SideServer)
+
+	if hooks := s.loadHooks(); hooks.OnConnect != nil {
+		hooks.OnConnect(onynetClientConn)
+	}
 
 	return onynetClientConn, nil
 }
 
-func (s *Server) CloseClient(id int) error {
+func (s *Server) loadIdleTimeout() time.Duration {
+	return time.Duration(s.idleTimeout.Load())
+}
+
+// admittedConn is a connection which sent its hello and passed admission control.
+type admittedConn struct {
+	client  intTransport.Conn
+	release func()
+}
+
+// admit waits for a new connection, of any listener, which passes the connection filters, sends its hello and passes admission control.
+func (s *Server) admit() (intTransport.Conn, func(), error) {
+	select {
+	case admitted := <-s.admitted:
+		return admitted.client, admitted.release, nil
+	case <-s.admitDone:
+		return nil, nil, errors.Join(intErrors.ErrAcceptClient, s.admitErr)
+	}
+}
+
+// admitLoop accepts the connections of listener and waits for their hellos concurrently, so a connection which never
+// sends one does not hold back the others. A connection only takes an admission slot once its hello arrived,
+// KCP sessions themselves are only created for addresses which echoed a handshake cookie (see kcp.NewServer).
+// Accept fails once the primary listener, the KCP one, fails, the others only stop being accepted from.
+func (s *Server) admitLoop(listener intTransport.Listener, primary bool) {
+	for {
+		client, err := listener.Accept()
+		if err != nil {
+			if primary {
+				s.admitErr = err
+				close(s.admitDone)
+			}
+			return
+		}
+
+		if reason := s.allowed(client.RemoteAddr()); reason != nil {
+			s.loadLogger().Warn("rejecting client", "remote_addr", client.RemoteAddr().String(), "reason", reason, "error_kind", intErrors.Kind(reason))
+			s.loadMetrics().Handshake(metrics.SideServer, handshakeResult(reason))
+			auth.Reject(client, reason)
+			client.Close()
+			continue
+		}
+
+		go func() {
+			if err := auth.ReceiveHello(client); err != nil {
+				s.loadLogger().Debug("dropping client without hello", "remote_addr", client.RemoteAddr().String(), "error", err, "error_kind", intErrors.Kind(err))
+				client.Close()
+				return
+			}
+
+			release, reason := s.admission.admit(client.RemoteAddr())
+			if reason != nil {
+				s.loadLogger().Warn("rejecting client", "remote_addr", client.RemoteAddr().String(), "reason", reason, "error_kind", intErrors.Kind(reason))
+				s.loadMetrics().Handshake(metrics.SideServer, handshakeResult(reason))
+				auth.Reject(client, reason)
+				client.Close()
+				return
+			}
+			if err := auth.Admit(client); err != nil {
+				s.loadLogger().Debug("dropping client", "remote_addr", client.RemoteAddr().String(), "error", err, "error_kind", intErrors.Kind(err))
+				release()
+				client.Close()
+				return
+			}
+
+			select {
+			case s.admitted <- admittedConn{client: client, release: release}:
+			case <-s.admitDone:
+				release()
+				client.Close()
+			}
+		}()
+	}
+}
+
+// register adds cn to the clients of the server under its ID, or under a new unique ID if it is taken.
+func (s *Server) register(cn *ClientConn) ClientID {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for id := cn.id; ; id = newClientID() {
+		if _, ok := s.clients[id]; ok || id == (ClientID{}) {
+			continue
+		}
+		cn.id = id
+		s.clients[id] = cn
+		return id
+	}
+}
+
+// unregister removes the client with the given ID, it does nothing if the client was already removed.
+func (s *Server) unregister(id ClientID) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	delete(s.clients, id)
+}
+
+// CloseClient disconnects the client with the given ID and removes it from the clients of the server.
+// It does nothing if no such client is connected.
+func (s *Server) CloseClient(id ClientID) error {
 	client := s.GetClient(id)
 	if client == nil {
 		return nil
 	}
-	client.connected = false
-	delete(s.clients, id)
 	return client.Close()
 }
 
 // GetClients returns a map of all connected clients with id being the key and ClientConn being the value.
-func (s *Server) GetClients() map[int]*ClientConn {
+func (s *Server) GetClients() map[ClientID]*ClientConn {
 	s.mu.RLock()
 	defer s.mu.RUnlock()
 
-	copyMap := make(map[int]*ClientConn, len(s.clients))
+	copyMap := make(map[ClientID]*ClientConn, len(s.clients))
 	for k, v := range s.clients {
 		copyMap[k] = v
 	}
 	return copyMap
 }
 
-// GetClient returns a ClientConn of a connected client with the id provided.
-func (s *Server) GetClient(id int) *ClientConn {
+// GetClient returns a ClientConn of a connected client with the id provided, or nil if there is none.
+func (s *Server) GetClient(id ClientID) *ClientConn {
 	s.mu.RLock()
 	defer s.mu.RUnlock()
 	client, ok := s.clients[id]
@@ -148,7 +360,12 @@ func (s *Server) GetClient(id int) *ClientConn {
 	return nil
 }
 
-// Close shuts down the server and all active connections.
+// Addr returns the address the server is listening on, which tells the port picked for a port of 0.
+func (s *Server) Addr() net.Addr {
+	return s.server.Addr()
+}
+
+// Close shuts down the server, its listeners and all active connections.
 func (s *Server) Close() error {
-	return s.server.Close()
+	return errors.Join(s.server.Close(), s.closeListeners())
 }
@@ -0,0 +1,104 @@
+package socks
+
+import (
+	"encoding/binary"
+	"errors"
+	"io"
+	"net"
+	"net/netip"
+	"strconv"
+
+	intErrors "github.com/Onyz107/onynet/errors"
+)
+
+// readAddr reads a SOCKS address: type(1) + IPv4(4), IPv6(16) or domain length(1) + domain + port(2).
+func readAddr(r io.Reader) (string, error) {
+	header := make([]byte, 2)
+	if _, err := io.ReadFull(r, header); err != nil {
+		return "", errors.Join(intErrors.ErrRead, err)
+	}
+
+	var length int
+	switch header[0] {
+	case addrIPv4:
+		length = 1 + net.IPv4len
+	case addrIPv6:
+		length = 1 + net.IPv6len
+	case addrDomain:
+		length = 2 + int(header[1])
+	default:
+		return "", intErrors.ErrMalformedFrame
+	}
+
+	frame := make([]byte, length+2)
+	copy(frame, header)
+	if _, err := io.ReadFull(r, frame[2:]); err != nil {
+		return "", errors.Join(intErrors.ErrRead, err)
+	}
+	addr, _, err := splitAddr(frame)
+	return addr, err
+}
+
+// splitAddr splits the SOCKS address at the front of b from what follows it.
+func splitAddr(b []byte) (addr string, rest []byte, err error) {
+	if len(b) < 1 {
+		return "", nil, intErrors.ErrMalformedFrame
+	}
+
+	var host string
+	switch b[0] {
+	case addrIPv4, addrIPv6:
+		size := net.IPv4len
+		if b[0] == addrIPv6 {
+			size = net.IPv6len
+		}
+		if len(b) < 1+size+2 {
+			return "", nil, intErrors.ErrMalformedFrame
+		}
+		ip, _ := netip.AddrFromSlice(b[1 : 1+size])
+		host, b = ip.String(), b[1+size:]
+	case addrDomain:
+		if len(b) < 2 {
+			return "", nil, intErrors.ErrMalformedFrame
+		}
+		size := int(b[1])
+		if len(b) < 2+size+2 {
+			return "", nil, intErrors.ErrMalformedFrame
+		}
+		host, b = string(b[2:2+size]), b[2+size:]
+	default:
+		return "", nil, intErrors.ErrMalformedFrame
+	}
+
+	port := binary.BigEndian.Uint16(b)
+	return net.JoinHostPort(host, strconv.Itoa(int(port))), b[2:], nil
+}
+
+// appendAddr appends addr, a "host:port" address, to b as a SOCKS address.
+func appendAddr(b []byte, addr string) ([]byte, error) {
+	host, portString, err := net.SplitHostPort(addr)
+	if err != nil {
+		return nil, errors.Join(intErrors.ErrBadAddr, err)
+	}
+	port, err := strconv.ParseUint(portString, 10, 16)
+	if err != nil {
+		return nil, errors.Join(intErrors.ErrBadAddr, err)
+	}
+
+	if ip, err := netip.ParseAddr(host); err == nil {
+		ip = ip.Unmap()
+		if ip.Is4() {
+			b = append(b, addrIPv4)
+		} else {
+			b = append(b, addrIPv6)
+		}
+		b = append(b, ip.AsSlice()...)
+	} else {
+		if len(host) > 0xFF {
+			return nil, errors.Join(intErrors.ErrBadAddr, errors.New("domain too long"))
+		}
+		b = append(b, addrDomain, byte(len(host)))
+		b = append(b, host...)
+	}
+	return binary.BigEndian.AppendUint16(b, uint16(port)), nil
+}
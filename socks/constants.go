@@ -0,0 +1,56 @@
+package socks
+
+import "time"
+
+// StreamName is the name of the streams the proxy asks the server to connect or associate through.
+const StreamName = "onynet/socks"
+
+// socksVersion is the version byte of every SOCKS5 message.
+const socksVersion = 5
+
+const (
+	methodNoAuth       = 0x00
+	methodNoAcceptable = 0xFF
+)
+
+// The commands of SOCKS5 requests, also used for the requests the proxy sends to the server.
+const (
+	commandConnect   = 0x01
+	commandBind      = 0x02
+	commandAssociate = 0x03
+)
+
+const (
+	addrIPv4   = 0x01
+	addrDomain = 0x03
+	addrIPv6   = 0x04
+)
+
+// The reply codes of SOCKS5, which the server answers requests with and the proxy passes on.
+const (
+	replySucceeded           = 0x00
+	replyFailure             = 0x01
+	replyNotAllowed          = 0x02
+	replyNetworkUnreachable  = 0x03
+	replyHostUnreachable     = 0x04
+	replyConnectionRefused   = 0x05
+	replyCommandNotSupported = 0x07
+	replyAddressNotSupported = 0x08
+)
+
+// The ways the packets of a UDP association travel between the proxy and the server.
+const (
+	overStream byte = iota
+	overDatagrams
+)
+
+const (
+	// requestTimeout bounds the SOCKS handshake, opening a stream to the server and its answer.
+	requestTimeout = 10 * time.Second
+	// dialTimeout bounds dialing the destination of a CONNECT request.
+	dialTimeout = 10 * time.Second
+	// pollInterval is the longest time Serve waits for a stream before checking it was not stopped.
+	pollInterval = time.Second
+	// maxPacketSize is the largest UDP payload relayed.
+	maxPacketSize = 0xFFFF
+)
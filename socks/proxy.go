@@ -0,0 +1,309 @@
+package socks
+
+import (
+	"context"
+	"encoding/binary"
+	"errors"
+	"io"
+	"net"
+	"net/netip"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/Onyz107/onynet"
+	intErrors "github.com/Onyz107/onynet/errors"
+	"github.com/Onyz107/onynet/internal/forwarding"
+)
+
+// Proxy is a SOCKS5 server on the client side of a connection. It tunnels every CONNECT request through
+// a new stream to the server, which dials the destination, and relays the packets of UDP associations
+// through a UDP socket of the server. It accepts clients without authentication and does not support BIND.
+type Proxy struct {
+	h         onynet.Handler
+	listener  net.Listener
+	ctx       context.Context
+	cancel    context.CancelFunc
+	datagrams atomic.Bool
+	once      sync.Once
+	dispatch  *dispatcher
+}
+
+// Listen starts a SOCKS5 proxy on addr tunneling through h, until Close is called or ctx is cancelled.
+// As the proxy does not authenticate its clients, addr is usually a loopback address.
+//
+// Possible errors:
+//   - ErrBadAddr: failed to listen on addr
+func Listen(h onynet.Handler, addr string, ctx context.Context) (*Proxy, error) {
+	var config net.ListenConfig
+	listener, err := config.Listen(ctx, "tcp", addr)
+	if err != nil {
+		return nil, errors.Join(intErrors.ErrBadAddr, err)
+	}
+
+	ctx, cancel := context.WithCancel(ctx)
+	p := &Proxy{
+		h:        h,
+		listener: listener,
+		ctx:      ctx,
+		cancel:   cancel,
+	}
+	go func() {
+		<-ctx.Done()
+		listener.Close()
+	}()
+	go p.acceptLoop()
+	return p, nil
+}
+
+// SetDatagrams sets whether the UDP associations made afterwards relay their packets as datagrams, which
+// are never retransmitted nor wait behind other packets, like the UDP they carry. It only applies when h is
+// an onynet.Client whose connection carries datagrams, the proxy then reads every datagram of the connection.
+// Otherwise, and by default, packets are relayed in order on the association's stream.
+func (p *Proxy) SetDatagrams(enabled bool) {
+	p.datagrams.Store(enabled)
+}
+
+// Addr returns the address the proxy accepts clients on.
+func (p *Proxy) Addr() net.Addr {
+	return p.listener.Addr()
+}
+
+// Done returns a channel closed once the proxy stops.
+func (p *Proxy) Done() <-chan struct{} {
+	return p.ctx.Done()
+}
+
+// Close stops accepting clients and closes the connections and associations being relayed.
+func (p *Proxy) Close() error {
+	p.cancel()
+	return nil
+}
+
+func (p *Proxy) acceptLoop() {
+	defer p.cancel()
+	for {
+		conn, err := p.listener.Accept()
+		if err != nil {
+			return
+		}
+		go p.serve(conn)
+	}
+}
+
+// serve reads the greeting and the request of a SOCKS client and serves it.
+func (p *Proxy) serve(conn net.Conn) {
+	conn.SetDeadline(time.Now().Add(requestTimeout))
+
+	if err := negotiate(conn); err != nil {
+		conn.Close()
+		return
+	}
+
+	// version(1) + command(1) + reserved(1) + address
+	header := make([]byte, 3)
+	if _, err := io.ReadFull(conn, header); err != nil || header[0] != socksVersion {
+		conn.Close()
+		return
+	}
+	addr, err := readAddr(conn)
+	if err != nil {
+		writeReply(conn, replyAddressNotSupported, "")
+		conn.Close()
+		return
+	}
+
+	switch header[1] {
+	case commandConnect:
+		p.connect(conn, addr)
+	case commandAssociate:
+		p.associate(conn)
+	default:
+		writeReply(conn, replyCommandNotSupported, "")
+		conn.Close()
+	}
+}
+
+// negotiate reads the methods offered by the client and picks no authentication.
+func negotiate(conn net.Conn) error {
+	header := make([]byte, 2)
+	if _, err := io.ReadFull(conn, header); err != nil {
+		return errors.Join(intErrors.ErrRead, err)
+	}
+	if header[0] != socksVersion {
+		return intErrors.ErrMalformedFrame
+	}
+	methods := make([]byte, header[1])
+	if _, err := io.ReadFull(conn, methods); err != nil {
+		return errors.Join(intErrors.ErrRead, err)
+	}
+
+	for _, method := range methods {
+		if method == methodNoAuth {
+			_, err := conn.Write([]byte{socksVersion, methodNoAuth})
+			return err
+		}
+	}
+	conn.Write([]byte{socksVersion, methodNoAcceptable})
+	return errors.New("no acceptable authentication method")
+}
+
+// writeReply writes a SOCKS reply carrying bound, the unspecified address if empty.
+func writeReply(conn net.Conn, reply byte, bound string) error {
+	if bound == "" {
+		bound = "0.0.0.0:0"
+	}
+	response, err := appendAddr([]byte{socksVersion, reply, 0}, bound)
+	if err != nil {
+		return err
+	}
+	_, err = conn.Write(response)
+	return err
+}
+
+// open opens a stream to the server and sends it request, returning the stream with the reply of the server.
+func (p *Proxy) open(request []byte) (*onynet.Stream, byte) {
+	stream, err := p.h.OpenStream(StreamName, p.ctx, requestTimeout)
+	if err != nil {
+		return nil, replyFailure
+	}
+	stream.SetDeadline(time.Now().Add(requestTimeout))
+
+	reply := make([]byte, 1)
+	if _, err := stream.Write(request); err != nil {
+		stream.Close()
+		return nil, replyFailure
+	}
+	if _, err := io.ReadFull(stream, reply); err != nil {
+		stream.Close()
+		return nil, replyFailure
+	}
+	if reply[0] != replySucceeded {
+		stream.Close()
+		return nil, reply[0]
+	}
+	return stream, replySucceeded
+}
+
+// connect asks the server to dial addr and splices conn with the stream it answers on.
+func (p *Proxy) connect(conn net.Conn, addr string) {
+	request, err := appendAddr([]byte{commandConnect}, addr)
+	if err != nil {
+		writeReply(conn, replyAddressNotSupported, "")
+		conn.Close()
+		return
+	}
+
+	stream, reply := p.open(request)
+	if stream == nil {
+		writeReply(conn, reply, "")
+		conn.Close()
+		return
+	}
+	bound, err := readAddr(stream)
+	if err == nil {
+		err = writeReply(conn, replySucceeded, bound)
+	}
+	if err != nil {
+		stream.Close()
+		conn.Close()
+		return
+	}
+
+	stream.SetDeadline(time.Time{})
+	conn.SetDeadline(time.Time{})
+	forwarding.Splice(stream, conn)
+}
+
+// associate relays the packets the client sends to a UDP socket of the proxy through the server,
+// until the client closes conn.
+func (p *Proxy) associate(conn net.Conn) {
+	mode := overStream
+	datagrams, ok := p.h.(datagrammer)
+	if ok && p.datagrams.Load() && datagrams.MaxDatagramSize() > 0 {
+		mode = overDatagrams
+	}
+
+	stream, reply := p.open([]byte{commandAssociate, mode})
+	if stream == nil {
+		writeReply(conn, reply, "")
+		conn.Close()
+		return
+	}
+	defer stream.Close()
+	defer conn.Close()
+
+	// id(4) + the way packets travel
+	response := make([]byte, 5)
+	if _, err := io.ReadFull(stream, response); err != nil {
+		writeReply(conn, replyFailure, "")
+		return
+	}
+	t := &tunnel{stream: stream, id: binary.BigEndian.Uint32(response)}
+	if response[4] == overDatagrams && mode == overDatagrams {
+		t.datagrams = datagrams
+	}
+
+	// The client sends its packets from the host it connected from
+	local := conn.LocalAddr().(*net.TCPAddr)
+	remote := conn.RemoteAddr().(*net.TCPAddr)
+	socket, err := net.ListenUDP("udp", &net.UDPAddr{IP: local.IP, Zone: local.Zone})
+	if err != nil {
+		writeReply(conn, replyFailure, "")
+		return
+	}
+	defer socket.Close()
+
+	if err := writeReply(conn, replySucceeded, socket.LocalAddr().String()); err != nil {
+		return
+	}
+	stream.SetDeadline(time.Time{})
+	conn.SetDeadline(time.Time{})
+
+	var client atomic.Pointer[netip.AddrPort]
+	receive := func(packet []byte) {
+		if to := client.Load(); to != nil {
+			// reserved(2) + fragment(1) + packet
+			socket.WriteToUDPAddrPort(append([]byte{0, 0, 0}, packet...), *to)
+		}
+	}
+	if t.datagrams != nil {
+		p.once.Do(func() { p.dispatch = newDispatcher(datagrams, p.ctx) })
+		p.dispatch.add(t.id, receive)
+		defer p.dispatch.remove(t.id)
+	}
+
+	go func() {
+		for {
+			packet, err := t.receive()
+			if err != nil {
+				conn.Close()
+				return
+			}
+			receive(packet)
+		}
+	}()
+
+	go func() {
+		clientIP, _ := netip.AddrFromSlice(remote.IP)
+		buf := make([]byte, maxPacketSize)
+		for {
+			n, from, err := socket.ReadFromUDPAddrPort(buf)
+			if err != nil {
+				return
+			}
+			// Fragmented packets are not supported and dropped, as the RFC allows
+			if from.Addr().Unmap() != clientIP.Unmap() || n < 3 || buf[2] != 0 {
+				continue
+			}
+			if _, _, err := splitAddr(buf[3:n]); err != nil {
+				continue
+			}
+			client.Store(&from)
+			t.send(buf[3:n])
+		}
+	}()
+
+	// The association lasts as long as the TCP connection it was requested on
+	io.Copy(io.Discard, conn)
+}
@@ -0,0 +1,235 @@
+package socks
+
+import (
+	"context"
+	"encoding/binary"
+	"errors"
+	"io"
+	"net"
+	"sync/atomic"
+	"syscall"
+	"time"
+
+	"github.com/Onyz107/onynet"
+	intErrors "github.com/Onyz107/onynet/errors"
+	"github.com/Onyz107/onynet/forward"
+	"github.com/Onyz107/onynet/internal/forwarding"
+)
+
+// Server connects to the destinations the proxies of its clients ask for, within the rules it is given.
+// A single Server is shared by every client of an onynet.Server.
+type Server struct {
+	connect   atomic.Pointer[forward.Rule]
+	associate atomic.Pointer[forward.Rule]
+	nextID    atomic.Uint32
+}
+
+// NewServer returns a Server which denies every destination until SetConnectRule or SetAssociateRule is called.
+func NewServer() *Server {
+	return &Server{}
+}
+
+// SetConnectRule sets which destinations CONNECT requests may reach, a nil rule denies them all.
+// The rule is given the destination as the proxy's client wrote it, a domain name is not resolved first.
+func (s *Server) SetConnectRule(rule forward.Rule) {
+	s.connect.Store(&rule)
+}
+
+// SetAssociateRule sets which destinations the packets of UDP associations may be sent to, a nil rule
+// denies them all. Packets to other destinations are dropped.
+func (s *Server) SetAssociateRule(rule forward.Rule) {
+	s.associate.Store(&rule)
+}
+
+// Serve accepts the SOCKS streams of clientConn and serves them until ctx is cancelled or the connection
+// is closed. Once a proxy relays a UDP association over datagrams, Serve reads every datagram of clientConn.
+// Serve blocks, so it is usually run in its own goroutine for every accepted client.
+//
+// Possible errors:
+//   - ErrCtxCancelled: ctx was cancelled
+//   - the errors returned by AcceptStream
+func (s *Server) Serve(clientConn *onynet.ClientConn, ctx context.Context) error {
+	dispatch := newDispatcher(clientConn, ctx)
+	for {
+		stream, err := clientConn.AcceptStream(StreamName, ctx, pollInterval)
+		if err != nil {
+			if errors.Is(err, intErrors.ErrTimeout) {
+				continue
+			}
+			return err
+		}
+		go s.handle(clientConn, dispatch, stream, ctx)
+	}
+}
+
+// handle reads the request of stream and serves it.
+func (s *Server) handle(clientConn *onynet.ClientConn, dispatch *dispatcher, stream *onynet.Stream, ctx context.Context) {
+	logger := stream.Logger()
+	stream.SetDeadline(time.Now().Add(requestTimeout))
+
+	command := make([]byte, 1)
+	if _, err := io.ReadFull(stream, command); err != nil {
+		stream.Close()
+		return
+	}
+
+	switch command[0] {
+	case commandConnect:
+		s.handleConnect(clientConn, stream, ctx)
+	case commandAssociate:
+		s.handleAssociate(clientConn, dispatch, stream)
+	default:
+		logger.Debug("unknown SOCKS request", "command", command[0])
+		stream.Write([]byte{replyCommandNotSupported})
+		stream.Close()
+	}
+}
+
+// handleConnect dials the destination of the request and splices the connection with stream.
+// It answers reply(1) + the SOCKS address the server dialed from.
+func (s *Server) handleConnect(clientConn *onynet.ClientConn, stream *onynet.Stream, ctx context.Context) {
+	addr, err := readAddr(stream)
+	if err != nil {
+		stream.Write([]byte{replyAddressNotSupported})
+		stream.Close()
+		return
+	}
+	logger := stream.Logger().With("destination", addr)
+
+	if !forwarding.Allowed(s.connect.Load(), clientConn, addr) {
+		logger.Debug("SOCKS connect denied")
+		stream.Write([]byte{replyNotAllowed})
+		stream.Close()
+		return
+	}
+
+	dialer := net.Dialer{Timeout: dialTimeout}
+	conn, err := dialer.DialContext(ctx, "tcp", addr)
+	if err != nil {
+		logger.Debug("failed to dial SOCKS destination", "error", err, "error_kind", intErrors.Kind(err))
+		stream.Write([]byte{dialReply(err)})
+		stream.Close()
+		return
+	}
+
+	response, err := appendAddr([]byte{replySucceeded}, conn.LocalAddr().String())
+	if err == nil {
+		_, err = stream.Write(response)
+	}
+	if err != nil {
+		stream.Close()
+		conn.Close()
+		return
+	}
+	stream.SetDeadline(time.Time{})
+	forwarding.Splice(stream, conn)
+}
+
+// handleAssociate relays the packets of a UDP association through a UDP socket of its own until stream is closed.
+// The request carries the way the proxy wants packets to travel, the server answers reply(1) + id(4) + the way
+// they will, datagrams being only used when the connection carries them.
+func (s *Server) handleAssociate(clientConn *onynet.ClientConn, dispatch *dispatcher, stream *onynet.Stream) {
+	logger := stream.Logger()
+
+	mode := make([]byte, 1)
+	if _, err := io.ReadFull(stream, mode); err != nil {
+		stream.Close()
+		return
+	}
+	rule := s.associate.Load()
+	if rule == nil || *rule == nil {
+		logger.Debug("SOCKS associate denied")
+		stream.Write([]byte{replyNotAllowed})
+		stream.Close()
+		return
+	}
+
+	socket, err := net.ListenUDP("udp", nil)
+	if err != nil {
+		logger.Debug("failed to open UDP socket", "error", err, "error_kind", intErrors.Kind(err))
+		stream.Write([]byte{replyFailure})
+		stream.Close()
+		return
+	}
+	defer socket.Close()
+
+	t := &tunnel{stream: stream, id: s.nextID.Add(1)}
+	if mode[0] == overDatagrams && clientConn.MaxDatagramSize() > 0 {
+		t.datagrams = clientConn
+	} else {
+		mode[0] = overStream
+	}
+
+	response := binary.BigEndian.AppendUint32([]byte{replySucceeded}, t.id)
+	if _, err := stream.Write(append(response, mode[0])); err != nil {
+		stream.Close()
+		return
+	}
+	stream.SetDeadline(time.Time{})
+
+	sendTo := func(packet []byte) {
+		addr, payload, err := splitAddr(packet)
+		if err != nil {
+			return
+		}
+		if !forwarding.Allowed(s.associate.Load(), clientConn, addr) {
+			logger.Debug("dropping SOCKS packet", "destination", addr, "reason", "denied")
+			return
+		}
+		udpAddr, err := net.ResolveUDPAddr("udp", addr)
+		if err != nil {
+			logger.Debug("dropping SOCKS packet", "destination", addr, "reason", err, "error_kind", intErrors.Kind(err))
+			return
+		}
+		socket.WriteToUDP(payload, udpAddr)
+	}
+	if t.datagrams != nil {
+		dispatch.add(t.id, sendTo)
+		defer dispatch.remove(t.id)
+	}
+
+	go func() {
+		buf := make([]byte, maxPacketSize)
+		for {
+			n, from, err := socket.ReadFromUDPAddrPort(buf)
+			if err != nil {
+				return
+			}
+			packet, err := appendAddr(nil, from.String())
+			if err != nil {
+				continue
+			}
+			if err := t.send(append(packet, buf[:n]...)); err != nil {
+				logger.Debug("failed to relay SOCKS packet", "error", err, "error_kind", intErrors.Kind(err))
+			}
+		}
+	}()
+
+	for {
+		packet, err := t.receive()
+		if err != nil {
+			stream.Close()
+			return
+		}
+		sendTo(packet)
+	}
+}
+
+// dialReply returns the SOCKS reply for an error dialing a destination.
+func dialReply(err error) byte {
+	var dnsErr *net.DNSError
+	switch {
+	case errors.Is(err, syscall.ECONNREFUSED):
+		return replyConnectionRefused
+	case errors.Is(err, syscall.ENETUNREACH):
+		return replyNetworkUnreachable
+	case errors.Is(err, syscall.EHOSTUNREACH), errors.As(err, &dnsErr), errors.Is(err, context.DeadlineExceeded):
+		return replyHostUnreachable
+	default:
+		var netErr net.Error
+		if errors.As(err, &netErr) && netErr.Timeout() {
+			return replyHostUnreachable
+		}
+		return replyFailure
+	}
+}
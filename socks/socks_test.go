@@ -0,0 +1,261 @@
+package socks_test
+
+import (
+	"bytes"
+	"io"
+	"net"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/Onyz107/onynet/forward"
+	"github.com/Onyz107/onynet/onynettest"
+	"github.com/Onyz107/onynet/socks"
+	"golang.org/x/net/proxy"
+)
+
+func newProxy(tb testing.TB, server *socks.Server, datagrams bool) *socks.Proxy {
+	tb.Helper()
+
+	pair := onynettest.NewPair(tb, nil)
+	go server.Serve(pair.ClientConn, tb.Context())
+
+	p, err := socks.Listen(pair.Client, "127.0.0.1:0", tb.Context())
+	if err != nil {
+		tb.Fatal(err)
+	}
+	p.SetDatagrams(datagrams)
+	tb.Cleanup(func() { p.Close() })
+	return p
+}
+
+func newTCPEcho(tb testing.TB) string {
+	tb.Helper()
+
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		tb.Fatal(err)
+	}
+	tb.Cleanup(func() { listener.Close() })
+
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			go func() {
+				defer conn.Close()
+				io.Copy(conn, conn)
+			}()
+		}
+	}()
+	return listener.Addr().String()
+}
+
+func newUDPEcho(tb testing.TB) *net.UDPAddr {
+	tb.Helper()
+
+	conn, err := net.ListenUDP("udp", &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1)})
+	if err != nil {
+		tb.Fatal(err)
+	}
+	tb.Cleanup(func() { conn.Close() })
+
+	go func() {
+		buf := make([]byte, 2048)
+		for {
+			n, from, err := conn.ReadFromUDP(buf)
+			if err != nil {
+				return
+			}
+			conn.WriteToUDP(buf[:n], from)
+		}
+	}()
+	return conn.LocalAddr().(*net.UDPAddr)
+}
+
+func TestConnect(t *testing.T) {
+	echo := newTCPEcho(t)
+	server := socks.NewServer()
+	server.SetConnectRule(forward.AllowAddrs(echo))
+	p := newProxy(t, server, false)
+
+	dialer, err := proxy.SOCKS5("tcp", p.Addr().String(), nil, proxy.Direct)
+	if err != nil {
+		t.Fatal(err)
+	}
+	conn, err := dialer.Dial("tcp", echo)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	conn.SetDeadline(time.Now().Add(10 * time.Second))
+
+	message := []byte("through the proxy")
+	if _, err := conn.Write(message); err != nil {
+		t.Fatal(err)
+	}
+	echoed := make([]byte, len(message))
+	if _, err := io.ReadFull(conn, echoed); err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(echoed, message) {
+		t.Fatalf("expected %q, got %q", message, echoed)
+	}
+
+	// The rule only allows the echo server
+	host, _, _ := net.SplitHostPort(echo)
+	if _, err := dialer.Dial("tcp", net.JoinHostPort(host, "1")); err == nil {
+		t.Fatal("expected the denied destination to fail")
+	}
+}
+
+func TestAssociate(t *testing.T) {
+	for name, datagrams := range map[string]bool{"stream": false, "datagrams": true} {
+		t.Run(name, func(t *testing.T) {
+			echo := newUDPEcho(t)
+			server := socks.NewServer()
+			server.SetAssociateRule(forward.AllowAddrs(echo.String()))
+			p := newProxy(t, server, datagrams)
+
+			control, relay := associate(t, p.Addr().String())
+			defer control.Close()
+
+			client, err := net.ListenUDP("udp", &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1)})
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer client.Close()
+
+			// reserved(2) + fragment(1) + IPv4 address + port + payload
+			packet := append([]byte{0, 0, 0, 1}, echo.IP.To4()...)
+			packet = append(packet, byte(echo.Port>>8), byte(echo.Port))
+			packet = append(packet, "ping"...)
+
+			buf := make([]byte, 2048)
+			for attempt := 0; ; attempt++ {
+				if _, err := client.WriteToUDP(packet, relay); err != nil {
+					t.Fatal(err)
+				}
+				client.SetReadDeadline(time.Now().Add(time.Second))
+				n, err := client.Read(buf)
+				if err == nil {
+					if !bytes.Equal(buf[:n], packet) {
+						t.Fatalf("expected %v, got %v", packet, buf[:n])
+					}
+					break
+				}
+				if attempt == 5 {
+					t.Fatal(err)
+				}
+			}
+		})
+	}
+}
+
+// associate requests a UDP association from the proxy at addr, returning the control connection
+// and the address to send packets to.
+func associate(tb testing.TB, addr string) (net.Conn, *net.UDPAddr) {
+	tb.Helper()
+
+	conn, err := net.Dial("tcp", addr)
+	if err != nil {
+		tb.Fatal(err)
+	}
+	conn.SetDeadline(time.Now().Add(10 * time.Second))
+
+	// greeting, then UDP ASSOCIATE from 0.0.0.0:0
+	conn.Write([]byte{5, 1, 0})
+	conn.Write([]byte{5, 3, 0, 1, 0, 0, 0, 0, 0, 0})
+
+	method := make([]byte, 2)
+	if _, err := io.ReadFull(conn, method); err != nil || method[1] != 0 {
+		tb.Fatalf("unexpected method selection: %v: %v", method, err)
+	}
+	reply := make([]byte, 10)
+	if _, err := io.ReadFull(conn, reply); err != nil {
+		tb.Fatal(err)
+	}
+	if reply[1] != 0 || reply[3] != 1 {
+		tb.Fatalf("unexpected reply: %v", reply)
+	}
+	conn.SetDeadline(time.Time{})
+
+	relay, err := net.ResolveUDPAddr("udp", net.JoinHostPort(net.IP(reply[4:8]).String(), strconv.Itoa(int(reply[8])<<8|int(reply[9]))))
+	if err != nil {
+		tb.Fatal(err)
+	}
+	return conn, relay
+}
+
+// request sends a raw CONNECT request for address, a SOCKS address with its port, to the proxy at addr
+// and returns the reply code, closing the write side first when truncated is set.
+func request(tb testing.TB, addr string, address []byte, truncated bool) byte {
+	tb.Helper()
+
+	conn, err := net.Dial("tcp", addr)
+	if err != nil {
+		tb.Fatal(err)
+	}
+	defer conn.Close()
+	conn.SetDeadline(time.Now().Add(10 * time.Second))
+
+	conn.Write([]byte{5, 1, 0})
+	conn.Write(append([]byte{5, 1, 0}, address...))
+	if truncated {
+		conn.(*net.TCPConn).CloseWrite()
+	}
+
+	method := make([]byte, 2)
+	if _, err := io.ReadFull(conn, method); err != nil || method[1] != 0 {
+		tb.Fatalf("unexpected method selection: %v: %v", method, err)
+	}
+	reply := make([]byte, 2)
+	if _, err := io.ReadFull(conn, reply); err != nil {
+		tb.Fatal(err)
+	}
+	return reply[1]
+}
+
+// domainAddr returns a SOCKS domain address of a domain of size bytes.
+func domainAddr(size int) []byte {
+	address := append([]byte{3, byte(size)}, bytes.Repeat([]byte("a"), size)...)
+	return append(address, 0, 80)
+}
+
+func TestDomainAddress(t *testing.T) {
+	echo := newTCPEcho(t)
+	_, port, _ := net.SplitHostPort(echo)
+	server := socks.NewServer()
+	server.SetConnectRule(forward.AllowAddrs(net.JoinHostPort("localhost", port)))
+	p := newProxy(t, server, false)
+
+	dialer, err := proxy.SOCKS5("tcp", p.Addr().String(), nil, proxy.Direct)
+	if err != nil {
+		t.Fatal(err)
+	}
+	conn, err := dialer.Dial("tcp", net.JoinHostPort("localhost", port))
+	if err != nil {
+		t.Fatal(err)
+	}
+	conn.Close()
+
+	// The longest domains reach the server, whose rule denies them
+	for _, size := range []int{254, 255} {
+		if reply := request(t, p.Addr().String(), domainAddr(size), false); reply != 0x02 {
+			t.Fatalf("expected a %d bytes domain to be denied, got reply %d", size, reply)
+		}
+	}
+
+	for name, address := range map[string][]byte{
+		"domain":      domainAddr(10)[:6],
+		"domain port": domainAddr(10)[:12],
+		"IPv4":        {1, 127, 0},
+		"IPv6":        {4, 0, 0, 0, 0},
+	} {
+		if reply := request(t, p.Addr().String(), address, true); reply != 0x08 {
+			t.Fatalf("expected a truncated %s address to be refused, got reply %d", name, reply)
+		}
+	}
+}
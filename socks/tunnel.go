@@ -0,0 +1,114 @@
+package socks
+
+import (
+	"context"
+	"encoding/binary"
+	"errors"
+	"io"
+	"sync"
+
+	"github.com/Onyz107/onynet"
+	intErrors "github.com/Onyz107/onynet/errors"
+)
+
+// datagrammer is a connection carrying datagrams, such as an onynet.Client or an onynet.ClientConn.
+type datagrammer interface {
+	SendDatagram(b []byte) error
+	ReceiveDatagram(ctx context.Context) ([]byte, error)
+	MaxDatagramSize() int
+}
+
+// tunnel carries the packets of a UDP association between the proxy and the server, a packet being the
+// SOCKS address of its destination or source followed by its payload. Packets are sent as datagrams of
+// id(4) + packet when the association uses them and the packet fits, otherwise on the association's stream
+// as length(2) + packet.
+type tunnel struct {
+	stream    *onynet.Stream
+	datagrams datagrammer
+	id        uint32
+	mu        sync.Mutex
+}
+
+func (t *tunnel) send(packet []byte) error {
+	if t.datagrams != nil && 4+len(packet) <= t.datagrams.MaxDatagramSize() {
+		return t.datagrams.SendDatagram(append(binary.BigEndian.AppendUint32(nil, t.id), packet...))
+	}
+	if len(packet) > maxPacketSize {
+		return intErrors.ErrMessageTooLong
+	}
+
+	frame := binary.BigEndian.AppendUint16(nil, uint16(len(packet)))
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if _, err := t.stream.Write(append(frame, packet...)); err != nil {
+		return errors.Join(intErrors.ErrWrite, err)
+	}
+	return nil
+}
+
+// receive waits for the next packet sent on the stream.
+func (t *tunnel) receive() ([]byte, error) {
+	header := make([]byte, 2)
+	if _, err := io.ReadFull(t.stream, header); err != nil {
+		return nil, errors.Join(intErrors.ErrRead, err)
+	}
+	packet := make([]byte, binary.BigEndian.Uint16(header))
+	if _, err := io.ReadFull(t.stream, packet); err != nil {
+		return nil, errors.Join(intErrors.ErrRead, err)
+	}
+	return packet, nil
+}
+
+// dispatcher hands the datagrams of a connection to the UDP associations whose id they carry.
+// It reads every datagram of the connection once the first association using them is added.
+type dispatcher struct {
+	conn     datagrammer
+	ctx      context.Context
+	mu       sync.Mutex
+	handlers map[uint32]func(packet []byte)
+	started  bool
+}
+
+func newDispatcher(conn datagrammer, ctx context.Context) *dispatcher {
+	return &dispatcher{
+		conn:     conn,
+		ctx:      ctx,
+		handlers: make(map[uint32]func([]byte)),
+	}
+}
+
+func (d *dispatcher) add(id uint32, handler func(packet []byte)) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	d.handlers[id] = handler
+	if !d.started {
+		d.started = true
+		go d.loop()
+	}
+}
+
+func (d *dispatcher) remove(id uint32) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	delete(d.handlers, id)
+}
+
+func (d *dispatcher) loop() {
+	for {
+		b, err := d.conn.ReceiveDatagram(d.ctx)
+		if err != nil {
+			return
+		}
+		if len(b) < 4 {
+			continue
+		}
+
+		d.mu.Lock()
+		handler := d.handlers[binary.BigEndian.Uint32(b)]
+		d.mu.Unlock()
+		if handler != nil {
+			handler(b[4:])
+		}
+	}
+}
@@ -0,0 +1,31 @@
+package onynet
+
+import "github.com/Onyz107/onynet/tracing"
+
+// SetTracer sets the tracer the server starts spans with for accepting clients, their handshake and their streams.
+// A nil tracer means tracing.Noop, which is the default.
+// Connections already accepted keep the tracer they were accepted with.
+func (s *Server) SetTracer(tracer tracing.Tracer) {
+	if tracer == nil {
+		tracer = tracing.Noop
+	}
+	s.tracer.Store(&tracer)
+}
+
+func (s *Server) loadTracer() tracing.Tracer {
+	if tracer := s.tracer.Load(); tracer != nil {
+		return *tracer
+	}
+	return tracing.Noop
+}
+
+// WithTracer sets the tracer the client starts spans with for Dial, the handshake and its streams.
+// A nil tracer means tracing.Noop, which is the default.
+func WithTracer(tracer tracing.Tracer) DialOption {
+	return func(o *dialOptions) {
+		if tracer == nil {
+			tracer = tracing.Noop
+		}
+		o.tracer = tracer
+	}
+}
@@ -0,0 +1,90 @@
+package tracing
+
+import (
+	"encoding/binary"
+	"errors"
+	"slices"
+
+	intErrors "github.com/Onyz107/onynet/errors"
+)
+
+// Carrier holds the key value pairs a span context is propagated with, such as the W3C traceparent header.
+// Its Get, Set and Keys methods make it usable as an OpenTelemetry propagation.TextMapCarrier.
+type Carrier map[string]string
+
+// Get returns the value of key.
+func (c Carrier) Get(key string) string {
+	return c[key]
+}
+
+// Set sets the value of key.
+func (c Carrier) Set(key, value string) {
+	c[key] = value
+}
+
+// Keys returns the keys of the carrier.
+func (c Carrier) Keys() []string {
+	keys := make([]string, 0, len(c))
+	for key := range c {
+		keys = append(keys, key)
+	}
+	return keys
+}
+
+// MaxCarrierSize is the largest encoded carrier, pairs that do not fit are dropped.
+const MaxCarrierSize = 0xFFFF
+
+// MarshalBinary encodes the carrier as a count of pairs followed by each key, prefixed by its 1 byte length,
+// and value, prefixed by its 2 bytes length. Keys longer than 255 bytes are dropped, as are pairs past
+// MaxCarrierSize or the 255th one.
+func (c Carrier) MarshalBinary() ([]byte, error) {
+	if len(c) == 0 {
+		return nil, nil
+	}
+
+	keys := c.Keys()
+	slices.Sort(keys)
+
+	b := []byte{0}
+	for _, key := range keys {
+		value := c[key]
+		if len(key) > 0xFF || len(b)+3+len(key)+len(value) > MaxCarrierSize || b[0] == 0xFF {
+			continue
+		}
+		b[0]++
+		b = append(b, byte(len(key)))
+		b = append(b, key...)
+		b = binary.BigEndian.AppendUint16(b, uint16(len(value)))
+		b = append(b, value...)
+	}
+	return b, nil
+}
+
+// UnmarshalBinary decodes a carrier encoded by MarshalBinary into c, which must not be nil.
+func (c Carrier) UnmarshalBinary(b []byte) error {
+	if len(b) == 0 {
+		return nil
+	}
+
+	count := int(b[0])
+	b = b[1:]
+	for range count {
+		if len(b) < 1 {
+			return errors.Join(intErrors.ErrMalformedFrame, errors.New("truncated trace carrier"))
+		}
+		keyLength := int(b[0])
+		if len(b) < 1+keyLength+2 {
+			return errors.Join(intErrors.ErrMalformedFrame, errors.New("truncated trace carrier"))
+		}
+		key := string(b[1 : 1+keyLength])
+		b = b[1+keyLength:]
+
+		length := int(binary.BigEndian.Uint16(b))
+		if len(b) < 2+length {
+			return errors.Join(intErrors.ErrMalformedFrame, errors.New("truncated trace carrier"))
+		}
+		c[key] = string(b[2 : 2+length])
+		b = b[2+length:]
+	}
+	return nil
+}
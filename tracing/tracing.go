@@ -0,0 +1,66 @@
+// Package tracing defines the small tracer interface onynet records spans with, so traces can be exported
+// to OpenTelemetry or anything else without onynet depending on it. The default tracer, Noop, records nothing.
+//
+// onynet starts spans for Dial, the handshake, OpenStream and AcceptStream and the calls of the rpc package.
+// The context of the span opening a stream is carried in the stream's open header, and the one of an rpc call
+// in its request, so the accepting side continues the trace: the context of an accepted stream, and the
+// one given to an rpc handler, hold the span of the remote end.
+package tracing
+
+import "context"
+
+// Tracer starts spans and propagates their context across connections.
+type Tracer interface {
+	// Start starts a span named name as a child of the span in ctx, if any,
+	// and returns a context holding the new span.
+	Start(ctx context.Context, name string, attrs ...Attribute) (context.Context, Span)
+	// Inject writes the context of the span in ctx to carrier.
+	Inject(ctx context.Context, carrier Carrier)
+	// Extract returns a copy of ctx holding the remote span context read from carrier.
+	Extract(ctx context.Context, carrier Carrier) context.Context
+}
+
+// Span is an operation being traced.
+type Span interface {
+	SetAttributes(attrs ...Attribute)
+	// RecordError records err on the span and marks it as failed, a nil err is ignored.
+	RecordError(err error)
+	End()
+}
+
+// Attribute is a key value pair describing a span.
+type Attribute struct {
+	Key   string
+	Value any
+}
+
+// String returns an Attribute with a string value.
+func String(key, value string) Attribute {
+	return Attribute{Key: key, Value: value}
+}
+
+// Int returns an Attribute with an int value.
+func Int(key string, value int) Attribute {
+	return Attribute{Key: key, Value: value}
+}
+
+// Noop is a Tracer which records nothing and propagates nothing, it is the default tracer.
+var Noop Tracer = noopTracer{}
+
+type noopTracer struct{}
+
+func (noopTracer) Start(ctx context.Context, name string, attrs ...Attribute) (context.Context, Span) {
+	return ctx, noopSpan{}
+}
+
+func (noopTracer) Inject(ctx context.Context, carrier Carrier) {}
+
+func (noopTracer) Extract(ctx context.Context, carrier Carrier) context.Context {
+	return ctx
+}
+
+type noopSpan struct{}
+
+func (noopSpan) SetAttributes(attrs ...Attribute) {}
+func (noopSpan) RecordError(err error)            {}
+func (noopSpan) End()                             {}
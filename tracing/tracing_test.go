@@ -0,0 +1,113 @@
+package tracing_test
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"testing"
+
+	intErrors "github.com/Onyz107/onynet/errors"
+	"github.com/Onyz107/onynet/tracing"
+)
+
+func TestCarrier(t *testing.T) {
+	carrier := tracing.Carrier{
+		"traceparent":            "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01",
+		"tracestate":             "vendor=value",
+		strings.Repeat("k", 256): "dropped because the key is too long",
+	}
+
+	b, err := carrier.MarshalBinary()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	decoded := tracing.Carrier{}
+	if err := decoded.UnmarshalBinary(b); err != nil {
+		t.Fatal(err)
+	}
+	if len(decoded) != 2 || decoded.Get("traceparent") != carrier["traceparent"] || decoded.Get("tracestate") != "vendor=value" {
+		t.Fatalf("expected the carrier to round trip without the long key, got: %v", decoded)
+	}
+
+	if err := (tracing.Carrier{}).UnmarshalBinary(b[:len(b)-1]); !errors.Is(err, intErrors.ErrMalformedFrame) {
+		t.Fatalf("expected ErrMalformedFrame for a truncated carrier, got: %v", err)
+	}
+
+	empty, _ := tracing.Carrier{}.MarshalBinary()
+	if len(empty) != 0 {
+		t.Fatalf("expected an empty carrier to encode to nothing, got: %v", empty)
+	}
+}
+
+func TestCarrierLongestKey(t *testing.T) {
+	key := strings.Repeat("k", 255)
+	b, err := tracing.Carrier{key: "value"}.MarshalBinary()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	decoded := tracing.Carrier{}
+	if err := decoded.UnmarshalBinary(b); err != nil {
+		t.Fatal(err)
+	}
+	if decoded.Get(key) != "value" {
+		t.Fatalf("expected the 255 bytes key to round trip, got: %v", decoded)
+	}
+
+	for i := range b {
+		if err := (tracing.Carrier{}).UnmarshalBinary(b[:i]); i > 0 && !errors.Is(err, intErrors.ErrMalformedFrame) {
+			t.Fatalf("expected ErrMalformedFrame for a carrier truncated to %d bytes, got: %v", i, err)
+		}
+	}
+}
+
+func TestMalformedCarrier(t *testing.T) {
+	for name, b := range map[string][]byte{
+		"missing key length":   {1},
+		"key past the end":     {1, 0xFF, 'k'},
+		"missing value length": {1, 1, 'k'},
+		"value past the end":   {1, 1, 'k', 0xFF, 0xFF, 'v'},
+		"missing second pair":  {2, 1, 'k', 0, 1, 'v'},
+	} {
+		if err := (tracing.Carrier{}).UnmarshalBinary(b); !errors.Is(err, intErrors.ErrMalformedFrame) {
+			t.Errorf("%s: expected ErrMalformedFrame, got: %v", name, err)
+		}
+	}
+}
+
+func FuzzCarrier(f *testing.F) {
+	f.Add("traceparent", "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01", []byte{1, 1, 'k', 0, 1, 'v'})
+	f.Add(strings.Repeat("k", 255), "value", []byte{1, 0xFF, 'k'})
+	f.Fuzz(func(t *testing.T, key, value string, raw []byte) {
+		// Decoding arbitrary bytes never panics
+		(tracing.Carrier{}).UnmarshalBinary(raw)
+
+		carrier := tracing.Carrier{key: value}
+		b, err := carrier.MarshalBinary()
+		if err != nil {
+			t.Fatal(err)
+		}
+		decoded := tracing.Carrier{}
+		if err := decoded.UnmarshalBinary(b); err != nil {
+			t.Fatal(err)
+		}
+		kept := len(key) <= 0xFF && 4+len(key)+len(value) <= tracing.MaxCarrierSize
+		if got, ok := decoded[key]; ok != kept || got != value && kept {
+			t.Fatalf("expected the pair to round trip: %v, got: %v", kept, decoded)
+		}
+	})
+}
+
+func TestNoop(t *testing.T) {
+	ctx := context.Background()
+	spanCtx, span := tracing.Noop.Start(ctx, "test")
+	span.RecordError(errors.New("ignored"))
+	span.End()
+
+	carrier := tracing.Carrier{}
+	tracing.Noop.Inject(spanCtx, carrier)
+	if len(carrier) != 0 || tracing.Noop.Extract(ctx, carrier) != ctx {
+		t.Fatal("expected the noop tracer to propagate nothing")
+	}
+}